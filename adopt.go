@@ -0,0 +1,122 @@
+package wgmesh
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// adoptableDevice returns the device to take over on start, if
+// Config.AdoptDevice is set and the interface already runs with the
+// configured private key, e.g. as left behind by the process being
+// upgraded. Otherwise it returns nil and the tunnel is brought up from
+// scratch.
+func (w *WgMesh) adoptableDevice() *wgtypes.Device {
+	if !w.Config.AdoptDevice {
+		return nil
+	}
+
+	device, err := w.deviceClient().Device(w.Config.NetworkName)
+	if err != nil {
+		log.Info().Err(err).Msg("No device to adopt, bringing the tunnel up")
+		return nil
+	}
+	pk, err := parseKey(w.Config.PrivateKey)
+	if err != nil || device.PrivateKey != pk {
+		log.Info().Msg("Device runs with another private key, not adopting it")
+		return nil
+	}
+	return device
+}
+
+// adoptDevice brings an adopted device in line with the configuration. Peers
+// that are already configured as wanted are left alone, so their sessions
+// survive the takeover; only missing or differing peers are configured and
+// peers that aren't in the configuration are removed.
+func (w *WgMesh) adoptDevice(device *wgtypes.Device) error {
+	report, err := w.compareDevice(w.Config, device)
+	if err != nil {
+		return err
+	}
+
+	drifted := make(map[string]bool, len(report.Missing)+len(report.Mismatched))
+	for _, name := range report.Missing {
+		drifted[name] = true
+	}
+	for _, d := range report.Mismatched {
+		drifted[d.Peer] = true
+	}
+
+	devicePeers := make(map[wgtypes.Key]wgtypes.Peer, len(device.Peers))
+	for _, peer := range device.Peers {
+		devicePeers[peer.PublicKey] = peer
+	}
+
+	var (
+		peerConfigs []wgtypes.PeerConfig
+		changed     []string
+	)
+	for _, key := range report.Extra {
+		pubKey, err := wgtypes.ParseKey(key)
+		if err != nil {
+			return err
+		}
+		peerConfigs = append(peerConfigs, wgtypes.PeerConfig{PublicKey: pubKey, Remove: true})
+	}
+	for _, peer := range w.Config.Peers {
+		peerConfig, err := w.createPeerConfig(peer)
+		if err != nil {
+			w.handlePeerError(peer, err)
+			continue
+		}
+		w.updatePeerState(peer.Name, PeerStateConfiguring, nil)
+		if drifted[peer.Name] || !adoptedPeerMatches(peerConfig, devicePeers[peerConfig.PublicKey]) {
+			peerConfigs = append(peerConfigs, peerConfig)
+			changed = append(changed, peer.Name)
+		}
+	}
+
+	var listenPort *int
+	if w.Config.ListenPort != 0 && device.ListenPort != w.Config.ListenPort {
+		listenPort = &w.Config.ListenPort
+	}
+
+	if len(peerConfigs) == 0 && listenPort == nil {
+		log.Info().Int("peers", len(device.Peers)).Msg("Adopted device, already up to date")
+		return nil
+	}
+
+	cfg := wgtypes.Config{
+		ListenPort: listenPort,
+		Peers:      peerConfigs,
+	}
+	if err := w.deviceClient().ConfigureDevice(w.Config.NetworkName, cfg); err != nil {
+		for _, name := range changed {
+			w.updatePeerState(name, PeerStateError, err)
+		}
+		return fmt.Errorf("failed to configure WireGuard device: %w", err)
+	}
+
+	log.Info().
+		Strs("changed", changed).
+		Strs("removed", report.Extra).
+		Msg("Adopted device, applied the differences to the configuration")
+	return nil
+}
+
+// adoptedPeerMatches reports whether the settings of a device peer that the
+// drift report doesn't cover, its keepalive and preshared key, are as
+// wanted.
+func adoptedPeerMatches(want wgtypes.PeerConfig, have wgtypes.Peer) bool {
+	var keepalive time.Duration
+	if want.PersistentKeepaliveInterval != nil {
+		keepalive = *want.PersistentKeepaliveInterval
+	}
+	var presharedKey wgtypes.Key
+	if want.PresharedKey != nil {
+		presharedKey = *want.PresharedKey
+	}
+	return have.PersistentKeepaliveInterval == keepalive && have.PresharedKey == presharedKey
+}
@@ -0,0 +1,180 @@
+package wgmesh_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const adoptConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+adopt_device: true
+pre_up: ["echo pre_up %i"]
+post_up: ["echo post_up %i"]
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: [10.0.0.2/32]
+    endpoint: 192.0.2.1:51820
+    persistent_keepalive: 25
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: [10.0.0.3/32]
+`
+
+// runningDevice returns the device as adoptConfig left it.
+func runningDevice(t *testing.T) *wgtypes.Device {
+	return &wgtypes.Device{
+		Name:       "wg0",
+		PrivateKey: mustParseKey(t, "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8="),
+		ListenPort: 51820,
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:                   mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+				AllowedIPs:                  []net.IPNet{mustParseCIDR(t, "10.0.0.2/32")},
+				Endpoint:                    &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820},
+				PersistentKeepaliveInterval: 25 * time.Second,
+				LastHandshakeTime:           time.Now(),
+			},
+			{
+				PublicKey:         mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="),
+				AllowedIPs:        []net.IPNet{mustParseCIDR(t, "10.0.0.3/32")},
+				LastHandshakeTime: time.Now(),
+			},
+		},
+	}
+}
+
+func TestAdoptDeviceUpToDate(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, adoptConfig)
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("Device", "wg0").Return(runningDevice(t), nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	require.NoError(t, mesh.Close())
+
+	// Neither the device nor the hooks were touched
+	assert.Empty(t, configureCalls(mockClient))
+	assert.Empty(t, runner.Commands())
+}
+
+func TestAdoptDeviceAppliesDifferences(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, adoptConfig)
+	mesh.CommandRunner = &fakeRunner{}
+
+	device := runningDevice(t)
+	device.ListenPort = 51821
+	device.Peers[0].PersistentKeepaliveInterval = 0
+	stray := mustParseKey(t, "WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=")
+	device.Peers[1] = wgtypes.Peer{PublicKey: stray}
+	mockClient.On("Device", "wg0").Return(device, nil)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	require.NoError(t, mesh.Close())
+
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	assert.Nil(t, calls[0].PrivateKey)
+	assert.False(t, calls[0].ReplacePeers)
+	require.NotNil(t, calls[0].ListenPort)
+	assert.Equal(t, 51820, *calls[0].ListenPort)
+
+	// The stray peer is removed, the others are reconfigured individually
+	require.Len(t, calls[0].Peers, 3)
+	assert.Equal(t, stray, calls[0].Peers[0].PublicKey)
+	assert.True(t, calls[0].Peers[0].Remove)
+	assert.Equal(t, mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="), calls[0].Peers[1].PublicKey)
+	require.NotNil(t, calls[0].Peers[1].PersistentKeepaliveInterval)
+	assert.Equal(t, 25*time.Second, *calls[0].Peers[1].PersistentKeepaliveInterval)
+	assert.Equal(t, mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="), calls[0].Peers[2].PublicKey)
+}
+
+func TestAdoptDeviceFallsBack(t *testing.T) {
+	tests := []struct {
+		name   string
+		device func(*testing.T) (*wgtypes.Device, error)
+	}{
+		{
+			name: "no device",
+			device: func(*testing.T) (*wgtypes.Device, error) {
+				return nil, errors.New("no such device")
+			},
+		},
+		{
+			name: "other private key",
+			device: func(t *testing.T) (*wgtypes.Device, error) {
+				device := runningDevice(t)
+				device.PrivateKey = mustParseKey(t, "mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=")
+				return device, nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh, mockClient := newTestMesh(t, adoptConfig)
+			runner := &fakeRunner{}
+			mesh.CommandRunner = runner
+			mockClient.On("Device", "wg0").Return(tt.device(t))
+			mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+			mockClient.On("Close").Return(nil)
+
+			require.NoError(t, mesh.Start())
+			require.NoError(t, mesh.Close())
+
+			// The tunnel is brought up as without adopt_device
+			calls := configureCalls(mockClient)
+			require.Len(t, calls, 1)
+			require.NotNil(t, calls[0].PrivateKey)
+			assert.Len(t, calls[0].Peers, 2)
+			assert.Equal(t, []string{"sh -c echo pre_up wg0", "sh -c echo post_up wg0"}, runner.Commands())
+		})
+	}
+}
+
+func TestAdoptDeviceAcrossRestart(t *testing.T) {
+	config := adoptConfig + `
+pre_down: ["echo pre_down %i"]
+post_down: ["echo post_down %i"]
+`
+	// The old process brings the tunnel up from scratch
+	old, oldClient := newTestMesh(t, config)
+	oldRunner := &fakeRunner{}
+	old.CommandRunner = oldRunner
+	oldClient.On("Device", "wg0").Return(nil, errors.New("no such device")).Once()
+	oldClient.On("Device", "wg0").Return(runningDevice(t), nil)
+	oldClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	oldClient.On("Close").Return(nil)
+	require.NoError(t, old.Start())
+	configured := len(configureCalls(oldClient))
+	started := len(oldRunner.Commands())
+
+	// and leaves it running when stopped for the upgrade
+	require.NoError(t, old.Shutdown())
+	assert.Len(t, configureCalls(oldClient), configured)
+	assert.Len(t, oldRunner.Commands(), started)
+
+	// The new process adopts it without touching the device or the hooks
+	mesh, mockClient := newTestMesh(t, config)
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("Device", "wg0").Return(runningDevice(t), nil)
+	mockClient.On("Close").Return(nil)
+	require.NoError(t, mesh.Start())
+	require.NoError(t, mesh.Close())
+
+	assert.Empty(t, configureCalls(mockClient))
+	assert.Empty(t, runner.Commands())
+}
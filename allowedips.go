@@ -0,0 +1,262 @@
+package wgmesh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ErrUnmanagedDevice is returned by changes to a single peer when the mesh
+// doesn't configure the device, as in observe-only mode or on a replica.
+var ErrUnmanagedDevice = errors.New("device is not managed by this mesh")
+
+// checkManaged returns ErrUnmanagedDevice unless the mesh configures the
+// device with config.
+func (w *WgMesh) checkManaged(config *Config) error {
+	if config.ObserveOnly || w.replicaSource() != nil {
+		return ErrUnmanagedDevice
+	}
+	return nil
+}
+
+// AddAllowedIP adds cidr to the allowed IPs of the named peer. The device is
+// updated incrementally, so routes already assigned to the peer are kept.
+// With ManageRoutes the route for cidr is added too. A quarantined peer only
+// gets cidr in the configuration, the device follows once it is released.
+func (w *WgMesh) AddAllowedIP(peer, cidr string) error {
+	w.applyMu.Lock()
+	defer w.applyMu.Unlock()
+
+	config := w.currentConfig()
+	if err := w.checkManaged(config); err != nil {
+		return err
+	}
+	idx := config.peerIndex(peer)
+	if idx < 0 {
+		return &UnknownPeerError{Name: peer}
+	}
+
+	ipNet, err := parseAllowedIP(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid allowed IP for peer %s: %w", peer, err)
+	}
+	cidr = ipNet.String()
+	if prefix := config.forbiddenPrefix(*ipNet); prefix != "" {
+		return fmt.Errorf("allowed IP %s of peer %s overlaps forbidden prefix %s", cidr, peer, prefix)
+	}
+
+	current := config.Peers[idx]
+	for _, existing := range current.AllowedIPs {
+		if existing == cidr {
+			return nil
+		}
+	}
+
+	pubKey, err := parseKey(current.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key for peer %s: %w", peer, err)
+	}
+
+	// The route comes first, so a failure leaves neither device nor
+	// configuration changed
+	route := Peer{Name: peer, AllowedIPs: []string{cidr}, RouteMetric: current.RouteMetric}
+	if err := w.addRoutes(config, route); err != nil {
+		return err
+	}
+
+	if !w.isQuarantined(peer) {
+		cfg := wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{{
+				PublicKey:  pubKey,
+				UpdateOnly: true,
+				AllowedIPs: []net.IPNet{*ipNet},
+			}},
+		}
+		if err := w.deviceClient().ConfigureDevice(config.NetworkName, cfg); err != nil {
+			if err := w.delRoutes(config, route); err != nil {
+				log.Warn().Err(err).Msg("Failed to remove routes of peer: " + peer)
+			}
+			return fmt.Errorf("failed to add allowed IP %s to peer %s: %w", cidr, peer, err)
+		}
+	}
+
+	allowedIPs := make([]string, 0, len(current.AllowedIPs)+1)
+	allowedIPs = append(allowedIPs, current.AllowedIPs...)
+	w.setConfig(config.withAllowedIPs(idx, append(allowedIPs, cidr)))
+
+	log.Info().Str("peer", peer).Str("cidr", cidr).Msg("Added allowed IP")
+	return nil
+}
+
+// RemoveAllowedIP removes cidr from the allowed IPs of the named peer. WireGuard
+// can't drop a single allowed IP, so the remaining list replaces the old one.
+// With ManageRoutes the route for cidr is removed too. The device keeps a
+// quarantined peer without allowed IPs until it is released.
+func (w *WgMesh) RemoveAllowedIP(peer, cidr string) error {
+	w.applyMu.Lock()
+	defer w.applyMu.Unlock()
+
+	config := w.currentConfig()
+	if err := w.checkManaged(config); err != nil {
+		return err
+	}
+	idx := config.peerIndex(peer)
+	if idx < 0 {
+		return &UnknownPeerError{Name: peer}
+	}
+
+	ipNet, err := parseAllowedIP(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid allowed IP for peer %s: %w", peer, err)
+	}
+	cidr = ipNet.String()
+
+	current := config.Peers[idx]
+	remaining := make([]string, 0, len(current.AllowedIPs))
+	for _, existing := range current.AllowedIPs {
+		if existing != cidr {
+			remaining = append(remaining, existing)
+		}
+	}
+	if len(remaining) == len(current.AllowedIPs) {
+		return fmt.Errorf("peer %s has no allowed IP %s", peer, cidr)
+	}
+
+	pubKey, err := parseKey(current.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key for peer %s: %w", peer, err)
+	}
+
+	allowedIPs, err := (Peer{Name: peer, AllowedIPs: remaining}).ParsedAllowedIPs()
+	if err != nil {
+		return err
+	}
+
+	if !w.isQuarantined(peer) {
+		cfg := wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{{
+				PublicKey:         pubKey,
+				UpdateOnly:        true,
+				ReplaceAllowedIPs: true,
+				AllowedIPs:        allowedIPs,
+			}},
+		}
+		if err := w.deviceClient().ConfigureDevice(config.NetworkName, cfg); err != nil {
+			return fmt.Errorf("failed to remove allowed IP %s from peer %s: %w", cidr, peer, err)
+		}
+	}
+
+	w.setConfig(config.withAllowedIPs(idx, remaining))
+
+	if err := w.delRoutes(config, Peer{Name: peer, AllowedIPs: []string{cidr}, RouteMetric: current.RouteMetric}); err != nil {
+		return err
+	}
+
+	log.Info().Str("peer", peer).Str("cidr", cidr).Msg("Removed allowed IP")
+	return nil
+}
+
+// UnknownPeerError is returned by operations on a single peer when no peer
+// of that name is configured.
+type UnknownPeerError struct {
+	Name string
+}
+
+func (e *UnknownPeerError) Error() string {
+	return "unknown peer " + e.Name
+}
+
+// peerIndex returns the index of the named peer in c.Peers, or -1.
+func (c *Config) peerIndex(name string) int {
+	for i, peer := range c.Peers {
+		if peer.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// withAllowedIPs returns a copy of c in which the peer at idx has the given
+// allowed IPs. c itself is shared with readers of the active configuration
+// and must not change.
+func (c *Config) withAllowedIPs(idx int, allowedIPs []string) *Config {
+	next := *c
+	next.Peers = slices.Clone(c.Peers)
+	next.Peers[idx].AllowedIPs = allowedIPs
+	return &next
+}
+
+// allowedIPCache holds parsed allowed IPs by their configured string. The
+// same strings are parsed on every reload and reconcile, and the set of
+// distinct values in a config is small. Values of removed peers would still
+// pile up in a long-running daemon, so the cache starts over once it holds
+// maxAllowedIPCache of them.
+var allowedIPCache struct {
+	sync.Mutex
+	nets map[string]*net.IPNet
+}
+
+const maxAllowedIPCache = 4096
+
+// ParsedAllowedIPs returns the allowed IPs of the peer as networks. A bare
+// address is taken as a single host (/32 or /128).
+func (p Peer) ParsedAllowedIPs() ([]net.IPNet, error) {
+	nets := make([]net.IPNet, 0, len(p.AllowedIPs))
+	for _, ip := range p.AllowedIPs {
+		ipNet, err := parseAllowedIP(ip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed IP for peer %s: %w", p.Name, err)
+		}
+		nets = append(nets, *ipNet)
+	}
+	return nets, nil
+}
+
+func parseAllowedIP(s string) (*net.IPNet, error) {
+	allowedIPCache.Lock()
+	cached, ok := allowedIPCache.nets[s]
+	allowedIPCache.Unlock()
+	if ok {
+		return cloneIPNet(cached), nil
+	}
+
+	var ipNet *net.IPNet
+	if strings.Contains(s, "/") {
+		_, parsed, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid CIDR", s)
+		}
+		ipNet = parsed
+	} else {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%q is not a valid IP address or CIDR", s)
+		}
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+	}
+
+	allowedIPCache.Lock()
+	if allowedIPCache.nets == nil || len(allowedIPCache.nets) >= maxAllowedIPCache {
+		allowedIPCache.nets = make(map[string]*net.IPNet)
+	}
+	allowedIPCache.nets[s] = ipNet
+	allowedIPCache.Unlock()
+	return cloneIPNet(ipNet), nil
+}
+
+// cloneIPNet copies n so callers can't modify cached values.
+func cloneIPNet(n *net.IPNet) *net.IPNet {
+	return &net.IPNet{
+		IP:   append(net.IP(nil), n.IP...),
+		Mask: append(net.IPMask(nil), n.Mask...),
+	}
+}
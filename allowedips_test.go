@@ -0,0 +1,180 @@
+package wgmesh_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const allowedIPsConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    ip: 10.0.0.1/24
+    public_key: a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA=
+    allowed_ips: ["10.0.0.0/24"]
+`
+
+func TestAddAllowedIP(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, allowedIPsConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, mesh.AddAllowedIP("peer1", "192.168.10.0/24"))
+
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	require.Len(t, calls[0].Peers, 1)
+	peerCfg := calls[0].Peers[0]
+	assert.True(t, peerCfg.UpdateOnly)
+	assert.False(t, peerCfg.ReplaceAllowedIPs)
+	require.Len(t, peerCfg.AllowedIPs, 1)
+	assert.Equal(t, "192.168.10.0/24", peerCfg.AllowedIPs[0].String())
+
+	assert.Equal(t, []string{"10.0.0.0/24", "192.168.10.0/24"}, mesh.Config.Peers[0].AllowedIPs)
+}
+
+func TestAddAllowedIPValidation(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, allowedIPsConfig+"forbidden_allowed_ips: [172.16.0.0/12]\n")
+
+	assert.Error(t, mesh.AddAllowedIP("missing", "192.168.10.0/24"))
+	assert.Error(t, mesh.AddAllowedIP("peer1", "not-a-cidr"))
+	assert.EqualError(t, mesh.AddAllowedIP("peer1", "172.20.0.0/16"), "allowed IP 172.20.0.0/16 of peer peer1 overlaps forbidden prefix 172.16.0.0/12")
+	mockClient.AssertNotCalled(t, "ConfigureDevice", mock.Anything, mock.Anything)
+}
+
+func TestRemoveAllowedIP(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, allowedIPsConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, mesh.AddAllowedIP("peer1", "192.168.10.0/24"))
+	require.NoError(t, mesh.RemoveAllowedIP("peer1", "10.0.0.0/24"))
+
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 2)
+	peerCfg := calls[1].Peers[0]
+	assert.True(t, peerCfg.UpdateOnly)
+	assert.True(t, peerCfg.ReplaceAllowedIPs)
+	require.Len(t, peerCfg.AllowedIPs, 1)
+	assert.Equal(t, "192.168.10.0/24", peerCfg.AllowedIPs[0].String())
+
+	assert.Equal(t, []string{"192.168.10.0/24"}, mesh.Config.Peers[0].AllowedIPs)
+	assert.Error(t, mesh.RemoveAllowedIP("peer1", "10.0.0.0/24"))
+}
+
+func TestAllowedIPCopiesConfig(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, allowedIPsConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	// Readers holding the previous configuration don't see it change
+	before := mesh.Config
+	require.NoError(t, mesh.AddAllowedIP("peer1", "192.168.10.0/24"))
+	assert.Equal(t, []string{"10.0.0.0/24"}, before.Peers[0].AllowedIPs)
+
+	during := mesh.Config
+	require.NoError(t, mesh.RemoveAllowedIP("peer1", "10.0.0.0/24"))
+	assert.Equal(t, []string{"10.0.0.0/24", "192.168.10.0/24"}, during.Peers[0].AllowedIPs)
+	assert.Equal(t, []string{"192.168.10.0/24"}, mesh.Config.Peers[0].AllowedIPs)
+}
+
+func TestAllowedIPRoutes(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, allowedIPsConfig+"manage_routes: true\nroute_metric: 100\n")
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, mesh.AddAllowedIP("peer1", "192.168.10.0/24"))
+	require.NoError(t, mesh.RemoveAllowedIP("peer1", "192.168.10.0/24"))
+
+	assert.Equal(t, []string{
+		"ip route replace 192.168.10.0/24 dev wg0 metric 100",
+		"ip route del 192.168.10.0/24 dev wg0 metric 100",
+	}, runner.Commands())
+}
+
+func TestAllowedIPRouteFailure(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, allowedIPsConfig+"manage_routes: true\n")
+	mesh.CommandRunner = &fakeRunner{fail: map[string]error{
+		"ip route replace 192.168.10.0/24 dev wg0": errors.New("exit status 2"),
+	}}
+
+	// Without its route the allowed IP reaches neither device nor configuration
+	assert.Error(t, mesh.AddAllowedIP("peer1", "192.168.10.0/24"))
+	assert.Equal(t, []string{"10.0.0.0/24"}, mesh.Config.Peers[0].AllowedIPs)
+	mockClient.AssertNotCalled(t, "ConfigureDevice", mock.Anything, mock.Anything)
+}
+
+func TestAllowedIPQuarantined(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, allowedIPsConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	require.NoError(t, mesh.QuarantinePeer("peer1"))
+
+	// The device keeps the peer cut off, only the configuration changes
+	require.NoError(t, mesh.AddAllowedIP("peer1", "192.168.10.0/24"))
+	require.NoError(t, mesh.RemoveAllowedIP("peer1", "10.0.0.0/24"))
+	assert.Len(t, configureCalls(mockClient), 1)
+	assert.Equal(t, []string{"192.168.10.0/24"}, mesh.Config.Peers[0].AllowedIPs)
+	assert.True(t, mesh.GetStatus().Peers["peer1"].Quarantined)
+
+	require.NoError(t, mesh.UnquarantinePeer("peer1"))
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 2)
+	assert.Equal(t, "192.168.10.0/24", calls[1].Peers[0].AllowedIPs[0].String())
+}
+
+func TestAllowedIPObserveOnly(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, allowedIPsConfig+"observe_only: true\n")
+
+	assert.ErrorIs(t, mesh.AddAllowedIP("peer1", "192.168.10.0/24"), wgmesh.ErrUnmanagedDevice)
+	assert.ErrorIs(t, mesh.RemoveAllowedIP("peer1", "10.0.0.0/24"), wgmesh.ErrUnmanagedDevice)
+	mockClient.AssertNotCalled(t, "ConfigureDevice", mock.Anything, mock.Anything)
+}
+
+func TestParsedAllowedIPs(t *testing.T) {
+	peer := wgmesh.Peer{
+		Name:       "peer1",
+		AllowedIPs: []string{"10.0.0.0/24", "fd00::/64", "192.168.1.7", "fd00::7", "10.1.2.3/16"},
+	}
+
+	nets, err := peer.ParsedAllowedIPs()
+	require.NoError(t, err)
+
+	got := make([]string, 0, len(nets))
+	for _, n := range nets {
+		got = append(got, n.String())
+	}
+	assert.Equal(t, []string{"10.0.0.0/24", "fd00::/64", "192.168.1.7/32", "fd00::7/128", "10.1.0.0/16"}, got)
+
+	// Modifying the result doesn't affect later calls
+	nets[0].IP[0] = 99
+	again, err := peer.ParsedAllowedIPs()
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.0/24", again[0].String())
+}
+
+func TestParsedAllowedIPsInvalid(t *testing.T) {
+	peer := wgmesh.Peer{Name: "peer1", AllowedIPs: []string{"10.0.0.0/24", "10.0.0.300/32"}}
+
+	_, err := peer.ParsedAllowedIPs()
+	assert.EqualError(t, err, `invalid allowed IP for peer peer1: "10.0.0.300/32" is not a valid CIDR`)
+
+	peer.AllowedIPs = []string{"gateway"}
+	_, err = peer.ParsedAllowedIPs()
+	assert.EqualError(t, err, `invalid allowed IP for peer peer1: "gateway" is not a valid IP address or CIDR`)
+}
+
+func TestParsedAllowedIPsCacheBounded(t *testing.T) {
+	// Allowed IPs of peers coming and going don't accumulate
+	for i := range 10000 {
+		peer := wgmesh.Peer{Name: "peer", AllowedIPs: []string{fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)}}
+		_, err := peer.ParsedAllowedIPs()
+		require.NoError(t, err)
+		require.LessOrEqual(t, wgmesh.AllowedIPCacheLen(), 4096)
+	}
+}
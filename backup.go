@@ -0,0 +1,165 @@
+package wgmesh
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultBackupFileMode is used when Config.BackupFileMode is unset, as the
+// files contain private keys.
+const defaultBackupFileMode FileMode = 0o600
+
+// FileMode is a file permission written in octal in the configuration, e.g.
+// "0640".
+type FileMode uint32
+
+func (m FileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(m))
+}
+
+func (m *FileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	mode, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid file mode %q: must be octal", s)
+	}
+	*m = FileMode(mode)
+	return nil
+}
+
+func (m FileMode) MarshalYAML() (interface{}, error) {
+	return m.String(), nil
+}
+
+// writeConfigFile atomically writes data to path with the backup mode and
+// ownership of cfg.
+func writeConfigFile(path string, data []byte, cfg *Config) error {
+	mode := cfg.BackupFileMode
+	if mode == 0 {
+		mode = defaultBackupFileMode
+	}
+	if mode&0o004 != 0 && cfg.hasPrivateKeys() {
+		log.Warn().
+			Str("path", path).
+			Stringer("mode", mode).
+			Msg("Backup file mode makes private keys world-readable")
+	}
+
+	return writeFileAtomic(path, data, os.FileMode(mode), func(f *os.File) error {
+		return chownBackup(f, cfg)
+	})
+}
+
+// renameFile moves a completely written temporary file into place. Tests
+// replace it to inspect the temporary file.
+var renameFile = os.Rename
+
+// writeFileAtomic writes data to a temporary file in the directory of path
+// and renames it into place, so readers (the config watcher among them)
+// never see a partial file, even after a crash. setup, if not nil, can adjust
+// the temporary file before the rename.
+func writeFileAtomic(path string, data []byte, mode os.FileMode, setup func(*os.File) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// The mode is applied explicitly so it isn't narrowed by the umask
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if setup != nil {
+		if err := setup(tmp); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return renameFile(tmp.Name(), path)
+}
+
+// chownBackup applies the backup ownership of cfg to f, if any.
+func chownBackup(f *os.File, cfg *Config) error {
+	if cfg.BackupOwner == "" && cfg.BackupGroup == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	if cfg.BackupOwner != "" {
+		id, err := lookupID(cfg.BackupOwner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("unknown backup owner %q: %w", cfg.BackupOwner, err)
+		}
+		uid = id
+	}
+	if cfg.BackupGroup != "" {
+		id, err := lookupID(cfg.BackupGroup, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("unknown backup group %q: %w", cfg.BackupGroup, err)
+		}
+		gid = id
+	}
+
+	return f.Chown(uid, gid)
+}
+
+// lookupID returns name as a numeric ID, resolving it with lookup unless it
+// already is one.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+func (c *Config) hasPrivateKeys() bool {
+	if c.PrivateKey != "" {
+		return true
+	}
+	for _, peer := range c.Peers {
+		if peer.PrivateKey != "" || peer.PresharedKey != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package wgmesh_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCurrentConfigMode(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		want os.FileMode
+	}{
+		{name: "default", want: 0o600},
+		{name: "group readable", mode: "backup_file_mode: 0640", want: 0o640},
+		{name: "0o prefix", mode: "backup_file_mode: 0o640", want: 0o640},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh, _ := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+`+tt.mode+`
+peers: []
+`)
+
+			path := filepath.Join(t.TempDir(), "backup.yaml")
+			// An existing file gets the configured mode as well
+			require.NoError(t, os.WriteFile(path, nil, 0o644))
+			require.NoError(t, mesh.WriteCurrentConfig(path))
+
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, info.Mode().Perm())
+		})
+	}
+}
+
+func TestWriteCurrentConfigOwner(t *testing.T) {
+	mesh, _ := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+backup_owner: "`+strconv.Itoa(os.Getuid())+`"
+backup_group: "`+strconv.Itoa(os.Getgid())+`"
+peers: []
+`)
+
+	path := filepath.Join(t.TempDir(), "backup.yaml")
+	require.NoError(t, mesh.WriteCurrentConfig(path))
+
+	cfg, err := (&wgmesh.FileConfigSource{Path: path}).Load()
+	require.NoError(t, err)
+	assert.Equal(t, wgmesh.FileMode(0), cfg.BackupFileMode)
+	assert.Equal(t, strconv.Itoa(os.Getuid()), cfg.BackupOwner)
+
+	mesh.Config.BackupOwner = "no-such-user-wgmesh"
+	assert.ErrorContains(t, mesh.WriteCurrentConfig(path), "unknown backup owner")
+}
+
+func TestBackupFileModeRoundTrip(t *testing.T) {
+	cfg, err := loadConfig(t, `
+network_name: wg0
+backup_file_mode: 0640
+`)
+	require.NoError(t, err)
+	assert.Equal(t, wgmesh.FileMode(0o640), cfg.BackupFileMode)
+	assert.Equal(t, "0640", cfg.BackupFileMode.String())
+
+	cfg.BackupFileMode = 0o1777
+	assert.ErrorContains(t, cfg.Validate(), "invalid backup file mode")
+}
+
+func TestWriteCurrentConfigAtomic(t *testing.T) {
+	mesh, _ := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("old content\n"), 0o600))
+
+	renamed := false
+	restore := wgmesh.SetRenameFile(func(oldpath, newpath string) error {
+		renamed = true
+		assert.Equal(t, path, newpath)
+		assert.Equal(t, dir, filepath.Dir(oldpath), "temporary file must be on the same filesystem")
+
+		// Until the rename the old file is untouched...
+		current, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "old content\n", string(current))
+
+		// ...while the temporary file is already complete
+		tmp, err := (&wgmesh.WgMesh{}).LoadConfig(oldpath)
+		require.NoError(t, err)
+		assert.Equal(t, mesh.Config.Peers, tmp.Peers)
+
+		info, err := os.Stat(oldpath)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		return os.Rename(oldpath, newpath)
+	})
+	defer restore()
+
+	require.NoError(t, mesh.WriteCurrentConfig(path))
+	assert.True(t, renamed)
+
+	written, err := (&wgmesh.WgMesh{}).LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, mesh.Config.Peers, written.Peers)
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
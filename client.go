@@ -0,0 +1,70 @@
+package wgmesh
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// defaultClientTimeout is used when Config.ClientTimeout is unset.
+const defaultClientTimeout = 30 * time.Second
+
+// timeoutClient bounds every call of the wrapped client. wgctrl has no
+// context support, so a hung call is left running in its goroutine and
+// context.DeadlineExceeded is returned instead.
+type timeoutClient struct {
+	client  WireGuardClient
+	timeout time.Duration
+}
+
+// deviceClient returns the client bounded by the configured timeout. The
+// mesh uses it for all device reads and writes.
+func (w *WgMesh) deviceClient() WireGuardClient {
+	timeout := w.currentConfig().ClientTimeout
+	if timeout <= 0 {
+		timeout = defaultClientTimeout
+	}
+	return timeoutClient{client: w.Client(), timeout: timeout}
+}
+
+func (c timeoutClient) Device(name string) (*wgtypes.Device, error) {
+	var device *wgtypes.Device
+	err := c.call("read device "+name, func() error {
+		var err error
+		device, err = c.client.Device(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+func (c timeoutClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	return c.call("configure device "+name, func() error {
+		return c.client.ConfigureDevice(name, cfg)
+	})
+}
+
+func (c timeoutClient) Close() error {
+	return c.client.Close()
+}
+
+func (c timeoutClient) call(op string, fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("failed to %s within %s: %w", op, c.timeout, ctx.Err())
+	}
+}
@@ -0,0 +1,64 @@
+package wgmesh_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// blockingClient never answers, like a hung kernel module.
+type blockingClient struct {
+	release chan struct{}
+}
+
+func (c *blockingClient) Device(name string) (*wgtypes.Device, error) {
+	<-c.release
+	return &wgtypes.Device{}, nil
+}
+
+func (c *blockingClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	<-c.release
+	return nil
+}
+
+func (c *blockingClient) Close() error { return nil }
+
+func TestClientTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+client_timeout: 20ms
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600))
+
+	client := &blockingClient{release: make(chan struct{})}
+	defer close(client.release)
+
+	mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(client))
+	require.NoError(t, err)
+	defer mesh.Close()
+
+	start := time.Now()
+	err = mesh.StartTunnel()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	_, err = mesh.DetectDrift()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The caller's client is still handed out unwrapped
+	assert.Same(t, client, mesh.Client())
+}
@@ -0,0 +1,105 @@
+package wgmesh
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ClientConfig renders a wg-quick configuration for the named peer to
+// connect to this node, e.g. to import on a phone or render as a QR code. The
+// peer must have its private key and ip in the configuration. serverPubKey
+// defaults to this node's public key. The client routes the network of the
+// local address and the allowed IPs of all other peers through this node.
+func (w *WgMesh) ClientConfig(peerName string, serverPubKey, serverEndpoint string) (string, error) {
+	cfg := w.currentConfig()
+
+	idx := slices.IndexFunc(cfg.Peers, func(p Peer) bool { return p.Name == peerName })
+	if idx < 0 {
+		return "", &UnknownPeerError{Name: peerName}
+	}
+	peer := cfg.Peers[idx]
+
+	if peer.PrivateKey == "" {
+		return "", fmt.Errorf("peer %s has no private key in the configuration", peerName)
+	}
+	privateKey, err := parseKey(peer.PrivateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key for peer %s: %w", peerName, err)
+	}
+	var presharedKey *wgtypes.Key
+	if peer.PresharedKey != "" {
+		key, err := parseKey(peer.PresharedKey)
+		if err != nil {
+			return "", fmt.Errorf("invalid preshared key for peer %s: %w", peerName, err)
+		}
+		presharedKey = &key
+	}
+	if peer.IP == "" {
+		return "", fmt.Errorf("peer %s has no ip in the configuration", peerName)
+	}
+	if serverEndpoint == "" {
+		return "", fmt.Errorf("server endpoint is required")
+	}
+	if serverPubKey == "" {
+		key, err := w.LocalPublicKey()
+		if err != nil {
+			return "", err
+		}
+		serverPubKey = key
+	}
+
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", privateKey)
+	fmt.Fprintf(&b, "Address = %s\n", peer.IP)
+	if len(cfg.DNS) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(cfg.DNS, ", "))
+	}
+	if cfg.MTU > 0 {
+		fmt.Fprintf(&b, "MTU = %d\n", cfg.MTU)
+	}
+
+	b.WriteString("\n[Peer]\n")
+	fmt.Fprintf(&b, "PublicKey = %s\n", serverPubKey)
+	if presharedKey != nil {
+		fmt.Fprintf(&b, "PresharedKey = %s\n", presharedKey)
+	}
+	fmt.Fprintf(&b, "Endpoint = %s\n", serverEndpoint)
+	if allowed := cfg.clientAllowedIPs(peerName); len(allowed) > 0 {
+		fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(allowed, ", "))
+	}
+	if keepalive := peer.keepalive(); keepalive > 0 {
+		fmt.Fprintf(&b, "PersistentKeepalive = %d\n", keepalive)
+	}
+
+	return b.String(), nil
+}
+
+// clientAllowedIPs returns what a client connecting as peerName reaches
+// through this node: the network of the local address and the allowed IPs of
+// every other peer.
+func (c *Config) clientAllowedIPs(peerName string) []string {
+	var allowed []string
+	add := func(cidr string) {
+		if !slices.Contains(allowed, cidr) {
+			allowed = append(allowed, cidr)
+		}
+	}
+
+	if _, network, err := net.ParseCIDR(c.Address); err == nil {
+		add(network.String())
+	}
+	for _, peer := range c.Peers {
+		if peer.Name == peerName {
+			continue
+		}
+		for _, cidr := range peer.AllowedIPs {
+			add(cidr)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,63 @@
+package wgmesh_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const clientConfigYAML = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+address: 10.0.0.1/24
+dns: ["10.0.0.1"]
+peers:
+  - name: phone
+    ip: 10.0.0.2/32
+    private_key: mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    nat: true
+  - name: office
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32", "192.168.1.0/24"]
+    endpoint: "office.example.com:51820"
+`
+
+func TestClientConfig(t *testing.T) {
+	mesh, _ := newTestMesh(t, clientConfigYAML)
+
+	conf, err := mesh.ClientConfig("phone", "", "vpn.example.com:51820")
+	require.NoError(t, err)
+	assert.Equal(t, `[Interface]
+PrivateKey = mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=
+Address = 10.0.0.2/32
+DNS = 10.0.0.1
+
+[Peer]
+PublicKey = a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA=
+Endpoint = vpn.example.com:51820
+AllowedIPs = 10.0.0.0/24, 10.0.0.3/32, 192.168.1.0/24
+PersistentKeepalive = 25
+`, conf)
+
+	t.Run("explicit server key", func(t *testing.T) {
+		conf, err := mesh.ClientConfig("phone", "WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=", "203.0.113.1:51820")
+		require.NoError(t, err)
+		assert.Contains(t, conf, "PublicKey = WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=\n")
+		assert.Contains(t, conf, "Endpoint = 203.0.113.1:51820\n")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := mesh.ClientConfig("missing", "", "vpn.example.com:51820")
+		assert.EqualError(t, err, "unknown peer missing")
+
+		_, err = mesh.ClientConfig("office", "", "vpn.example.com:51820")
+		assert.EqualError(t, err, "peer office has no private key in the configuration")
+
+		_, err = mesh.ClientConfig("phone", "", "")
+		assert.EqualError(t, err, "server endpoint is required")
+	})
+}
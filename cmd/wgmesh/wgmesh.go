@@ -1,38 +1,113 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"syscall"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	"github.com/pilab-cloud/wgmesh"
 )
 
+// Build information, set with -ldflags "-X main.Version=...".
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
 var (
-	Version     = "dev"
 	showVersion = flag.Bool("version", false, "Show version information")
+	once        = flag.Bool("once", false, "Apply the configuration once, print the status and exit (0 when the mesh is up)")
+	pprof       = flag.Bool("pprof", false, "Serve pprof profiles on the HTTP server (127.0.0.1:9586 unless http_listen is set)")
+	strict      = flag.Bool("strict", false, "Reject configuration files with unknown keys, like strict_yaml")
+	allowRemove = flag.Bool("allow-peer-removal", false, "Apply reloads removing more peers than max_peer_removal_percent")
 )
 
 func main() {
 	flag.Parse()
 
 	if *showVersion {
-		fmt.Printf("wgmesh version %s\n", Version)
+		fmt.Println(versionString())
 		os.Exit(0)
 	}
 
-	if len(os.Args) < 2 {
-		println("Usage: wgmesh [config_file]")
+	if flag.NArg() < 1 {
+		println("Usage: wgmesh [-once] [-pprof] [-strict] [-allow-peer-removal] [config_file]")
+		println("       wgmesh <config_dir>")
+		println("       wgmesh status|reload|list|backups|drift <config_file>")
+		println("       wgmesh lint|pubkey|diag|render|graph <config_file>")
+		println("       wgmesh client-config <config_file> <peer> <server_endpoint>")
+		println("       wgmesh rotate-psk <config_file> <peer>")
+		println("       wgmesh diff <old_config_file> <new_config_file>")
+		println("       wgmesh restore <config_file> <backup>")
+		println("       wgmesh version")
 		os.Exit(1)
 	}
 
-	configFile := os.Args[1]
+	switch flag.Arg(0) {
+	case "version":
+		fmt.Println(versionString())
+		return
+	case "drift":
+		os.Exit(runDrift(flag.Args()[1:]))
+	case "lint":
+		os.Exit(runLint(flag.Args()[1:]))
+	case "pubkey":
+		os.Exit(runPubkey(os.Stdout, flag.Args()[1:]))
+	case "diag":
+		os.Exit(runDiag(os.Stdout, flag.Args()[1:]))
+	case "render":
+		os.Exit(runRender(os.Stdout, flag.Args()[1:]))
+	case "graph":
+		os.Exit(runGraph(os.Stdout, flag.Args()[1:]))
+	case "client-config":
+		os.Exit(runClientConfig(os.Stdout, flag.Args()[1:]))
+	case "rotate-psk":
+		os.Exit(runRotatePSK(os.Stdout, flag.Args()[1:]))
+	case "diff":
+		os.Exit(runDiff(os.Stdout, flag.Args()[1:]))
+	case "restore":
+		os.Exit(runRestore(os.Stdout, flag.Args()[1:]))
+	case "status", "reload", "list", "backups":
+		os.Exit(runControl(flag.Arg(0), flag.Args()[1:]))
+	}
+
+	configFile := flag.Arg(0)
+
+	var opts []wgmesh.Option
+	if *pprof {
+		opts = append(opts, wgmesh.WithPprof())
+	}
+	if *strict {
+		opts = append(opts, wgmesh.WithStrictYAML())
+	}
+	if *allowRemove {
+		opts = append(opts, wgmesh.WithAllowPeerRemoval())
+	}
+
+	if *once {
+		os.Exit(runOnce(os.Stdout, configFile, opts...))
+	}
 
-	mesh, err := wgmesh.NewWgMesh(configFile)
+	if info, err := os.Stat(configFile); err == nil && info.IsDir() {
+		os.Exit(runDir(configFile, opts...))
+	}
+
+	mesh, err := wgmesh.NewWgMesh(configFile, opts...)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create wgmesh")
 		os.Exit(1)
@@ -50,4 +125,440 @@ func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	<-c
+
+	log.Info().Msg("shutting down")
+	if err := mesh.Shutdown(); err != nil {
+		log.Error().Err(err).Msg("failed to shut down wgmesh")
+	}
+}
+
+// runDir manages one mesh per configuration file in dir until SIGINT or
+// SIGTERM. opts are passed to every mesh.
+func runDir(dir string, opts ...wgmesh.Option) int {
+	manager := wgmesh.NewDirManager(dir, opts...)
+	if err := manager.Start(); err != nil {
+		log.Error().Err(err).Msg("failed to start wgmesh")
+		return 1
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	<-c
+
+	log.Info().Msg("shutting down")
+	if err := manager.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to stop meshes")
+		return 1
+	}
+	return 0
+}
+
+// runOnce applies the configuration a single time without starting the
+// daemon loops and prints the resulting status. It returns 0 when the mesh
+// is up and 1 otherwise.
+func runOnce(out io.Writer, configFile string, opts ...wgmesh.Option) int {
+	mesh, err := wgmesh.NewWgMesh(configFile, opts...)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create wgmesh")
+		return 1
+	}
+	defer mesh.Close()
+
+	status, err := mesh.RunOnce()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to apply configuration")
+		return 1
+	}
+
+	fmt.Fprintf(out, "%s: %s\n", status.NetworkName, status.Status)
+	names := make([]string, 0, len(status.Peers))
+	for name := range status.Peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		peer := status.Peers[name]
+		fmt.Fprintf(out, "  %s: %s", name, peer.State)
+		if peer.Error != "" {
+			fmt.Fprintf(out, " (%s)", peer.Error)
+		}
+		fmt.Fprintln(out)
+	}
+
+	if status.Status != wgmesh.MeshStateUp {
+		return 1
+	}
+	return 0
+}
+
+// runLint validates a configuration file and prints the errors and warnings
+// found, resolving the endpoint hostnames to check where they point. It
+// exits with 2 when there are only warnings.
+func runLint(args []string) int {
+	if len(args) != 1 {
+		println("Usage: wgmesh lint <config_file>")
+		return 1
+	}
+
+	// The warnings are printed below, don't log them as well
+	log.Logger = log.Level(zerolog.ErrorLevel)
+
+	cfg, err := (&wgmesh.FileConfigSource{Path: args[0], Strict: *strict}).Load()
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 1
+	}
+
+	failed := false
+	if err := cfg.Validate(); err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Printf("error: %s\n", line)
+		}
+		failed = true
+	}
+
+	warnings := cfg.LintResolved(context.Background(), nil)
+	for _, warning := range warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+
+	switch {
+	case failed:
+		return 1
+	case len(warnings) > 0:
+		return 2
+	}
+	fmt.Println("configuration OK")
+	return 0
+}
+
+// runPubkey prints the public key of the node, for adding it as a peer
+// elsewhere.
+func runPubkey(out io.Writer, args []string, opts ...wgmesh.Option) int {
+	if len(args) != 1 {
+		println("Usage: wgmesh pubkey <config_file>")
+		return 1
+	}
+
+	mesh, err := wgmesh.NewWgMesh(args[0], opts...)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create wgmesh")
+		return 1
+	}
+	defer mesh.Close()
+
+	key, err := mesh.LocalPublicKey()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get public key")
+		return 1
+	}
+
+	fmt.Fprintln(out, key)
+	return 0
+}
+
+// runRender prints the device configuration the config file translates to,
+// without applying it.
+func runRender(out io.Writer, args []string, opts ...wgmesh.Option) int {
+	if len(args) != 1 {
+		println("Usage: wgmesh render <config_file>")
+		return 1
+	}
+
+	mesh, err := wgmesh.NewWgMesh(args[0], opts...)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create wgmesh")
+		return 1
+	}
+	defer mesh.Close()
+
+	cfg, err := mesh.RenderDeviceConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to render device config")
+		return 1
+	}
+
+	fmt.Fprint(out, wgmesh.FormatDeviceConfig(cfg))
+	return 0
+}
+
+// runGraph prints the mesh described by a configuration as a Graphviz graph.
+func runGraph(out io.Writer, args []string) int {
+	if len(args) != 1 {
+		println("Usage: wgmesh graph <config_file>")
+		return 1
+	}
+
+	cfg, err := (&wgmesh.FileConfigSource{Path: args[0]}).Load()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load configuration")
+		return 1
+	}
+
+	fmt.Fprint(out, cfg.ToDOT())
+	return 0
+}
+
+// runDiff prints how the peers of two configuration files differ, e.g. the
+// running and a proposed one, without a daemon. Keys are redacted.
+func runDiff(out io.Writer, args []string) int {
+	if len(args) != 2 {
+		println("Usage: wgmesh diff <old_config_file> <new_config_file>")
+		return 1
+	}
+
+	var configs []*wgmesh.Config
+	for _, path := range args {
+		cfg, err := (&wgmesh.FileConfigSource{Path: path, Strict: *strict}).Load()
+		if err != nil {
+			log.Error().Err(err).Str("path", path).Msg("failed to load configuration")
+			return 1
+		}
+		configs = append(configs, cfg)
+	}
+
+	diff := wgmesh.FormatConfigDiff(configs[0], configs[1])
+	if diff == "" {
+		diff = "no differences\n"
+	}
+	fmt.Fprint(out, diff)
+	return 0
+}
+
+// runClientConfig prints a wg-quick configuration for a peer to connect to
+// this node, e.g. to pipe into qrencode -t ansiutf8.
+func runClientConfig(out io.Writer, args []string, opts ...wgmesh.Option) int {
+	if len(args) != 3 {
+		println("Usage: wgmesh client-config <config_file> <peer> <server_endpoint>")
+		return 1
+	}
+
+	mesh, err := wgmesh.NewWgMesh(args[0], opts...)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create wgmesh")
+		return 1
+	}
+	defer mesh.Close()
+
+	conf, err := mesh.ClientConfig(args[1], "", args[2])
+	if err != nil {
+		log.Error().Err(err).Msg("failed to generate client config")
+		return 1
+	}
+
+	fmt.Fprint(out, conf)
+	return 0
+}
+
+// runDiag writes a redacted snapshot of the runtime state for bug reports.
+// The running daemon is asked when reachable, as only it knows the status.
+func runDiag(out io.Writer, args []string, opts ...wgmesh.Option) int {
+	if len(args) != 1 {
+		println("Usage: wgmesh diag <config_file>")
+		return 1
+	}
+
+	if socket := controlSocket(args[0]); socket != "" {
+		var diag wgmesh.Diagnostics
+		if err := wgmesh.ControlCall(socket, "diag", &diag); err != nil {
+			log.Error().Err(err).Msg("failed to collect diagnostics")
+			return 1
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(diag); err != nil {
+			log.Error().Err(err).Msg("failed to write diagnostics")
+			return 1
+		}
+		return 0
+	}
+
+	mesh, err := wgmesh.NewWgMesh(args[0], opts...)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create wgmesh")
+		return 1
+	}
+	defer mesh.Close()
+
+	if err := mesh.ExportDiagnostics(out); err != nil {
+		log.Error().Err(err).Msg("failed to collect diagnostics")
+		return 1
+	}
+	return 0
+}
+
+// runDrift prints the differences between the device and the configuration.
+// It exits with 2 when drift is found so scripts can tell it from failures.
+func runDrift(args []string) int {
+	if len(args) != 1 {
+		println("Usage: wgmesh drift <config_file>")
+		return 1
+	}
+
+	var report wgmesh.DriftReport
+	if socket := controlSocket(args[0]); socket != "" {
+		// Ask the daemon so the report matches the config it has applied
+		if err := wgmesh.ControlCall(socket, "drift", &report); err != nil {
+			log.Error().Err(err).Msg("failed to detect drift")
+			return 1
+		}
+	} else {
+		mesh, err := wgmesh.NewWgMesh(args[0])
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create wgmesh")
+			return 1
+		}
+		defer mesh.Close()
+
+		report, err = mesh.DetectDrift()
+		if err != nil {
+			log.Error().Err(err).Msg("failed to detect drift")
+			return 1
+		}
+	}
+
+	fmt.Print(report.String())
+	if report.HasDrift() {
+		return 2
+	}
+	return 0
+}
+
+// runControl sends command to the running daemon and prints its reply.
+func runControl(command string, args []string) int {
+	if len(args) != 1 {
+		fmt.Printf("Usage: wgmesh %s <config_file>\n", command)
+		return 1
+	}
+
+	socket := controlSocket(args[0])
+	if socket == "" {
+		log.Error().Msg("daemon not reachable, is control_socket configured and wgmesh running?")
+		return 1
+	}
+
+	var result json.RawMessage
+	if err := wgmesh.ControlCall(socket, command, &result); err != nil {
+		log.Error().Err(err).Msgf("%s failed", command)
+		return 1
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, result, "", "  "); err != nil {
+		fmt.Println(string(result))
+		return 0
+	}
+	fmt.Println(out.String())
+	return 0
+}
+
+// runRotatePSK makes the running daemon replace the preshared key of a peer
+// and prints the new key, to be installed on the peer.
+func runRotatePSK(out io.Writer, args []string) int {
+	if len(args) != 2 {
+		println("Usage: wgmesh rotate-psk <config_file> <peer>")
+		return 1
+	}
+
+	socket := controlSocket(args[0])
+	if socket == "" {
+		log.Error().Msg("daemon not reachable, is control_socket configured and wgmesh running?")
+		return 1
+	}
+
+	var psk string
+	req := wgmesh.ControlRequest{Command: "rotate-psk", Peer: args[1]}
+	if err := wgmesh.ControlCallRequest(socket, req, &psk); err != nil {
+		log.Error().Err(err).Msg("rotate-psk failed")
+		return 1
+	}
+	fmt.Fprintln(out, psk)
+	return 0
+}
+
+// runRestore makes the named backup the configuration file again. A running
+// daemon applies it right away.
+func runRestore(out io.Writer, args []string, opts ...wgmesh.Option) int {
+	if len(args) != 2 {
+		println("Usage: wgmesh restore <config_file> <backup>")
+		return 1
+	}
+
+	if socket := controlSocket(args[0]); socket != "" {
+		req := wgmesh.ControlRequest{Command: "restore", Backup: args[1]}
+		if err := wgmesh.ControlCallRequest(socket, req, nil); err != nil {
+			log.Error().Err(err).Msg("restore failed")
+			return 1
+		}
+	} else {
+		mesh, err := wgmesh.NewWgMesh(args[0], opts...)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to create wgmesh")
+			return 1
+		}
+		defer mesh.Close()
+
+		if err := mesh.RestoreBackup(args[1]); err != nil {
+			log.Error().Err(err).Msg("restore failed")
+			return 1
+		}
+	}
+
+	fmt.Fprintf(out, "restored %s\n", args[1])
+	return 0
+}
+
+// controlSocket returns the control socket configured in configFile if a
+// daemon is listening on it, and "" otherwise.
+func controlSocket(configFile string) string {
+	cfg, err := (&wgmesh.FileConfigSource{Path: configFile}).Load()
+	if err != nil || cfg.ControlSocket == "" {
+		return ""
+	}
+
+	conn, err := net.Dial("unix", cfg.ControlSocket)
+	if err != nil {
+		return ""
+	}
+	conn.Close()
+	return cfg.ControlSocket
+}
+
+// versionString renders the build information. Values missing from the
+// linker flags are taken from the module build info when available.
+func versionString() string {
+	version, commit, date := Version, Commit, Date
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if commit == "" {
+					commit = setting.Value
+				}
+			case "vcs.time":
+				if date == "" {
+					date = setting.Value
+				}
+			}
+		}
+	}
+
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "wgmesh version %s\n", version)
+	fmt.Fprintf(&b, "  commit: %s\n", commit)
+	fmt.Fprintf(&b, "  built:  %s\n", date)
+	fmt.Fprintf(&b, "  go:     %s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return b.String()
 }
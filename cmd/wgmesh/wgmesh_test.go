@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestVersionString(t *testing.T) {
+	oldVersion, oldCommit, oldDate := Version, Commit, Date
+	defer func() { Version, Commit, Date = oldVersion, oldCommit, oldDate }()
+
+	Version, Commit, Date = "1.2.3", "abc1234", "2024-01-02T03:04:05Z"
+
+	out := versionString()
+	assert.Contains(t, out, "wgmesh version 1.2.3")
+	assert.Contains(t, out, "commit: abc1234")
+	assert.Contains(t, out, "built:  2024-01-02T03:04:05Z")
+	assert.Contains(t, out, "go:     "+runtime.Version())
+}
+
+// fakeClient serves a fixed device and accepts every configuration.
+type fakeClient struct {
+	device  *wgtypes.Device
+	configs []wgtypes.Config
+}
+
+func (c *fakeClient) Device(name string) (*wgtypes.Device, error) { return c.device, nil }
+func (c *fakeClient) Close() error                                { return nil }
+
+func (c *fakeClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	c.configs = append(c.configs, cfg)
+	return nil
+}
+
+func TestRunOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600))
+
+	key, err := wgtypes.ParseKey("236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")
+	require.NoError(t, err)
+	_, allowed, err := net.ParseCIDR("10.0.0.2/32")
+	require.NoError(t, err)
+	peer := wgtypes.Peer{PublicKey: key, AllowedIPs: []net.IPNet{*allowed}}
+
+	t.Run("healthy", func(t *testing.T) {
+		peer := peer
+		peer.LastHandshakeTime = time.Now()
+		client := &fakeClient{device: &wgtypes.Device{Peers: []wgtypes.Peer{peer}}}
+
+		var out bytes.Buffer
+		assert.Equal(t, 0, runOnce(&out, path, wgmesh.WithClient(client)))
+		assert.Equal(t, "wg0: up\n  peer1: up\n", out.String())
+		// Applied once, no drift to revert
+		assert.Len(t, client.configs, 1)
+	})
+
+	t.Run("unhealthy", func(t *testing.T) {
+		client := &fakeClient{device: &wgtypes.Device{Peers: []wgtypes.Peer{peer}}}
+
+		var out bytes.Buffer
+		assert.Equal(t, 1, runOnce(&out, path, wgmesh.WithClient(client)))
+		assert.Contains(t, out.String(), "peer1: configuring")
+	})
+}
+
+func TestRunPubkey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers: []
+`), 0o600))
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, runPubkey(&out, []string{path}, wgmesh.WithClient(&fakeClient{})))
+	assert.Equal(t, "a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA=\n", out.String())
+}
+
+func TestRunClientConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: phone
+    ip: 10.0.0.2/32
+    private_key: mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600))
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, runClientConfig(&out, []string{path, "phone", "vpn.example.com:51820"}, wgmesh.WithClient(&fakeClient{})))
+	assert.Contains(t, out.String(), "PrivateKey = mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=\n")
+	assert.Contains(t, out.String(), "PublicKey = a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA=\n")
+	assert.Contains(t, out.String(), "Endpoint = vpn.example.com:51820\n")
+
+	assert.Equal(t, 1, runClientConfig(&out, []string{path, "missing", "vpn.example.com:51820"}, wgmesh.WithClient(&fakeClient{})))
+}
+
+func TestRunRender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600))
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, runRender(&out, []string{path}, wgmesh.WithClient(&fakeClient{})))
+	assert.Contains(t, out.String(), "private key: (hidden)\n")
+	assert.Contains(t, out.String(), "peer: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=\n")
+	assert.NotContains(t, out.String(), "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=")
+}
+
+func TestRunGraph(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600))
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, runGraph(&out, []string{path}))
+	assert.Contains(t, out.String(), `"wg0" -- "peer1" [label="10.0.0.2/32"];`)
+	assert.NotContains(t, out.String(), "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=")
+}
+
+func TestRunDiff(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.yaml")
+	newPath := filepath.Join(dir, "new.yaml")
+	require.NoError(t, os.WriteFile(oldPath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    preshared_key: mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600))
+	require.NoError(t, os.WriteFile(newPath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    preshared_key: cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600))
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, runDiff(&out, []string{oldPath, newPath}))
+	assert.Equal(t, "~ peer1\n    PresharedKey: <redacted> -> <redacted>\n", out.String())
+
+	out.Reset()
+	assert.Equal(t, 0, runDiff(&out, []string{oldPath, oldPath}))
+	assert.Equal(t, "no differences\n", out.String())
+
+	assert.Equal(t, 1, runDiff(&out, []string{oldPath}))
+}
+
+func TestRunRestore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers: []
+`), 0o600))
+	backup := `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml.backup_20250101_120000"), []byte(backup), 0o600))
+
+	var out bytes.Buffer
+	assert.Equal(t, 0, runRestore(&out, []string{path, "config.yaml.backup_20250101_120000"}, wgmesh.WithClient(&fakeClient{})))
+	assert.Equal(t, "restored config.yaml.backup_20250101_120000\n", out.String())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, backup, string(data))
+
+	assert.Equal(t, 1, runRestore(&out, []string{path, "config.yaml.backup_20240101_000000"}, wgmesh.WithClient(&fakeClient{})))
+	assert.Equal(t, 1, runRestore(&out, []string{path}))
+}
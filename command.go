@@ -0,0 +1,52 @@
+package wgmesh
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CommandRunner runs external commands such as ip(8) and the user-defined
+// interface hooks.
+type CommandRunner interface {
+	Run(name string, args ...string) error
+}
+
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// runHook runs a single hook through the shell. Like wg-quick, %i is replaced
+// with the interface name.
+func (w *WgMesh) runHook(hook string) error {
+	return w.CommandRunner.Run("sh", "-c", strings.ReplaceAll(hook, "%i", w.Config.NetworkName))
+}
+
+// runHooks runs hooks in order and stops at the first failure.
+func (w *WgMesh) runHooks(hooks []string) error {
+	for _, hook := range hooks {
+		if err := w.runHook(hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAllHooks runs every hook even if some of them fail, returning the
+// combined errors.
+func (w *WgMesh) runAllHooks(hooks []string) error {
+	var errs []error
+	for _, hook := range hooks {
+		if err := w.runHook(hook); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,88 @@
+package wgmesh_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeRunner records the commands it is asked to run. Commands listed in
+// fail return an error.
+type fakeRunner struct {
+	mu       sync.Mutex
+	commands []string
+	fail     map[string]error
+}
+
+func (r *fakeRunner) Run(name string, args ...string) error {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, cmd)
+	return r.fail[cmd]
+}
+
+func (r *fakeRunner) Commands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+const hooksConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+address: 10.0.0.1/24
+pre_down: ["echo pre_down %i"]
+post_down: ["echo post_down %i", "echo done"]
+peers: []
+`
+
+func TestStopTunnelTeardownSequence(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, hooksConfig)
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", wgtypes.Config{ReplacePeers: true}).Return(nil)
+
+	require.NoError(t, mesh.StopTunnel())
+
+	assert.Equal(t, []string{
+		"sh -c echo pre_down wg0",
+		"ip address del 10.0.0.1/24 dev wg0",
+		"sh -c echo post_down wg0",
+		"sh -c echo done",
+	}, runner.Commands())
+	mockClient.AssertExpectations(t)
+}
+
+func TestStopTunnelContinuesOnError(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, hooksConfig)
+	runner := &fakeRunner{fail: map[string]error{
+		"sh -c echo pre_down wg0":            errors.New("hook failed"),
+		"ip address del 10.0.0.1/24 dev wg0": errors.New("no such address"),
+	}}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(errors.New("device busy"))
+
+	err := mesh.StopTunnel()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "hook failed")
+	assert.Contains(t, err.Error(), "device busy")
+	assert.Contains(t, err.Error(), "no such address")
+
+	// Every step still ran despite the failures
+	assert.Equal(t, []string{
+		"sh -c echo pre_down wg0",
+		"ip address del 10.0.0.1/24 dev wg0",
+		"sh -c echo post_down wg0",
+		"sh -c echo done",
+	}, runner.Commands())
+	mockClient.AssertNumberOfCalls(t, "ConfigureDevice", 1)
+}
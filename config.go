@@ -0,0 +1,159 @@
+package wgmesh
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+// PeerTemplate holds peer settings shared by every peer with the same role.
+type PeerTemplate struct {
+	AllowedIPs          []string `yaml:"allowed_ips,omitempty"`
+	PersistentKeepalive int      `yaml:"persistent_keepalive,omitempty"`
+	NAT                 bool     `yaml:"nat,omitempty"`
+}
+
+// resolve fills in the peer fields derived from other parts of the
+// configuration. It runs once after the configuration is loaded.
+// Settings are taken from the peer itself first, then from the template of
+// its role and last from PeerDefaults.
+func (c *Config) resolve() error {
+	c.PeerDefaults.AllowedIPs = splitAllowedIPs(c.PeerDefaults.AllowedIPs...)
+	for i, key := range c.TrustedKeys {
+		c.TrustedKeys[i] = normalizePublicKey(key)
+	}
+	for i, key := range c.fileTrustedKeys {
+		c.fileTrustedKeys[i] = normalizePublicKey(key)
+	}
+	for i := range c.Peers {
+		peer := &c.Peers[i]
+		peer.PublicKey = normalizePublicKey(peer.PublicKey)
+		peer.AllowedIPs = splitAllowedIPs(peer.AllowedIPs...)
+		if peer.Role == "" {
+			peer.Role = c.PeerDefaults.Role
+		}
+		if err := c.applyRoleTemplate(peer); err != nil {
+			return err
+		}
+		c.applyPeerDefaults(peer)
+		peer.AllowedIPs = normalizeAllowedIPs(peer.Name, peer.AllowedIPs)
+	}
+	return nil
+}
+
+// normalizePublicKey rewrites a public key given in hex or as a file
+// reference in base64, the form the device reports it in. Invalid keys are
+// kept for Validate to report.
+func normalizePublicKey(s string) string {
+	key, err := parseKey(s)
+	if err != nil {
+		return s
+	}
+	return key.String()
+}
+
+// normalizeAllowedIPs rewrites the allowed IPs in canonical CIDR form, so
+// that 10.0.0.5/24 reads 10.0.0.0/24 as on the device and a bare address
+// gets its host prefix. Invalid entries are kept for Validate to report.
+func normalizeAllowedIPs(peer string, allowedIPs []string) []string {
+	for i, s := range allowedIPs {
+		ipNet, err := parseAllowedIP(s)
+		if err != nil || ipNet.String() == s {
+			continue
+		}
+		if strings.Contains(s, "/") {
+			log.Warn().Str("peer", peer).Str("allowed_ip", s).Str("network", ipNet.String()).
+				Msg("Allowed IP has host bits set, using its network")
+		}
+		allowedIPs[i] = ipNet.String()
+	}
+	return allowedIPs
+}
+
+// applyPeerDefaults fills the empty fields of peer from PeerDefaults. Flags
+// can only be turned on by default, as an unset flag looks like false.
+func (c *Config) applyPeerDefaults(peer *Peer) {
+	defaults := c.PeerDefaults
+	if len(peer.AllowedIPs) == 0 && len(defaults.AllowedIPs) > 0 {
+		peer.AllowedIPs = append([]string(nil), defaults.AllowedIPs...)
+	}
+	if peer.Port == 0 {
+		peer.Port = defaults.Port
+	}
+	if peer.PersistentKeepalive == 0 {
+		peer.PersistentKeepalive = defaults.PersistentKeepalive
+	}
+	if peer.HandshakeTimeout == 0 {
+		peer.HandshakeTimeout = defaults.HandshakeTimeout
+	}
+	peer.NAT = peer.NAT || defaults.NAT
+	peer.Required = peer.Required || defaults.Required
+}
+
+// UnmarshalYAML also accepts allowed_ips as a single string, as copied from
+// a wg-quick config.
+func (p *Peer) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Peer
+	err := unmarshal((*plain)(p))
+
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) || len(typeErr.Errors) != 1 {
+		return err
+	}
+
+	// The decoder filled in everything else, only the string is left
+	var raw map[string]interface{}
+	if unmarshal(&raw) != nil {
+		return err
+	}
+	s, ok := raw["allowed_ips"].(string)
+	if !ok {
+		return err
+	}
+	p.AllowedIPs = splitAllowedIPs(s)
+	return nil
+}
+
+// splitAllowedIPs splits entries holding several comma- or space-separated
+// allowed IPs and trims the whitespace around each.
+func splitAllowedIPs(entries ...string) []string {
+	if entries == nil {
+		return nil
+	}
+
+	ips := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ips = append(ips, strings.FieldsFunc(entry, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})...)
+	}
+	return ips
+}
+
+// applyRoleTemplate fills the empty fields of peer from the template of its
+// role. Values set on the peer itself always win.
+func (c *Config) applyRoleTemplate(peer *Peer) error {
+	if peer.Role == "" {
+		return nil
+	}
+
+	tmpl, ok := c.RoleTemplates[peer.Role]
+	if !ok {
+		return fmt.Errorf("peer %s has unknown role %q", peer.Name, peer.Role)
+	}
+
+	if len(peer.AllowedIPs) == 0 {
+		peer.AllowedIPs = append([]string(nil), tmpl.AllowedIPs...)
+	}
+	if peer.PersistentKeepalive == 0 {
+		peer.PersistentKeepalive = tmpl.PersistentKeepalive
+	}
+	if !peer.NAT {
+		peer.NAT = tmpl.NAT
+	}
+	return nil
+}
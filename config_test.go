@@ -0,0 +1,178 @@
+package wgmesh_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadConfig writes yamlData to a temporary file and loads it.
+func loadConfig(t *testing.T, yamlData string) (*wgmesh.Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(yamlData), 0o600))
+
+	return (&wgmesh.WgMesh{}).LoadConfig(path)
+}
+
+func TestRoleTemplates(t *testing.T) {
+	cfg, err := loadConfig(t, `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+role_templates:
+  gateway:
+    allowed_ips: ["10.0.0.0/16"]
+    persistent_keepalive: 25
+  client:
+    allowed_ips: ["10.0.0.10/32"]
+peers:
+  - name: gw
+    role: gateway
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+  - name: laptop
+    role: client
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.11/32"]
+  - name: phone
+    role: client
+    persistent_keepalive: 15
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+`)
+	require.NoError(t, err)
+	require.Len(t, cfg.Peers, 3)
+
+	gw := cfg.Peers[0]
+	assert.Equal(t, []string{"10.0.0.0/16"}, gw.AllowedIPs)
+	assert.Equal(t, 25, gw.PersistentKeepalive)
+
+	// Explicit values override the template
+	laptop := cfg.Peers[1]
+	assert.Equal(t, []string{"10.0.0.11/32"}, laptop.AllowedIPs)
+	assert.Equal(t, 0, laptop.PersistentKeepalive)
+
+	phone := cfg.Peers[2]
+	assert.Equal(t, []string{"10.0.0.10/32"}, phone.AllowedIPs)
+	assert.Equal(t, 15, phone.PersistentKeepalive)
+}
+
+func TestRoleTemplatesUnknownRole(t *testing.T) {
+	_, err := loadConfig(t, `
+network_name: wg0
+peers:
+  - name: gw
+    role: gatway
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "gatway")
+}
+
+func TestAllowedIPsWhitespace(t *testing.T) {
+	cfg, err := loadConfig(t, `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: comma
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: " 10.0.0.0/24 , 10.0.1.0/24"
+    persistent_keepalive: 25
+  - name: spaces
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: "10.0.2.0/24   10.0.3.0/24	fd00::/64"
+  - name: list
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: [" 10.0.4.0/24", "10.0.5.0/24, 10.0.6.0/24 "]
+`)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	assert.Equal(t, []string{"10.0.0.0/24", "10.0.1.0/24"}, cfg.Peers[0].AllowedIPs)
+	assert.Equal(t, 25, cfg.Peers[0].PersistentKeepalive, "other fields are still decoded")
+	assert.Equal(t, []string{"10.0.2.0/24", "10.0.3.0/24", "fd00::/64"}, cfg.Peers[1].AllowedIPs)
+	assert.Equal(t, []string{"10.0.4.0/24", "10.0.5.0/24", "10.0.6.0/24"}, cfg.Peers[2].AllowedIPs)
+}
+
+func TestAllowedIPsNormalized(t *testing.T) {
+	cfg, err := loadConfig(t, `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.5/24", "10.0.1.9/32", "10.0.2.7", "fd00::5/64", "fd00:1::5"]
+  - name: invalid
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.3.0/33"]
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"10.0.0.0/24", "10.0.1.9/32", "10.0.2.7/32", "fd00::/64", "fd00:1::5/128"}, cfg.Peers[0].AllowedIPs)
+	// Left for Validate to report
+	assert.Equal(t, []string{"10.0.3.0/33"}, cfg.Peers[1].AllowedIPs)
+}
+
+func TestAllowedIPsStringKeepsOtherErrors(t *testing.T) {
+	_, err := loadConfig(t, `
+network_name: wg0
+peers:
+  - name: peer1
+    allowed_ips: "10.0.0.0/24"
+    port: not-a-number
+`)
+	assert.Error(t, err)
+}
+
+func TestPeerDefaults(t *testing.T) {
+	cfg, err := loadConfig(t, `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+role_templates:
+  gateway:
+    persistent_keepalive: 15
+peer_defaults:
+  persistent_keepalive: 25
+  nat: true
+  allowed_ips: ["10.0.0.0/24"]
+peers:
+  - name: plain
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+  - name: explicit
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.1.0/24"]
+    persistent_keepalive: 5
+  - name: gw
+    role: gateway
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+`)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	plain, explicit, gw := cfg.Peers[0], cfg.Peers[1], cfg.Peers[2]
+	assert.Equal(t, 25, plain.PersistentKeepalive)
+	assert.True(t, plain.NAT)
+	assert.Equal(t, []string{"10.0.0.0/24"}, plain.AllowedIPs)
+
+	assert.Equal(t, 5, explicit.PersistentKeepalive)
+	assert.Equal(t, []string{"10.0.1.0/24"}, explicit.AllowedIPs)
+	assert.True(t, explicit.NAT)
+
+	// The role template wins over the defaults
+	assert.Equal(t, 15, gw.PersistentKeepalive)
+	assert.Equal(t, []string{"10.0.0.0/24"}, gw.AllowedIPs)
+}
+
+func TestPeerDefaultsRejectsIdentity(t *testing.T) {
+	cfg, err := loadConfig(t, `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peer_defaults:
+  public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+`)
+	require.NoError(t, err)
+	assert.ErrorContains(t, cfg.Validate(), "peer_defaults can't set")
+}
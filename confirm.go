@@ -0,0 +1,115 @@
+package wgmesh
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrAlreadyReverted is returned by the confirm function of
+// ApplyWithConfirmation when the configuration was reverted before it was
+// confirmed.
+var ErrAlreadyReverted = errors.New("configuration was already reverted")
+
+// ApplyWithConfirmation applies cfg and reverts to the current configuration
+// after revertAfter unless the returned confirm function is called first, so
+// a change that cuts off the operator's own connection undoes itself. The
+// revert is skipped if another change replaced cfg meanwhile, and when the
+// mesh is closed. Confirming twice is harmless; confirming after a failed
+// revert returns its error.
+func (w *WgMesh) ApplyWithConfirmation(cfg *Config, revertAfter time.Duration) (confirm func() error, err error) {
+	if err := cfg.resolve(); err != nil {
+		return nil, err
+	}
+
+	var previous *Config
+	err = w.reloadQueue.run(func() error {
+		previous = w.currentConfig()
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid configuration: %w", err)
+		}
+		if err := previous.checkImmutable(cfg); err != nil {
+			return err
+		}
+		if err := w.checkPeerRemoval(cfg); err != nil {
+			return err
+		}
+		return w.applyConfig(cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu        sync.Mutex
+		resolved  bool // confirmed or reverted
+		reverted  bool
+		revertErr error
+		confirmed = make(chan struct{})
+	)
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		timer := time.NewTimer(revertAfter)
+		defer timer.Stop()
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-confirmed:
+			return
+		case <-timer.C:
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if resolved {
+			return
+		}
+		resolved = true
+		reverted, revertErr = w.revertUnconfirmed(cfg, previous, revertAfter)
+	}()
+
+	log.Info().Dur("revert_after", revertAfter).Msg("Configuration applied, waiting for confirmation")
+
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if reverted {
+			return ErrAlreadyReverted
+		}
+		if revertErr != nil {
+			return fmt.Errorf("failed to revert unconfirmed configuration: %w", revertErr)
+		}
+		if !resolved {
+			resolved = true
+			close(confirmed)
+			log.Info().Msg("Configuration change confirmed")
+		}
+		return nil
+	}, nil
+}
+
+// revertUnconfirmed applies previous again if cfg is still the active
+// configuration, and reports whether it did. A failed revert leaves cfg
+// active and returns the error.
+func (w *WgMesh) revertUnconfirmed(cfg, previous *Config, after time.Duration) (reverted bool, err error) {
+	err = w.reloadQueue.run(func() error {
+		if w.currentConfig() != cfg {
+			log.Info().Msg("Unconfirmed configuration change was replaced meanwhile, not reverting it")
+			return nil
+		}
+
+		log.Warn().Dur("after", after).Msg("Configuration change not confirmed, reverting")
+		if err := w.applyConfig(previous); err != nil {
+			log.Error().Err(err).Msg("Failed to revert configuration change")
+			return err
+		}
+		reverted = true
+		return nil
+	})
+	return reverted, err
+}
@@ -0,0 +1,132 @@
+package wgmesh_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const confirmConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`
+
+// withPeer2 returns a copy of the mesh configuration with peer2 added.
+func withPeer2(mesh *wgmesh.WgMesh) *wgmesh.Config {
+	cfg := *mesh.Config
+	cfg.Peers = append([]wgmesh.Peer{}, cfg.Peers...)
+	cfg.Peers = append(cfg.Peers, wgmesh.Peer{
+		Name:       "peer2",
+		PublicKey:  "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=",
+		AllowedIPs: []string{"10.0.0.3/32"},
+	})
+	return &cfg
+}
+
+func peerCount(mesh *wgmesh.WgMesh) int {
+	return len(mesh.FindPeers(wgmesh.PeerFilter{}))
+}
+
+func TestApplyWithConfirmationReverts(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, confirmConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	confirm, err := mesh.ApplyWithConfirmation(withPeer2(mesh), 20*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 2, peerCount(mesh))
+
+	require.Eventually(t, func() bool {
+		return peerCount(mesh) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.ErrorIs(t, confirm(), wgmesh.ErrAlreadyReverted)
+
+	// peer2 was added and removed again
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 2)
+	assert.False(t, calls[0].Peers[0].Remove)
+	assert.True(t, calls[1].Peers[0].Remove)
+}
+
+func TestApplyWithConfirmationRevertFails(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, confirmConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil).Once()
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(errors.New("device busy"))
+
+	confirm, err := mesh.ApplyWithConfirmation(withPeer2(mesh), 20*time.Millisecond)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(configureCalls(mockClient)) == 2
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// The unconfirmed configuration is still active, confirm says why
+	err = confirm()
+	assert.NotErrorIs(t, err, wgmesh.ErrAlreadyReverted)
+	assert.ErrorContains(t, err, "failed to revert unconfirmed configuration")
+	assert.ErrorContains(t, err, "device busy")
+	assert.Equal(t, 2, peerCount(mesh))
+}
+
+func TestApplyWithConfirmationConfirmed(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, confirmConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	confirm, err := mesh.ApplyWithConfirmation(withPeer2(mesh), 20*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, confirm())
+	require.NoError(t, confirm())
+
+	time.Sleep(60 * time.Millisecond) // past the revert deadline
+	assert.Equal(t, 2, peerCount(mesh))
+	assert.Len(t, configureCalls(mockClient), 1)
+}
+
+func TestApplyWithConfirmationInvalid(t *testing.T) {
+	mesh, _ := newTestMesh(t, confirmConfig)
+
+	cfg := withPeer2(mesh)
+	cfg.Peers[1].PublicKey = "abc"
+	_, err := mesh.ApplyWithConfirmation(cfg, time.Minute)
+	assert.ErrorContains(t, err, "invalid public key for peer peer2")
+	assert.Equal(t, 1, peerCount(mesh))
+}
+
+func TestApplyWithConfirmationReplaced(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, confirmConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	_, err := mesh.ApplyWithConfirmation(withPeer2(mesh), 20*time.Millisecond)
+	require.NoError(t, err)
+
+	// A reload replacing the unconfirmed change isn't undone by the revert
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(confirmConfig+peer3Entry), 0o600))
+	require.NoError(t, mesh.Reload())
+	calls := len(configureCalls(mockClient))
+
+	time.Sleep(60 * time.Millisecond) // past the revert deadline
+	assert.Len(t, configureCalls(mockClient), calls)
+	assert.Len(t, mesh.FindPeers(wgmesh.PeerFilter{Name: "peer3"}), 1)
+}
+
+func TestApplyWithConfirmationClosed(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, confirmConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	_, err := mesh.ApplyWithConfirmation(withPeer2(mesh), 20*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, mesh.Close())
+
+	time.Sleep(60 * time.Millisecond) // past the revert deadline
+	assert.Len(t, configureCalls(mockClient), 1)
+}
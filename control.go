@@ -0,0 +1,232 @@
+package wgmesh
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ControlRequest is a single line, of at most 4 MiB, sent to the control
+// socket. Peer names the peer of commands acting on a single one, Config
+// carries the YAML configuration of the "push" command and Backup the backup
+// to "restore".
+type ControlRequest struct {
+	Command string `json:"command"`
+	Peer    string `json:"peer,omitempty"`
+	Config  string `json:"config,omitempty"`
+	Backup  string `json:"backup,omitempty"`
+}
+
+// ControlResponse is the reply to a ControlRequest, one JSON object per line.
+type ControlResponse struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// ControlPeer describes a configured peer in the "list" reply. Private keys
+// are never sent over the socket.
+type ControlPeer struct {
+	Name       string    `json:"name"`
+	PublicKey  string    `json:"public_key"`
+	AllowedIPs []string  `json:"allowed_ips,omitempty"`
+	Endpoint   string    `json:"endpoint,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	State      PeerState `json:"state,omitempty"`
+}
+
+// maxControlRequest bounds a request line on the control socket. It leaves
+// room for pushed configurations with thousands of peers.
+const maxControlRequest = 4 << 20
+
+// startControl starts serving the control socket, if configured.
+func (w *WgMesh) startControl() error {
+	path := w.currentConfig().ControlSocket
+	if path == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		// A socket left behind by a crashed daemon would make Listen fail
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("control socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stale control socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict control socket: %w", err)
+	}
+
+	w.wg.Add(2)
+	go func() {
+		defer w.wg.Done()
+		<-w.ctx.Done()
+		listener.Close()
+	}()
+	go func() {
+		defer w.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if w.ctx.Err() == nil {
+					log.Error().Err(err).Msg("Control socket stopped accepting connections")
+				}
+				return
+			}
+			go w.serveControlConn(conn)
+		}
+	}()
+
+	return nil
+}
+
+func (w *WgMesh) serveControlConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, maxControlRequest)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req ControlRequest
+		var resp ControlResponse
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+		} else {
+			resp = w.handleControl(req)
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		encoder.Encode(ControlResponse{Error: fmt.Sprintf("request exceeds %d bytes", maxControlRequest)})
+	}
+}
+
+func (w *WgMesh) handleControl(req ControlRequest) ControlResponse {
+	var result any
+	switch req.Command {
+	case "status":
+		result = w.GetStatus()
+	case "reload":
+		if err := w.Reload(); err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		result = "ok"
+	case "list":
+		result = w.listPeers()
+	case "drift":
+		report, err := w.DetectDrift()
+		if err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		result = report
+	case "diag":
+		diag, err := w.Diagnostics()
+		if err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		result = diag
+	case "rotate-psk":
+		if req.Peer == "" {
+			return ControlResponse{Error: "rotate-psk requires a peer"}
+		}
+		psk, err := w.RotatePeerPSK(req.Peer)
+		if err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		result = psk
+	case "push":
+		if req.Config == "" {
+			return ControlResponse{Error: "push requires a configuration"}
+		}
+		status, err := w.PushConfig([]byte(req.Config))
+		if err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		result = status
+	case "backups":
+		backups, err := w.ListBackups()
+		if err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		result = backups
+	case "restore":
+		if req.Backup == "" {
+			return ControlResponse{Error: "restore requires a backup"}
+		}
+		if err := w.RestoreBackup(req.Backup); err != nil {
+			return ControlResponse{Error: err.Error()}
+		}
+		result = w.GetStatus()
+	default:
+		return ControlResponse{Error: fmt.Sprintf("unknown command %q", req.Command)}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return ControlResponse{Error: err.Error()}
+	}
+	return ControlResponse{Result: data}
+}
+
+func (w *WgMesh) listPeers() []ControlPeer {
+	views := w.FindPeers(PeerFilter{})
+
+	peers := make([]ControlPeer, 0, len(views))
+	for _, view := range views {
+		peers = append(peers, ControlPeer{
+			Name:       view.Peer.Name,
+			PublicKey:  view.Peer.PublicKey,
+			AllowedIPs: view.Peer.AllowedIPs,
+			Endpoint:   view.Peer.Endpoint,
+			Tags:       view.Peer.Tags,
+			State:      view.Status.State,
+		})
+	}
+	return peers
+}
+
+// ControlCall sends command to the daemon listening on the control socket at
+// path and decodes the result into result, which may be nil.
+func ControlCall(path, command string, result any) error {
+	return ControlCallRequest(path, ControlRequest{Command: command}, result)
+}
+
+// ControlCallRequest is ControlCall for requests with arguments.
+func ControlCallRequest(path string, req ControlRequest, result any) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to connect to control socket: %w", err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp ControlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
@@ -0,0 +1,76 @@
+package wgmesh_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// controlSocketPath returns a socket path short enough for sun_path.
+func controlSocketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "wgmesh")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "ctl.sock")
+}
+
+func TestControlSocket(t *testing.T) {
+	socket := controlSocketPath(t)
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+control_socket: %s
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    private_key: mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=
+    allowed_ips: ["10.0.0.2/32"]
+`, socket))
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	info, err := os.Stat(socket)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	var status wgmesh.MeshStatus
+	require.NoError(t, wgmesh.ControlCall(socket, "status", &status))
+	assert.Equal(t, "wg0", status.NetworkName)
+	require.Contains(t, status.Peers, "peer1")
+	assert.Equal(t, "peer1", status.Peers["peer1"].Name)
+
+	var peers []wgmesh.ControlPeer
+	require.NoError(t, wgmesh.ControlCall(socket, "list", &peers))
+	assert.Equal(t, []wgmesh.ControlPeer{{
+		Name:       "peer1",
+		PublicKey:  "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=",
+		AllowedIPs: []string{"10.0.0.2/32"},
+		State:      status.Peers["peer1"].State,
+	}}, peers)
+
+	var psk string
+	require.NoError(t, wgmesh.ControlCallRequest(socket, wgmesh.ControlRequest{Command: "rotate-psk", Peer: "peer1"}, &psk))
+	saved, err := mesh.LoadConfig(mesh.YamlFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, psk, saved.Peers[0].PresharedKey)
+	err = wgmesh.ControlCallRequest(socket, wgmesh.ControlRequest{Command: "rotate-psk", Peer: "peer2"}, nil)
+	assert.EqualError(t, err, "unknown peer peer2")
+	err = wgmesh.ControlCall(socket, "rotate-psk", nil)
+	assert.EqualError(t, err, "rotate-psk requires a peer")
+
+	err = wgmesh.ControlCall(socket, "bogus", nil)
+	assert.EqualError(t, err, `unknown command "bogus"`)
+}
@@ -0,0 +1,189 @@
+package wgmesh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v2"
+)
+
+// Diagnostics is a snapshot of the runtime state for attaching to bug
+// reports. Private keys are redacted, public keys are kept as they are
+// needed to match peers.
+type Diagnostics struct {
+	Time        time.Time          `json:"time"`
+	Runtime     RuntimeInfo        `json:"runtime"`
+	Config      string             `json:"config"` // YAML, as in the config file
+	Status      MeshStatus         `json:"status"`
+	Device      *DeviceInfo        `json:"device,omitempty"`
+	DeviceError string             `json:"device_error,omitempty"`
+	Errors      []DiagnosticsError `json:"errors"`
+}
+
+// RuntimeInfo describes the running binary.
+type RuntimeInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// DeviceInfo is the state of the WireGuard device as read from the kernel.
+type DeviceInfo struct {
+	Name           string         `json:"name"`
+	Type           string         `json:"type"`
+	Implementation Implementation `json:"implementation"`
+	PublicKey      string         `json:"public_key"`
+	ListenPort     int            `json:"listen_port"`
+	FirewallMark   int            `json:"firewall_mark,omitempty"`
+	Peers          []DevicePeer   `json:"peers"`
+}
+
+// DevicePeer is a peer as configured on the device.
+type DevicePeer struct {
+	PublicKey           string    `json:"public_key"`
+	HasPresharedKey     bool      `json:"has_preshared_key"`
+	Endpoint            string    `json:"endpoint,omitempty"`
+	AllowedIPs          []string  `json:"allowed_ips"`
+	LastHandshake       time.Time `json:"last_handshake"`
+	ReceiveBytes        int64     `json:"rx_bytes"`
+	TransmitBytes       int64     `json:"tx_bytes"`
+	PersistentKeepalive float64   `json:"persistent_keepalive_seconds"`
+}
+
+// DiagnosticsError is the last error recorded for a peer.
+type DiagnosticsError struct {
+	Peer  string    `json:"peer"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+}
+
+// Diagnostics collects the current configuration, status and device state.
+// A device that can't be read is reported in DeviceError rather than
+// failing the whole snapshot.
+func (w *WgMesh) Diagnostics() (Diagnostics, error) {
+	config, err := yaml.Marshal(redactConfig(w.currentConfig()))
+	if err != nil {
+		return Diagnostics{}, fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+
+	status := w.GetStatus()
+	diag := Diagnostics{
+		Time:    time.Now(),
+		Runtime: runtimeInfo(),
+		Config:  string(config),
+		Status:  status,
+		Errors:  []DiagnosticsError{},
+	}
+
+	device, err := w.deviceClient().Device(w.currentConfig().NetworkName)
+	if err != nil {
+		diag.DeviceError = err.Error()
+	} else {
+		diag.Device = newDeviceInfo(device)
+	}
+
+	for name, peer := range status.Peers {
+		if peer.Error == "" {
+			continue
+		}
+		diag.Errors = append(diag.Errors, DiagnosticsError{
+			Peer:  name,
+			Error: peer.Error,
+			Time:  peer.LastErrorTime,
+			Count: peer.ErrorCount,
+		})
+	}
+	sort.Slice(diag.Errors, func(i, j int) bool { return diag.Errors[i].Peer < diag.Errors[j].Peer })
+
+	return diag, nil
+}
+
+// ExportDiagnostics writes the Diagnostics as indented JSON to out.
+func (w *WgMesh) ExportDiagnostics(out io.Writer) error {
+	diag, err := w.Diagnostics()
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(diag)
+}
+
+// redactConfig returns a copy of cfg without private and preshared keys and
+// API token.
+func redactConfig(cfg *Config) *Config {
+	c := *cfg
+	if c.PrivateKey != "" {
+		c.PrivateKey = redacted
+	}
+	if c.APIToken != "" {
+		c.APIToken = redacted
+	}
+	c.Peers = append([]Peer(nil), cfg.Peers...)
+	for i := range c.Peers {
+		if c.Peers[i].PrivateKey != "" {
+			c.Peers[i].PrivateKey = redacted
+		}
+		if c.Peers[i].PresharedKey != "" {
+			c.Peers[i].PresharedKey = redacted
+		}
+	}
+	if c.PeerDefaults.PrivateKey != "" {
+		c.PeerDefaults.PrivateKey = redacted
+	}
+	return &c
+}
+
+func runtimeInfo() RuntimeInfo {
+	info := RuntimeInfo{
+		Version:   "unknown",
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok && build.Main.Version != "" {
+		info.Version = build.Main.Version
+	}
+	return info
+}
+
+func newDeviceInfo(device *wgtypes.Device) *DeviceInfo {
+	info := &DeviceInfo{
+		Name:           device.Name,
+		Type:           device.Type.String(),
+		Implementation: deviceImplementation(device),
+		PublicKey:      device.PublicKey.String(),
+		ListenPort:     device.ListenPort,
+		FirewallMark:   device.FirewallMark,
+		Peers:          make([]DevicePeer, 0, len(device.Peers)),
+	}
+	for _, peer := range device.Peers {
+		p := DevicePeer{
+			PublicKey:           peer.PublicKey.String(),
+			HasPresharedKey:     peer.PresharedKey != wgtypes.Key{},
+			AllowedIPs:          make([]string, 0, len(peer.AllowedIPs)),
+			LastHandshake:       peer.LastHandshakeTime,
+			ReceiveBytes:        peer.ReceiveBytes,
+			TransmitBytes:       peer.TransmitBytes,
+			PersistentKeepalive: peer.PersistentKeepaliveInterval.Seconds(),
+		}
+		if peer.Endpoint != nil {
+			p.Endpoint = peer.Endpoint.String()
+		}
+		for _, ip := range peer.AllowedIPs {
+			p.AllowedIPs = append(p.AllowedIPs, ip.String())
+		}
+		info.Peers = append(info.Peers, p)
+	}
+	return info
+}
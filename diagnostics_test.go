@@ -0,0 +1,83 @@
+package wgmesh_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const diagConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+api_token: diag-api-token
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    private_key: mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=
+    allowed_ips: ["10.0.0.2/32"]
+`
+
+func TestExportDiagnostics(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, diagConfig)
+
+	privateKey := mustParseKey(t, "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=")
+	presharedKey := mustParseKey(t, "cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=")
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Name:       "wg0",
+		PrivateKey: privateKey,
+		PublicKey:  privateKey.PublicKey(),
+		ListenPort: 51820,
+		Peers: []wgtypes.Peer{{
+			PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+			PresharedKey:      presharedKey,
+			Endpoint:          &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 51820},
+			AllowedIPs:        []net.IPNet{mustParseCIDR(t, "10.0.0.2/32")},
+			LastHandshakeTime: time.Now(),
+		}},
+	}, nil)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateError, errors.New("handshake failed"))
+
+	var out bytes.Buffer
+	require.NoError(t, mesh.ExportDiagnostics(&out))
+
+	var diag map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(out.Bytes(), &diag))
+	for _, section := range []string{"time", "runtime", "config", "status", "device", "errors"} {
+		assert.Contains(t, diag, section)
+	}
+	assert.Contains(t, string(diag["runtime"]), `"go_version"`)
+	assert.Contains(t, string(diag["errors"]), "handshake failed")
+	assert.Contains(t, string(diag["config"]), "<redacted>")
+
+	// No private or preshared key material, nor the API token
+	for _, key := range []string{
+		"ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+		"mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=",
+		presharedKey.String(),
+		"diag-api-token",
+	} {
+		assert.NotContains(t, out.String(), key)
+	}
+}
+
+func TestExportDiagnosticsDeviceUnreadable(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, diagConfig)
+	mockClient.On("Device", "wg0").Return(nil, errors.New("no such device"))
+
+	var out bytes.Buffer
+	require.NoError(t, mesh.ExportDiagnostics(&out))
+
+	var diag wgmesh.Diagnostics
+	require.NoError(t, json.Unmarshal(out.Bytes(), &diag))
+	assert.Nil(t, diag.Device)
+	assert.Equal(t, "no such device", diag.DeviceError)
+}
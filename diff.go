@@ -0,0 +1,59 @@
+package wgmesh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PeerDiff is a peer present in two configurations with differences. Old
+// and New are the two versions, Changes lists the differing fields with
+// secrets redacted.
+type PeerDiff struct {
+	Old     Peer
+	New     Peer
+	Changes []PeerChange
+}
+
+// DiffConfigs compares the peers of two configurations the way a reload
+// does: peers are matched by public key, else by name. It returns the
+// updated, added and removed peers.
+func DiffConfigs(oldConfig, newConfig *Config) (updated []PeerDiff, added, removed []Peer) {
+	added, removed, updated = diffPeers(oldConfig.Peers, newConfig.Peers)
+	return updated, added, removed
+}
+
+// FormatConfigDiff describes the differences between the peers of two
+// configurations for humans: a line per added (+), removed (-) and updated
+// (~) peer, followed by the changed fields of updated ones. Private and
+// preshared keys are never printed.
+func FormatConfigDiff(oldConfig, newConfig *Config) string {
+	updated, added, removed := DiffConfigs(oldConfig, newConfig)
+
+	var b strings.Builder
+	for _, peer := range added {
+		fmt.Fprintf(&b, "+ %s", peer.Name)
+		if peer.PublicKey != "" {
+			fmt.Fprintf(&b, " (%s)", peer.PublicKey)
+		}
+		if len(peer.AllowedIPs) > 0 {
+			fmt.Fprintf(&b, " allowed IPs %s", strings.Join(peer.AllowedIPs, ","))
+		}
+		if peer.Endpoint != "" {
+			fmt.Fprintf(&b, " at %s", peer.Endpoint)
+		}
+		b.WriteString("\n")
+	}
+	for _, peer := range removed {
+		fmt.Fprintf(&b, "- %s\n", peer.Name)
+	}
+	for _, diff := range updated {
+		fmt.Fprintf(&b, "~ %s\n", diff.New.Name)
+		if len(diff.Changes) == 0 {
+			b.WriteString("    other settings changed\n")
+		}
+		for _, change := range diff.Changes {
+			fmt.Fprintf(&b, "    %s\n", change)
+		}
+	}
+	return b.String()
+}
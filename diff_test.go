@@ -0,0 +1,75 @@
+package wgmesh_test
+
+import (
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func diffConfigs() (*wgmesh.Config, *wgmesh.Config) {
+	oldConfig := validConfig()
+	oldConfig.Peers = append(oldConfig.Peers, wgmesh.Peer{
+		Name:       "peer3",
+		PublicKey:  "WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=",
+		AllowedIPs: []string{"10.0.0.4/32"},
+	})
+	oldConfig.Peers[0].PresharedKey = "mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4="
+
+	newConfig := validConfig()
+	newConfig.Peers[0].AllowedIPs = []string{"10.0.1.2/32"}
+	newConfig.Peers[0].PresharedKey = "cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E="
+	newConfig.Peers[1].Name = "peer2-renamed"
+	newConfig.Peers = append(newConfig.Peers, wgmesh.Peer{
+		Name:       "peer4",
+		PublicKey:  "n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=",
+		PrivateKey: "cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=",
+		AllowedIPs: []string{"10.0.0.5/32"},
+		Endpoint:   "peer4.example.com:51820",
+	})
+	return oldConfig, newConfig
+}
+
+func TestDiffConfigs(t *testing.T) {
+	oldConfig, newConfig := diffConfigs()
+
+	updated, added, removed := wgmesh.DiffConfigs(oldConfig, newConfig)
+	require.Len(t, added, 1)
+	assert.Equal(t, "peer4", added[0].Name)
+	require.Len(t, removed, 1)
+	assert.Equal(t, "peer3", removed[0].Name)
+
+	// The renamed peer is matched by its key
+	require.Len(t, updated, 2)
+	assert.Equal(t, "peer1", updated[0].New.Name)
+	assert.Equal(t, []wgmesh.PeerChange{
+		{Field: "PresharedKey", From: "<redacted>", To: "<redacted>"},
+		{Field: "AllowedIPs", From: "10.0.0.2/32", To: "10.0.1.2/32"},
+	}, updated[0].Changes)
+	assert.Equal(t, "peer2", updated[1].Old.Name)
+	assert.Equal(t, []wgmesh.PeerChange{{Field: "Name", From: "peer2", To: "peer2-renamed"}}, updated[1].Changes)
+
+	updated, added, removed = wgmesh.DiffConfigs(oldConfig, oldConfig)
+	assert.Empty(t, updated)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
+
+func TestFormatConfigDiff(t *testing.T) {
+	oldConfig, newConfig := diffConfigs()
+
+	out := wgmesh.FormatConfigDiff(oldConfig, newConfig)
+	assert.Equal(t, `+ peer4 (n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=) allowed IPs 10.0.0.5/32 at peer4.example.com:51820
+- peer3
+~ peer1
+    PresharedKey: <redacted> -> <redacted>
+    AllowedIPs: 10.0.0.2/32 -> 10.0.1.2/32
+~ peer2-renamed
+    Name: peer2 -> peer2-renamed
+`, out)
+	assert.NotContains(t, out, "mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=")
+	assert.NotContains(t, out, "cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=")
+
+	assert.Empty(t, wgmesh.FormatConfigDiff(oldConfig, oldConfig))
+}
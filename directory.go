@@ -0,0 +1,280 @@
+package wgmesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// DirManager runs one mesh per *.yaml file in a directory, e.g. one per
+// network. Files added to the directory start a mesh, removed ones stop it.
+// Changes to a file are picked up by its own mesh like in single-file mode.
+type DirManager struct {
+	Dir string
+
+	opts   []Option
+	mu     sync.Mutex
+	meshes map[string]*WgMesh // by config file path
+	starts map[string]string  // networks of the meshes starting, by path
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDirManager creates a manager for the configuration files in dir. opts
+// are passed to every mesh.
+func NewDirManager(dir string, opts ...Option) *DirManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DirManager{
+		Dir:    dir,
+		opts:   opts,
+		meshes: make(map[string]*WgMesh),
+		starts: make(map[string]string),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// isMeshConfig reports whether path names a configuration file to manage.
+// Hidden files are skipped, which includes the temporary files of atomic
+// writes.
+func isMeshConfig(path string) bool {
+	name := filepath.Base(path)
+	return filepath.Ext(name) == ".yaml" && !strings.HasPrefix(name, ".")
+}
+
+// Start starts a mesh for every configuration file in the directory and
+// keeps watching it for added and removed files. A file that fails to load
+// is logged and retried on its next change.
+func (d *DirManager) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to initialize directory watcher: %w", err)
+	}
+	if err := watcher.Add(d.Dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch directory %s: %w", d.Dir, err)
+	}
+
+	entries, err := os.ReadDir(d.Dir)
+	if err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to read directory %s: %w", d.Dir, err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(d.Dir, entry.Name())
+		if !entry.IsDir() && isMeshConfig(path) {
+			if err := d.add(path); err != nil {
+				log.Error().Err(err).Str("path", path).Msg("Failed to start mesh")
+			}
+		}
+	}
+
+	log.Info().Str("dir", d.Dir).Msg("Directory watcher started")
+	d.notifyReady()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-d.ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !isMeshConfig(event.Name) {
+					continue
+				}
+
+				switch {
+				case event.Op.Has(fsnotify.Remove) || event.Op.Has(fsnotify.Rename):
+					d.remove(event.Name)
+				case event.Op.Has(fsnotify.Create) || event.Op.Has(fsnotify.Write):
+					// Files being written may not parse yet, the next write
+					// event retries
+					if err := d.add(event.Name); err != nil {
+						log.Error().Err(err).Str("path", event.Name).Msg("Failed to start mesh")
+					}
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Err(err).Msg("Error watching directory")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// add starts a mesh for path unless one is already running. A file for a
+// network that another file already manages is rejected. The mesh starts
+// outside the lock, so slow starts don't hold up the other meshes.
+func (d *DirManager) add(path string) error {
+	if d.managed(path) {
+		return nil
+	}
+
+	mesh, err := NewWgMesh(path, d.opts...)
+	if err != nil {
+		return err
+	}
+	mesh.dirManaged = true
+	network := mesh.currentConfig().NetworkName
+
+	d.mu.Lock()
+	if _, ok := d.meshes[path]; ok || d.starts[path] != "" {
+		d.mu.Unlock()
+		mesh.Close()
+		return nil
+	}
+	if other := d.managerOf(network); other != "" {
+		d.mu.Unlock()
+		mesh.Close()
+		return fmt.Errorf("network %s is already managed by %s", network, other)
+	}
+	d.starts[path] = network
+	d.mu.Unlock()
+
+	err = mesh.Start()
+
+	d.mu.Lock()
+	delete(d.starts, path)
+	closed := d.ctx.Err() != nil
+	if err == nil && !closed {
+		d.meshes[path] = mesh
+	}
+	d.mu.Unlock()
+
+	if err != nil {
+		mesh.Close()
+		return err
+	}
+	if closed {
+		// Close ran meanwhile and missed this mesh
+		return mesh.Shutdown()
+	}
+	log.Info().Str("path", path).Str("network", network).Msg("Started mesh")
+	return nil
+}
+
+// managed reports whether a mesh runs or starts for path.
+func (d *DirManager) managed(path string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.meshes[path]
+	return ok || d.starts[path] != ""
+}
+
+// managerOf returns the configuration file of the mesh running or starting
+// for network, if any. d.mu must be held.
+func (d *DirManager) managerOf(network string) string {
+	for path, mesh := range d.meshes {
+		if mesh.currentConfig().NetworkName == network {
+			return path
+		}
+	}
+	for path, name := range d.starts {
+		if name == network {
+			return path
+		}
+	}
+	return ""
+}
+
+// remove tears down the mesh of path, if any.
+func (d *DirManager) remove(path string) {
+	d.mu.Lock()
+	mesh, ok := d.meshes[path]
+	delete(d.meshes, path)
+	d.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := stopMesh(mesh); err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to stop mesh")
+		return
+	}
+	log.Info().Str("path", path).Msg("Stopped mesh")
+}
+
+// stopMesh tears down the tunnel of mesh and closes it.
+func stopMesh(mesh *WgMesh) error {
+	var errs []error
+	if err := mesh.StopTunnel(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to tear down tunnel: %w", err))
+	}
+	if err := mesh.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Mesh returns the mesh running for the configuration file at path.
+func (d *DirManager) Mesh(path string) (*WgMesh, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	mesh, ok := d.meshes[path]
+	return mesh, ok
+}
+
+// running returns the running meshes.
+func (d *DirManager) running() []*WgMesh {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	meshes := make([]*WgMesh, 0, len(d.meshes))
+	for _, mesh := range d.meshes {
+		meshes = append(meshes, mesh)
+	}
+	return meshes
+}
+
+// Paths returns the configuration files with a running mesh, sorted.
+func (d *DirManager) Paths() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	paths := make([]string, 0, len(d.meshes))
+	for path := range d.meshes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Close stops watching the directory and shuts all meshes down, see
+// WgMesh.Shutdown.
+func (d *DirManager) Close() error {
+	d.cancel()
+	d.wg.Wait()
+
+	d.mu.Lock()
+	meshes := d.meshes
+	d.meshes = make(map[string]*WgMesh)
+	d.mu.Unlock()
+
+	var errs []error
+	for path, mesh := range meshes {
+		if err := mesh.Shutdown(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
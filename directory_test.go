@@ -0,0 +1,124 @@
+package wgmesh_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func networkConfig(name string) []byte {
+	return []byte(fmt.Sprintf(`
+network_name: %s
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`, name))
+}
+
+func TestDirManager(t *testing.T) {
+	dir := t.TempDir()
+	wg0 := filepath.Join(dir, "wg0.yaml")
+	wg1 := filepath.Join(dir, "wg1.yaml")
+	require.NoError(t, os.WriteFile(wg0, networkConfig("wg0"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a config"), 0o600))
+
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", mock.Anything, mock.Anything).Return(nil)
+	mockClient.On("Device", mock.Anything).Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	manager := wgmesh.NewDirManager(dir, wgmesh.WithClient(mockClient))
+	require.NoError(t, manager.Start())
+	defer manager.Close()
+
+	assert.Equal(t, []string{wg0}, manager.Paths())
+
+	// Wait for directory watcher to start
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(wg1, networkConfig("wg1"), 0o600))
+	require.Eventually(t, func() bool {
+		_, ok := manager.Mesh(wg1)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	mesh, _ := manager.Mesh(wg1)
+	assert.Equal(t, "wg1", mesh.GetStatus().NetworkName)
+	mockClient.AssertCalled(t, "ConfigureDevice", "wg1", mock.Anything)
+
+	require.NoError(t, os.Remove(wg1))
+	require.Eventually(t, func() bool {
+		_, ok := manager.Mesh(wg1)
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{wg0}, manager.Paths())
+
+	// The tunnel was torn down
+	mockClient.AssertCalled(t, "ConfigureDevice", "wg1", wgtypes.Config{ReplacePeers: true})
+}
+
+func TestDirManagerDuplicateNetwork(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.yaml"), networkConfig("wg0"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.yaml"), networkConfig("wg0"), 0o600))
+
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", mock.Anything, mock.Anything).Return(nil)
+	mockClient.On("Device", mock.Anything).Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	manager := wgmesh.NewDirManager(dir, wgmesh.WithClient(mockClient))
+	require.NoError(t, manager.Start())
+	defer manager.Close()
+
+	// The second file for wg0 doesn't start another mesh on the interface
+	assert.Equal(t, []string{filepath.Join(dir, "a.yaml")}, manager.Paths())
+}
+
+func TestDirManagerStartsOutsideLock(t *testing.T) {
+	dir := t.TempDir()
+	wg1 := filepath.Join(dir, "wg1.yaml")
+
+	release := make(chan struct{})
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg1", mock.Anything).Run(func(mock.Arguments) { <-release }).Return(nil).Once()
+	mockClient.On("ConfigureDevice", mock.Anything, mock.Anything).Return(nil)
+	mockClient.On("Device", mock.Anything).Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	manager := wgmesh.NewDirManager(dir, wgmesh.WithClient(mockClient))
+	require.NoError(t, manager.Start())
+	defer manager.Close()
+
+	// Wait for directory watcher to start
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(wg1, networkConfig("wg1"), 0o600))
+	require.Eventually(t, func() bool { return len(configureCalls(mockClient)) > 0 }, 5*time.Second, time.Millisecond)
+
+	// The manager answers while wg1 is still starting
+	listed := make(chan []string, 1)
+	go func() { listed <- manager.Paths() }()
+	select {
+	case paths := <-listed:
+		assert.Empty(t, paths)
+	case <-time.After(time.Second):
+		t.Fatal("manager blocked by a starting mesh")
+	}
+
+	close(release)
+	require.Eventually(t, func() bool {
+		_, ok := manager.Mesh(wg1)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+}
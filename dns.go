@@ -0,0 +1,46 @@
+package wgmesh
+
+import (
+	"fmt"
+	"net"
+)
+
+// resolvconfAdd feeds one nameserver line per argument to resolvconf(8) for
+// the interface in $0. The servers are passed as arguments rather than
+// spliced into the script, as CommandRunner has no stdin.
+const resolvconfAdd = `printf 'nameserver %s\n' "$@" | resolvconf -a "$0" -m 0 -x`
+
+// setDNS registers the configured DNS servers for the interface with
+// resolvconf, like wg-quick does.
+func (w *WgMesh) setDNS() error {
+	if len(w.Config.DNS) == 0 {
+		return nil
+	}
+
+	args := append([]string{"-c", resolvconfAdd, w.Config.NetworkName}, w.Config.DNS...)
+	if err := w.CommandRunner.Run("sh", args...); err != nil {
+		return fmt.Errorf("failed to set DNS servers: %w", err)
+	}
+	return nil
+}
+
+// unsetDNS removes the DNS servers registered by setDNS.
+func (w *WgMesh) unsetDNS() error {
+	if len(w.Config.DNS) == 0 {
+		return nil
+	}
+
+	if err := w.CommandRunner.Run("resolvconf", "-d", w.Config.NetworkName, "-f"); err != nil {
+		return fmt.Errorf("failed to remove DNS servers: %w", err)
+	}
+	return nil
+}
+
+func validateDNS(servers []string) error {
+	for _, server := range servers {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("dns server %q is not a valid IP address", server)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package wgmesh_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const dnsConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+dns: ["10.0.0.53", "fd00::53"]
+peers: []
+`
+
+func TestDNSSequence(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, dnsConfig)
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+	assert.Equal(t, []string{
+		`sh -c printf 'nameserver %s\n' "$@" | resolvconf -a "$0" -m 0 -x wg0 10.0.0.53 fd00::53`,
+	}, runner.Commands())
+
+	runner.commands = nil
+	require.NoError(t, mesh.StopTunnel())
+	assert.Equal(t, []string{"resolvconf -d wg0 -f"}, runner.Commands())
+}
+
+func TestDNSInvalidServer(t *testing.T) {
+	cfg := validConfig()
+	cfg.DNS = []string{"10.0.0.53", "dns.example.com"}
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `dns server "dns.example.com" is not a valid IP address`)
+}
@@ -0,0 +1,85 @@
+package wgmesh
+
+import "time"
+
+// downAlert is a callback registered with OnPeerDownFor.
+type downAlert struct {
+	after time.Duration
+	fn    func(peer string, downSince time.Time)
+	fired map[string]time.Time // the outage each peer was last reported for
+}
+
+// OnPeerDownFor registers fn to be called once a peer has been down (or
+// errored) without interruption for d, unlike the on_down hooks, which fire
+// on every transition. fn is called once per outage, with the time the peer
+// went down; a peer coming up again arms it anew. It runs on the monitor
+// goroutine after a device poll, so it should return quickly.
+func (w *WgMesh) OnPeerDownFor(d time.Duration, fn func(peer string, downSince time.Time)) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	w.downAlerts = append(w.downAlerts, &downAlert{
+		after: d,
+		fn:    fn,
+		fired: make(map[string]time.Time),
+	})
+}
+
+// checkDownAlerts calls the OnPeerDownFor callbacks of peers that have been
+// down for long enough at now.
+func (w *WgMesh) checkDownAlerts(now time.Time) {
+	type call struct {
+		fn        func(string, time.Time)
+		peer      string
+		downSince time.Time
+	}
+	var calls []call
+
+	w.statusMu.Lock()
+	for _, alert := range w.downAlerts {
+		for name, fired := range alert.fired {
+			if w.status.Peers[name].downSince() != fired {
+				// Recovered or gone since, arm it for the next outage
+				delete(alert.fired, name)
+			}
+		}
+		for name, status := range w.status.Peers {
+			since := status.downSince()
+			if since.IsZero() || now.Sub(since) < alert.after {
+				continue
+			}
+			if _, ok := alert.fired[name]; ok {
+				continue
+			}
+			alert.fired[name] = since
+			calls = append(calls, call{fn: alert.fn, peer: name, downSince: since})
+		}
+	}
+	w.statusMu.Unlock()
+
+	// Outside the lock, so the callbacks can read the status
+	for _, c := range calls {
+		c.fn(c.peer, c.downSince)
+	}
+}
+
+// downSince returns when the peer went down, or errored, without coming up
+// in between. It is zero while the peer isn't down.
+func (s PeerStatus) downSince() time.Time {
+	if !isDown(s.State) {
+		return time.Time{}
+	}
+
+	var since time.Time
+	for i := len(s.Events) - 1; i >= 0; i-- {
+		event := s.Events[i]
+		if !isDown(event.To) {
+			break
+		}
+		since = event.Time
+		if !isDown(event.From) {
+			break
+		}
+	}
+	return since
+}
@@ -0,0 +1,93 @@
+package wgmesh_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// downAlerts records the calls of an OnPeerDownFor callback.
+type downAlerts struct {
+	mu    sync.Mutex
+	calls []string
+	since []time.Time
+}
+
+func (a *downAlerts) record(peer string, downSince time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, peer)
+	a.since = append(a.since, downSince)
+}
+
+func (a *downAlerts) Calls() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.calls...)
+}
+
+func TestOnPeerDownFor(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig)
+	alerts := &downAlerts{}
+	mesh.OnPeerDownFor(5*time.Minute, alerts.record)
+
+	key := mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")
+	start := time.Now()
+	handshake := func(at, now time.Time) {
+		mesh.UpdatePeerStatus([]wgtypes.Peer{{PublicKey: key, LastHandshakeTime: at}}, now)
+	}
+
+	handshake(start, start)
+	mesh.CheckDownAlerts(start)
+
+	// The handshake goes stale and the peer down
+	down := start.Add(4 * time.Minute)
+	handshake(start, down)
+	mesh.CheckDownAlerts(down.Add(time.Minute))
+	assert.Empty(t, alerts.Calls())
+
+	// Down for longer than the threshold, the callback fires once
+	handshake(start, down.Add(5*time.Minute))
+	mesh.CheckDownAlerts(down.Add(5 * time.Minute))
+	mesh.CheckDownAlerts(down.Add(10 * time.Minute))
+	handshake(start, down.Add(15*time.Minute))
+	mesh.CheckDownAlerts(down.Add(15 * time.Minute))
+	assert.Equal(t, []string{"peer1"}, alerts.Calls())
+	assert.Equal(t, []time.Time{down}, alerts.since)
+
+	// Recovering resets it, a short outage doesn't fire
+	up := down.Add(20 * time.Minute)
+	handshake(up, up)
+	mesh.CheckDownAlerts(up)
+	handshake(up, up.Add(4*time.Minute))
+	mesh.CheckDownAlerts(up.Add(6 * time.Minute))
+	handshake(up.Add(7*time.Minute), up.Add(7*time.Minute))
+	mesh.CheckDownAlerts(up.Add(10 * time.Minute))
+	assert.Equal(t, []string{"peer1"}, alerts.Calls())
+
+	// A new long outage fires again
+	again := up.Add(11 * time.Minute)
+	handshake(up.Add(7*time.Minute), again)
+	mesh.CheckDownAlerts(again.Add(5 * time.Minute))
+	assert.Equal(t, []string{"peer1", "peer1"}, alerts.Calls())
+	assert.Equal(t, again, alerts.since[1])
+}
+
+func TestOnPeerDownForMonitor(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	alerts := &downAlerts{}
+	mesh.OnPeerDownFor(0, alerts.record)
+
+	device := &wgtypes.Device{Peers: []wgtypes.Peer{{
+		PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+		LastHandshakeTime: time.Now().Add(-time.Hour),
+	}}}
+	pollOnce(t, mesh, mockClient, device)
+
+	require.Len(t, alerts.Calls(), 1)
+	assert.Equal(t, "peer1", alerts.Calls()[0])
+}
@@ -0,0 +1,154 @@
+package wgmesh
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DriftReport lists the differences between the live device and the
+// configuration.
+type DriftReport struct {
+	// Extra holds the public keys of device peers that aren't configured.
+	Extra []string `json:"extra,omitempty"`
+
+	// Missing holds the names of configured peers absent from the device.
+	Missing []string `json:"missing,omitempty"`
+
+	// Mismatched holds the peer settings that differ from the config.
+	Mismatched []PeerDrift `json:"mismatched,omitempty"`
+}
+
+// PeerDrift is a single peer setting that differs between config and device.
+type PeerDrift struct {
+	Peer  string `json:"peer"`
+	Field string `json:"field"`
+	Want  string `json:"want"`
+	Have  string `json:"have"`
+}
+
+// HasDrift reports whether the device differs from the configuration.
+func (r DriftReport) HasDrift() bool {
+	return len(r.Extra) > 0 || len(r.Missing) > 0 || len(r.Mismatched) > 0
+}
+
+func (r DriftReport) String() string {
+	if !r.HasDrift() {
+		return "no drift detected\n"
+	}
+
+	var b strings.Builder
+	for _, key := range r.Extra {
+		fmt.Fprintf(&b, "extra peer on device: %s\n", key)
+	}
+	for _, name := range r.Missing {
+		fmt.Fprintf(&b, "missing peer: %s\n", name)
+	}
+	for _, d := range r.Mismatched {
+		fmt.Fprintf(&b, "peer %s: %s is %q, want %q\n", d.Peer, d.Field, d.Have, d.Want)
+	}
+	return b.String()
+}
+
+// DetectDrift reads the device and compares its peers, allowed IPs and
+// endpoints with the configuration. Endpoints are only compared for peers
+// that configure one.
+func (w *WgMesh) DetectDrift() (DriftReport, error) {
+	cfg := w.currentConfig()
+
+	device, err := w.deviceClient().Device(cfg.NetworkName)
+	if err != nil {
+		return DriftReport{}, fmt.Errorf("failed to read device: %w", err)
+	}
+	return w.compareDevice(cfg, device)
+}
+
+// compareDevice compares the peers of a device read with cfg.
+func (w *WgMesh) compareDevice(cfg *Config, device *wgtypes.Device) (DriftReport, error) {
+	devicePeers := make(map[wgtypes.Key]wgtypes.Peer, len(device.Peers))
+	for _, peer := range device.Peers {
+		devicePeers[peer.PublicKey] = peer
+	}
+
+	var report DriftReport
+	configured := make(map[wgtypes.Key]bool, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		pubKey, err := parseKey(peer.PublicKey)
+		if err != nil {
+			return DriftReport{}, fmt.Errorf("invalid public key for peer %s: %w", peer.Name, err)
+		}
+		configured[pubKey] = true
+
+		current, ok := devicePeers[pubKey]
+		if !ok {
+			report.Missing = append(report.Missing, peer.Name)
+			continue
+		}
+
+		report.Mismatched = append(report.Mismatched, w.comparePeer(peer, current)...)
+	}
+
+	for key := range devicePeers {
+		if !configured[key] && !w.removalPending(key) {
+			report.Extra = append(report.Extra, key.String())
+		}
+	}
+	sort.Strings(report.Extra)
+
+	return report, nil
+}
+
+func (w *WgMesh) comparePeer(peer Peer, current wgtypes.Peer) []PeerDrift {
+	var drift []PeerDrift
+
+	want := make([]string, 0, len(peer.AllowedIPs))
+	for _, ip := range peer.AllowedIPs {
+		if ipNet, err := parseAllowedIP(ip); err == nil {
+			want = append(want, ipNet.String())
+		}
+	}
+	if w.isQuarantined(peer.Name) {
+		want = want[:0]
+	}
+	have := make([]string, 0, len(current.AllowedIPs))
+	for _, ipNet := range current.AllowedIPs {
+		have = append(have, ipNet.String())
+	}
+	sort.Strings(want)
+	sort.Strings(have)
+	if strings.Join(want, ",") != strings.Join(have, ",") {
+		drift = append(drift, PeerDrift{
+			Peer:  peer.Name,
+			Field: "AllowedIPs",
+			Want:  strings.Join(want, ","),
+			Have:  strings.Join(have, ","),
+		})
+	}
+
+	if !peer.IsRoaming() {
+		haveEndpoint := ""
+		if current.Endpoint != nil {
+			haveEndpoint = current.Endpoint.String()
+		}
+		wantEndpoint := peer.Endpoint
+		if endpoint, err := w.resolveEndpoint(peer); err == nil {
+			if current.Endpoint != nil && current.Endpoint.Zone == "" {
+				// The device doesn't report the zone of scoped endpoints
+				endpoint.Zone = ""
+			}
+			wantEndpoint = endpoint.String()
+		}
+		if wantEndpoint != haveEndpoint {
+			drift = append(drift, PeerDrift{
+				Peer:  peer.Name,
+				Field: "Endpoint",
+				Want:  wantEndpoint,
+				Have:  haveEndpoint,
+			})
+		}
+	}
+
+	return drift
+}
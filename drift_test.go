@@ -0,0 +1,109 @@
+package wgmesh_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const driftConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32", "10.1.0.0/16"]
+    endpoint: 192.0.2.1:51820
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+  - name: peer3
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.4/32"]
+`
+
+func mustParseCIDR(t *testing.T, s string) net.IPNet {
+	t.Helper()
+	_, ipNet, err := net.ParseCIDR(s)
+	require.NoError(t, err)
+	return *ipNet
+}
+
+func TestDetectDrift(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, driftConfig)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{
+			{
+				// Same allowed IPs in a different order, endpoint changed by hand
+				PublicKey:  mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+				AllowedIPs: []net.IPNet{mustParseCIDR(t, "10.1.0.0/16"), mustParseCIDR(t, "10.0.0.2/32")},
+				Endpoint:   &net.UDPAddr{IP: net.ParseIP("198.51.100.9"), Port: 51820},
+			},
+			{
+				PublicKey:  mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="),
+				AllowedIPs: []net.IPNet{mustParseCIDR(t, "10.0.0.0/24")},
+			},
+			{
+				// Added with wg set
+				PublicKey: mustParseKey(t, "n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw="),
+			},
+		},
+	}, nil)
+
+	report, err := mesh.DetectDrift()
+	require.NoError(t, err)
+	assert.True(t, report.HasDrift())
+
+	assert.Equal(t, []string{"n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw="}, report.Extra)
+	assert.Equal(t, []string{"peer3"}, report.Missing)
+	assert.Equal(t, []wgmesh.PeerDrift{
+		{Peer: "peer1", Field: "Endpoint", Want: "192.0.2.1:51820", Have: "198.51.100.9:51820"},
+		{Peer: "peer2", Field: "AllowedIPs", Want: "10.0.0.3/32", Have: "10.0.0.0/24"},
+	}, report.Mismatched)
+	assert.Contains(t, report.String(), "missing peer: peer3")
+}
+
+func TestDetectDriftInSync(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, allowedIPsConfig)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{{
+			PublicKey:  mustParseKey(t, "a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA="),
+			AllowedIPs: []net.IPNet{mustParseCIDR(t, "10.0.0.0/24")},
+		}},
+	}, nil)
+
+	report, err := mesh.DetectDrift()
+	require.NoError(t, err)
+	assert.False(t, report.HasDrift())
+	assert.Equal(t, "no drift detected\n", report.String())
+}
+
+func TestDetectDriftScopedEndpoint(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    endpoint: "[fe80::1%eth0]:51820"
+`)
+	// The device reports the endpoint without its zone
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{{
+			PublicKey:  mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+			AllowedIPs: []net.IPNet{mustParseCIDR(t, "10.0.0.2/32")},
+			Endpoint:   &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 51820},
+		}},
+	}, nil)
+
+	report, err := mesh.DetectDrift()
+	require.NoError(t, err)
+	assert.False(t, report.HasDrift(), report.String())
+}
@@ -0,0 +1,158 @@
+package wgmesh
+
+import (
+	"io"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Exported for tests in package wgmesh_test.
+var MonitorBackoff = monitorBackoff
+
+// HandshakeState derives the peer state with the default handshake timeout.
+func HandshakeState(lastHandshake, now time.Time) (PeerState, bool) {
+	return handshakeState(lastHandshake, now, handshakeTimeout)
+}
+
+var AggregateMeshState = aggregateMeshState
+
+const MaxPeerEvents = maxPeerEvents
+
+// Reconcile runs a single reconcile pass.
+func (w *WgMesh) Reconcile() (DriftReport, error) {
+	return w.reconcile()
+}
+
+func (w *WgMesh) UpdatePeerState(name string, state PeerState, err error) {
+	w.updatePeerState(name, state, err)
+}
+
+// Wait blocks until the goroutines of the mesh, e.g. running peer hooks,
+// have finished.
+func (w *WgMesh) Wait() {
+	w.wg.Wait()
+}
+
+var HasNetAdmin = hasNetAdmin
+
+var DeviceImplementation = deviceImplementation
+
+// SetUAPIDir replaces the directory of userspace control sockets until the
+// returned restore function is called.
+func SetUAPIDir(dir string) (restore func()) {
+	old := uapiDir
+	uapiDir = dir
+	return func() { uapiDir = old }
+}
+
+// SetRenameFile replaces the function moving atomically written files into
+// place until the returned restore function is called.
+func SetRenameFile(rename func(oldpath, newpath string) error) (restore func()) {
+	old := renameFile
+	renameFile = rename
+	return func() { renameFile = old }
+}
+
+// UpdatePeerStatus applies a device read taken at now to the peer statuses.
+func (w *WgMesh) UpdatePeerStatus(peers []wgtypes.Peer, now time.Time) {
+	w.updatePeerStatus(peers, now)
+}
+
+// ProbePathMTU runs a single path MTU probe pass.
+func (w *WgMesh) ProbePathMTU() {
+	w.probePathMTU()
+}
+
+// DiffMesh classifies the peers of a reload as added, removed and updated.
+func (w *WgMesh) DiffMesh(oldPeers, newPeers []Peer) (added, removed, updated []Peer) {
+	return w.diffMesh(oldPeers, newPeers)
+}
+
+// SetWatchRetryDelay replaces the first delay before re-establishing a
+// failed configuration watch until the returned restore function is called.
+func SetWatchRetryDelay(delay time.Duration) (restore func()) {
+	old := watchRetryDelay
+	watchRetryDelay = delay
+	return func() { watchRetryDelay = old }
+}
+
+// SetNewWatcher replaces the constructor of file source watchers until the
+// returned restore function is called.
+func SetNewWatcher(create func() (*fsnotify.Watcher, error)) (restore func()) {
+	old := newWatcher
+	newWatcher = create
+	return func() { newWatcher = old }
+}
+
+var Jitter = jitter
+
+// SetJitterRand replaces the random source of the interval jitter until the
+// returned restore function is called.
+func SetJitterRand(random func() float64) (restore func()) {
+	old := jitterRand
+	jitterRand = random
+	return func() { jitterRand = old }
+}
+
+// EffectiveJitterPercent returns the jitter applied with c.
+func EffectiveJitterPercent(c *Config) int {
+	return c.jitterPercent()
+}
+
+// SetStreamThreshold replaces the size from which configuration files are
+// streamed until the returned restore function is called.
+func SetStreamThreshold(size int64) (restore func()) {
+	old := streamThreshold
+	streamThreshold = size
+	return func() { streamThreshold = old }
+}
+
+// StreamConfig decodes a configuration with the streaming decoder only.
+func StreamConfig(r io.Reader) (*Config, error) {
+	return streamConfig(r)
+}
+
+var ParseKey = parseKey
+
+// CheckDownAlerts runs the OnPeerDownFor callbacks due at now.
+func (w *WgMesh) CheckDownAlerts(now time.Time) {
+	w.checkDownAlerts(now)
+}
+
+// PendingReloads returns the number of Reload calls waiting for the
+// follow-up reload.
+func (w *WgMesh) PendingReloads() int {
+	return w.reloadQueue.pending()
+}
+
+// PollDevice runs a single device poll, like the monitor does.
+func (w *WgMesh) PollDevice() error {
+	return w.pollDevice()
+}
+
+// ReassertPeers adds back peers found missing by an earlier poll.
+func (w *WgMesh) ReassertPeers(missing []Peer) {
+	w.reassertPeers(missing)
+}
+
+// ReresolveEndpoints runs a single endpoint re-resolution.
+func (w *WgMesh) ReresolveEndpoints() error {
+	return w.reresolveEndpoints()
+}
+
+// AllowedIPCacheLen returns the number of cached parsed allowed IPs.
+func AllowedIPCacheLen() int {
+	allowedIPCache.Lock()
+	defer allowedIPCache.Unlock()
+	return len(allowedIPCache.nets)
+}
+
+// SetMonitorStallTolerance replaces how late the monitor may be before the
+// watchdog pings stop until the returned restore function is called.
+func SetMonitorStallTolerance(tolerance time.Duration) (restore func()) {
+	old := monitorStallTolerance
+	monitorStallTolerance = tolerance
+	return func() { monitorStallTolerance = old }
+}
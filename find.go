@@ -0,0 +1,56 @@
+package wgmesh
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// PeerFilter selects peers in FindPeers. Empty criteria match every peer,
+// set ones must all match.
+type PeerFilter struct {
+	// Name matches peers whose name contains it.
+	Name string
+
+	// States matches peers in any of the given states.
+	States []PeerState
+
+	// Tags matches peers carrying all of the given tags.
+	Tags []string
+}
+
+// PeerView is a configured peer together with its current status.
+type PeerView struct {
+	Peer   Peer
+	Status PeerStatus
+}
+
+// FindPeers returns the configured peers matching filter, sorted by name.
+func (w *WgMesh) FindPeers(filter PeerFilter) []PeerView {
+	status := w.GetStatus()
+
+	var views []PeerView
+	for _, peer := range w.currentConfig().Peers {
+		view := PeerView{Peer: peer, Status: status.Peers[peer.Name]}
+		if filter.matches(view) {
+			views = append(views, view)
+		}
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Peer.Name < views[j].Peer.Name })
+	return views
+}
+
+func (f PeerFilter) matches(view PeerView) bool {
+	if f.Name != "" && !strings.Contains(view.Peer.Name, f.Name) {
+		return false
+	}
+	if len(f.States) > 0 && !slices.Contains(f.States, view.Status.State) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !slices.Contains(view.Peer.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
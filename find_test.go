@@ -0,0 +1,74 @@
+package wgmesh_test
+
+import (
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+)
+
+const findConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: office-gw
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.1.0.0/16"]
+    tags: [gateway, office]
+  - name: office-laptop
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+    tags: [office]
+  - name: home-gw
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.2.0.0/16"]
+    tags: [gateway]
+`
+
+func peerNames(views []wgmesh.PeerView) []string {
+	names := make([]string, 0, len(views))
+	for _, view := range views {
+		names = append(names, view.Peer.Name)
+	}
+	return names
+}
+
+func TestFindPeers(t *testing.T) {
+	mesh, _ := newTestMesh(t, findConfig)
+	mesh.UpdatePeerState("office-gw", wgmesh.PeerStateUp, nil)
+	mesh.UpdatePeerState("office-laptop", wgmesh.PeerStateDown, nil)
+	mesh.UpdatePeerState("home-gw", wgmesh.PeerStateDown, nil)
+
+	tests := []struct {
+		name   string
+		filter wgmesh.PeerFilter
+		want   []string
+	}{
+		{"all", wgmesh.PeerFilter{}, []string{"home-gw", "office-gw", "office-laptop"}},
+		{"down", wgmesh.PeerFilter{States: []wgmesh.PeerState{wgmesh.PeerStateDown}}, []string{"home-gw", "office-laptop"}},
+		{"up or error", wgmesh.PeerFilter{States: []wgmesh.PeerState{wgmesh.PeerStateUp, wgmesh.PeerStateError}}, []string{"office-gw"}},
+		{"name", wgmesh.PeerFilter{Name: "office"}, []string{"office-gw", "office-laptop"}},
+		{"tag", wgmesh.PeerFilter{Tags: []string{"gateway"}}, []string{"home-gw", "office-gw"}},
+		{"all tags", wgmesh.PeerFilter{Tags: []string{"gateway", "office"}}, []string{"office-gw"}},
+		{"name and state", wgmesh.PeerFilter{Name: "gw", States: []wgmesh.PeerState{wgmesh.PeerStateDown}}, []string{"home-gw"}},
+		{"no match", wgmesh.PeerFilter{Name: "office", Tags: []string{"home"}}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, peerNames(mesh.FindPeers(tt.filter)))
+		})
+	}
+}
+
+func TestFindPeersMergesStatus(t *testing.T) {
+	mesh, _ := newTestMesh(t, findConfig)
+	mesh.UpdatePeerState("office-gw", wgmesh.PeerStateUp, nil)
+
+	views := mesh.FindPeers(wgmesh.PeerFilter{Name: "office-gw"})
+	if assert.Len(t, views, 1) {
+		assert.Equal(t, []string{"10.1.0.0/16"}, views[0].Peer.AllowedIPs)
+		assert.Equal(t, wgmesh.PeerStateUp, views[0].Status.State)
+	}
+}
@@ -0,0 +1,53 @@
+package wgmesh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ToDOT returns the mesh as an undirected Graphviz graph: the local
+// interface in the middle, an edge to every peer labeled with the allowed
+// IPs routed to it, and the peers labeled with their endpoints. Render it
+// with e.g. `dot -Tsvg`.
+func (c *Config) ToDOT() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "graph %s {\n", dotQuote(c.NetworkName))
+	b.WriteString("  node [shape=box];\n")
+
+	local := []string{c.NetworkName}
+	if c.Address != "" {
+		local = append(local, c.Address)
+	}
+	if c.ListenPort != 0 {
+		local = append(local, fmt.Sprintf("port %d", c.ListenPort))
+	}
+	fmt.Fprintf(&b, "  %s [label=%s, shape=doubleoctagon];\n", dotQuote(c.NetworkName), dotQuote(strings.Join(local, "\n")))
+
+	for _, peer := range c.Peers {
+		label := []string{peer.Name}
+		if peer.IsRoaming() {
+			label = append(label, "(roaming)")
+		} else {
+			label = append(label, peer.Endpoint)
+		}
+		attrs := "label=" + dotQuote(strings.Join(label, "\n"))
+		if peer.Required {
+			attrs += ", style=bold"
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", dotQuote(peer.Name), attrs)
+		fmt.Fprintf(&b, "  %s -- %s [label=%s];\n", dotQuote(c.NetworkName), dotQuote(peer.Name), dotQuote(strings.Join(peer.AllowedIPs, "\n")))
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote returns s as a quoted DOT ID. Newlines become line breaks in
+// labels.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
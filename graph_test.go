@@ -0,0 +1,41 @@
+package wgmesh_test
+
+import (
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToDOT(t *testing.T) {
+	cfg := &wgmesh.Config{
+		NetworkName: "wg0",
+		ListenPort:  51820,
+		Address:     "10.0.0.1/24",
+		Peers: []wgmesh.Peer{
+			{
+				Name:       "hub",
+				PublicKey:  "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=",
+				AllowedIPs: []string{"10.0.0.2/32", "192.168.10.0/24"},
+				Endpoint:   "hub.example.com:51820",
+				Required:   true,
+			},
+			{
+				Name:       `laptop "work"`,
+				PublicKey:  "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=",
+				AllowedIPs: []string{"10.0.0.3/32"},
+			},
+		},
+	}
+
+	dot := cfg.ToDOT()
+	assert.Equal(t, `graph "wg0" {
+  node [shape=box];
+  "wg0" [label="wg0\n10.0.0.1/24\nport 51820", shape=doubleoctagon];
+  "hub" [label="hub\nhub.example.com:51820", style=bold];
+  "wg0" -- "hub" [label="10.0.0.2/32\n192.168.10.0/24"];
+  "laptop \"work\"" [label="laptop \"work\"\n(roaming)"];
+  "wg0" -- "laptop \"work\"" [label="10.0.0.3/32"];
+}
+`, dot)
+}
@@ -0,0 +1,45 @@
+package wgmesh
+
+import "time"
+
+// HealthScore returns the fraction of peers that are up, between 0.0 and
+// 1.0. When some peers are required only those are counted. A peer only
+// counts as up while its last handshake is recent, so a stale status can't
+// inflate the score. A mesh without peers is fully healthy.
+func (w *WgMesh) HealthScore() float64 {
+	return healthScore(w.currentConfig().Peers, w.GetStatus().Peers, time.Now())
+}
+
+func healthScore(peers []Peer, statuses map[string]PeerStatus, now time.Time) float64 {
+	counted := peers
+	for _, peer := range peers {
+		if peer.Required {
+			counted = requiredPeers(peers)
+			break
+		}
+	}
+
+	if len(counted) == 0 {
+		return 1.0
+	}
+
+	up := 0
+	for _, peer := range counted {
+		status := statuses[peer.Name]
+		if status.State == PeerStateUp && now.Sub(status.LastSeen) < peer.staleAfter() {
+			up++
+		}
+	}
+
+	return float64(up) / float64(len(counted))
+}
+
+func requiredPeers(peers []Peer) []Peer {
+	var required []Peer
+	for _, peer := range peers {
+		if peer.Required {
+			required = append(required, peer)
+		}
+	}
+	return required
+}
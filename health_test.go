@@ -0,0 +1,76 @@
+package wgmesh_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const healthConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+monitor_interval: 5ms
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+
+func TestHealthScore(t *testing.T) {
+	fresh := time.Now()
+	stale := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name       string
+		handshakes [2]time.Time
+		want       float64
+	}{
+		{"all up", [2]time.Time{fresh, fresh}, 1.0},
+		{"half down", [2]time.Time{fresh, stale}, 0.5},
+		{"all down", [2]time.Time{stale, {}}, 0.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh, mockClient := newTestMesh(t, healthConfig)
+			pollOnce(t, mesh, mockClient, &wgtypes.Device{
+				Peers: []wgtypes.Peer{
+					{
+						PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+						LastHandshakeTime: tt.handshakes[0],
+					},
+					{
+						PublicKey:         mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="),
+						LastHandshakeTime: tt.handshakes[1],
+					},
+				},
+			})
+
+			assert.InDelta(t, tt.want, mesh.HealthScore(), 0.001)
+		})
+	}
+}
+
+func TestHealthScoreRequiredPeers(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, healthConfig+`
+  - name: hub
+    required: true
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.0/24"]
+`)
+	pollOnce(t, mesh, mockClient, &wgtypes.Device{
+		Peers: []wgtypes.Peer{{
+			PublicKey:         mustParseKey(t, "WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ="),
+			LastHandshakeTime: time.Now(),
+		}},
+	})
+
+	// Only the hub counts, the optional peers are down
+	assert.InDelta(t, 1.0, mesh.HealthScore(), 0.001)
+}
@@ -0,0 +1,200 @@
+package wgmesh
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultHTTPListen is used when the HTTP server is only enabled for pprof.
+// Profiles expose internals, so it only listens on localhost.
+const defaultHTTPListen = "127.0.0.1:9586"
+
+// HTTPHandler serves the metrics at /metrics, the status as JSON at /status
+// and interface totals at /stats. The pprof profiles are added under
+// /debug/pprof/ when enabled with Config.Pprof or WithPprof. All endpoints
+// but the /healthz liveness check require the API token when one is
+// configured.
+func (w *WgMesh) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(rw, "ok")
+	})
+
+	api := http.NewServeMux()
+	mux.Handle("/", w.requireToken(api))
+
+	api.Handle("/metrics", w.MetricsHandler())
+	api.HandleFunc("/status", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(w.GetStatus()); err != nil {
+			log.Error().Err(err).Msg("Failed to write status")
+		}
+	})
+
+	api.HandleFunc("/stats", func(rw http.ResponseWriter, r *http.Request) {
+		stats, err := w.InterfaceStats()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(stats); err != nil {
+			log.Error().Err(err).Msg("Failed to write stats")
+		}
+	})
+
+	if w.pprofEnabled() {
+		api.HandleFunc("/debug/pprof/", pprof.Index)
+		api.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		api.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		api.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		api.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	return mux
+}
+
+// requireToken rejects requests without the bearer API token, if any. The
+// token is looked up on every request so that reloads and rotated token
+// files take effect.
+func (w *WgMesh) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		token, err := w.apiToken()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read API token")
+			http.Error(rw, "API token unavailable", http.StatusInternalServerError)
+			return
+		}
+		if token != "" {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				rw.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(rw, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// apiToken returns the token required by the HTTP API, or "" when it is
+// open.
+func (w *WgMesh) apiToken() (string, error) {
+	cfg := w.currentConfig()
+	if cfg.APITokenFile == "" {
+		return cfg.APIToken, nil
+	}
+
+	data, err := os.ReadFile(cfg.APITokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("API token file %s is empty", cfg.APITokenFile)
+	}
+	return token, nil
+}
+
+func (w *WgMesh) pprofEnabled() bool {
+	return w.pprof || w.currentConfig().Pprof
+}
+
+// httpListenAddr returns the address of the HTTP server, or "" when it is
+// disabled.
+func (w *WgMesh) httpListenAddr() string {
+	if addr := w.currentConfig().HTTPListen; addr != "" {
+		return addr
+	}
+	if w.pprofEnabled() {
+		return defaultHTTPListen
+	}
+	return ""
+}
+
+// startHTTP starts the HTTP server, if enabled.
+func (w *WgMesh) startHTTP() error {
+	addr := w.httpListenAddr()
+	if addr == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	if w.pprofEnabled() {
+		log.Warn().Str("addr", listener.Addr().String()).Msg("Serving pprof profiles, don't expose this address")
+	}
+
+	tlsConfig, err := w.HTTPTLSConfig()
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	server := &http.Server{Handler: w.HTTPHandler()}
+
+	w.wg.Add(2)
+	go func() {
+		defer w.wg.Done()
+		<-w.ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		defer w.wg.Done()
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("HTTP server stopped")
+		}
+	}()
+
+	return nil
+}
+
+// HTTPTLSConfig returns the TLS configuration of the HTTP server, or nil when
+// it serves plain HTTP. Clients must present a certificate signed by
+// Config.HTTPTLSClientCA when it is set.
+func (w *WgMesh) HTTPTLSConfig() (*tls.Config, error) {
+	cfg := w.currentConfig()
+	if cfg.HTTPTLSCert == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.HTTPTLSCert, cfg.HTTPTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load HTTP TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.HTTPTLSClientCA != "" {
+		pem, err := os.ReadFile(cfg.HTTPTLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HTTP TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in HTTP TLS client CA %s", cfg.HTTPTLSClientCA)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,317 @@
+package wgmesh_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const httpConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`
+
+func get(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestHTTPHandler(t *testing.T) {
+	mesh, _ := newTestMesh(t, httpConfig)
+	handler := mesh.HTTPHandler()
+
+	rec := get(t, handler, "/status")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var status wgmesh.MeshStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, "wg0", status.NetworkName)
+
+	rec = get(t, handler, "/metrics")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "wgmesh_")
+}
+
+func TestHTTPStats(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, httpConfig+`
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`)
+	now := time.Now().UTC().Truncate(time.Second)
+	device := &wgtypes.Device{
+		Name:       "wg0",
+		PublicKey:  mustParseKey(t, "a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA="),
+		ListenPort: 51820,
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+				LastHandshakeTime: now,
+				ReceiveBytes:      1000,
+				TransmitBytes:     2000,
+			},
+			{
+				// Not configured, still counted in the interface totals
+				PublicKey:     mustParseKey(t, "n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw="),
+				ReceiveBytes:  10,
+				TransmitBytes: 20,
+			},
+		},
+	}
+	mockClient.On("Device", "wg0").Return(device, nil)
+	mesh.UpdatePeerStatus(device.Peers, now)
+
+	rec := get(t, mesh.HTTPHandler(), "/stats")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var stats wgmesh.InterfaceStats
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	assert.Equal(t, wgmesh.InterfaceStats{
+		NetworkName: "wg0",
+		PublicKey:   "a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA=",
+		ListenPort:  51820,
+		RxBytes:     1010,
+		TxBytes:     2020,
+		Peers:       2,
+		PeersByState: map[wgmesh.PeerState]int{
+			wgmesh.PeerStateUp:    1,
+			wgmesh.PeerStateError: 1, // peer2 is missing from the device
+		},
+		LastPoll: now,
+	}, stats)
+}
+
+func TestHTTPStatsDeviceError(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, httpConfig)
+	mockClient.On("Device", "wg0").Return(nil, errors.New("no such device"))
+
+	rec := get(t, mesh.HTTPHandler(), "/stats")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "no such device")
+}
+
+func TestHTTPPprof(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		mesh, _ := newTestMesh(t, httpConfig)
+		assert.Equal(t, http.StatusNotFound, get(t, mesh.HTTPHandler(), "/debug/pprof/").Code)
+		assert.Equal(t, http.StatusNotFound, get(t, mesh.HTTPHandler(), "/debug/pprof/cmdline").Code)
+	})
+
+	t.Run("config", func(t *testing.T) {
+		mesh, _ := newTestMesh(t, httpConfig+"pprof: true\n")
+		assert.Equal(t, http.StatusOK, get(t, mesh.HTTPHandler(), "/debug/pprof/").Code)
+		assert.Equal(t, http.StatusOK, get(t, mesh.HTTPHandler(), "/debug/pprof/cmdline").Code)
+	})
+
+	t.Run("option", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(httpConfig), 0o600))
+		mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(&MockWireguardClient{}), wgmesh.WithPprof())
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, get(t, mesh.HTTPHandler(), "/debug/pprof/").Code)
+	})
+}
+
+// testCert is a certificate with its key, written to PEM files.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA
+// when parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return c
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestHTTPTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	server := newTestCert(t, "server", ca)
+	client := newTestCert(t, "client", ca)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	serve := func(t *testing.T, extra string) string {
+		t.Helper()
+
+		mesh, _ := newTestMesh(t, httpConfig+fmt.Sprintf("http_tls_cert: %s\nhttp_tls_key: %s\n", server.certFile, server.keyFile)+extra)
+		tlsConfig, err := mesh.HTTPTLSConfig()
+		require.NoError(t, err)
+		require.NotNil(t, tlsConfig)
+
+		ts := httptest.NewUnstartedServer(mesh.HTTPHandler())
+		ts.TLS = tlsConfig
+		ts.StartTLS()
+		t.Cleanup(ts.Close)
+		return ts.URL
+	}
+	request := func(url string, certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+		return client.Get(url + "/status")
+	}
+
+	t.Run("tls", func(t *testing.T) {
+		url := serve(t, "")
+		resp, err := request(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("mtls", func(t *testing.T) {
+		url := serve(t, fmt.Sprintf("http_tls_client_ca: %s\n", ca.certFile))
+
+		resp, err := request(url, client.tlsCertificate(t))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		resp, err = request(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		assert.Error(t, err)
+
+		// A certificate of another CA is rejected as well
+		other := newTestCert(t, "other", newTestCert(t, "other-ca", nil))
+		resp, err = request(url, other.tlsCertificate(t))
+		if err == nil {
+			resp.Body.Close()
+		}
+		assert.Error(t, err)
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		mesh, _ := newTestMesh(t, httpConfig)
+		tlsConfig, err := mesh.HTTPTLSConfig()
+		require.NoError(t, err)
+		assert.Nil(t, tlsConfig)
+	})
+}
+
+func TestHTTPAPIToken(t *testing.T) {
+	request := func(handler http.Handler, path, auth string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("token", func(t *testing.T) {
+		mesh, _ := newTestMesh(t, httpConfig+"api_token: s3cret\n")
+		handler := mesh.HTTPHandler()
+
+		assert.Equal(t, http.StatusOK, request(handler, "/status", "Bearer s3cret"))
+		assert.Equal(t, http.StatusOK, request(handler, "/metrics", "Bearer s3cret"))
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/status", ""))
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/status", "Bearer wrong"))
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/metrics", "s3cret"))
+		assert.Equal(t, http.StatusOK, request(handler, "/healthz", ""))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(path, []byte("s3cret\n"), 0o600))
+		mesh, _ := newTestMesh(t, httpConfig+"api_token_file: "+path+"\n")
+		handler := mesh.HTTPHandler()
+
+		assert.Equal(t, http.StatusOK, request(handler, "/status", "Bearer s3cret"))
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/status", ""))
+
+		// Rotating the file takes effect without a reload
+		require.NoError(t, os.WriteFile(path, []byte("rotated"), 0o600))
+		assert.Equal(t, http.StatusUnauthorized, request(handler, "/status", "Bearer s3cret"))
+		assert.Equal(t, http.StatusOK, request(handler, "/status", "Bearer rotated"))
+
+		// A missing token file fails closed
+		require.NoError(t, os.Remove(path))
+		assert.Equal(t, http.StatusInternalServerError, request(handler, "/status", "Bearer rotated"))
+		assert.Equal(t, http.StatusOK, request(handler, "/healthz", ""))
+	})
+
+	t.Run("open", func(t *testing.T) {
+		mesh, _ := newTestMesh(t, httpConfig)
+		assert.Equal(t, http.StatusOK, request(mesh.HTTPHandler(), "/status", ""))
+	})
+}
@@ -0,0 +1,57 @@
+package wgmesh
+
+import (
+	"fmt"
+	"time"
+)
+
+// InterfaceStats are totals for the whole interface, served at /stats.
+type InterfaceStats struct {
+	NetworkName string `json:"network_name"`
+	PublicKey   string `json:"public_key"`
+	ListenPort  int    `json:"listen_port"`
+
+	// RxBytes and TxBytes add up the counters of all peers on the device.
+	RxBytes int64 `json:"rx_bytes"`
+	TxBytes int64 `json:"tx_bytes"`
+
+	// Peers counts the configured peers, PeersByState them by state.
+	Peers        int               `json:"peers"`
+	PeersByState map[PeerState]int `json:"peers_by_state"`
+
+	// LastPoll is when the monitor last read the device, zero before the
+	// first read.
+	LastPoll time.Time `json:"last_poll"`
+}
+
+// InterfaceStats reads the device and sums up its peer counters, along with
+// the peer states of the status.
+func (w *WgMesh) InterfaceStats() (InterfaceStats, error) {
+	cfg := w.currentConfig()
+	device, err := w.deviceClient().Device(cfg.NetworkName)
+	if err != nil {
+		return InterfaceStats{}, fmt.Errorf("failed to read device %s: %w", cfg.NetworkName, err)
+	}
+
+	stats := InterfaceStats{
+		NetworkName:  cfg.NetworkName,
+		PublicKey:    device.PublicKey.String(),
+		ListenPort:   device.ListenPort,
+		PeersByState: make(map[PeerState]int),
+	}
+	for _, peer := range device.Peers {
+		stats.RxBytes += peer.ReceiveBytes
+		stats.TxBytes += peer.TransmitBytes
+	}
+
+	w.statusMu.RLock()
+	defer w.statusMu.RUnlock()
+	for _, peer := range cfg.Peers {
+		stats.Peers++
+		if state := w.status.Peers[peer.Name].State; state != "" {
+			stats.PeersByState[state]++
+		}
+	}
+	stats.LastPoll = w.lastPoll
+	return stats, nil
+}
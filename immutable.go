@@ -0,0 +1,45 @@
+package wgmesh
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// checkImmutable returns an error if newConfig changes a field listed in
+// ImmutableFields of the active configuration. The list itself is protected
+// too, so a reload can't lift the restriction it is subject to.
+func (c *Config) checkImmutable(newConfig *Config) error {
+	if len(c.ImmutableFields) == 0 {
+		return nil
+	}
+
+	var changed []string
+	for _, name := range append([]string{"immutable_fields"}, c.ImmutableFields...) {
+		old, ok := configField(c, name)
+		if !ok {
+			continue
+		}
+		current, _ := configField(newConfig, name)
+		if !reflect.DeepEqual(old.Interface(), current.Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("configuration changes immutable fields: %s", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+// configField returns the field of c with the given YAML name.
+func configField(c *Config, name string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,64 @@
+package wgmesh_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const immutableConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: %s
+immutable_fields: [private_key, listen_port]
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: [%s]
+`
+
+func TestImmutableFields(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(immutableConfig, "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=", "10.0.0.2/32"))
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	// A new private key is rejected, even with peer changes alongside
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(fmt.Sprintf(immutableConfig, "mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=", "10.0.0.2/32, 10.0.1.0/24")), 0o600))
+	err := mesh.Reload()
+	assert.EqualError(t, err, "configuration changes immutable fields: private_key")
+	assert.Equal(t, "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=", mesh.Config.PrivateKey)
+	assert.Equal(t, []string{"10.0.0.2/32"}, mesh.Config.Peers[0].AllowedIPs)
+	assert.Empty(t, configureCalls(mockClient))
+
+	// Changing only the peers is fine
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(fmt.Sprintf(immutableConfig, "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=", "10.0.0.2/32, 10.0.1.0/24")), 0o600))
+	require.NoError(t, mesh.Reload())
+	assert.Equal(t, []string{"10.0.0.2/32", "10.0.1.0/24"}, mesh.Config.Peers[0].AllowedIPs)
+	assert.NotEmpty(t, configureCalls(mockClient))
+}
+
+func TestImmutableFieldsProtectThemselves(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(immutableConfig, "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=", "10.0.0.2/32"))
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=
+peers: []
+`), 0o600))
+	err := mesh.Reload()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "immutable_fields")
+	assert.Contains(t, err.Error(), "private_key")
+}
+
+func TestImmutableFieldsValidation(t *testing.T) {
+	cfg := validConfig()
+	cfg.ImmutableFields = []string{"private_key", "no_such_field"}
+
+	assert.EqualError(t, cfg.Validate(), `unknown immutable field "no_such_field"`)
+}
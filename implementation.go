@@ -0,0 +1,49 @@
+package wgmesh
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Implementation tells which WireGuard implementation backs the interface,
+// as the kernel module and wireguard-go differ in subtle ways.
+type Implementation string
+
+const (
+	ImplementationKernel    Implementation = "kernel"
+	ImplementationUserspace Implementation = "userspace"
+	ImplementationUnknown   Implementation = "unknown"
+)
+
+// uapiDir is where userspace implementations create their control sockets.
+var uapiDir = "/var/run/wireguard"
+
+// deviceImplementation derives the implementation from the device type
+// reported by wgctrl. A device of unknown type is taken as userspace when it
+// has a UAPI socket, which the kernel module never creates.
+func deviceImplementation(device *wgtypes.Device) Implementation {
+	switch device.Type {
+	case wgtypes.LinuxKernel, wgtypes.OpenBSDKernel, wgtypes.FreeBSDKernel, wgtypes.WindowsKernel:
+		return ImplementationKernel
+	case wgtypes.Userspace:
+		return ImplementationUserspace
+	}
+
+	if _, err := os.Stat(filepath.Join(uapiDir, device.Name+".sock")); err == nil {
+		return ImplementationUserspace
+	}
+	return ImplementationUnknown
+}
+
+// setImplementation records the implementation of the device in the status.
+func (w *WgMesh) setImplementation(impl Implementation) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	if w.status.Implementation != impl {
+		w.status.Implementation = impl
+		w.publishStatus()
+	}
+}
@@ -0,0 +1,47 @@
+package wgmesh_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestDeviceImplementation(t *testing.T) {
+	dir := t.TempDir()
+	defer wgmesh.SetUAPIDir(dir)()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "wg1.sock"), nil, 0o600))
+
+	tests := []struct {
+		name   string
+		device wgtypes.Device
+		want   wgmesh.Implementation
+	}{
+		{"linux kernel", wgtypes.Device{Name: "wg0", Type: wgtypes.LinuxKernel}, wgmesh.ImplementationKernel},
+		{"openbsd kernel", wgtypes.Device{Name: "wg0", Type: wgtypes.OpenBSDKernel}, wgmesh.ImplementationKernel},
+		{"userspace", wgtypes.Device{Name: "wg0", Type: wgtypes.Userspace}, wgmesh.ImplementationUserspace},
+		{"unknown with uapi socket", wgtypes.Device{Name: "wg1"}, wgmesh.ImplementationUserspace},
+		{"unknown", wgtypes.Device{Name: "wg0"}, wgmesh.ImplementationUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, wgmesh.DeviceImplementation(&tt.device))
+		})
+	}
+}
+
+func TestImplementationInStatus(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	pollOnce(t, mesh, mockClient, &wgtypes.Device{Name: "wg0", Type: wgtypes.Userspace})
+	assert.Equal(t, wgmesh.ImplementationUserspace, mesh.GetStatus().Implementation)
+
+	diag, err := mesh.Diagnostics()
+	require.NoError(t, err)
+	require.NotNil(t, diag.Device)
+	assert.Equal(t, wgmesh.ImplementationUserspace, diag.Device.Implementation)
+}
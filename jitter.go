@@ -0,0 +1,37 @@
+package wgmesh
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// defaultJitterPercent is used when Config.JitterPercent is unset.
+const defaultJitterPercent = 10
+
+// maxJitterPercent keeps jittered intervals well away from zero.
+const maxJitterPercent = 50
+
+// jitterRand returns a random number in [0, 1). Replaced by tests.
+var jitterRand = rand.Float64
+
+func (c *Config) jitterPercent() int {
+	switch {
+	case c.JitterPercent < 0:
+		return 0
+	case c.JitterPercent == 0:
+		return defaultJitterPercent
+	default:
+		return c.JitterPercent
+	}
+}
+
+// jitter spreads interval randomly by up to percent in either direction, so
+// the tickers of many instances don't synchronize and hit netlink or DNS all
+// at once.
+func jitter(interval time.Duration, percent int) time.Duration {
+	if percent <= 0 || interval <= 0 {
+		return interval
+	}
+	spread := float64(interval) * float64(percent) / 100
+	return interval + time.Duration(spread*(2*jitterRand()-1))
+}
@@ -0,0 +1,52 @@
+package wgmesh_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitter(t *testing.T) {
+	values := []float64{0, 0.25, 0.5, 0.75, 0.999}
+	next := 0
+	defer wgmesh.SetJitterRand(func() float64 {
+		v := values[next%len(values)]
+		next++
+		return v
+	})()
+
+	var intervals []time.Duration
+	for range values {
+		interval := wgmesh.Jitter(10*time.Second, 20)
+		assert.GreaterOrEqual(t, interval, 8*time.Second)
+		assert.LessOrEqual(t, interval, 12*time.Second)
+		intervals = append(intervals, interval)
+	}
+	assert.Equal(t, 8*time.Second, intervals[0])
+	assert.Equal(t, 9*time.Second, intervals[1])
+	assert.Equal(t, 10*time.Second, intervals[2])
+	assert.Equal(t, 11*time.Second, intervals[3])
+	assert.Greater(t, intervals[4], intervals[3])
+
+	// Without jitter the interval is kept
+	assert.Equal(t, 10*time.Second, wgmesh.Jitter(10*time.Second, 0))
+}
+
+func TestJitterPercent(t *testing.T) {
+	assert.Equal(t, 10, wgmesh.EffectiveJitterPercent(&wgmesh.Config{}))
+	assert.Equal(t, 25, wgmesh.EffectiveJitterPercent(&wgmesh.Config{JitterPercent: 25}))
+	assert.Equal(t, 0, wgmesh.EffectiveJitterPercent(&wgmesh.Config{JitterPercent: -1}))
+}
+
+func TestJitterRandom(t *testing.T) {
+	seen := make(map[time.Duration]bool)
+	for range 20 {
+		interval := wgmesh.Jitter(time.Minute, 10)
+		assert.GreaterOrEqual(t, interval, 54*time.Second)
+		assert.LessOrEqual(t, interval, 66*time.Second)
+		seen[interval] = true
+	}
+	assert.Greater(t, len(seen), 1, "consecutive intervals should vary")
+}
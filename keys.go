@@ -0,0 +1,52 @@
+package wgmesh
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// hexKeyLen is the length of a hex-encoded key, as printed by some tools.
+const hexKeyLen = 2 * wgtypes.KeyLen
+
+// parseKey parses a key in any of the formats the configuration accepts:
+// base64 as printed by wg(8), 64 hex characters, or @/path of a file
+// holding the key in either encoding. The lengths of the encodings differ,
+// so a key can't be mistaken for another encoding.
+func parseKey(s string) (wgtypes.Key, error) {
+	if path, ok := strings.CutPrefix(s, "@"); ok {
+		if path == "" {
+			return wgtypes.Key{}, fmt.Errorf("key file reference %q has no path", s)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("failed to read key file: %w", err)
+		}
+		content := strings.TrimSpace(string(data))
+		if strings.HasPrefix(content, "@") {
+			return wgtypes.Key{}, fmt.Errorf("key file %s refers to another key file", path)
+		}
+		key, err := parseKey(content)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("key file %s: %w", path, err)
+		}
+		return key, nil
+	}
+
+	if len(s) == hexKeyLen {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("key of %d characters is not valid hex: %w", hexKeyLen, err)
+		}
+		return wgtypes.NewKey(b)
+	}
+
+	key, err := wgtypes.ParseKey(s)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("expected a base64 key of 44 characters, a hex key of %d characters or @/path of a key file: %w", hexKeyLen, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,124 @@
+package wgmesh_test
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKey(t *testing.T) {
+	const base64Key = "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8="
+	want := mustParseKey(t, base64Key)
+	hexKey := hex.EncodeToString(want[:])
+
+	dir := t.TempDir()
+	writeKey := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	base64File := writeKey("base64.key", base64Key+"\n")
+	hexFile := writeKey("hex.key", hexKey+"\n")
+	nestedFile := writeKey("nested.key", "@"+base64File+"\n")
+	invalidFile := writeKey("invalid.key", "not-a-key\n")
+
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "base64", input: base64Key},
+		{name: "hex", input: hexKey},
+		{name: "upper case hex", input: strings.ToUpper(hexKey)},
+		{name: "base64 file", input: "@" + base64File},
+		{name: "hex file", input: "@" + hexFile},
+		{
+			name:    "invalid",
+			input:   "not-a-key",
+			wantErr: "expected a base64 key of 44 characters, a hex key of 64 characters or @/path of a key file",
+		},
+		{
+			name:    "truncated base64",
+			input:   base64Key[:40],
+			wantErr: "expected a base64 key of 44 characters",
+		},
+		{
+			name:    "invalid hex",
+			input:   "zz" + hexKey[2:],
+			wantErr: "key of 64 characters is not valid hex",
+		},
+		{
+			name:    "missing file",
+			input:   "@" + filepath.Join(dir, "missing.key"),
+			wantErr: "failed to read key file",
+		},
+		{
+			name:    "empty path",
+			input:   "@",
+			wantErr: `key file reference "@" has no path`,
+		},
+		{
+			name:    "nested file",
+			input:   "@" + nestedFile,
+			wantErr: "refers to another key file",
+		},
+		{
+			name:    "invalid file",
+			input:   "@" + invalidFile,
+			wantErr: "key file " + invalidFile + ": expected a base64 key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := wgmesh.ParseKey(tt.input)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, want, key)
+		})
+	}
+}
+
+func TestKeyFormats(t *testing.T) {
+	privateKey := mustParseKey(t, "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=")
+	publicKey := mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")
+
+	keyFile := filepath.Join(t.TempDir(), "private.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(privateKey.String()+"\n"), 0o600))
+
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: "@`+keyFile+`"
+peers:
+  - name: peer1
+    public_key: `+hex.EncodeToString(publicKey[:])+`
+    allowed_ips: [10.0.0.2/32]
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	// Public keys are compared with the device's, so they are kept in base64
+	assert.Equal(t, publicKey.String(), mesh.Config.Peers[0].PublicKey)
+
+	require.NoError(t, mesh.Start())
+	require.NoError(t, mesh.Close())
+
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	require.NotNil(t, calls[0].PrivateKey)
+	assert.Equal(t, privateKey, *calls[0].PrivateKey)
+	require.Len(t, calls[0].Peers, 1)
+	assert.Equal(t, publicKey, calls[0].Peers[0].PublicKey)
+}
@@ -0,0 +1,84 @@
+package wgmesh
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// LinkManager manages the network interface itself: creating it, its
+// address, MTU and admin state. The default uses ip(8) through the
+// CommandRunner; the netlink one needs no external binaries.
+type LinkManager interface {
+	// EnsureLink creates the WireGuard interface unless it exists.
+	EnsureLink(name string) error
+	DeleteLink(name string) error
+	SetMTU(name string, mtu int) error
+	SetUp(name string) error
+	SetDown(name string) error
+	ReplaceAddress(name, cidr string) error
+	DeleteAddress(name, cidr string) error
+}
+
+// Link manager names for Config.LinkManager.
+const (
+	LinkManagerIP      = "ip"
+	LinkManagerNetlink = "netlink"
+)
+
+// newLinkManager returns the link manager selected by name.
+func newLinkManager(name string, runner CommandRunner) (LinkManager, error) {
+	switch name {
+	case "", LinkManagerIP:
+		return &ipLinkManager{runner: runner}, nil
+	case LinkManagerNetlink:
+		return newNetlinkLinkManager()
+	default:
+		return nil, fmt.Errorf("unknown link manager %q", name)
+	}
+}
+
+// linkManager returns the link manager set with WithLinkManager, or the one
+// selected in the configuration. The ip one is built on demand so it uses the
+// current CommandRunner.
+func (w *WgMesh) linkManager() (LinkManager, error) {
+	if w.links != nil {
+		return w.links, nil
+	}
+	return newLinkManager(w.Config.LinkManager, w.CommandRunner)
+}
+
+// ipLinkManager manages the interface with ip(8).
+type ipLinkManager struct {
+	runner CommandRunner
+}
+
+func (m *ipLinkManager) EnsureLink(name string) error {
+	if m.runner.Run("ip", "link", "show", "dev", name) == nil {
+		return nil
+	}
+	return m.runner.Run("ip", "link", "add", "dev", name, "type", "wireguard")
+}
+
+func (m *ipLinkManager) DeleteLink(name string) error {
+	return m.runner.Run("ip", "link", "del", "dev", name)
+}
+
+func (m *ipLinkManager) SetMTU(name string, mtu int) error {
+	return m.runner.Run("ip", "link", "set", "dev", name, "mtu", strconv.Itoa(mtu))
+}
+
+func (m *ipLinkManager) SetUp(name string) error {
+	return m.runner.Run("ip", "link", "set", "dev", name, "up")
+}
+
+func (m *ipLinkManager) SetDown(name string) error {
+	return m.runner.Run("ip", "link", "set", "dev", name, "down")
+}
+
+func (m *ipLinkManager) ReplaceAddress(name, cidr string) error {
+	return m.runner.Run("ip", "address", "replace", cidr, "dev", name)
+}
+
+func (m *ipLinkManager) DeleteAddress(name, cidr string) error {
+	return m.runner.Run("ip", "address", "del", cidr, "dev", name)
+}
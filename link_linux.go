@@ -0,0 +1,92 @@
+package wgmesh
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// netlinkLinkManager manages the interface over rtnetlink.
+type netlinkLinkManager struct{}
+
+func newNetlinkLinkManager() (LinkManager, error) {
+	return netlinkLinkManager{}, nil
+}
+
+func (netlinkLinkManager) EnsureLink(name string) error {
+	_, err := netlink.LinkByName(name)
+	if err == nil {
+		return nil
+	}
+	var notFound netlink.LinkNotFoundError
+	if !errors.As(err, &notFound) {
+		return err
+	}
+
+	link := &netlink.Wireguard{LinkAttrs: netlink.LinkAttrs{Name: name}}
+	if err := netlink.LinkAdd(link); err != nil {
+		return fmt.Errorf("failed to create interface %s: %w", name, err)
+	}
+	return nil
+}
+
+func (netlinkLinkManager) DeleteLink(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkDel(link)
+}
+
+func (netlinkLinkManager) SetMTU(name string, mtu int) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetMTU(link, mtu)
+}
+
+func (netlinkLinkManager) SetUp(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(link)
+}
+
+func (netlinkLinkManager) SetDown(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	return netlink.LinkSetDown(link)
+}
+
+func (netlinkLinkManager) ReplaceAddress(name, cidr string) error {
+	link, addr, err := linkAddr(name, cidr)
+	if err != nil {
+		return err
+	}
+	return netlink.AddrReplace(link, addr)
+}
+
+func (netlinkLinkManager) DeleteAddress(name, cidr string) error {
+	link, addr, err := linkAddr(name, cidr)
+	if err != nil {
+		return err
+	}
+	return netlink.AddrDel(link, addr)
+}
+
+func linkAddr(name, cidr string) (netlink.Link, *netlink.Addr, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr, err := netlink.ParseAddr(cidr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return link, addr, nil
+}
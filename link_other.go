@@ -0,0 +1,9 @@
+//go:build !linux
+
+package wgmesh
+
+import "errors"
+
+func newNetlinkLinkManager() (LinkManager, error) {
+	return nil, errors.New("the netlink link manager is only supported on Linux")
+}
@@ -0,0 +1,128 @@
+package wgmesh_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeLinkManager records the link operations it is asked to perform.
+type fakeLinkManager struct {
+	mu  sync.Mutex
+	ops []string
+}
+
+func (m *fakeLinkManager) record(format string, args ...interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ops = append(m.ops, fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (m *fakeLinkManager) Ops() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.ops...)
+}
+
+func (m *fakeLinkManager) EnsureLink(name string) error { return m.record("ensure %s", name) }
+func (m *fakeLinkManager) DeleteLink(name string) error { return m.record("delete %s", name) }
+func (m *fakeLinkManager) SetUp(name string) error      { return m.record("up %s", name) }
+func (m *fakeLinkManager) SetDown(name string) error    { return m.record("down %s", name) }
+
+func (m *fakeLinkManager) SetMTU(name string, mtu int) error {
+	return m.record("mtu %s %d", name, mtu)
+}
+
+func (m *fakeLinkManager) ReplaceAddress(name, cidr string) error {
+	return m.record("address replace %s %s", name, cidr)
+}
+
+func (m *fakeLinkManager) DeleteAddress(name, cidr string) error {
+	return m.record("address del %s %s", name, cidr)
+}
+
+func TestLinkManagerSequence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+address: 10.0.0.1/24
+create_interface: true
+mtu: 1420
+peers: []
+`), 0o600))
+
+	links := &fakeLinkManager{}
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(mockClient), wgmesh.WithLinkManager(links))
+	require.NoError(t, err)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+	assert.Equal(t, []string{
+		"ensure wg0",
+		"mtu wg0 1420",
+		"address replace wg0 10.0.0.1/24",
+		"up wg0",
+	}, links.Ops())
+
+	require.NoError(t, mesh.StopTunnel())
+	assert.Equal(t, []string{
+		"address del wg0 10.0.0.1/24",
+		"down wg0",
+		"delete wg0",
+	}, links.Ops()[4:])
+}
+
+func TestIPLinkManagerCommands(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+address: 10.0.0.1/24
+create_interface: true
+mtu: 1420
+peers: []
+`)
+	runner := &fakeRunner{fail: map[string]error{
+		"ip link show dev wg0": fmt.Errorf("Device \"wg0\" does not exist."),
+	}}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+	assert.Equal(t, []string{
+		"ip link show dev wg0",
+		"ip link add dev wg0 type wireguard",
+		"ip link set dev wg0 mtu 1420",
+		"ip address replace 10.0.0.1/24 dev wg0",
+		"ip link set dev wg0 up",
+	}, runner.Commands())
+}
+
+func TestValidateLinkManager(t *testing.T) {
+	cfg := validConfig()
+	cfg.LinkManager = "ifconfig"
+	assert.ErrorContains(t, cfg.Validate(), `unknown link_manager "ifconfig"`)
+
+	cfg = validConfig()
+	cfg.MTU = 100
+	assert.ErrorContains(t, cfg.Validate(), "mtu 100 is out of range")
+}
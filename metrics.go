@@ -0,0 +1,164 @@
+package wgmesh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultTextfileInterval is used when Config.MetricsTextfileInterval is
+// unset.
+const defaultTextfileInterval = 15 * time.Second
+
+// WriteMetrics writes the mesh metrics in the Prometheus text exposition
+// format.
+func (w *WgMesh) WriteMetrics(out io.Writer) error {
+	status := w.GetStatus()
+	network := status.NetworkName
+
+	var b bytes.Buffer
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("wgmesh_mesh_state", "gauge", "Mesh state, 1 for the current state.")
+	for _, state := range []MeshState{MeshStateUp, MeshStatePartial, MeshStateDown} {
+		value := 0
+		if status.Status == state {
+			value = 1
+		}
+		fmt.Fprintf(&b, "wgmesh_mesh_state{network=%s,state=%s} %d\n", quoteLabel(network), quoteLabel(string(state)), value)
+	}
+
+	metric("wgmesh_health_score", "gauge", "Fraction of counted peers with a recent handshake.")
+	fmt.Fprintf(&b, "wgmesh_health_score{network=%s} %g\n", quoteLabel(network), w.HealthScore())
+
+	reloads := w.reloadCounts()
+	metric("wgmesh_reload_total", "counter", "Configuration reloads by result.")
+	fmt.Fprintf(&b, "wgmesh_reload_total{network=%s,result=\"success\"} %d\n", quoteLabel(network), reloads.success)
+	fmt.Fprintf(&b, "wgmesh_reload_total{network=%s,result=\"failure\"} %d\n", quoteLabel(network), reloads.failure)
+
+	metric("wgmesh_reload_duration_seconds", "summary", "Time spent reloading the configuration.")
+	fmt.Fprintf(&b, "wgmesh_reload_duration_seconds_sum{network=%s} %g\n", quoteLabel(network), reloads.seconds)
+	fmt.Fprintf(&b, "wgmesh_reload_duration_seconds_count{network=%s} %d\n", quoteLabel(network), reloads.success+reloads.failure)
+
+	metric("wgmesh_config_last_reload_timestamp", "gauge", "Unix time the active configuration was applied.")
+	fmt.Fprintf(&b, "wgmesh_config_last_reload_timestamp{network=%s} %d\n", quoteLabel(network), status.ConfigLoadedAt.Unix())
+
+	metric("wgmesh_reload_peers_total", "counter", "Peers added, removed and updated by configuration changes.")
+	fmt.Fprintf(&b, "wgmesh_reload_peers_total{network=%s,change=\"added\"} %d\n", quoteLabel(network), reloads.added)
+	fmt.Fprintf(&b, "wgmesh_reload_peers_total{network=%s,change=\"removed\"} %d\n", quoteLabel(network), reloads.removed)
+	fmt.Fprintf(&b, "wgmesh_reload_peers_total{network=%s,change=\"updated\"} %d\n", quoteLabel(network), reloads.updated)
+
+	names := make([]string, 0, len(status.Peers))
+	for name := range status.Peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	peerMetric := func(name, typ, help string, value func(PeerStatus) float64) {
+		metric(name, typ, help)
+		for _, peer := range names {
+			fmt.Fprintf(&b, "%s{network=%s,peer=%s} %g\n", name, quoteLabel(network), quoteLabel(peer), value(status.Peers[peer]))
+		}
+	}
+
+	peerMetric("wgmesh_peer_up", "gauge", "Whether the peer is up.", func(p PeerStatus) float64 {
+		if p.State == PeerStateUp {
+			return 1
+		}
+		return 0
+	})
+	peerMetric("wgmesh_peer_receive_bytes_total", "counter", "Bytes received from the peer.", func(p PeerStatus) float64 {
+		return float64(p.BytesRecv)
+	})
+	peerMetric("wgmesh_peer_transmit_bytes_total", "counter", "Bytes sent to the peer.", func(p PeerStatus) float64 {
+		return float64(p.BytesSent)
+	})
+	peerMetric("wgmesh_peer_handshakes_observed_total", "counter", "Monitor polls that found a fresh handshake of the peer.", func(p PeerStatus) float64 {
+		return float64(p.HandshakesObserved)
+	})
+	peerMetric("wgmesh_peer_stale_polls_total", "counter", "Monitor polls that found no fresh handshake of the peer.", func(p PeerStatus) float64 {
+		return float64(p.StalePolls)
+	})
+	peerMetric("wgmesh_peer_last_seen_seconds", "gauge", "Unix time the peer was last seen, 0 if never.", func(p PeerStatus) float64 {
+		if p.LastSeen.IsZero() {
+			return 0
+		}
+		return float64(p.LastSeen.Unix())
+	})
+	peerMetric("wgmesh_peer_errors", "gauge", "Errors since the peer was last up.", func(p PeerStatus) float64 {
+		return float64(p.ErrorCount)
+	})
+
+	_, err := out.Write(b.Bytes())
+	return err
+}
+
+// MetricsHandler serves the mesh metrics for Prometheus.
+func (w *WgMesh) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := w.WriteMetrics(rw); err != nil {
+			log.Error().Err(err).Msg("Failed to write metrics")
+		}
+	})
+}
+
+// WriteTextfileMetrics writes the metrics to path for the node_exporter
+// textfile collector. The file is replaced atomically so the collector never
+// reads a partial file.
+func (w *WgMesh) WriteTextfileMetrics(path string) error {
+	var b bytes.Buffer
+	if err := w.WriteMetrics(&b); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, b.Bytes(), 0o644, nil)
+}
+
+// startTextfileMetrics starts the goroutine writing the textfile metrics, if
+// enabled.
+func (w *WgMesh) startTextfileMetrics() {
+	cfg := w.currentConfig()
+	if cfg.MetricsTextfile == "" {
+		return
+	}
+
+	interval := cfg.MetricsTextfileInterval
+	if interval <= 0 {
+		interval = defaultTextfileInterval
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := w.WriteTextfileMetrics(cfg.MetricsTextfile); err != nil {
+				log.Error().Err(err).Str("path", cfg.MetricsTextfile).Msg("Failed to write textfile metrics")
+			}
+
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// quoteLabel quotes a Prometheus label value.
+func quoteLabel(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
@@ -0,0 +1,163 @@
+package wgmesh_test
+
+import (
+	"bufio"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{([a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\]|\\.)*",?)*\})? (\S+)$`)
+
+// parseMetrics parses the text exposition format into samples keyed by the
+// metric name with labels, failing on malformed lines.
+func parseMetrics(t *testing.T, text string) map[string]float64 {
+	t.Helper()
+
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+
+		m := sampleLine.FindStringSubmatch(line)
+		require.NotNil(t, m, "malformed metric line %q", line)
+		value, err := strconv.ParseFloat(m[4], 64)
+		require.NoError(t, err, "malformed value in %q", line)
+		samples[m[1]+m[2]] = value
+	}
+	return samples
+}
+
+func TestWriteTextfileMetrics(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig+`
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	mesh.UpdatePeerState("peer2", wgmesh.PeerStateError, fmt.Errorf("unreachable"))
+
+	path := filepath.Join(t.TempDir(), "wgmesh.prom")
+	require.NoError(t, mesh.WriteTextfileMetrics(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	samples := parseMetrics(t, string(data))
+
+	assert.Equal(t, 1.0, samples[`wgmesh_mesh_state{network="wg0",state="partial"}`])
+	assert.Equal(t, 0.0, samples[`wgmesh_mesh_state{network="wg0",state="up"}`])
+	assert.Equal(t, 0.5, samples[`wgmesh_health_score{network="wg0"}`])
+	assert.Equal(t, 1.0, samples[`wgmesh_peer_up{network="wg0",peer="peer1"}`])
+	assert.Equal(t, 0.0, samples[`wgmesh_peer_up{network="wg0",peer="peer2"}`])
+	assert.Equal(t, 1.0, samples[`wgmesh_peer_errors{network="wg0",peer="peer2"}`])
+	assert.Contains(t, samples, `wgmesh_peer_receive_bytes_total{network="wg0",peer="peer1"}`)
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+
+	rec := httptest.NewRecorder()
+	mesh.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+	samples := parseMetrics(t, rec.Body.String())
+	assert.Equal(t, 1.0, samples[`wgmesh_peer_up{network="wg0",peer="peer1"}`])
+}
+
+func TestTextfileMetricsPeriodic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wgmesh.prom")
+	mesh, mockClient := newTestMesh(t, monitorConfig+`
+metrics_textfile: `+path+`
+metrics_textfile_interval: 5ms
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestReloadMetrics(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	scrape := func() map[string]float64 {
+		rec := httptest.NewRecorder()
+		mesh.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return parseMetrics(t, rec.Body.String())
+	}
+
+	// Add peer2 and drop peer1
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte("peers: ["), 0o600))
+	require.Error(t, mesh.Reload())
+
+	samples := scrape()
+	assert.Equal(t, 1.0, samples[`wgmesh_reload_total{network="wg0",result="success"}`])
+	assert.Equal(t, 1.0, samples[`wgmesh_reload_total{network="wg0",result="failure"}`])
+	assert.Equal(t, 2.0, samples[`wgmesh_reload_duration_seconds_count{network="wg0"}`])
+	assert.Contains(t, samples, `wgmesh_reload_duration_seconds_sum{network="wg0"}`)
+	assert.Equal(t, 1.0, samples[`wgmesh_reload_peers_total{network="wg0",change="added"}`])
+	assert.Equal(t, 1.0, samples[`wgmesh_reload_peers_total{network="wg0",change="removed"}`])
+	assert.Equal(t, 0.0, samples[`wgmesh_reload_peers_total{network="wg0",change="updated"}`])
+	assert.Equal(t, float64(mesh.GetStatus().ConfigLoadedAt.Unix()), samples[`wgmesh_config_last_reload_timestamp{network="wg0"}`])
+}
+
+func TestReloadMetricsWatch(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// A file that fails to parse counts as a failed reload too
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte("peers: ["), 0o600))
+
+	assert.Eventually(t, func() bool {
+		var b strings.Builder
+		if err := mesh.WriteMetrics(&b); err != nil {
+			return false
+		}
+		return strings.Contains(b.String(), `wgmesh_reload_total{network="wg0",result="failure"} 1`)
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,382 @@
+package wgmesh
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// defaultMonitorInterval is used when Config.MonitorInterval is unset.
+	defaultMonitorInterval = 10 * time.Second
+
+	// maxMonitorBackoff caps the delay between device reads while they fail.
+	maxMonitorBackoff = 5 * time.Minute
+
+	// monitorErrorThreshold is the number of consecutive failed device reads
+	// after which all peers are marked as errored and the mesh as down.
+	monitorErrorThreshold = 3
+
+	// handshakeTimeout is how recent the last handshake must be for a peer
+	// to be considered up, unless the peer sets its own.
+	handshakeTimeout = 3 * time.Minute
+
+	// defaultStartupGrace is used when Config.StartupGrace is unset.
+	defaultStartupGrace = 2 * handshakeTimeout
+
+	// clockSkewTolerance is how far in the future a handshake may be before
+	// it is reported as clock skew rather than measurement noise.
+	clockSkewTolerance = time.Second
+
+	// skewWarnInterval is how often clock skew is logged per peer.
+	skewWarnInterval = 10 * time.Minute
+)
+
+// startMonitor starts the peer monitoring goroutine.
+func (w *WgMesh) startMonitor() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.monitorPeers()
+	}()
+}
+
+func (w *WgMesh) monitorInterval() time.Duration {
+	if interval := w.currentConfig().MonitorInterval; interval > 0 {
+		return interval
+	}
+	return defaultMonitorInterval
+}
+
+// monitorBackoff returns the delay before the next device read after the
+// given number of consecutive failures. It doubles with every failure and is
+// capped at maxMonitorBackoff, but is never shorter than the base interval.
+func monitorBackoff(interval time.Duration, failures int) time.Duration {
+	if interval >= maxMonitorBackoff {
+		return interval
+	}
+
+	delay := interval
+	for i := 0; i < failures; i++ {
+		delay *= 2
+		if delay >= maxMonitorBackoff {
+			return maxMonitorBackoff
+		}
+	}
+	return delay
+}
+
+func (w *WgMesh) monitorPeers() {
+	interval := w.monitorInterval()
+	percent := w.currentConfig().jitterPercent()
+	timer := time.NewTimer(w.expectPoll(jitter(interval, percent)))
+	defer timer.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		err := w.pollDevice()
+		w.checkDownAlerts(time.Now())
+		if err != nil {
+			failures++
+			delay := monitorBackoff(interval, failures)
+			log.Error().
+				Err(err).
+				Int("failures", failures).
+				Dur("retry_in", delay).
+				Msg("Failed to get device status")
+
+			if failures == monitorErrorThreshold {
+				w.markDeviceUnreachable(err)
+			}
+
+			timer.Reset(w.expectPoll(jitter(delay, percent)))
+			continue
+		}
+
+		if failures > 0 {
+			log.Info().Int("failures", failures).Msg("Device status readable again")
+			failures = 0
+		}
+		timer.Reset(w.expectPoll(jitter(interval, percent)))
+	}
+}
+
+// pollDevice reads the device once and updates the status of all peers. The
+// poll is skipped while a new configuration is being applied.
+func (w *WgMesh) pollDevice() error {
+	if w.reconfiguring.Load() > 0 {
+		log.Debug().Msg("Configuration change in progress, skipping device poll")
+		return nil
+	}
+
+	device, err := w.deviceClient().Device(w.currentConfig().NetworkName)
+	if err != nil {
+		return err
+	}
+	if w.reconfiguring.Load() > 0 {
+		// The read may have seen a partially applied configuration
+		return nil
+	}
+
+	w.setImplementation(deviceImplementation(device))
+	now := time.Now()
+	stats, missing := w.updatePeerStatus(device.Peers, now)
+	w.notifyEndpointChanges(now)
+	w.writeStats(stats)
+	w.saveStatus()
+	w.reassertPeers(missing)
+	return nil
+}
+
+// updatePeerStatus applies the device peers to the status and returns the
+// stats of the configured ones, along with the configured peers absent from
+// the device. With several monitor workers the new peer statuses are
+// computed in parallel; they are applied all at once while statusMu is
+// held, so readers never see a partial update.
+func (w *WgMesh) updatePeerStatus(peers []wgtypes.Peer, now time.Time) ([]peerStats, []Peer) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	w.lastPoll = now
+	cfg := w.currentConfig()
+	names := cfg.peerNamesByKey()
+
+	grace := cfg.StartupGrace
+	if grace <= 0 {
+		grace = defaultStartupGrace
+	}
+	starting := !w.startedAt.IsZero() && now.Sub(w.startedAt) < grace
+
+	timeouts := make(map[string]time.Duration, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		timeouts[peer.Name] = peer.staleAfter()
+	}
+
+	// Only configured peers are tracked
+	var (
+		configured []wgtypes.Peer
+		peerNames  []string
+	)
+	for _, peer := range peers {
+		if name := names[peer.PublicKey.String()]; name != "" {
+			configured = append(configured, peer)
+			peerNames = append(peerNames, name)
+		}
+	}
+
+	updated := make([]PeerStatus, len(configured))
+	update := func(from, to int) {
+		for i := from; i < to; i++ {
+			name := peerNames[i]
+			updated[i] = nextPeerStatus(w.status.Peers[name], name, configured[i], now, timeouts[name], starting)
+		}
+	}
+
+	workers := min(max(cfg.MonitorWorkers, 1), len(configured))
+	if workers <= 1 {
+		update(0, len(configured))
+	} else {
+		var wg sync.WaitGroup
+		chunk := (len(configured) + workers - 1) / workers
+		for from := 0; from < len(configured); from += chunk {
+			wg.Add(1)
+			go func(from, to int) {
+				defer wg.Done()
+				update(from, to)
+			}(from, min(from+chunk, len(configured)))
+		}
+		wg.Wait()
+	}
+
+	var stats []peerStats
+	seen := make(map[string]bool, len(updated))
+	for i, status := range updated {
+		w.peerStateChanged(status.Name, w.status.Peers[status.Name].State, status.State, now)
+		w.status.Peers[status.Name] = status
+		seen[status.Name] = true
+		if w.statsSink != nil {
+			stats = append(stats, newPeerStats(status.Name, status.State, configured[i], now))
+		}
+	}
+
+	// A peer missing from the device altogether was dropped behind our back
+	// (or never added), which is worse than a stale handshake
+	var missing []Peer
+	for _, peer := range cfg.Peers {
+		if seen[peer.Name] {
+			continue
+		}
+		missing = append(missing, peer)
+		status := w.status.Peers[peer.Name]
+		if status.State == PeerStateError {
+			// Keep the reason it failed to be configured, if known
+			continue
+		}
+		w.peerStateChanged(peer.Name, status.State, PeerStateError, now)
+		status.Name = peer.Name
+		status.setState(PeerStateError, now)
+		status.recordError(missingPeerError, now)
+		w.status.Peers[peer.Name] = status
+	}
+
+	w.updateMeshState()
+	return stats, missing
+}
+
+// missingPeerError is the error of configured peers absent from the device.
+const missingPeerError = "peer is missing from the device"
+
+// reassertPeers adds configured peers absent from the device back to it,
+// if Config.ReassertMissingPeers is set. missing comes from a poll that a
+// reload may have overtaken since, so it holds applyMu and only adds peers
+// the active configuration still has.
+func (w *WgMesh) reassertPeers(missing []Peer) {
+	cfg := w.currentConfig()
+	if len(missing) == 0 || !cfg.ReassertMissingPeers || cfg.ObserveOnly {
+		return
+	}
+
+	w.applyMu.Lock()
+	defer w.applyMu.Unlock()
+	if w.reconfiguring.Load() > 0 {
+		return
+	}
+
+	keys := make(map[string]bool, len(missing))
+	for _, peer := range missing {
+		keys[peer.PublicKey] = true
+	}
+	cfg = w.currentConfig()
+	for _, peer := range cfg.Peers {
+		if !keys[peer.PublicKey] {
+			continue
+		}
+		log.Warn().Str("peer", peer.Name).Msg("Peer missing from the device, adding it again")
+		if err := w.addPeer(cfg, peer); err != nil {
+			log.Error().Err(err).Str("peer", peer.Name).Msg("Failed to add missing peer again")
+		}
+	}
+}
+
+// nextPeerStatus derives the status of a peer from its previous status and
+// the device's view of it. The peer is down once its last handshake is older
+// than timeout.
+func nextPeerStatus(status PeerStatus, name string, peer wgtypes.Peer, now time.Time, timeout time.Duration, starting bool) PeerStatus {
+	status.Name = name
+	recv, sent := uint64(peer.ReceiveBytes), uint64(peer.TransmitBytes)
+	status.RxBitsPerSec, status.TxBitsPerSec = 0, 0
+	if elapsed := now.Sub(status.sampledAt).Seconds(); !status.sampledAt.IsZero() && elapsed > 0 {
+		status.RxBitsPerSec = bitRate(status.BytesRecv, recv, elapsed)
+		status.TxBitsPerSec = bitRate(status.BytesSent, sent, elapsed)
+	}
+	status.BytesRecv, status.BytesSent = recv, sent
+	status.sampledAt = now
+
+	if peer.Endpoint != nil {
+		endpoint := peer.Endpoint.String()
+		if status.Endpoint != "" && status.Endpoint != endpoint {
+			status.PreviousEndpoint = status.Endpoint
+			status.EndpointChangedAt = now
+		}
+		status.Endpoint = endpoint
+	}
+
+	state, skewed := handshakeState(peer.LastHandshakeTime, now, timeout)
+	if skewed && now.Sub(status.skewWarnedAt) >= skewWarnInterval {
+		status.skewWarnedAt = now
+		log.Warn().
+			Str("peer", name).
+			Time("handshake", peer.LastHandshakeTime).
+			Time("now", now).
+			Msg("Handshake time is in the future, check for clock skew")
+	}
+	if state == PeerStateUp {
+		status.HandshakesObserved++
+	} else {
+		status.StalePolls++
+	}
+
+	if state == PeerStateDown && starting && peer.LastHandshakeTime.IsZero() {
+		// No handshake yet is expected right after start
+		state = PeerStateConfiguring
+	}
+
+	status.setState(state, now)
+	if state == PeerStateUp {
+		status.LastSeen = peer.LastHandshakeTime
+		status.Error = ""
+		status.ErrorCount = 0
+	}
+	return status
+}
+
+// bitRate returns the rate in bits per second at which a byte counter went
+// from prev to cur in seconds, or 0 if it was reset.
+func bitRate(prev, cur uint64, seconds float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) * 8 / seconds
+}
+
+// handshakeState derives the peer state from its last handshake time, which
+// must be more recent than timeout. A handshake in the future has no age to
+// trust, so the peer is reported down; beyond clockSkewTolerance the clocks
+// disagree and skewed is set too.
+func handshakeState(lastHandshake, now time.Time, timeout time.Duration) (state PeerState, skewed bool) {
+	if lastHandshake.IsZero() {
+		return PeerStateDown, false
+	}
+
+	age := now.Sub(lastHandshake)
+	if age < 0 {
+		return PeerStateDown, age < -clockSkewTolerance
+	}
+	if age < timeout {
+		return PeerStateUp, false
+	}
+	return PeerStateDown, false
+}
+
+// markDeviceUnreachable marks every configured peer as errored and the mesh as
+// down once the device could not be read for too long.
+func (w *WgMesh) markDeviceUnreachable(err error) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	now := time.Now()
+	msg := fmt.Sprintf("device unreachable: %v", err)
+	for _, peer := range w.currentConfig().Peers {
+		status := w.status.Peers[peer.Name]
+		w.peerStateChanged(peer.Name, status.State, PeerStateError, now)
+		status.Name = peer.Name
+		status.setState(PeerStateError, now)
+		status.recordError(msg, now)
+		w.status.Peers[peer.Name] = status
+	}
+
+	w.status.Status = MeshStateDown
+	w.status.LastUpdate = now
+	w.publishStatus()
+}
+
+// peerNamesByKey maps the public keys of the peers to their names. Validate
+// rejects duplicate keys; should one slip through, the first peer wins.
+func (c *Config) peerNamesByKey() map[string]string {
+	names := make(map[string]string, len(c.Peers))
+	for _, peer := range c.Peers {
+		if _, ok := names[peer.PublicKey]; !ok {
+			names[peer.PublicKey] = peer.Name
+		}
+	}
+	return names
+}
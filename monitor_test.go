@@ -0,0 +1,610 @@
+package wgmesh_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const monitorConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+monitor_interval: 5ms
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`
+
+func mustParseKey(t *testing.T, s string) wgtypes.Key {
+	t.Helper()
+	key, err := wgtypes.ParseKey(s)
+	require.NoError(t, err)
+	return key
+}
+
+func TestMonitorBackoff(t *testing.T) {
+	base := 10 * time.Second
+
+	assert.Equal(t, base, wgmesh.MonitorBackoff(base, 0))
+	assert.Equal(t, 20*time.Second, wgmesh.MonitorBackoff(base, 1))
+	assert.Equal(t, 40*time.Second, wgmesh.MonitorBackoff(base, 2))
+	assert.Equal(t, 5*time.Minute, wgmesh.MonitorBackoff(base, 10))
+	assert.Equal(t, 5*time.Minute, wgmesh.MonitorBackoff(base, 1000))
+	assert.Equal(t, 10*time.Minute, wgmesh.MonitorBackoff(10*time.Minute, 3))
+}
+
+func TestMonitorBacksOffAndRecovers(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	var (
+		mu    sync.Mutex
+		reads []time.Time
+	)
+	record := func(mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		reads = append(reads, time.Now())
+	}
+
+	const failures = 4
+	downSeen := make(chan wgmesh.MeshStatus, 1)
+	mockClient.On("Device", "wg0").Return(nil, errors.New("no such device")).Times(failures).Run(func(args mock.Arguments) {
+		record(args)
+		mu.Lock()
+		n := len(reads)
+		mu.Unlock()
+		if n == failures {
+			// The threshold was crossed by the previous failed read
+			downSeen <- mesh.GetStatus()
+		}
+	})
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{{
+			PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+			LastHandshakeTime: time.Now(),
+		}},
+	}, nil).Run(record)
+
+	require.NoError(t, mesh.Start())
+
+	select {
+	case status := <-downSeen:
+		assert.Equal(t, wgmesh.MeshStateDown, status.Status)
+		assert.Equal(t, wgmesh.PeerStateError, status.Peers["peer1"].State)
+		assert.Equal(t, 1, status.Peers["peer1"].ErrorCount)
+		assert.False(t, status.Peers["peer1"].LastErrorTime.IsZero())
+	case <-time.After(5 * time.Second):
+		t.Fatal("device was never read")
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reads) >= failures+2
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, mesh.Close())
+
+	status := mesh.GetStatus()
+	assert.Equal(t, wgmesh.MeshStateUp, status.Status)
+	assert.Equal(t, wgmesh.PeerStateUp, status.Peers["peer1"].State)
+	assert.Zero(t, status.Peers["peer1"].ErrorCount)
+
+	mu.Lock()
+	defer mu.Unlock()
+	gaps := make([]time.Duration, 0, len(reads)-1)
+	for i := 1; i < len(reads); i++ {
+		gaps = append(gaps, reads[i].Sub(reads[i-1]))
+	}
+
+	// Failed reads are retried with a growing delay...
+	for i := 1; i < failures; i++ {
+		assert.Greater(t, gaps[i], gaps[i-1], "delay after failure %d should grow", i+1)
+	}
+	// ...and the base interval is restored once reads succeed again.
+	assert.Less(t, gaps[failures], gaps[failures-1])
+}
+
+func TestHandshakeState(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		handshake time.Time
+		state     wgmesh.PeerState
+		skewed    bool
+	}{
+		{"never", time.Time{}, wgmesh.PeerStateDown, false},
+		{"fresh", now.Add(-10 * time.Second), wgmesh.PeerStateUp, false},
+		{"stale", now.Add(-10 * time.Minute), wgmesh.PeerStateDown, false},
+		{"slightly ahead", now.Add(100 * time.Millisecond), wgmesh.PeerStateDown, false},
+		{"future", now.Add(time.Hour), wgmesh.PeerStateDown, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, skewed := wgmesh.HandshakeState(tt.handshake, now)
+			assert.Equal(t, tt.state, state)
+			assert.Equal(t, tt.skewed, skewed)
+		})
+	}
+}
+
+func TestPeerHandshakeTimeout(t *testing.T) {
+	mesh, _ := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: batch
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    handshake_timeout: 10m
+  - name: realtime
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+    handshake_timeout: 2m
+`)
+
+	// Both last shook hands 5 minutes ago, past the default of 3 minutes
+	now := time.Now()
+	mesh.UpdatePeerStatus([]wgtypes.Peer{
+		{PublicKey: mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="), LastHandshakeTime: now.Add(-5 * time.Minute)},
+		{PublicKey: mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="), LastHandshakeTime: now.Add(-5 * time.Minute)},
+	}, now)
+
+	peers := mesh.GetStatus().Peers
+	assert.Equal(t, wgmesh.PeerStateUp, peers["batch"].State)
+	assert.Equal(t, wgmesh.PeerStateDown, peers["realtime"].State)
+	assert.Equal(t, 0.5, mesh.HealthScore())
+}
+
+func TestPeerThroughput(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig)
+	key := mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")
+	poll := func(now time.Time, recv, sent int64) wgmesh.PeerStatus {
+		mesh.UpdatePeerStatus([]wgtypes.Peer{{PublicKey: key, ReceiveBytes: recv, TransmitBytes: sent}}, now)
+		return mesh.GetStatus().Peers["peer1"]
+	}
+
+	// No rate before there are two reads
+	now := time.Now()
+	status := poll(now, 1000, 500)
+	assert.Zero(t, status.RxBitsPerSec)
+	assert.Zero(t, status.TxBitsPerSec)
+
+	status = poll(now.Add(2*time.Second), 3000, 1500)
+	assert.Equal(t, 8000.0, status.RxBitsPerSec)
+	assert.Equal(t, 4000.0, status.TxBitsPerSec)
+	assert.Equal(t, uint64(3000), status.BytesRecv)
+
+	// A reset counter reads as zero, not negative, and the next read
+	// measures from the reset value
+	status = poll(now.Add(3*time.Second), 100, 1600)
+	assert.Zero(t, status.RxBitsPerSec)
+	assert.Equal(t, 800.0, status.TxBitsPerSec)
+
+	status = poll(now.Add(4*time.Second), 200, 1600)
+	assert.Equal(t, 800.0, status.RxBitsPerSec)
+	assert.Zero(t, status.TxBitsPerSec)
+}
+
+func TestMonitorFutureHandshakeNotUp(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	pollOnce(t, mesh, mockClient, &wgtypes.Device{
+		Peers: []wgtypes.Peer{{
+			PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+			LastHandshakeTime: time.Now().Add(time.Hour),
+		}},
+	})
+
+	assert.Equal(t, wgmesh.PeerStateDown, mesh.GetStatus().Peers["peer1"].State)
+}
+
+// pollOnce starts the mesh, waits until the monitor has applied at least one
+// read of device and closes the mesh again.
+func pollOnce(t *testing.T, mesh *wgmesh.WgMesh, mockClient *MockWireguardClient, device *wgtypes.Device) {
+	t.Helper()
+
+	mockClient.On("ConfigureDevice", mock.Anything, mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	polled := make(chan struct{}, 1)
+	mockClient.On("Device", mock.Anything).Return(device, nil).Run(func(mock.Arguments) {
+		select {
+		case polled <- struct{}{}:
+		default:
+		}
+	})
+
+	require.NoError(t, mesh.Start())
+	<-polled
+	<-polled // the first poll has been fully applied
+	require.NoError(t, mesh.Close())
+}
+
+func TestMonitorStartupGrace(t *testing.T) {
+	device := &wgtypes.Device{
+		Peers: []wgtypes.Peer{{PublicKey: mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")}},
+	}
+
+	t.Run("within grace", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, monitorConfig)
+		pollOnce(t, mesh, mockClient, device)
+
+		status := mesh.GetStatus()
+		assert.Equal(t, wgmesh.PeerStateConfiguring, status.Peers["peer1"].State)
+		assert.NotEqual(t, wgmesh.MeshStateDown, status.Status)
+	})
+
+	t.Run("after grace", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, monitorConfig+"startup_grace: 1ns\n")
+		pollOnce(t, mesh, mockClient, device)
+
+		status := mesh.GetStatus()
+		assert.Equal(t, wgmesh.PeerStateDown, status.Peers["peer1"].State)
+		assert.Equal(t, wgmesh.MeshStateDown, status.Status)
+	})
+}
+
+// reloadingClient serves a device with a single peer whose handshake time
+// the test controls. ConfigureDevice can be held to observe the mesh while a
+// configuration is being applied.
+type reloadingClient struct {
+	mu        sync.Mutex
+	handshake time.Time
+	hold      chan struct{} // ConfigureDevice waits on it when not nil
+	fail      error
+}
+
+func (c *reloadingClient) setHandshake(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handshake = t
+}
+
+func (c *reloadingClient) Device(name string) (*wgtypes.Device, error) {
+	key, _ := wgtypes.ParseKey("236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &wgtypes.Device{Peers: []wgtypes.Peer{{PublicKey: key, LastHandshakeTime: c.handshake}}}, nil
+}
+
+func (c *reloadingClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	c.mu.Lock()
+	hold, fail := c.hold, c.fail
+	c.mu.Unlock()
+
+	if hold != nil {
+		<-hold
+	}
+	return fail
+}
+
+func (c *reloadingClient) Close() error { return nil }
+
+func TestMonitorPausedDuringReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(monitorConfig+"startup_grace: 1ns\n"), 0o600))
+
+	client := &reloadingClient{handshake: time.Now()}
+	mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(client))
+	require.NoError(t, err)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+	require.Eventually(t, func() bool {
+		return mesh.GetStatus().Peers["peer1"].State == wgmesh.PeerStateUp
+	}, 5*time.Second, time.Millisecond)
+
+	// While peer1 is being updated, the device briefly shows it without a
+	// handshake.
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+monitor_interval: 5ms
+startup_grace: 1ns
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32", "10.0.1.0/24"]
+`), 0o600))
+
+	hold := make(chan struct{})
+	client.mu.Lock()
+	client.hold = hold
+	client.fail = errors.New("device busy")
+	client.mu.Unlock()
+
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- mesh.Reload() }()
+
+	client.setHandshake(time.Time{})
+	time.Sleep(50 * time.Millisecond) // several monitor intervals
+	assert.Equal(t, wgmesh.PeerStateUp, mesh.GetStatus().Peers["peer1"].State)
+
+	client.mu.Lock()
+	client.hold = nil
+	client.mu.Unlock()
+	close(hold)
+	require.ErrorContains(t, <-reloaded, "device busy")
+
+	// The failed reload doesn't leave the monitor paused
+	require.Eventually(t, func() bool {
+		return mesh.GetStatus().Peers["peer1"].State == wgmesh.PeerStateDown
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestRoamingPeerLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(monitorConfig+"startup_grace: 1ns\n"), 0o600))
+
+	client := &reloadingClient{}
+	mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(client))
+	require.NoError(t, err)
+	defer mesh.Close()
+
+	require.True(t, mesh.Config.Peers[0].IsRoaming())
+	assert.Empty(t, mesh.Config.Lint())
+
+	require.NoError(t, mesh.StartTunnel())
+
+	state := func() wgmesh.PeerState { return mesh.GetStatus().Peers["peer1"].State }
+
+	// Down until the client connects for the first time...
+	require.Eventually(t, func() bool { return state() == wgmesh.PeerStateDown }, 5*time.Second, time.Millisecond)
+
+	// ...up once it did...
+	client.setHandshake(time.Now())
+	require.Eventually(t, func() bool { return state() == wgmesh.PeerStateUp }, 5*time.Second, time.Millisecond)
+
+	// ...and down again after it went away
+	client.setHandshake(time.Now().Add(-10 * time.Minute))
+	require.Eventually(t, func() bool { return state() == wgmesh.PeerStateDown }, 5*time.Second, time.Millisecond)
+}
+
+func TestRoamingPeerHasNoEndpoint(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	// The client connected from wherever it is
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{{
+			PublicKey:  mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+			Endpoint:   &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 41641},
+			AllowedIPs: []net.IPNet{mustParseCIDR(t, "10.0.0.2/32")},
+		}},
+	}, nil)
+
+	_, err := mesh.RunOnce()
+	require.NoError(t, err)
+
+	calls := configureCalls(mockClient)
+	require.NotEmpty(t, calls)
+	require.Len(t, calls[0].Peers, 1)
+	assert.Nil(t, calls[0].Peers[0].Endpoint)
+
+	// The learned endpoint isn't drift
+	report, err := mesh.DetectDrift()
+	require.NoError(t, err)
+	assert.False(t, report.HasDrift())
+}
+
+// largeMesh returns a configuration with n peers and a device read in which
+// every other peer has a recent handshake.
+func largeMesh(tb testing.TB, n int) (*wgmesh.Config, []wgtypes.Peer) {
+	tb.Helper()
+
+	cfg := &wgmesh.Config{
+		NetworkName: "wg0",
+		ListenPort:  51820,
+		PrivateKey:  "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+	}
+	now := time.Now()
+	peers := make([]wgtypes.Peer, n)
+	for i := range peers {
+		key, err := wgtypes.GeneratePrivateKey()
+		require.NoError(tb, err)
+
+		cfg.Peers = append(cfg.Peers, wgmesh.Peer{
+			Name:       fmt.Sprintf("peer%d", i),
+			PublicKey:  key.PublicKey().String(),
+			AllowedIPs: []string{fmt.Sprintf("10.%d.%d.%d/32", i>>16&0xff, i>>8&0xff, i&0xff)},
+		})
+		peers[i] = wgtypes.Peer{
+			PublicKey:     key.PublicKey(),
+			ReceiveBytes:  int64(i),
+			TransmitBytes: int64(2 * i),
+		}
+		if i%2 == 0 {
+			peers[i].LastHandshakeTime = now.Add(-time.Minute)
+		}
+	}
+	return cfg, peers
+}
+
+func newWorkerMesh(tb testing.TB, cfg *wgmesh.Config, workers int) *wgmesh.WgMesh {
+	tb.Helper()
+
+	c := *cfg
+	c.Peers = append([]wgmesh.Peer(nil), cfg.Peers...)
+	c.MonitorWorkers = workers
+	mesh, err := wgmesh.NewWgMeshFromConfig(&c, wgmesh.WithClient(new(MockWireguardClient)))
+	require.NoError(tb, err)
+	return mesh
+}
+
+func TestMonitorWorkersMatchSingleWorker(t *testing.T) {
+	cfg, peers := largeMesh(t, 100)
+	now := time.Now()
+
+	single := newWorkerMesh(t, cfg, 1)
+	single.UpdatePeerStatus(peers, now)
+	want := single.GetStatus()
+
+	multi := newWorkerMesh(t, cfg, 8)
+	multi.UpdatePeerStatus(peers, now)
+	got := multi.GetStatus()
+
+	assert.Equal(t, want.Status, got.Status)
+	assert.Equal(t, want.Peers, got.Peers)
+	assert.Equal(t, wgmesh.PeerStateUp, got.Peers["peer0"].State)
+	assert.Equal(t, wgmesh.PeerStateDown, got.Peers["peer1"].State)
+	assert.Equal(t, uint64(198), got.Peers["peer99"].BytesSent)
+}
+
+func BenchmarkUpdatePeerStatus(b *testing.B) {
+	cfg, peers := largeMesh(b, 5000)
+	for _, workers := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			mesh := newWorkerMesh(b, cfg, workers)
+			now := time.Now()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				mesh.UpdatePeerStatus(peers, now)
+			}
+		})
+	}
+}
+
+// peerConfigures counts the device changes touching the peer with key.
+func peerConfigures(m *MockWireguardClient, key wgtypes.Key) int {
+	n := 0
+	for _, call := range configureCalls(m) {
+		for _, peer := range call.Peers {
+			if peer.PublicKey == key {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestMonitorMissingPeer(t *testing.T) {
+	config := monitorConfig + `
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+	peer2 := mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=")
+	now := time.Now()
+	stale := []wgtypes.Peer{{
+		PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+		LastHandshakeTime: now.Add(-time.Hour),
+	}}
+
+	t.Run("reported", func(t *testing.T) {
+		mesh, _ := newTestMesh(t, config)
+
+		mesh.UpdatePeerStatus(stale, now)
+		status := mesh.GetStatus()
+		assert.Equal(t, wgmesh.PeerStateDown, status.Peers["peer1"].State)
+		assert.Equal(t, wgmesh.PeerStateError, status.Peers["peer2"].State)
+		assert.Equal(t, "peer is missing from the device", status.Peers["peer2"].Error)
+
+		// Still missing on the next poll isn't another error
+		mesh.UpdatePeerStatus(stale, now.Add(time.Second))
+		assert.Equal(t, 1, mesh.GetStatus().Peers["peer2"].ErrorCount)
+	})
+
+	t.Run("reasserted", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, config+"reassert_missing_peers: true\n")
+		pollOnce(t, mesh, mockClient, &wgtypes.Device{Peers: stale})
+
+		// Configured on start, and added again after the poll
+		assert.GreaterOrEqual(t, peerConfigures(mockClient, peer2), 2)
+	})
+
+	t.Run("removed meanwhile", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, config+"reassert_missing_peers: true\n")
+		mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+		missing := mesh.Config.Peers[1:]
+
+		// A reload removes peer2 between the poll and the reassert
+		require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(monitorConfig+"reassert_missing_peers: true\n"), 0o600))
+		require.NoError(t, mesh.Reload())
+		calls := len(configureCalls(mockClient))
+
+		mesh.ReassertPeers(missing)
+		assert.Len(t, configureCalls(mockClient), calls)
+	})
+
+	t.Run("left alone", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, config)
+		pollOnce(t, mesh, mockClient, &wgtypes.Device{Peers: stale})
+
+		assert.Equal(t, 1, peerConfigures(mockClient, peer2))
+	})
+}
+
+func TestHandshakeCounters(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig)
+	key := mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")
+
+	// Alternate fresh and stale handshakes over five ticks
+	start := time.Now()
+	for i, fresh := range []bool{true, false, true, false, false} {
+		now := start.Add(time.Duration(i) * 10 * time.Second)
+		handshake := now.Add(-time.Second)
+		if !fresh {
+			handshake = now.Add(-time.Hour)
+		}
+		mesh.UpdatePeerStatus([]wgtypes.Peer{{PublicKey: key, LastHandshakeTime: handshake}}, now)
+	}
+
+	status := mesh.GetStatus().Peers["peer1"]
+	assert.Equal(t, uint64(2), status.HandshakesObserved)
+	assert.Equal(t, uint64(3), status.StalePolls)
+
+	var b strings.Builder
+	require.NoError(t, mesh.WriteMetrics(&b))
+	samples := parseMetrics(t, b.String())
+	assert.Equal(t, 2.0, samples[`wgmesh_peer_handshakes_observed_total{network="wg0",peer="peer1"}`])
+	assert.Equal(t, 3.0, samples[`wgmesh_peer_stale_polls_total{network="wg0",peer="peer1"}`])
+}
+
+func TestClockSkewWarningRateLimited(t *testing.T) {
+	var buf bytes.Buffer
+	oldLogger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = oldLogger }()
+
+	mesh, _ := newTestMesh(t, monitorConfig)
+	now := time.Now()
+	poll := func(at time.Time) {
+		mesh.UpdatePeerStatus([]wgtypes.Peer{{
+			PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+			LastHandshakeTime: at.Add(time.Hour),
+		}}, at)
+	}
+
+	// Warned once, not on every poll
+	poll(now)
+	poll(now.Add(time.Second))
+	poll(now.Add(time.Minute))
+	assert.Equal(t, 1, strings.Count(buf.String(), "check for clock skew"))
+
+	poll(now.Add(11 * time.Minute))
+	assert.Equal(t, 2, strings.Count(buf.String(), "check for clock skew"))
+	assert.Equal(t, wgmesh.PeerStateDown, mesh.GetStatus().Peers["peer1"].State)
+}
@@ -0,0 +1,95 @@
+package wgmesh
+
+import "io"
+
+// Option customizes a WgMesh at construction time.
+type Option func(*WgMesh)
+
+// WithClient makes the mesh use the given WireGuard client instead of
+// opening a wgctrl client.
+func WithClient(client WireGuardClient) Option {
+	return func(w *WgMesh) {
+		w.client = client
+	}
+}
+
+// WithCommandRunner replaces the runner used for ip(8) and hook commands.
+func WithCommandRunner(runner CommandRunner) Option {
+	return func(w *WgMesh) {
+		w.CommandRunner = runner
+	}
+}
+
+// WithResolver replaces the resolver used for endpoint hostnames.
+func WithResolver(resolver Resolver) Option {
+	return func(w *WgMesh) {
+		w.resolver = newResolverCache(resolver)
+	}
+}
+
+// WithPathMTUProber replaces the prober used to measure the path MTU to the
+// peers, which by default runs ping(8).
+func WithPathMTUProber(prober PathMTUProber) Option {
+	return func(w *WgMesh) {
+		w.prober = prober
+	}
+}
+
+// WithReplicaOf makes the mesh a replica mirroring the status of leader
+// instead of managing the device, overriding Config.ReplicaOf.
+func WithReplicaOf(leader StatusSource) Option {
+	return func(w *WgMesh) {
+		w.replica = leader
+	}
+}
+
+// WithPprof serves the pprof profiles on the HTTP server, like Config.Pprof.
+func WithPprof() Option {
+	return func(w *WgMesh) {
+		w.pprof = true
+	}
+}
+
+// WithStrictYAML rejects configuration files with unknown keys, like
+// Config.StrictYAML. It only applies to files, other sources decode
+// themselves.
+func WithStrictYAML() Option {
+	return func(w *WgMesh) {
+		w.strictYAML = true
+		if src, ok := w.source.(*FileConfigSource); ok {
+			src.Strict = true
+		}
+	}
+}
+
+// WithAllowPeerRemoval lets reloads remove more peers than
+// Config.MaxPeerRemovalPercent, like WGMESH_ALLOW_PEER_REMOVAL=1.
+func WithAllowPeerRemoval() Option {
+	return func(w *WgMesh) {
+		w.allowRemoval = true
+	}
+}
+
+// WithStatsSink makes the monitor write one JSON line per peer to sink on
+// every poll, for log pipelines.
+func WithStatsSink(sink io.Writer) Option {
+	return func(w *WgMesh) {
+		w.statsSink = sink
+	}
+}
+
+// WithLinkManager replaces the link manager selected in the configuration.
+func WithLinkManager(links LinkManager) Option {
+	return func(w *WgMesh) {
+		w.links = links
+	}
+}
+
+// WithPrivilegeCheck replaces the check run before the device is programmed,
+// which by default requires CAP_NET_ADMIN when no client was injected. The
+// check should return ErrInsufficientPrivileges when it fails.
+func WithPrivilegeCheck(check func() error) Option {
+	return func(w *WgMesh) {
+		w.checkPrivs = check
+	}
+}
@@ -0,0 +1,87 @@
+package wgmesh
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// defaultPeerHookInterval is used when Config.PeerHookInterval is unset.
+const defaultPeerHookInterval = time.Minute
+
+func (c *Config) peerHookInterval() time.Duration {
+	if c.PeerHookInterval > 0 {
+		return c.PeerHookInterval
+	}
+	return defaultPeerHookInterval
+}
+
+// isDown reports whether state counts as down for the peer hooks.
+func isDown(state PeerState) bool {
+	return state == PeerStateDown || state == PeerStateError
+}
+
+// peerStateChanged runs the on_down hooks of the named peer when it goes
+// down and its on_up hooks when it recovers. A peer only just configured
+// going down counts, one only just configured coming up doesn't. Each kind
+// of hook runs at most once per Config.PeerHookInterval, so a flapping peer
+// doesn't spam them. The caller must hold statusMu.
+func (w *WgMesh) peerStateChanged(name string, from, to PeerState, now time.Time) {
+	if from == to || from == "" {
+		return
+	}
+
+	cfg := w.currentConfig()
+	var peer Peer
+	for _, p := range cfg.Peers {
+		if p.Name == name {
+			peer = p
+			break
+		}
+	}
+
+	var hooks []string
+	switch {
+	case isDown(to) && !isDown(from):
+		hooks = peer.OnDown
+	case to == PeerStateUp && isDown(from):
+		hooks = peer.OnUp
+	}
+	if len(hooks) == 0 {
+		return
+	}
+
+	key := name + "/up"
+	if isDown(to) {
+		key = name + "/down"
+	}
+	if last, ok := w.peerHookRuns[key]; ok && now.Sub(last) < cfg.peerHookInterval() {
+		log.Debug().Str("peer", name).Str("state", string(to)).Msg("Skipping peer hooks, they ran recently")
+		return
+	}
+	if w.peerHookRuns == nil {
+		w.peerHookRuns = make(map[string]time.Time)
+	}
+	w.peerHookRuns[key] = now
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for _, hook := range hooks {
+			if err := w.runPeerHook(hook, name, to); err != nil {
+				log.Error().Err(err).Str("peer", name).Str("state", string(to)).Msg("Peer hook failed")
+			}
+		}
+	}()
+}
+
+// runPeerHook runs a peer hook through the shell with WGMESH_PEER and
+// WGMESH_STATE set. Like the interface hooks, %i is replaced with the
+// interface name.
+func (w *WgMesh) runPeerHook(hook, peer string, state PeerState) error {
+	return w.CommandRunner.Run("env",
+		"WGMESH_PEER="+peer,
+		"WGMESH_STATE="+string(state),
+		"sh", "-c", strings.ReplaceAll(hook, "%i", w.currentConfig().NetworkName))
+}
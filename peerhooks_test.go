@@ -0,0 +1,76 @@
+package wgmesh_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+)
+
+const peerHooksConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peer_hook_interval: %s
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    on_down: ["notify-down %%i"]
+    on_up: ["notify-up"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+
+func TestPeerHooks(t *testing.T) {
+	mesh, _ := newTestMesh(t, fmt.Sprintf(peerHooksConfig, "1h"))
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+
+	// A peer coming up after being configured is not a recovery
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateConfiguring, nil)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	mesh.UpdatePeerState("peer2", wgmesh.PeerStateUp, nil)
+	mesh.UpdatePeerState("peer2", wgmesh.PeerStateDown, nil)
+	mesh.Wait()
+	assert.Empty(t, runner.Commands())
+
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateDown, nil)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	mesh.Wait()
+	// Hooks run in the background, so their order isn't guaranteed
+	assert.ElementsMatch(t, []string{
+		"env WGMESH_PEER=peer1 WGMESH_STATE=down sh -c notify-down wg0",
+		"env WGMESH_PEER=peer1 WGMESH_STATE=up sh -c notify-up",
+	}, runner.Commands())
+
+	// Flapping within the interval doesn't run the hooks again, and an
+	// error after down is still the same outage
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateError, errors.New("handshake failed"))
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateDown, nil)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	mesh.Wait()
+	assert.Len(t, runner.Commands(), 2)
+}
+
+func TestPeerHooksInterval(t *testing.T) {
+	mesh, _ := newTestMesh(t, fmt.Sprintf(peerHooksConfig, "1ms"))
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateDown, nil)
+	time.Sleep(5 * time.Millisecond)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateDown, nil)
+	mesh.Wait()
+	assert.ElementsMatch(t, []string{
+		"env WGMESH_PEER=peer1 WGMESH_STATE=down sh -c notify-down wg0",
+		"env WGMESH_PEER=peer1 WGMESH_STATE=up sh -c notify-up",
+		"env WGMESH_PEER=peer1 WGMESH_STATE=down sh -c notify-down wg0",
+	}, runner.Commands())
+}
@@ -0,0 +1,90 @@
+package wgmesh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// peersFilePath returns the path of the peers file, relative paths being
+// taken from the directory of the configuration file at configPath.
+func (c *Config) peersFilePath(configPath string) string {
+	if c.PeersFile == "" || filepath.IsAbs(c.PeersFile) || configPath == "" {
+		return c.PeersFile
+	}
+	return filepath.Join(filepath.Dir(configPath), c.PeersFile)
+}
+
+// loadPeersFile appends the peers listed in the peers file, if any, to the
+// inline ones. Clashing names and keys are reported by Validate.
+func (c *Config) loadPeersFile(configPath string) error {
+	path := c.peersFilePath(configPath)
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read peers file: %w", err)
+	}
+	peers, err := parsePeersFile(data)
+	if err != nil {
+		return fmt.Errorf("invalid peers file %s: %w", path, err)
+	}
+	c.Peers = append(c.Peers, peers...)
+	return nil
+}
+
+// parsePeersFile parses lines of the form
+//
+//	name public_key allowed_ips [endpoint]
+//
+// where allowed_ips is a comma-separated list. Empty lines and lines
+// starting with # are skipped.
+func parsePeersFile(data []byte) ([]Peer, error) {
+	var peers []Peer
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected name, public key, allowed IPs and an optional endpoint, got %d fields", line, len(fields))
+		}
+		peer := Peer{
+			Name:       fields[0],
+			PublicKey:  fields[1],
+			AllowedIPs: splitAllowedIPs(fields[2]),
+			fromFile:   true,
+		}
+		if len(fields) == 4 {
+			peer.Endpoint = fields[3]
+		}
+		peers = append(peers, peer)
+	}
+	return peers, scanner.Err()
+}
+
+// withoutFilePeers returns c without the peers read from the peers file, to
+// be written back to the configuration file.
+func (c *Config) withoutFilePeers() *Config {
+	if c.PeersFile == "" {
+		return c
+	}
+
+	inline := *c
+	inline.Peers = nil
+	for _, peer := range c.Peers {
+		if !peer.fromFile {
+			inline.Peers = append(inline.Peers, peer)
+		}
+	}
+	return &inline
+}
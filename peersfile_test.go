@@ -0,0 +1,111 @@
+package wgmesh_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const peersFileConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers_file: peers.txt
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`
+
+const peersFile = `# generated by the inventory
+peer2 iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk= 10.0.0.3/32,192.168.3.0/24 peer2.example.com:51820
+
+peer3 WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ= 10.0.0.4
+`
+
+// writePeersConfig writes the configuration and its peers file to a new
+// directory and returns the path of the configuration.
+func writePeersConfig(t *testing.T, config, peers string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "peers.txt"), []byte(peers), 0o600))
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	return path
+}
+
+func TestPeersFile(t *testing.T) {
+	cfg, err := (&wgmesh.FileConfigSource{Path: writePeersConfig(t, peersFileConfig, peersFile)}).Load()
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	require.Len(t, cfg.Peers, 3)
+	assert.Equal(t, "peer1", cfg.Peers[0].Name)
+
+	assert.Equal(t, "peer2", cfg.Peers[1].Name)
+	assert.Equal(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=", cfg.Peers[1].PublicKey)
+	assert.Equal(t, []string{"10.0.0.3/32", "192.168.3.0/24"}, cfg.Peers[1].AllowedIPs)
+	assert.Equal(t, "peer2.example.com:51820", cfg.Peers[1].Endpoint)
+
+	assert.Equal(t, "peer3", cfg.Peers[2].Name)
+	assert.Equal(t, []string{"10.0.0.4/32"}, cfg.Peers[2].AllowedIPs)
+	assert.True(t, cfg.Peers[2].IsRoaming())
+}
+
+func TestPeersFileDuplicates(t *testing.T) {
+	t.Run("name", func(t *testing.T) {
+		path := writePeersConfig(t, peersFileConfig, "peer1 iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk= 10.0.0.3/32\n")
+		_, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(&MockWireguardClient{}))
+		assert.ErrorContains(t, err, "duplicate peer name peer1")
+	})
+
+	t.Run("key", func(t *testing.T) {
+		path := writePeersConfig(t, peersFileConfig, "peer2 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw= 10.0.0.3/32\n")
+		_, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(&MockWireguardClient{}))
+		assert.ErrorContains(t, err, "peers peer1 and peer2 have the same public key")
+	})
+}
+
+func TestPeersFileInvalid(t *testing.T) {
+	_, err := (&wgmesh.FileConfigSource{Path: writePeersConfig(t, peersFileConfig, "# peers\npeer2 iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=\n")}).Load()
+	assert.ErrorContains(t, err, "line 2: expected name, public key, allowed IPs and an optional endpoint, got 2 fields")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(peersFileConfig), 0o600))
+	_, err = (&wgmesh.FileConfigSource{Path: path}).Load()
+	assert.ErrorContains(t, err, "failed to read peers file")
+}
+
+func TestPeersFileReload(t *testing.T) {
+	path := writePeersConfig(t, peersFileConfig, peersFile)
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(mockClient))
+	require.NoError(t, err)
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	// Writing back the configuration keeps the file peers out of it
+	require.NoError(t, mesh.WriteCurrentConfig(path))
+	cfg, err := mesh.LoadConfig(path)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Peers, 3)
+
+	// A change to the peers file alone is picked up
+	peersPath := filepath.Join(filepath.Dir(path), "peers.txt")
+	require.Eventually(t, func() bool {
+		err := os.WriteFile(peersPath, []byte("peer2 iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk= 10.0.0.3/32\n"), 0o600)
+		return err == nil && len(mesh.FindPeers(wgmesh.PeerFilter{Name: "peer3"})) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Len(t, mesh.FindPeers(wgmesh.PeerFilter{}), 2)
+}
@@ -0,0 +1,175 @@
+package wgmesh
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultInterfaceMTU is the MTU WireGuard picks for its interfaces,
+	// assumed when Config.MTU is unset.
+	defaultInterfaceMTU = 1420
+
+	// minPathMTUv4 and minPathMTUv6 are the smallest sizes every IPv4 and
+	// IPv6 path must carry, the lower bound of the search.
+	minPathMTUv4 = 576
+	minPathMTUv6 = 1280
+
+	// pathMTUProbeTimeout bounds a single probe.
+	pathMTUProbeTimeout = 2 * time.Second
+)
+
+// PathMTUProber sends a single packet of size bytes, IP header included, to
+// addr with fragmentation prohibited. It returns nil when the packet got
+// through.
+type PathMTUProber interface {
+	Probe(ctx context.Context, addr net.IP, size int) error
+}
+
+// pingProber probes with ping(8), which reports the reply only if the
+// unfragmented packet made it.
+type pingProber struct {
+	runner CommandRunner
+}
+
+func (p pingProber) Probe(ctx context.Context, addr net.IP, size int) error {
+	// ping's size is the ICMP payload, without IP and ICMP headers
+	payload := size - 28
+	if addr.To4() == nil {
+		payload = size - 48
+	}
+	return p.runner.Run("ping", "-c", "1", "-W", "1", "-M", "do", "-s", strconv.Itoa(payload), addr.String())
+}
+
+// startPathMTUProber starts the goroutine probing the path MTU of the peers,
+// if enabled.
+func (w *WgMesh) startPathMTUProber() {
+	interval := w.currentConfig().PathMTUProbeInterval
+	if interval <= 0 {
+		return
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+				w.probePathMTU()
+			}
+		}
+	}()
+}
+
+// probePathMTU measures the path MTU of every peer that is up and has an IP,
+// recording it in the peer status. Peers whose path carries less than the
+// interface MTU silently drop large packets despite a healthy handshake, so
+// they are warned about.
+func (w *WgMesh) probePathMTU() {
+	cfg := w.currentConfig()
+	mtu := cfg.MTU
+	if mtu <= 0 {
+		mtu = defaultInterfaceMTU
+	}
+
+	prober := w.prober
+	if prober == nil {
+		prober = pingProber{runner: w.CommandRunner}
+	}
+
+	status := w.GetStatus()
+	for _, peer := range cfg.Peers {
+		if status.Peers[peer.Name].State != PeerStateUp {
+			continue
+		}
+		addr := peerAddr(peer.IP)
+		if addr == nil {
+			continue
+		}
+
+		pathMTU := w.searchPathMTU(prober, addr, mtu)
+		w.setPathMTU(peer.Name, pathMTU)
+
+		switch {
+		case pathMTU == 0:
+			log.Warn().
+				Str("peer", peer.Name).
+				Str("ip", addr.String()).
+				Msg("Path MTU probe failed, not even minimum-size packets get through")
+		case pathMTU < mtu:
+			log.Warn().
+				Str("peer", peer.Name).
+				Int("path_mtu", pathMTU).
+				Int("mtu", mtu).
+				Msg("Path MTU is below the interface MTU, large packets will be dropped")
+		}
+	}
+}
+
+// searchPathMTU returns the largest size up to max that reaches addr, or 0
+// if not even the protocol minimum does.
+func (w *WgMesh) searchPathMTU(prober PathMTUProber, addr net.IP, max int) int {
+	probe := func(size int) bool {
+		ctx, cancel := context.WithTimeout(w.ctx, pathMTUProbeTimeout)
+		defer cancel()
+		return prober.Probe(ctx, addr, size) == nil
+	}
+
+	if probe(max) {
+		return max
+	}
+
+	lo := minPathMTUv4
+	if addr.To4() == nil {
+		lo = minPathMTUv6
+	}
+	if lo >= max || !probe(lo) {
+		return 0
+	}
+
+	// lo is known to pass and hi to fail
+	hi := max
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		if probe(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+func (w *WgMesh) setPathMTU(name string, pathMTU int) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	status := w.status.Peers[name]
+	status.Name = name
+	status.PathMTU = pathMTU
+	w.status.Peers[name] = status
+	w.publishStatus()
+}
+
+// peerAddr returns the address of a peer IP given with or without prefix
+// length, or nil if there is none.
+func peerAddr(ip string) net.IP {
+	if ip == "" {
+		return nil
+	}
+	if addr, _, err := net.ParseCIDR(ip); err == nil {
+		return addr
+	}
+	return net.ParseIP(strings.TrimSpace(ip))
+}
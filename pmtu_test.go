@@ -0,0 +1,120 @@
+package wgmesh_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeProber lets packets up to pathMTU through.
+type fakeProber struct {
+	pathMTU int
+
+	mu     sync.Mutex
+	probed []string
+}
+
+func (p *fakeProber) Probe(_ context.Context, addr net.IP, size int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.probed = append(p.probed, addr.String())
+	if size > p.pathMTU {
+		return errors.New("message too long")
+	}
+	return nil
+}
+
+const pathMTUConfig = `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+mtu: 1420
+peers:
+  - name: peer1
+    ip: 10.0.0.2/24
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+
+func newPathMTUMesh(t *testing.T, prober wgmesh.PathMTUProber) *wgmesh.WgMesh {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(pathMTUConfig), 0o600))
+
+	mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(&MockWireguardClient{}), wgmesh.WithPathMTUProber(prober))
+	require.NoError(t, err)
+
+	now := time.Now()
+	mesh.UpdatePeerStatus([]wgtypes.Peer{
+		{PublicKey: mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="), LastHandshakeTime: now},
+		{PublicKey: mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="), LastHandshakeTime: now},
+	}, now)
+	return mesh
+}
+
+func TestPathMTUProbe(t *testing.T) {
+	var buf bytes.Buffer
+	oldLogger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = oldLogger }()
+
+	prober := &fakeProber{pathMTU: 1380}
+	mesh := newPathMTUMesh(t, prober)
+	mesh.ProbePathMTU()
+
+	status := mesh.GetStatus()
+	assert.Equal(t, 1380, status.Peers["peer1"].PathMTU)
+	// peer2 has no IP to probe
+	assert.Zero(t, status.Peers["peer2"].PathMTU)
+	for _, addr := range prober.probed {
+		assert.Equal(t, "10.0.0.2", addr)
+	}
+
+	assert.Contains(t, buf.String(), "Path MTU is below the interface MTU")
+	assert.Contains(t, buf.String(), `"path_mtu":1380`)
+}
+
+func TestPathMTUProbeFullSize(t *testing.T) {
+	var buf bytes.Buffer
+	oldLogger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = oldLogger }()
+
+	prober := &fakeProber{pathMTU: 1500}
+	mesh := newPathMTUMesh(t, prober)
+	mesh.ProbePathMTU()
+
+	assert.Equal(t, 1420, mesh.GetStatus().Peers["peer1"].PathMTU)
+	assert.Len(t, prober.probed, 1)
+	assert.NotContains(t, buf.String(), "Path MTU")
+}
+
+func TestPathMTUProbeBlackhole(t *testing.T) {
+	var buf bytes.Buffer
+	oldLogger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = oldLogger }()
+
+	mesh := newPathMTUMesh(t, &fakeProber{pathMTU: 500})
+	mesh.ProbePathMTU()
+
+	assert.Zero(t, mesh.GetStatus().Peers["peer1"].PathMTU)
+	assert.Contains(t, buf.String(), "Path MTU probe failed")
+}
@@ -0,0 +1,180 @@
+package wgmesh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+// allocationsSuffix names the file next to the configuration holding the
+// addresses handed out from the pool, unless Config.AllocationsFile or
+// Config.StateDir is set.
+const allocationsSuffix = ".allocations"
+
+// assignPoolAddresses gives every peer without an IP an address from the
+// configured pool. Earlier allocations are read from the allocations file so
+// peers keep their address across reloads and restarts; new ones are saved
+// by saveAllocations once the configuration is applied. configPath may be
+// empty for configurations not loaded from a file.
+func (c *Config) assignPoolAddresses(configPath string) error {
+	if c.AddressPool == "" {
+		return nil
+	}
+
+	path := c.AllocationsFile
+	if path == "" {
+		path = c.statePath(stateAllocationsFile)
+	}
+	if path == "" && configPath != "" {
+		path = configPath + allocationsSuffix
+	}
+
+	prev := make(map[string]string)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read address allocations: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &prev); err != nil {
+			return fmt.Errorf("failed to parse address allocations: %w", err)
+		}
+	}
+
+	allocations, err := c.allocateAddresses(prev)
+	if err != nil {
+		return err
+	}
+
+	if path != "" && !reflect.DeepEqual(prev, allocations) {
+		c.allocations, c.allocationsPath = allocations, path
+	}
+	return nil
+}
+
+// saveAllocations writes the pool addresses handed out by cfg to its
+// allocations file. It runs once cfg is applied, so a configuration that
+// fails to load or apply never moves the addresses of the running one.
+func (w *WgMesh) saveAllocations(cfg *Config) {
+	if cfg.allocations == nil {
+		return
+	}
+	if err := cfg.writeAllocations(); err != nil {
+		log.Error().Err(err).Str("path", cfg.allocationsPath).Msg("Failed to save address allocations")
+	}
+}
+
+func (c *Config) writeAllocations() error {
+	var data []byte
+	var err error
+	if c.allocationsPath == c.statePath(stateAllocationsFile) {
+		// JSON in the state directory, which the YAML decoder reads back
+		if err := c.ensureStateDir(); err != nil {
+			return err
+		}
+		data, err = json.MarshalIndent(c.allocations, "", "  ")
+	} else {
+		data, err = yaml.Marshal(c.allocations)
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.allocationsPath, data, 0o600, nil)
+}
+
+// allocateAddresses assigns pool addresses to the peers without an IP,
+// keeping the addresses in prev where possible. It returns the allocations
+// of the current peers.
+func (c *Config) allocateAddresses(prev map[string]string) (map[string]string, error) {
+	pool, err := netip.ParsePrefix(c.AddressPool)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address pool: %w", err)
+	}
+	pool = pool.Masked()
+
+	used := make(map[netip.Addr]bool)
+	if addr, ok := parseHostAddr(c.Address); ok {
+		used[addr] = true
+	}
+	for _, peer := range c.Peers {
+		if addr, ok := parseHostAddr(peer.IP); ok {
+			used[addr] = true
+		}
+	}
+
+	allocations := make(map[string]string)
+	var pending []int
+
+	// Keep earlier allocations first, so new peers can't take them
+	for i, peer := range c.Peers {
+		if peer.IP != "" {
+			continue
+		}
+		addr, ok := parseHostAddr(prev[peer.Name])
+		if ok && pool.Contains(addr) && !used[addr] {
+			used[addr] = true
+			c.assignAddress(i, addr)
+			allocations[peer.Name] = addr.String()
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	next := pool.Addr()
+	for _, i := range pending {
+		for ; pool.Contains(next); next = next.Next() {
+			if !used[next] && usableHost(pool, next) {
+				break
+			}
+		}
+		if !pool.Contains(next) {
+			return nil, fmt.Errorf("address pool %s exhausted", c.AddressPool)
+		}
+
+		used[next] = true
+		c.assignAddress(i, next)
+		allocations[c.Peers[i].Name] = next.String()
+	}
+
+	return allocations, nil
+}
+
+func (c *Config) assignAddress(i int, addr netip.Addr) {
+	host := netip.PrefixFrom(addr, addr.BitLen()).String()
+
+	c.Peers[i].IP = host
+	if len(c.Peers[i].AllowedIPs) == 0 {
+		c.Peers[i].AllowedIPs = []string{host}
+	}
+}
+
+// usableHost reports whether addr can be handed out: the network and
+// broadcast addresses of IPv4 pools are skipped.
+func usableHost(pool netip.Prefix, addr netip.Addr) bool {
+	if !addr.Is4() || pool.Bits() >= 31 {
+		return true
+	}
+	return addr != pool.Addr() && pool.Contains(addr.Next())
+}
+
+// parseHostAddr parses an address with or without a prefix length.
+func parseHostAddr(s string) (netip.Addr, bool) {
+	if s == "" {
+		return netip.Addr{}, false
+	}
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		return prefix.Addr(), true
+	}
+	addr, err := netip.ParseAddr(s)
+	return addr, err == nil
+}
@@ -0,0 +1,123 @@
+package wgmesh_test
+
+import (
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const poolConfig = `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+address: 10.9.0.1/29
+address_pool: 10.9.0.0/29
+peers:
+`
+
+const poolPeers = `
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+  - name: peer3
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+`
+
+func peerIPs(cfg *wgmesh.Config) map[string]string {
+	ips := make(map[string]string, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		ips[peer.Name] = peer.IP
+	}
+	return ips
+}
+
+func TestAddressPoolAllocation(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, poolConfig+poolPeers)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mesh.CommandRunner = &fakeRunner{}
+	allocations := mesh.YamlFilePath + ".allocations"
+
+	pool := netip.MustParsePrefix("10.9.0.0/29")
+	seen := map[string]bool{"10.9.0.1/32": true} // the local address
+	for _, peer := range mesh.Config.Peers {
+		prefix, err := netip.ParsePrefix(peer.IP)
+		require.NoError(t, err)
+		assert.Equal(t, 32, prefix.Bits())
+		assert.True(t, pool.Contains(prefix.Addr()), "%s outside the pool", peer.IP)
+		assert.False(t, seen[peer.IP], "%s assigned twice", peer.IP)
+		seen[peer.IP] = true
+
+		assert.Equal(t, []string{peer.IP}, peer.AllowedIPs)
+	}
+	first := peerIPs(mesh.Config)
+
+	// Allocations are only saved once the configuration is applied
+	assert.NoFileExists(t, allocations)
+	_, err := mesh.RunOnce()
+	require.NoError(t, err)
+	saved, err := os.ReadFile(allocations)
+	require.NoError(t, err)
+
+	// A configuration that is rejected leaves them alone
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(poolConfig+poolPeers+`
+  - name: peer4
+    public_key: n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=
+    allowed_ips: ["bogus"]
+`), 0o600))
+	require.Error(t, mesh.Reload())
+	data, err := os.ReadFile(allocations)
+	require.NoError(t, err)
+	assert.Equal(t, string(saved), string(data))
+
+	// A peer added in front must not shift the existing assignments
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(poolConfig+`
+  - name: peer0
+    public_key: n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=
+`+poolPeers), 0o600))
+	require.NoError(t, mesh.Reload())
+	second := peerIPs(mesh.Config)
+	for name, ip := range first {
+		assert.Equal(t, ip, second[name])
+	}
+	assert.False(t, seen[second["peer0"]])
+
+	data, err = os.ReadFile(allocations)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "peer0")
+}
+
+func TestAddressPoolExhausted(t *testing.T) {
+	_, err := loadConfig(t, `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+address_pool: 10.9.0.0/30
+peers:
+`+poolPeers)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exhausted")
+}
+
+func TestAddressPoolKeepsExplicitIP(t *testing.T) {
+	cfg, err := loadConfig(t, `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+address_pool: 10.9.0.0/29
+peers:
+  - name: peer1
+    ip: 10.9.0.1/32
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.9.0.1/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+`)
+	require.NoError(t, err)
+	assert.Equal(t, "10.9.0.1/32", cfg.Peers[0].IP)
+	assert.Equal(t, "10.9.0.2/32", cfg.Peers[1].IP)
+}
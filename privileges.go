@@ -0,0 +1,55 @@
+package wgmesh
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// capNetAdmin is the bit of CAP_NET_ADMIN in the capability sets.
+const capNetAdmin = 12
+
+// ErrInsufficientPrivileges is returned by StartTunnel when the process
+// can't configure network interfaces.
+var ErrInsufficientPrivileges = errors.New("insufficient privileges: CAP_NET_ADMIN is required to configure WireGuard interfaces, run as root or grant it with `setcap cap_net_admin=+ep`")
+
+// checkNetAdmin returns ErrInsufficientPrivileges if the process lacks
+// CAP_NET_ADMIN. Where the capabilities can't be read (e.g. outside Linux)
+// it doesn't block, the device calls will report any problem.
+func checkNetAdmin() error {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	ok, err := hasNetAdmin(f)
+	if err != nil || ok {
+		return nil
+	}
+	return ErrInsufficientPrivileges
+}
+
+// hasNetAdmin reports whether the effective capabilities in a
+// /proc/<pid>/status file include CAP_NET_ADMIN.
+func hasNetAdmin(status io.Reader) (bool, error) {
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return false, err
+		}
+		return caps&(1<<capNetAdmin) != 0, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, errors.New("no CapEff line in status")
+}
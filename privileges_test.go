@@ -0,0 +1,75 @@
+package wgmesh_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestHasNetAdmin(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		want    bool
+		wantErr bool
+	}{
+		{"root", "Name:\twgmesh\nCapEff:\t000001ffffffffff\n", true, false},
+		{"net admin only", "CapEff:\t0000000000001000\n", true, false},
+		{"unprivileged", "Name:\twgmesh\nCapEff:\t0000000000000000\n", false, false},
+		{"other caps", "CapEff:\t0000000000000400\n", false, false},
+		{"missing", "Name:\twgmesh\n", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wgmesh.HasNetAdmin(strings.NewReader(tt.status))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStartTunnelPrivilegeCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(monitorConfig), 0o600))
+
+	t.Run("unprivileged", func(t *testing.T) {
+		mockClient := &MockWireguardClient{}
+		mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(mockClient), wgmesh.WithPrivilegeCheck(func() error {
+			return wgmesh.ErrInsufficientPrivileges
+		}))
+		require.NoError(t, err)
+
+		err = mesh.StartTunnel()
+		assert.True(t, errors.Is(err, wgmesh.ErrInsufficientPrivileges))
+		assert.Contains(t, err.Error(), "CAP_NET_ADMIN")
+		mockClient.AssertNotCalled(t, "ConfigureDevice", mock.Anything, mock.Anything)
+	})
+
+	t.Run("privileged", func(t *testing.T) {
+		mockClient := &MockWireguardClient{}
+		mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+		mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+		mockClient.On("Close").Return(nil)
+		mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(mockClient), wgmesh.WithPrivilegeCheck(func() error {
+			return nil
+		}))
+		require.NoError(t, err)
+		defer mesh.Close()
+
+		require.NoError(t, mesh.StartTunnel())
+		assert.Len(t, configureCalls(mockClient), 1)
+	})
+}
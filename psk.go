@@ -0,0 +1,217 @@
+package wgmesh
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// RotatePeerPSK replaces the preshared key of the named peer with a new
+// random one and returns it, to be handed to the peer out of band. Only that
+// peer is touched on the device, the others keep their sessions. When the
+// mesh was created from a configuration file the new key is saved there, see
+// savePresharedKey; a key read from a file referenced as @path is written to
+// that file. A reload being applied is waited for.
+func (w *WgMesh) RotatePeerPSK(name string) (newPSK string, err error) {
+	w.applyMu.Lock()
+	defer w.applyMu.Unlock()
+
+	cfg := w.currentConfig()
+	idx := cfg.peerIndex(name)
+	if idx < 0 {
+		return "", &UnknownPeerError{Name: name}
+	}
+
+	pubKey, err := parseKey(cfg.Peers[idx].PublicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key for peer %s: %w", name, err)
+	}
+	psk, err := wgtypes.GenerateKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate preshared key: %w", err)
+	}
+
+	update := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:    pubKey,
+			UpdateOnly:   true,
+			PresharedKey: &psk,
+		}},
+	}
+	if err := w.deviceClient().ConfigureDevice(cfg.NetworkName, update); err != nil {
+		return "", fmt.Errorf("failed to set preshared key of peer %s: %w", name, err)
+	}
+
+	if keyFile, ok := strings.CutPrefix(cfg.Peers[idx].PresharedKey, "@"); ok {
+		// The configuration keeps referring to the key file
+		if err := writeKeyFile(keyFile, psk.String()); err != nil {
+			return psk.String(), fmt.Errorf("preshared key of peer %s rotated but not saved: %w", name, err)
+		}
+		log.Info().Str("peer", name).Str("file", keyFile).Msg("Rotated preshared key")
+		return psk.String(), nil
+	}
+
+	next := *cfg
+	next.Peers = slices.Clone(cfg.Peers)
+	next.Peers[idx].PresharedKey = psk.String()
+	w.setConfig(&next)
+
+	if w.YamlFilePath != "" {
+		if err := w.savePresharedKey(w.YamlFilePath, name, psk.String()); err != nil {
+			return psk.String(), fmt.Errorf("preshared key of peer %s rotated but not saved: %w", name, err)
+		}
+	}
+
+	log.Info().Str("peer", name).Msg("Rotated preshared key")
+	return psk.String(), nil
+}
+
+// savePresharedKey stores the rotated key of the named peer in the
+// configuration file at path. Only the preshared_key of that peer is edited
+// (or added), so comments, templates and the encoding of other keys stay as
+// written. The edit is only saved if the file then yields psk for the peer.
+func (w *WgMesh) savePresharedKey(path, name, psk string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	nameLine, keyLine := findPeerLines(lines, name)
+	if nameLine < 0 {
+		return fmt.Errorf("peer %s is not listed in %s", name, path)
+	}
+
+	if keyLine >= 0 {
+		lines[keyLine] = parseYAMLLine(lines[keyLine]).withValue(psk)
+	} else {
+		field := parseYAMLLine(lines[nameLine])
+		line := strings.Repeat(" ", field.column) + "preshared_key: " + psk + "\n"
+		lines = slices.Insert(lines, nameLine+1, line)
+	}
+	edited := []byte(strings.Join(lines, ""))
+
+	cfg, err := parseConfig(path, edited, w.strictYAML)
+	if err != nil {
+		return err
+	}
+	if idx := cfg.peerIndex(name); idx < 0 || cfg.Peers[idx].PresharedKey != psk {
+		return fmt.Errorf("preshared key of peer %s can't be edited in %s", name, path)
+	}
+	return writeFileAtomic(path, edited, info.Mode().Perm(), nil)
+}
+
+// writeKeyFile replaces the key in the file at path, keeping its mode.
+func writeKeyFile(path, key string) error {
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return writeFileAtomic(path, []byte(key+"\n"), mode, nil)
+}
+
+// findPeerLines returns the line of the name of the named peer in the block
+// style peers list of a configuration file, and the line of its
+// preshared_key. Either is -1 if not found.
+func findPeerLines(lines []string, name string) (nameLine, keyLine int) {
+	nameLine, keyLine = -1, -1
+
+	inPeers := false
+	itemIndent, column := -1, -1
+	itemName, itemKey := -1, -1
+	for i, raw := range lines {
+		line := parseYAMLLine(raw)
+		if line.blank {
+			continue
+		}
+		if !inPeers {
+			inPeers = line.indent == 0 && line.key == "peers" && line.value == ""
+			continue
+		}
+
+		if itemIndent < 0 && line.item {
+			itemIndent = line.indent
+		}
+		if itemIndent < 0 || line.indent < itemIndent || line.indent == itemIndent && !line.item {
+			// The peers list ended
+			break
+		}
+		if line.item && line.indent == itemIndent {
+			if itemName >= 0 {
+				break
+			}
+			column, itemKey = line.column, -1
+		} else if line.column != column {
+			// A value nested in a field of the peer
+			continue
+		}
+
+		switch line.key {
+		case "name":
+			if line.value == name {
+				itemName = i
+			}
+		case "preshared_key":
+			itemKey = i
+		}
+	}
+
+	if itemName < 0 {
+		return -1, -1
+	}
+	return itemName, itemKey
+}
+
+// yamlLine is a line of a YAML mapping, as far as findPeerLines needs it.
+type yamlLine struct {
+	blank   bool   // empty or only a comment
+	indent  int    // column of the line's first character
+	item    bool   // the line starts a list item
+	column  int    // column of the key
+	key     string // key of the line, if any
+	value   string // unquoted value without comment
+	raw     string // the line as read
+	comment string // trailing comment, with its leading space
+}
+
+func parseYAMLLine(raw string) yamlLine {
+	line := yamlLine{raw: raw}
+	text := strings.TrimRight(raw, "\r\n")
+	trimmed := strings.TrimLeft(text, " ")
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		line.blank = true
+		return line
+	}
+	line.indent = len(text) - len(trimmed)
+	line.column = line.indent
+	if rest, ok := strings.CutPrefix(trimmed, "-"); ok && (rest == "" || rest[0] == ' ') {
+		line.item = true
+		trimmed = strings.TrimLeft(rest, " ")
+		line.column = len(text) - len(trimmed)
+	}
+
+	key, value, ok := strings.Cut(trimmed, ":")
+	if !ok || strings.ContainsAny(key, " \"'") {
+		return line
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value, line.comment = value[:i], value[i:]
+	}
+	line.key = key
+	line.value = strings.Trim(strings.TrimSpace(value), `"'`)
+	return line
+}
+
+// withValue returns the line with its value replaced, keeping the comment.
+func (l yamlLine) withValue(value string) string {
+	end := l.raw[len(strings.TrimRight(l.raw, "\r\n")):]
+	return l.raw[:l.column] + l.key + ": " + value + l.comment + end
+}
@@ -0,0 +1,186 @@
+package wgmesh_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const pskConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    preshared_key: cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+
+func TestRotatePeerPSK(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, pskConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	psk, err := mesh.RotatePeerPSK("peer2")
+	require.NoError(t, err)
+	key := mustParseKey(t, psk)
+
+	// Only the preshared key of peer2 is set
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	assert.False(t, calls[0].ReplacePeers)
+	assert.Equal(t, []wgtypes.PeerConfig{{
+		PublicKey:    mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="),
+		UpdateOnly:   true,
+		PresharedKey: &key,
+	}}, calls[0].Peers)
+
+	// The new key is saved and kept by a reload
+	saved, err := mesh.LoadConfig(mesh.YamlFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, "cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=", saved.Peers[0].PresharedKey)
+	assert.Equal(t, psk, saved.Peers[1].PresharedKey)
+	require.NoError(t, mesh.Reload())
+	assert.Len(t, configureCalls(mockClient), 1)
+
+	// Every rotation generates another key
+	again, err := mesh.RotatePeerPSK("peer2")
+	require.NoError(t, err)
+	assert.NotEqual(t, psk, again)
+}
+
+func TestRotatePeerPSKKeepsFile(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "peer1.psk")
+	require.NoError(t, os.WriteFile(keyFile, []byte("cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=\n"), 0o600))
+	config := `
+# Office mesh
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+role_templates:
+  office:
+    persistent_keepalive: 25
+peer_defaults:
+  role: office
+peers:
+  - name: peer1 # gateway
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips:
+      - 10.0.0.2/32
+    preshared_key: "@` + keyFile + `"
+  # Laptop, key rotated monthly
+  - public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    name: "peer2"
+    allowed_ips: ["10.0.0.3/32"]
+`
+	mesh, mockClient := newTestMesh(t, config)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	// A key kept in a file is written there, the configuration is untouched
+	psk1, err := mesh.RotatePeerPSK("peer1")
+	require.NoError(t, err)
+	data, err := os.ReadFile(mesh.YamlFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, config, string(data))
+	key, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, psk1+"\n", string(key))
+
+	// Otherwise only the key of the peer is added, next to its name
+	psk2, err := mesh.RotatePeerPSK("peer2")
+	require.NoError(t, err)
+	data, err = os.ReadFile(mesh.YamlFilePath)
+	require.NoError(t, err)
+	want := strings.Replace(config, `    name: "peer2"`+"\n", `    name: "peer2"`+"\n    preshared_key: "+psk2+"\n", 1)
+	assert.Equal(t, want, string(data))
+
+	// Rotating again replaces it in place, and a reload changes nothing
+	psk2, err = mesh.RotatePeerPSK("peer2")
+	require.NoError(t, err)
+	data, err = os.ReadFile(mesh.YamlFilePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "    preshared_key: "+psk2+"\n")
+	assert.Equal(t, 1, strings.Count(string(data), "preshared_key: "+psk2))
+	calls := len(configureCalls(mockClient))
+	require.NoError(t, mesh.Reload())
+	assert.Len(t, configureCalls(mockClient), calls)
+}
+
+func TestRotatePeerPSKUnknownPeer(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, pskConfig)
+
+	_, err := mesh.RotatePeerPSK("peer3")
+	var unknown *wgmesh.UnknownPeerError
+	require.True(t, errors.As(err, &unknown))
+	assert.Equal(t, "peer3", unknown.Name)
+	assert.Empty(t, configureCalls(mockClient))
+}
+
+func TestPresharedKeyChange(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, pskConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+	require.NoError(t, mesh.StartTunnel())
+
+	psk := mustParseKey(t, "cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=")
+	for _, peer := range configureCalls(mockClient)[0].Peers {
+		if peer.PublicKey.String() == "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=" {
+			assert.Equal(t, &psk, peer.PresharedKey)
+		} else {
+			assert.Nil(t, peer.PresharedKey)
+		}
+	}
+
+	// Dropping the key from the configuration removes it from the device
+	before := len(configureCalls(mockClient))
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(strings.Replace(pskConfig, "    preshared_key: cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=\n", "", 1)), 0o600))
+	require.NoError(t, mesh.Reload())
+	calls := configureCalls(mockClient)[before:]
+	require.Len(t, calls, 1)
+	assert.Equal(t, &wgtypes.Key{}, calls[0].Peers[0].PresharedKey)
+	assert.True(t, calls[0].Peers[0].UpdateOnly)
+}
+
+func TestRotatePeerPSKWaitsForReload(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, confirmConfig)
+	release := make(chan struct{})
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Run(func(mock.Arguments) { <-release }).Return(nil).Once()
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	// A reload adding peer2 blocks on the device
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(confirmConfig+peer2Entry), 0o600))
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- mesh.Reload() }()
+	require.Eventually(t, func() bool { return len(configureCalls(mockClient)) == 1 }, time.Second, time.Millisecond)
+
+	// Rotating the key of peer2 meanwhile waits for it to be added
+	rotated := make(chan error, 1)
+	go func() {
+		_, err := mesh.RotatePeerPSK("peer2")
+		rotated <- err
+	}()
+	select {
+	case err := <-rotated:
+		t.Fatalf("rotated during the reload: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-reloaded)
+	require.NoError(t, <-rotated)
+	assert.NotEmpty(t, mesh.Config.Peers[1].PresharedKey)
+}
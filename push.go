@@ -0,0 +1,41 @@
+package wgmesh
+
+import (
+	"fmt"
+	"time"
+)
+
+// PushConfig applies a configuration pushed by a controller instead of read
+// from the configuration source. data is decoded like the configuration file
+// and validated and applied exactly like a reload, in turn with the other
+// reloads and counted in the reload metrics; relative paths in it (e.g.
+// peers_file) are taken relative to YamlFilePath. The status after applying
+// is returned. The pushed configuration isn't persisted: the next reload from
+// the source replaces it.
+func (w *WgMesh) PushConfig(data []byte) (MeshStatus, error) {
+	newConfig, err := parseConfig(w.YamlFilePath, data, w.strictYAML)
+	if err != nil {
+		return MeshStatus{}, fmt.Errorf("failed to decode pushed configuration: %w", err)
+	}
+	if err := w.reloadQueue.run(func() error { return w.push(newConfig) }); err != nil {
+		return MeshStatus{}, err
+	}
+	return w.GetStatus(), nil
+}
+
+// push validates and applies a pushed configuration for PushConfig.
+func (w *WgMesh) push(newConfig *Config) (err error) {
+	start := time.Now()
+	defer func() { w.recordReload(start, err) }()
+
+	if err := newConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := w.Config.checkImmutable(newConfig); err != nil {
+		return err
+	}
+	if err := w.checkPeerRemoval(newConfig); err != nil {
+		return err
+	}
+	return w.applyConfig(newConfig)
+}
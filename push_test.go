@@ -0,0 +1,100 @@
+package wgmesh_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const pushConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+control_socket: %s
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`
+
+func TestPushConfig(t *testing.T) {
+	socket := controlSocketPath(t)
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(pushConfig, socket))
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	pushed := fmt.Sprintf(pushConfig, socket) + `
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+	var status wgmesh.MeshStatus
+	require.NoError(t, wgmesh.ControlCallRequest(socket, wgmesh.ControlRequest{Command: "push", Config: pushed}, &status))
+	assert.Contains(t, status.Peers, "peer2")
+	assert.Len(t, mesh.FindPeers(wgmesh.PeerFilter{Name: "peer2"}), 1)
+
+	// Pushes count as reloads
+	var metrics strings.Builder
+	require.NoError(t, mesh.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), `wgmesh_reload_total{network="wg0",result="success"} 1`+"\n")
+
+	// An invalid configuration is rejected and the running one kept
+	calls := len(configureCalls(mockClient))
+	invalid := fmt.Sprintf(pushConfig, socket) + `
+  - name: peer1
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+	err := wgmesh.ControlCallRequest(socket, wgmesh.ControlRequest{Command: "push", Config: invalid}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid configuration")
+	assert.Len(t, configureCalls(mockClient), calls)
+	assert.Len(t, mesh.FindPeers(wgmesh.PeerFilter{Name: "peer2"}), 1)
+
+	err = wgmesh.ControlCallRequest(socket, wgmesh.ControlRequest{Command: "push", Config: "peers: ["}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to decode pushed configuration")
+
+	err = wgmesh.ControlCall(socket, "push", nil)
+	assert.EqualError(t, err, "push requires a configuration")
+}
+
+func TestPushConfigSize(t *testing.T) {
+	socket := controlSocketPath(t)
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(pushConfig, socket))
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	// Configurations beyond the default 64 KiB line of a scanner are fine
+	padding := "\n# " + strings.Repeat("x", 128<<10) + "\n"
+	pushed := fmt.Sprintf(pushConfig, socket) + padding + peer2Entry
+	require.NoError(t, wgmesh.ControlCallRequest(socket, wgmesh.ControlRequest{Command: "push", Config: pushed}, nil))
+	assert.Len(t, mesh.FindPeers(wgmesh.PeerFilter{Name: "peer2"}), 1)
+
+	// Oversized requests get an error instead of a closed connection
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	defer conn.Close()
+	go func() {
+		json.NewEncoder(conn).Encode(wgmesh.ControlRequest{Command: "push", Config: strings.Repeat("x", 5<<20)})
+	}()
+	var resp wgmesh.ControlResponse
+	require.NoError(t, json.NewDecoder(conn).Decode(&resp))
+	assert.Equal(t, "request exceeds 4194304 bytes", resp.Error)
+}
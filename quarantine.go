@@ -0,0 +1,109 @@
+package wgmesh
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// QuarantinePeer cuts the named peer off without editing the configuration.
+// Its allowed IPs are removed from the device, so no traffic is routed to it
+// and nothing it sends is accepted. The peer stays quarantined across
+// reloads and reconciles until UnquarantinePeer is called. A reload being
+// applied is waited for.
+func (w *WgMesh) QuarantinePeer(name string) error {
+	w.applyMu.Lock()
+	defer w.applyMu.Unlock()
+
+	cfg := w.currentConfig()
+	if err := w.checkManaged(cfg); err != nil {
+		return err
+	}
+	idx := cfg.peerIndex(name)
+	if idx < 0 {
+		return &UnknownPeerError{Name: name}
+	}
+
+	pubKey, err := parseKey(cfg.Peers[idx].PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key for peer %s: %w", name, err)
+	}
+
+	update := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:         pubKey,
+			UpdateOnly:        true,
+			ReplaceAllowedIPs: true,
+		}},
+	}
+	if err := w.deviceClient().ConfigureDevice(cfg.NetworkName, update); err != nil {
+		return fmt.Errorf("failed to quarantine peer %s: %w", name, err)
+	}
+
+	w.setQuarantined(name, true)
+	log.Warn().Str("peer", name).Msg("Peer quarantined")
+	return nil
+}
+
+// UnquarantinePeer restores the allowed IPs of a quarantined peer from the
+// configuration.
+func (w *WgMesh) UnquarantinePeer(name string) error {
+	w.applyMu.Lock()
+	defer w.applyMu.Unlock()
+
+	cfg := w.currentConfig()
+	if err := w.checkManaged(cfg); err != nil {
+		return err
+	}
+	idx := cfg.peerIndex(name)
+	if idx < 0 {
+		return &UnknownPeerError{Name: name}
+	}
+	if !w.isQuarantined(name) {
+		return fmt.Errorf("peer %s is not quarantined", name)
+	}
+
+	peer := cfg.Peers[idx]
+	pubKey, err := parseKey(peer.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key for peer %s: %w", name, err)
+	}
+	allowedIPs, err := peer.ParsedAllowedIPs()
+	if err != nil {
+		return err
+	}
+
+	update := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:         pubKey,
+			UpdateOnly:        true,
+			ReplaceAllowedIPs: true,
+			AllowedIPs:        allowedIPs,
+		}},
+	}
+	if err := w.deviceClient().ConfigureDevice(cfg.NetworkName, update); err != nil {
+		return fmt.Errorf("failed to restore peer %s: %w", name, err)
+	}
+
+	w.setQuarantined(name, false)
+	log.Info().Str("peer", name).Msg("Peer released from quarantine")
+	return nil
+}
+
+func (w *WgMesh) isQuarantined(name string) bool {
+	w.statusMu.RLock()
+	defer w.statusMu.RUnlock()
+	return w.status.Peers[name].Quarantined
+}
+
+func (w *WgMesh) setQuarantined(name string, quarantined bool) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	status := w.status.Peers[name]
+	status.Name = name
+	status.Quarantined = quarantined
+	w.status.Peers[name] = status
+	w.publishStatus()
+}
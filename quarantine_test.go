@@ -0,0 +1,121 @@
+package wgmesh_test
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const quarantineConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32", "192.168.10.0/24"]
+`
+
+func TestQuarantinePeer(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, quarantineConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, mesh.QuarantinePeer("peer1"))
+
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	require.Len(t, calls[0].Peers, 1)
+	peerCfg := calls[0].Peers[0]
+	assert.Equal(t, mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="), peerCfg.PublicKey)
+	assert.True(t, peerCfg.UpdateOnly)
+	assert.True(t, peerCfg.ReplaceAllowedIPs)
+	assert.Empty(t, peerCfg.AllowedIPs)
+	assert.True(t, mesh.GetStatus().Peers["peer1"].Quarantined)
+
+	// The configuration itself is untouched
+	assert.Equal(t, []string{"10.0.0.2/32", "192.168.10.0/24"}, mesh.Config.Peers[0].AllowedIPs)
+
+	require.NoError(t, mesh.UnquarantinePeer("peer1"))
+
+	calls = configureCalls(mockClient)
+	require.Len(t, calls, 2)
+	peerCfg = calls[1].Peers[0]
+	assert.True(t, peerCfg.UpdateOnly)
+	assert.True(t, peerCfg.ReplaceAllowedIPs)
+	assert.Equal(t, []net.IPNet{
+		mustParseCIDR(t, "10.0.0.2/32"),
+		mustParseCIDR(t, "192.168.10.0/24"),
+	}, peerCfg.AllowedIPs)
+	assert.False(t, mesh.GetStatus().Peers["peer1"].Quarantined)
+
+	assert.EqualError(t, mesh.UnquarantinePeer("peer1"), "peer peer1 is not quarantined")
+}
+
+func TestQuarantineUnknownPeer(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, quarantineConfig)
+
+	assert.EqualError(t, mesh.QuarantinePeer("missing"), "unknown peer missing")
+	assert.EqualError(t, mesh.UnquarantinePeer("missing"), "unknown peer missing")
+	mockClient.AssertNotCalled(t, "ConfigureDevice", mock.Anything, mock.Anything)
+}
+
+func TestQuarantineSurvivesReconcile(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, quarantineConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{{PublicKey: mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")}},
+	}, nil)
+
+	require.NoError(t, mesh.QuarantinePeer("peer1"))
+
+	// The device lacking the allowed IPs is what quarantine asked for
+	report, err := mesh.Reconcile()
+	require.NoError(t, err)
+	assert.False(t, report.HasDrift())
+	assert.Len(t, configureCalls(mockClient), 1)
+}
+
+func TestQuarantineDuringReload(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, quarantineConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	// Run with -race: quarantine reads the configuration reloads replace
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 20 {
+			config := quarantineConfig
+			if i%2 == 0 {
+				config = strings.Replace(config, "192.168.10.0/24", "192.168.20.0/24", 1)
+			}
+			assert.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(config), 0o600))
+			assert.NoError(t, mesh.Reload())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 20 {
+			assert.NoError(t, mesh.QuarantinePeer("peer1"))
+			assert.NoError(t, mesh.UnquarantinePeer("peer1"))
+		}
+	}()
+	wg.Wait()
+	assert.False(t, mesh.GetStatus().Peers["peer1"].Quarantined)
+}
+
+func TestQuarantineObserveOnly(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, quarantineConfig+"observe_only: true\n")
+
+	assert.ErrorIs(t, mesh.QuarantinePeer("peer1"), wgmesh.ErrUnmanagedDevice)
+	assert.ErrorIs(t, mesh.UnquarantinePeer("peer1"), wgmesh.ErrUnmanagedDevice)
+	mockClient.AssertNotCalled(t, "ConfigureDevice", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,76 @@
+package wgmesh
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// startReconciler starts the goroutine reverting out-of-band device changes,
+// if enabled.
+func (w *WgMesh) startReconciler() {
+	interval := w.currentConfig().ReconcileInterval
+	if interval <= 0 {
+		return
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := w.reconcile(); err != nil {
+					log.Error().Err(err).Msg("Failed to reconcile device")
+				}
+			}
+		}
+	}()
+}
+
+// reconcile re-applies the full configuration when the device drifted from
+// it and returns the drift that was corrected. It holds applyMu, so a reload
+// in progress, whose half-applied changes look like drift, finishes first
+// and drift is detected against the configuration it left active. It only
+// takes the config lock through currentConfig, so it never blocks the
+// monitor.
+func (w *WgMesh) reconcile() (DriftReport, error) {
+	w.applyMu.Lock()
+	defer w.applyMu.Unlock()
+
+	report, err := w.DetectDrift()
+	if err != nil {
+		return DriftReport{}, err
+	}
+	if !report.HasDrift() {
+		return report, nil
+	}
+
+	cfg := w.currentConfig()
+	desired, err := w.deviceConfig(cfg)
+	if err != nil {
+		return report, err
+	}
+	// Replacing the peers would hard remove the ones waiting for their grace
+	// period, keep them quarantined
+	desired.Peers = append(desired.Peers, w.pendingRemovalPeers()...)
+	if err := w.deviceClient().ConfigureDevice(cfg.NetworkName, desired); err != nil {
+		return report, fmt.Errorf("failed to configure WireGuard device: %w", err)
+	}
+
+	log.Warn().
+		Str("network", cfg.NetworkName).
+		Strs("extra", report.Extra).
+		Strs("missing", report.Missing).
+		Interface("mismatched", report.Mismatched).
+		Msg("Reverted out-of-band device changes")
+
+	return report, nil
+}
@@ -0,0 +1,136 @@
+package wgmesh_test
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const reconcileConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+reconcile_interval: 5ms
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`
+
+func TestReconcileRevertsDrift(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, reconcileConfig)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:  mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+				AllowedIPs: []net.IPNet{mustParseCIDR(t, "0.0.0.0/0")},
+			},
+			{PublicKey: mustParseKey(t, "n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=")},
+		},
+	}, nil)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	report, err := mesh.Reconcile()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw="}, report.Extra)
+	require.Len(t, report.Mismatched, 1)
+
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	assert.True(t, calls[0].ReplacePeers)
+	require.Len(t, calls[0].Peers, 1)
+	assert.Equal(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=", calls[0].Peers[0].PublicKey.String())
+	require.Len(t, calls[0].Peers[0].AllowedIPs, 1)
+	assert.Equal(t, "10.0.0.2/32", calls[0].Peers[0].AllowedIPs[0].String())
+}
+
+func TestReconcileInSyncDoesNothing(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, reconcileConfig)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{{
+			PublicKey:  mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+			AllowedIPs: []net.IPNet{mustParseCIDR(t, "10.0.0.2/32")},
+		}},
+	}, nil)
+
+	report, err := mesh.Reconcile()
+	require.NoError(t, err)
+	assert.False(t, report.HasDrift())
+	assert.Empty(t, configureCalls(mockClient))
+}
+
+func TestReconcileLoop(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, reconcileConfig)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+
+	// The device never has the peer, so every tick re-applies the config
+	assert.Eventually(t, func() bool {
+		for _, cfg := range configureCalls(mockClient) {
+			if cfg.ReplacePeers && len(cfg.Peers) == 1 {
+				return true
+			}
+		}
+		return false
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestReconcileWaitsForReload(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, reconcileConfig)
+
+	// The device as the reload adding peer2 leaves it
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:  mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+				AllowedIPs: []net.IPNet{mustParseCIDR(t, "10.0.0.2/32")},
+			},
+			{
+				PublicKey:  mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="),
+				AllowedIPs: []net.IPNet{mustParseCIDR(t, "10.0.0.3/32")},
+			},
+		},
+	}, nil)
+	release := make(chan struct{})
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Run(func(mock.Arguments) { <-release }).Return(nil).Once()
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(reconcileConfig+peer2Entry), 0o600))
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- mesh.Reload() }()
+	require.Eventually(t, func() bool { return len(configureCalls(mockClient)) == 1 }, time.Second, time.Millisecond)
+
+	// A tick while the reload is half-applied waits for it
+	type result struct {
+		report wgmesh.DriftReport
+		err    error
+	}
+	reconciled := make(chan result, 1)
+	go func() {
+		report, err := mesh.Reconcile()
+		reconciled <- result{report, err}
+	}()
+	select {
+	case <-reconciled:
+		t.Fatal("reconcile ran during the reload")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-reloaded)
+	res := <-reconciled
+	require.NoError(t, res.err)
+	assert.False(t, res.report.HasDrift())
+	assert.Len(t, configureCalls(mockClient), 1)
+}
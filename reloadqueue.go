@@ -0,0 +1,64 @@
+package wgmesh
+
+import "sync"
+
+// reloadQueue runs one reload at a time. Reloads requested while one runs
+// are coalesced into a single follow-up reload, which picks up the latest
+// configuration for all of them, so a burst of SIGHUPs, file changes and API
+// calls costs at most two reloads.
+type reloadQueue struct {
+	turn    sync.Mutex // held by the running reload
+	mu      sync.Mutex
+	next    *reloadCall // follow-up reload shared by the waiting callers
+	waiters int         // callers waiting for next
+}
+
+// reloadCall is a reload waited for by one or more callers.
+type reloadCall struct {
+	done chan struct{}
+	err  error
+}
+
+// do runs reload once the running reload, if any, is done, or joins the
+// follow-up reload another caller is already waiting to run and returns its
+// result.
+func (q *reloadQueue) do(reload func() error) error {
+	q.mu.Lock()
+	if call := q.next; call != nil {
+		q.waiters++
+		q.mu.Unlock()
+
+		<-call.done
+		return call.err
+	}
+	call := &reloadCall{done: make(chan struct{})}
+	q.next, q.waiters = call, 1
+	q.mu.Unlock()
+
+	q.turn.Lock()
+	// Callers from now on need another reload to see their changes
+	q.mu.Lock()
+	q.next, q.waiters = nil, 0
+	q.mu.Unlock()
+
+	call.err = reload()
+	q.turn.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// run runs fn alone once the running reload, if any, is done. Unlike do it
+// never coalesces: fn applies a configuration of its own, not the latest
+// one from the source.
+func (q *reloadQueue) run(fn func() error) error {
+	q.turn.Lock()
+	defer q.turn.Unlock()
+	return fn()
+}
+
+// pending returns the number of callers waiting for the follow-up reload.
+func (q *reloadQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waiters
+}
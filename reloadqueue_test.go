@@ -0,0 +1,124 @@
+package wgmesh_test
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	peer2Entry = `
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+	peer3Entry = `
+  - name: peer3
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.4/32"]
+`
+)
+
+func TestConcurrentReloadsCoalesce(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+
+	var active, overlapped atomic.Int32
+	track := func(block <-chan struct{}) func(mock.Arguments) {
+		return func(mock.Arguments) {
+			if active.Add(1) > 1 {
+				overlapped.Store(1)
+			}
+			defer active.Add(-1)
+			if block != nil {
+				<-block
+			}
+		}
+	}
+	release := make(chan struct{})
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Run(track(release)).Return(nil).Once()
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Run(track(nil)).Return(nil)
+
+	// The first reload adds peer2 and blocks on the device
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(monitorConfig+peer2Entry), 0o600))
+	errs := make(chan error, 3)
+	go func() { errs <- mesh.Reload() }()
+	require.Eventually(t, func() bool { return len(configureCalls(mockClient)) == 1 }, time.Second, time.Millisecond)
+
+	// Two more reloads arrive meanwhile and wait for a single follow-up
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(monitorConfig+peer2Entry+peer3Entry), 0o600))
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- mesh.Reload()
+		}()
+	}
+	require.Eventually(t, func() bool { return mesh.PendingReloads() == 2 }, time.Second, time.Millisecond)
+	close(release)
+
+	wg.Wait()
+	for range 3 {
+		assert.NoError(t, <-errs)
+	}
+
+	// One device change per applied reload, never overlapping
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 2)
+	require.Len(t, calls[0].Peers, 1)
+	assert.Equal(t, mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="), calls[0].Peers[0].PublicKey)
+	require.Len(t, calls[1].Peers, 1)
+	assert.Equal(t, mustParseKey(t, "WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ="), calls[1].Peers[0].PublicKey)
+	assert.Zero(t, overlapped.Load())
+	assert.Zero(t, mesh.PendingReloads())
+	assert.Len(t, mesh.Config.Peers, 3)
+}
+
+func TestWatchedChangesJoinReloadQueue(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	release := make(chan struct{})
+	var blocked atomic.Bool
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Run(func(mock.Arguments) {
+		if blocked.Load() {
+			<-release
+		}
+	}).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	// Wait for file watcher to start
+	time.Sleep(100 * time.Millisecond)
+
+	// A pushed configuration adding peer2 blocks on the device
+	blocked.Store(true)
+	pushed := make(chan error, 1)
+	go func() {
+		_, err := mesh.PushConfig([]byte(monitorConfig + peer2Entry))
+		pushed <- err
+	}()
+	require.Eventually(t, func() bool { return len(configureCalls(mockClient)) > 0 }, time.Second, time.Millisecond)
+
+	// The file change waits its turn, and a reload meanwhile shares it
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(monitorConfig+peer2Entry+peer3Entry), 0o600))
+	require.Eventually(t, func() bool { return mesh.PendingReloads() == 1 }, time.Second, time.Millisecond)
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- mesh.Reload() }()
+	require.Eventually(t, func() bool { return mesh.PendingReloads() == 2 }, time.Second, time.Millisecond)
+
+	blocked.Store(false)
+	close(release)
+	require.NoError(t, <-pushed)
+	require.NoError(t, <-reloaded)
+	assert.Len(t, mesh.FindPeers(wgmesh.PeerFilter{}), 3)
+}
@@ -0,0 +1,54 @@
+package wgmesh
+
+import (
+	"sync"
+	"time"
+)
+
+// reloadStats counts configuration reloads for the metrics.
+type reloadStats struct {
+	mu      sync.Mutex
+	success uint64
+	failure uint64
+	seconds float64 // spent in all reloads
+	added   uint64
+	removed uint64
+	updated uint64
+}
+
+// recordReload counts a reload that started at start and ended with err.
+func (w *WgMesh) recordReload(start time.Time, err error) {
+	elapsed := time.Since(start).Seconds()
+
+	w.reloads.mu.Lock()
+	defer w.reloads.mu.Unlock()
+	if err != nil {
+		w.reloads.failure++
+	} else {
+		w.reloads.success++
+	}
+	w.reloads.seconds += elapsed
+}
+
+// recordReloadPeers counts the peers changed by an applied configuration.
+func (w *WgMesh) recordReloadPeers(added, removed, updated int) {
+	w.reloads.mu.Lock()
+	defer w.reloads.mu.Unlock()
+	w.reloads.added += uint64(added)
+	w.reloads.removed += uint64(removed)
+	w.reloads.updated += uint64(updated)
+}
+
+// reloadCounts returns a copy of the counters, safe to read without the lock.
+func (w *WgMesh) reloadCounts() reloadStats {
+	w.reloads.mu.Lock()
+	defer w.reloads.mu.Unlock()
+	return reloadStats{
+		success: w.reloads.success,
+		failure: w.reloads.failure,
+		seconds: w.reloads.seconds,
+		added:   w.reloads.added,
+		removed: w.reloads.removed,
+		updated: w.reloads.updated,
+	}
+}
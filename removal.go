@@ -0,0 +1,135 @@
+package wgmesh
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// pendingRemoval is a peer removed from the configuration that is kept on
+// the device, without allowed IPs, until Config.PeerRemovalGrace passes.
+type pendingRemoval struct {
+	peer   Peer
+	cancel chan struct{}
+}
+
+// deferRemoval quarantines a peer removed from the configuration and hard
+// removes it once the grace period passes, unless a later reload brings it
+// back (see cancelRemoval). Like a quarantined peer it neither receives nor
+// sends traffic meanwhile, but a re-add doesn't cost it its session.
+func (w *WgMesh) deferRemoval(peer Peer, grace time.Duration) error {
+	pubKey, err := parseKey(peer.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key for peer %s: %w", peer.Name, err)
+	}
+
+	cfg := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:         pubKey,
+			UpdateOnly:        true,
+			ReplaceAllowedIPs: true,
+		}},
+	}
+	if err := w.deviceClient().ConfigureDevice(w.Config.NetworkName, cfg); err != nil {
+		return fmt.Errorf("failed to quarantine removed peer %s: %w", peer.Name, err)
+	}
+	if err := w.delRoutes(w.Config, peer); err != nil {
+		log.Warn().Err(err).Msg("Failed to remove routes of peer: " + peer.Name)
+	}
+
+	pending := &pendingRemoval{peer: peer, cancel: make(chan struct{})}
+	w.removalMu.Lock()
+	if w.removals == nil {
+		w.removals = make(map[wgtypes.Key]*pendingRemoval)
+	}
+	if old, ok := w.removals[pubKey]; ok {
+		close(old.cancel)
+	}
+	w.removals[pubKey] = pending
+	w.removalMu.Unlock()
+
+	log.Info().Str("peer", peer.Name).Dur("grace", grace).Msg("Peer quarantined, removing it after the grace period")
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+
+		select {
+		case <-w.ctx.Done():
+		case <-pending.cancel:
+		case <-timer.C:
+			w.finishRemoval(pubKey, pending)
+		}
+	}()
+	return nil
+}
+
+// finishRemoval hard removes a peer whose grace period passed. removalMu is
+// held throughout, so a reload re-adding the peer either cancels the
+// removal first or adds the peer back after it.
+func (w *WgMesh) finishRemoval(pubKey wgtypes.Key, pending *pendingRemoval) {
+	w.removalMu.Lock()
+	defer w.removalMu.Unlock()
+
+	if w.removals[pubKey] != pending {
+		return
+	}
+	delete(w.removals, pubKey)
+
+	cfg := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{PublicKey: pubKey, Remove: true}},
+	}
+	if err := w.deviceClient().ConfigureDevice(w.currentConfig().NetworkName, cfg); err != nil {
+		log.Error().Err(err).Str("peer", pending.peer.Name).Msg("Failed to remove peer after the grace period")
+		return
+	}
+	log.Info().Str("peer", pending.peer.Name).Msg("Removed peer after the grace period")
+}
+
+// cancelRemoval stops the pending removal of a peer that is configured
+// again, if any.
+func (w *WgMesh) cancelRemoval(peer Peer) {
+	pubKey, err := parseKey(peer.PublicKey)
+	if err != nil {
+		return
+	}
+
+	w.removalMu.Lock()
+	defer w.removalMu.Unlock()
+
+	pending, ok := w.removals[pubKey]
+	if !ok {
+		return
+	}
+	close(pending.cancel)
+	delete(w.removals, pubKey)
+
+	log.Info().Str("peer", peer.Name).Msg("Peer configured again, canceled its removal")
+}
+
+// removalPending reports whether the device peer with the given key is
+// waiting for its grace period to pass, so it is no drift.
+func (w *WgMesh) removalPending(pubKey wgtypes.Key) bool {
+	w.removalMu.Lock()
+	defer w.removalMu.Unlock()
+	_, ok := w.removals[pubKey]
+	return ok
+}
+
+// pendingRemovalPeers returns the quarantined peers waiting for their grace
+// period, as the device should keep them: present, without allowed IPs.
+func (w *WgMesh) pendingRemovalPeers() []wgtypes.PeerConfig {
+	w.removalMu.Lock()
+	defer w.removalMu.Unlock()
+
+	peers := make([]wgtypes.PeerConfig, 0, len(w.removals))
+	for pubKey := range w.removals {
+		peers = append(peers, wgtypes.PeerConfig{PublicKey: pubKey, ReplaceAllowedIPs: true})
+	}
+	return peers
+}
@@ -0,0 +1,159 @@
+package wgmesh_test
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const removalPeers = `
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: [10.0.0.2/32]
+`
+
+const removedPeer = `
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: [10.0.0.3/32]
+`
+
+func removalConfig(grace string) string {
+	return `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peer_removal_grace: ` + grace + removalPeers
+}
+
+// removedKeys returns the keys of the peers hard removed by calls.
+func removedKeys(calls []wgtypes.Config) []wgtypes.Key {
+	var keys []wgtypes.Key
+	for _, call := range calls {
+		for _, peer := range call.Peers {
+			if peer.Remove {
+				keys = append(keys, peer.PublicKey)
+			}
+		}
+	}
+	return keys
+}
+
+func TestPeerRemovalGraceReadded(t *testing.T) {
+	config := removalConfig("1h")
+	mesh, mockClient := newTestMesh(t, config+removedPeer)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+	peer2 := mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=")
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(config), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	// The removed peer is only quarantined
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	require.Len(t, calls[0].Peers, 1)
+	assert.Equal(t, peer2, calls[0].Peers[0].PublicKey)
+	assert.True(t, calls[0].Peers[0].UpdateOnly)
+	assert.True(t, calls[0].Peers[0].ReplaceAllowedIPs)
+	assert.Empty(t, calls[0].Peers[0].AllowedIPs)
+	assert.Len(t, mesh.Config.Peers, 1)
+
+	// While the peer waits for its removal, it is no drift
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:  mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+				AllowedIPs: []net.IPNet{mustParseCIDR(t, "10.0.0.2/32")},
+			},
+			{PublicKey: peer2},
+		},
+	}, nil)
+	report, err := mesh.DetectDrift()
+	require.NoError(t, err)
+	assert.False(t, report.HasDrift())
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(config+removedPeer), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	calls = configureCalls(mockClient)
+	require.Len(t, calls, 2)
+	require.Len(t, calls[1].Peers, 1)
+	assert.Equal(t, peer2, calls[1].Peers[0].PublicKey)
+	assert.Equal(t, []net.IPNet{mustParseCIDR(t, "10.0.0.3/32")}, calls[1].Peers[0].AllowedIPs)
+
+	// The grace period of the first removal doesn't remove it later either
+	require.NoError(t, mesh.Close())
+	assert.Empty(t, removedKeys(configureCalls(mockClient)))
+}
+
+func TestPeerRemovalGraceExpires(t *testing.T) {
+	config := removalConfig("10ms")
+	mesh, mockClient := newTestMesh(t, config+removedPeer)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(config), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	peer2 := mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=")
+	assert.Eventually(t, func() bool {
+		return len(removedKeys(configureCalls(mockClient))) > 0
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []wgtypes.Key{peer2}, removedKeys(configureCalls(mockClient)))
+}
+
+func TestPeerRemovalWithoutGrace(t *testing.T) {
+	config := removalConfig("0s")
+	mesh, mockClient := newTestMesh(t, config+removedPeer)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(config), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	assert.Equal(t, []wgtypes.Key{mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=")}, removedKeys(configureCalls(mockClient)))
+}
+
+func TestPeerRemovalGraceReconcile(t *testing.T) {
+	config := removalConfig("1h")
+	mesh, mockClient := newTestMesh(t, config+removedPeer)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+	peer2 := mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=")
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(config), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	// Some other drift makes reconcile replace the peers
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:  mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+				AllowedIPs: []net.IPNet{mustParseCIDR(t, "0.0.0.0/0")},
+			},
+			{PublicKey: peer2},
+		},
+	}, nil)
+	_, err := mesh.Reconcile()
+	require.NoError(t, err)
+
+	// The quarantined peer stays on the device, still without allowed IPs
+	calls := configureCalls(mockClient)
+	desired := calls[len(calls)-1]
+	require.True(t, desired.ReplacePeers)
+	require.Len(t, desired.Peers, 2)
+	assert.Equal(t, peer2, desired.Peers[1].PublicKey)
+	assert.True(t, desired.Peers[1].ReplaceAllowedIPs)
+	assert.Empty(t, desired.Peers[1].AllowedIPs)
+	assert.Empty(t, removedKeys(calls))
+}
@@ -0,0 +1,57 @@
+package wgmesh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// allowPeerRemovalEnv overrides Config.MaxPeerRemovalPercent when true.
+const allowPeerRemovalEnv = "WGMESH_ALLOW_PEER_REMOVAL"
+
+// ErrTooManyPeersRemoved is returned by reloads rejected as they remove more
+// peers than Config.MaxPeerRemovalPercent allows.
+var ErrTooManyPeersRemoved = errors.New("configuration removes too many peers")
+
+// checkPeerRemoval rejects newConfig if it removes more than
+// Config.MaxPeerRemovalPercent of the active peers, unless overridden.
+func (w *WgMesh) checkPeerRemoval(newConfig *Config) error {
+	cfg := w.currentConfig()
+	limit := cfg.MaxPeerRemovalPercent
+	if limit <= 0 || len(cfg.Peers) == 0 {
+		return nil
+	}
+
+	_, removed, _ := diffPeers(cfg.Peers, newConfig.Peers)
+	if len(removed)*100 <= limit*len(cfg.Peers) {
+		return nil
+	}
+
+	percent := len(removed) * 100 / len(cfg.Peers)
+	if w.peerRemovalAllowed() {
+		log.Warn().
+			Int("removed", len(removed)).
+			Int("peers", len(cfg.Peers)).
+			Int("limit_percent", limit).
+			Msg("Configuration removes more peers than allowed, applying it as overridden")
+		return nil
+	}
+
+	err := fmt.Errorf("%w: %d of %d peers (%d%%) exceed max_peer_removal_percent %d%%, set %s=1 to apply it anyway",
+		ErrTooManyPeersRemoved, len(removed), len(cfg.Peers), percent, limit, allowPeerRemovalEnv)
+	log.Error().Err(err).Msg("Ignoring configuration change")
+	return err
+}
+
+// peerRemovalAllowed reports whether Config.MaxPeerRemovalPercent is
+// overridden, with WithAllowPeerRemoval or the environment.
+func (w *WgMesh) peerRemovalAllowed() bool {
+	if w.allowRemoval {
+		return true
+	}
+	allow, _ := strconv.ParseBool(os.Getenv(allowPeerRemovalEnv))
+	return allow
+}
@@ -0,0 +1,108 @@
+package wgmesh_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const removalGuardConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+max_peer_removal_percent: 50
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`
+
+// removalGuardPeers are four more peers, so dropping them removes 80% of
+// the peers, and dropping peer4 and peer5 40%.
+const removalGuardPeers = peers2and3 + peers4and5
+
+const peers2and3 = `
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+  - name: peer3
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.4/32"]
+`
+
+const peers4and5 = `
+  - name: peer4
+    public_key: n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=
+    allowed_ips: ["10.0.0.5/32"]
+  - name: peer5
+    public_key: H7M6ss1HZh5G+s6tDJGvXWMqJiga3rahmllFIirT3xo=
+    allowed_ips: ["10.0.0.6/32"]
+`
+
+// newRemovalGuardMesh returns a mesh with five peers, wired to a mock client.
+func newRemovalGuardMesh(t *testing.T, opts ...wgmesh.Option) (*wgmesh.WgMesh, *MockWireguardClient) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(removalGuardConfig+removalGuardPeers), 0o600))
+
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mesh, err := wgmesh.NewWgMesh(path, append([]wgmesh.Option{wgmesh.WithClient(mockClient)}, opts...)...)
+	require.NoError(t, err)
+	return mesh, mockClient
+}
+
+func TestMaxPeerRemovalPercent(t *testing.T) {
+	mesh, mockClient := newRemovalGuardMesh(t)
+
+	// Dropping four of five peers is rejected
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(removalGuardConfig), 0o600))
+	err := mesh.Reload()
+	require.ErrorIs(t, err, wgmesh.ErrTooManyPeersRemoved)
+	assert.ErrorContains(t, err, "4 of 5 peers (80%) exceed max_peer_removal_percent 50%")
+	assert.Len(t, mesh.Config.Peers, 5)
+	assert.Empty(t, configureCalls(mockClient))
+
+	// So are the other ways to change the configuration
+	_, err = mesh.PushConfig([]byte(removalGuardConfig))
+	require.ErrorIs(t, err, wgmesh.ErrTooManyPeersRemoved)
+	require.ErrorIs(t, mesh.ReloadSelective(wgmesh.PeerFilter{}), wgmesh.ErrTooManyPeersRemoved)
+	cfg := *mesh.Config
+	cfg.Peers = cfg.Peers[:1]
+	_, err = mesh.ApplyWithConfirmation(&cfg, time.Minute)
+	require.ErrorIs(t, err, wgmesh.ErrTooManyPeersRemoved)
+	backup := "config.yaml.backup_20260101_000000"
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(mesh.YamlFilePath), backup), []byte(removalGuardConfig), 0o600))
+	require.ErrorIs(t, mesh.RestoreBackup(backup), wgmesh.ErrTooManyPeersRemoved)
+	assert.Len(t, mesh.Config.Peers, 5)
+	assert.Empty(t, configureCalls(mockClient))
+
+	// Up to the limit is fine
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(removalGuardConfig+peers2and3), 0o600))
+	require.NoError(t, mesh.Reload())
+	assert.Len(t, mesh.Config.Peers, 3)
+}
+
+func TestMaxPeerRemovalPercentOverride(t *testing.T) {
+	t.Run("option", func(t *testing.T) {
+		mesh, _ := newRemovalGuardMesh(t, wgmesh.WithAllowPeerRemoval())
+		require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(removalGuardConfig), 0o600))
+		require.NoError(t, mesh.Reload())
+		assert.Len(t, mesh.Config.Peers, 1)
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("WGMESH_ALLOW_PEER_REMOVAL", "1")
+		mesh, _ := newRemovalGuardMesh(t)
+		require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(removalGuardConfig), 0o600))
+		require.NoError(t, mesh.Reload())
+		assert.Len(t, mesh.Config.Peers, 1)
+	})
+}
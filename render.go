@@ -0,0 +1,90 @@
+package wgmesh
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// RenderDeviceConfig returns the complete device configuration wgmesh would
+// hand to the kernel for the current configuration, without applying it.
+// Endpoint hostnames are resolved as they would be when applying.
+func (w *WgMesh) RenderDeviceConfig() (wgtypes.Config, error) {
+	return w.deviceConfig(w.currentConfig())
+}
+
+// deviceConfig builds the device configuration replacing all peers with the
+// ones of cfg.
+func (w *WgMesh) deviceConfig(cfg *Config) (wgtypes.Config, error) {
+	pk, err := parseKey(cfg.PrivateKey)
+	if err != nil {
+		return wgtypes.Config{}, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	peerConfigs := make([]wgtypes.PeerConfig, 0, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		peerConfig, err := w.createPeerConfig(peer)
+		if err != nil {
+			return wgtypes.Config{}, err
+		}
+		peerConfigs = append(peerConfigs, peerConfig)
+	}
+
+	return wgtypes.Config{
+		PrivateKey:   &pk,
+		ListenPort:   &cfg.ListenPort,
+		ReplacePeers: true,
+		Peers:        peerConfigs,
+	}, nil
+}
+
+// FormatDeviceConfig renders a device configuration in the style of wg
+// show. Private and preshared keys are hidden.
+func FormatDeviceConfig(cfg wgtypes.Config) string {
+	var b strings.Builder
+
+	b.WriteString("interface:\n")
+	if cfg.PrivateKey != nil {
+		b.WriteString("  private key: (hidden)\n")
+	}
+	if cfg.ListenPort != nil {
+		fmt.Fprintf(&b, "  listening port: %d\n", *cfg.ListenPort)
+	}
+	if cfg.FirewallMark != nil {
+		fmt.Fprintf(&b, "  fwmark: %#x\n", *cfg.FirewallMark)
+	}
+	fmt.Fprintf(&b, "  replace peers: %t\n", cfg.ReplacePeers)
+
+	for _, peer := range cfg.Peers {
+		fmt.Fprintf(&b, "\npeer: %s\n", peer.PublicKey)
+		if peer.Remove {
+			b.WriteString("  remove: true\n")
+			continue
+		}
+		if peer.PresharedKey != nil {
+			b.WriteString("  preshared key: (hidden)\n")
+		}
+		if peer.Endpoint != nil {
+			fmt.Fprintf(&b, "  endpoint: %s\n", peer.Endpoint)
+		}
+
+		allowed := make([]string, 0, len(peer.AllowedIPs))
+		for _, ipNet := range peer.AllowedIPs {
+			allowed = append(allowed, ipNet.String())
+		}
+		if len(allowed) == 0 {
+			allowed = append(allowed, "(none)")
+		}
+		fmt.Fprintf(&b, "  allowed ips: %s\n", strings.Join(allowed, ", "))
+		if peer.ReplaceAllowedIPs {
+			b.WriteString("  replace allowed ips: true\n")
+		}
+
+		if peer.PersistentKeepaliveInterval != nil && *peer.PersistentKeepaliveInterval > 0 {
+			fmt.Fprintf(&b, "  persistent keepalive: every %d seconds\n", int(peer.PersistentKeepaliveInterval.Seconds()))
+		}
+	}
+
+	return b.String()
+}
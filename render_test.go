@@ -0,0 +1,68 @@
+package wgmesh_test
+
+import (
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const renderConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    endpoint: "192.0.2.1:51820"
+    nat: true
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32", "192.168.1.0/24"]
+`
+
+func TestRenderDeviceConfig(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, renderConfig)
+
+	cfg, err := mesh.RenderDeviceConfig()
+	require.NoError(t, err)
+
+	require.NotNil(t, cfg.PrivateKey)
+	assert.Equal(t, "a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA=", cfg.PrivateKey.PublicKey().String())
+	require.NotNil(t, cfg.ListenPort)
+	assert.Equal(t, 51820, *cfg.ListenPort)
+	assert.True(t, cfg.ReplacePeers)
+	require.Len(t, cfg.Peers, 2)
+	assert.Equal(t, 51820, cfg.Peers[0].Endpoint.Port)
+	assert.Nil(t, cfg.Peers[1].Endpoint)
+	assert.Len(t, cfg.Peers[1].AllowedIPs, 2)
+
+	// Nothing is applied
+	mockClient.AssertNotCalled(t, "ConfigureDevice")
+}
+
+func TestFormatDeviceConfig(t *testing.T) {
+	mesh, _ := newTestMesh(t, renderConfig)
+
+	cfg, err := mesh.RenderDeviceConfig()
+	require.NoError(t, err)
+
+	assert.Equal(t, `interface:
+  private key: (hidden)
+  listening port: 51820
+  replace peers: true
+
+peer: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+  endpoint: 192.0.2.1:51820
+  allowed ips: 10.0.0.2/32
+  replace allowed ips: true
+  persistent keepalive: every 25 seconds
+
+peer: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+  allowed ips: 10.0.0.3/32, 192.168.1.0/24
+  replace allowed ips: true
+`, wgmesh.FormatDeviceConfig(cfg))
+	assert.NotContains(t, wgmesh.FormatDeviceConfig(cfg), "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=")
+}
@@ -0,0 +1,114 @@
+package wgmesh
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// StatusSource delivers the status computed by another wgmesh instance, the
+// leader of a replica.
+type StatusSource interface {
+	WatchStatus(ctx context.Context) (<-chan MeshStatus, error)
+}
+
+// WatchStatus implements StatusSource, so a mesh in the same process can
+// lead a replica. The channel is closed when the mesh is closed.
+func (w *WgMesh) WatchStatus(ctx context.Context) (<-chan MeshStatus, error) {
+	return w.StatusUpdates(), nil
+}
+
+// ControlStatusSource polls the status of a leader through its control
+// socket every Interval.
+type ControlStatusSource struct {
+	Socket   string
+	Interval time.Duration
+}
+
+func (s *ControlStatusSource) WatchStatus(ctx context.Context) (<-chan MeshStatus, error) {
+	statuses := make(chan MeshStatus)
+	go func() {
+		defer close(statuses)
+
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			var status MeshStatus
+			if err := ControlCall(s.Socket, "status", &status); err != nil {
+				log.Warn().Err(err).Str("socket", s.Socket).Msg("Failed to get leader status")
+			} else {
+				select {
+				case statuses <- status:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return statuses, nil
+}
+
+// replicaSource returns the leader whose status is mirrored, or nil when the
+// mesh isn't a replica.
+func (w *WgMesh) replicaSource() StatusSource {
+	if w.replica != nil {
+		return w.replica
+	}
+	if socket := w.currentConfig().ReplicaOf; socket != "" {
+		return &ControlStatusSource{Socket: socket, Interval: w.monitorInterval()}
+	}
+	return nil
+}
+
+// startReplica starts mirroring the status of the leader. The device is
+// never read nor configured.
+func (w *WgMesh) startReplica(src StatusSource) error {
+	statuses, err := src.WatchStatus(w.ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msg("Running as replica, mirroring the leader's status")
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case status, ok := <-statuses:
+				if !ok {
+					log.Warn().Msg("Leader status stream ended, keeping the last status")
+					return
+				}
+				w.mirrorStatus(status)
+			}
+		}
+	}()
+	return nil
+}
+
+// mirrorStatus replaces the status with the one of the leader.
+func (w *WgMesh) mirrorStatus(status MeshStatus) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	peers := make(map[string]PeerStatus, len(status.Peers))
+	for name, peer := range status.Peers {
+		peers[name] = peer
+	}
+	status.Peers = peers
+
+	w.status = status
+	w.publishStatus()
+}
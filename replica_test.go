@@ -0,0 +1,56 @@
+package wgmesh_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const replicaConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+
+func TestReplicaMirrorsLeader(t *testing.T) {
+	leader, _ := newTestMesh(t, replicaConfig)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(replicaConfig), 0o600))
+
+	// No expectations: any device access by the replica fails the test
+	replicaClient := &MockWireguardClient{}
+	replica, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(replicaClient), wgmesh.WithReplicaOf(leader))
+	require.NoError(t, err)
+	require.NoError(t, replica.Start())
+
+	leader.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	leader.UpdatePeerState("peer2", wgmesh.PeerStateError, assert.AnError)
+
+	require.Eventually(t, func() bool {
+		return replica.GetStatus().Peers["peer2"].State == wgmesh.PeerStateError
+	}, 5*time.Second, 10*time.Millisecond)
+
+	want := leader.GetStatus()
+	got := replica.GetStatus()
+	assert.Equal(t, want.Status, got.Status)
+	assert.Equal(t, want.Peers, got.Peers)
+
+	require.NoError(t, replica.StopTunnel())
+	replicaClient.On("Close").Return(nil)
+	require.NoError(t, replica.Close())
+	replicaClient.AssertNotCalled(t, "ConfigureDevice")
+	replicaClient.AssertNotCalled(t, "Device")
+}
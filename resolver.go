@@ -0,0 +1,394 @@
+package wgmesh
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// defaultResolveCacheTTL is used when Config.ResolveCacheTTL is unset.
+	defaultResolveCacheTTL = 30 * time.Second
+
+	// resolveTimeout bounds a single endpoint lookup.
+	resolveTimeout = 10 * time.Second
+)
+
+// Resolver looks up the addresses of endpoint hostnames. *net.Resolver
+// implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// SRVResolver is implemented by resolvers that can also look up SRV
+// records, needed for srv:// endpoints. *net.Resolver implements it.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// dnsPort is the port of a Config.Resolver given without one.
+const dnsPort = "53"
+
+// configResolver resolves through the DNS server of Config.Resolver, or the
+// system resolver when it is unset. The server is read on every lookup, so
+// a reload changing it takes effect right away.
+type configResolver struct {
+	w *WgMesh
+}
+
+func (r configResolver) resolver() *net.Resolver {
+	server := r.w.currentConfig().Resolver
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return dnsServerResolver(resolverAddress(server))
+}
+
+func (r configResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r.resolver().LookupIPAddr(ctx, host)
+}
+
+func (r configResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return r.resolver().LookupSRV(ctx, service, proto, name)
+}
+
+// dnsServerResolver returns a resolver sending every query to server
+// instead of the name servers of the system.
+func dnsServerResolver(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// resolverAddress returns the address of a Config.Resolver, adding the DNS
+// port if it has none.
+func resolverAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), dnsPort)
+}
+
+// srvScheme prefixes endpoints discovered through an SRV record, e.g.
+// srv://_wireguard._udp.example.com.
+const srvScheme = "srv://"
+
+// resolverCache remembers resolved hostnames for a while, so peers sharing
+// an endpoint hostname don't cause a lookup each.
+type resolverCache struct {
+	resolver Resolver
+
+	mu      sync.Mutex
+	entries map[string]resolvedHost
+	srv     map[string]resolvedSRV
+}
+
+type resolvedHost struct {
+	addr    net.IPAddr
+	expires time.Time
+}
+
+type resolvedSRV struct {
+	target  string
+	port    int
+	expires time.Time
+}
+
+func newResolverCache(resolver Resolver) *resolverCache {
+	return &resolverCache{
+		resolver: resolver,
+		entries:  make(map[string]resolvedHost),
+		srv:      make(map[string]resolvedSRV),
+	}
+}
+
+// lookupSRV returns the target and port of the SRV record chosen for name.
+// The choice is kept for ttl so the endpoint doesn't flap between targets.
+func (c *resolverCache) lookupSRV(ctx context.Context, name string, ttl time.Duration) (string, int, error) {
+	srvResolver, ok := c.resolver.(SRVResolver)
+	if !ok {
+		return "", 0, fmt.Errorf("resolver can't look up SRV records for %s", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := c.srv[name]; ok && now.Before(entry.expires) {
+		return entry.target, entry.port, nil
+	}
+
+	_, records, err := srvResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return "", 0, err
+	}
+	record := pickSRV(records)
+	if record == nil {
+		return "", 0, fmt.Errorf("no SRV records found for %s", name)
+	}
+
+	entry := resolvedSRV{
+		target:  strings.TrimSuffix(record.Target, "."),
+		port:    int(record.Port),
+		expires: now.Add(ttl),
+	}
+	c.srv[name] = entry
+	return entry.target, entry.port, nil
+}
+
+// pickSRV chooses a record as described in RFC 2782: the lowest priority
+// wins, records sharing it are picked at random in proportion to their
+// weight.
+func pickSRV(records []*net.SRV) *net.SRV {
+	var candidates []*net.SRV
+	for _, record := range records {
+		switch {
+		case len(candidates) == 0 || record.Priority < candidates[0].Priority:
+			candidates = []*net.SRV{record}
+		case record.Priority == candidates[0].Priority:
+			candidates = append(candidates, record)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, record := range candidates {
+		total += int(record.Weight)
+	}
+	if total == 0 {
+		return candidates[0]
+	}
+
+	n := rand.IntN(total)
+	for _, record := range candidates {
+		n -= int(record.Weight)
+		if n < 0 {
+			return record
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+func (c *resolverCache) lookup(ctx context.Context, host string, ttl time.Duration) (net.IPAddr, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := c.entries[host]; ok && now.Before(entry.expires) {
+		return entry.addr, nil
+	}
+
+	addrs, err := c.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return net.IPAddr{}, err
+	}
+	if len(addrs) == 0 {
+		return net.IPAddr{}, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	c.entries[host] = resolvedHost{addr: addrs[0], expires: now.Add(ttl)}
+	return addrs[0], nil
+}
+
+// endpointHostPort splits the peer endpoint into host and port. The port
+// comes from Port when set, otherwise from the endpoint itself. For srv://
+// endpoints the host is the SRV name and the port comes from the record.
+func (p *Peer) endpointHostPort() (string, int, error) {
+	if name, ok := strings.CutPrefix(p.Endpoint, srvScheme); ok {
+		if name == "" {
+			return "", 0, fmt.Errorf("missing SRV name in %q", p.Endpoint)
+		}
+		return name, 0, nil
+	}
+	if p.Port != 0 {
+		host := strings.Trim(p.Endpoint, "[]")
+		if err := checkScopedHost(host); err != nil {
+			return "", 0, err
+		}
+		return host, p.Port, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(p.Endpoint)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+	if err := checkScopedHost(host); err != nil {
+		return "", 0, err
+	}
+	return host, port, nil
+}
+
+// parseEndpointIP parses host as an IP address with an optional zone, as in
+// fe80::1%eth0 for link-local peers. The IP is nil if host is no (valid
+// scoped) IP address.
+func parseEndpointIP(host string) (net.IP, string) {
+	addr, zone, scoped := strings.Cut(host, "%")
+	ip := net.ParseIP(addr)
+	if ip == nil || (scoped && (zone == "" || ip.To4() != nil)) {
+		return nil, ""
+	}
+	return ip, zone
+}
+
+// checkScopedHost rejects a host with a zone that isn't a scoped IPv6
+// address, as a zone can't be resolved.
+func checkScopedHost(host string) error {
+	if !strings.Contains(host, "%") {
+		return nil
+	}
+	if ip, _ := parseEndpointIP(host); ip == nil {
+		return fmt.Errorf("invalid scoped address %q: a zone requires an IPv6 address", host)
+	}
+	return nil
+}
+
+// resolveEndpoint resolves the peer endpoint, using the cache for hostnames.
+func (w *WgMesh) resolveEndpoint(peer Peer) (*net.UDPAddr, error) {
+	host, port, err := peer.endpointHostPort()
+	if err != nil {
+		return nil, err
+	}
+
+	if ip, zone := parseEndpointIP(host); ip != nil {
+		return &net.UDPAddr{IP: ip, Port: port, Zone: zone}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(w.ctx, resolveTimeout)
+	defer cancel()
+
+	ttl := w.currentConfig().ResolveCacheTTL
+	if ttl <= 0 {
+		ttl = defaultResolveCacheTTL
+	}
+
+	if strings.HasPrefix(peer.Endpoint, srvScheme) {
+		host, port, err = w.resolver.lookupSRV(ctx, host, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if ip, zone := parseEndpointIP(host); ip != nil {
+			return &net.UDPAddr{IP: ip, Port: port, Zone: zone}, nil
+		}
+	}
+
+	addr, err := w.resolver.lookup(ctx, host, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: addr.IP, Port: port, Zone: addr.Zone}, nil
+}
+
+// startResolver starts the goroutine re-resolving endpoint hostnames, if
+// enabled.
+func (w *WgMesh) startResolver() {
+	cfg := w.currentConfig()
+	interval := cfg.ResolveInterval
+	if interval <= 0 {
+		return
+	}
+	percent := cfg.jitterPercent()
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		timer := time.NewTimer(jitter(interval, percent))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-timer.C:
+				if err := w.reresolveEndpoints(); err != nil {
+					log.Error().Err(err).Msg("Failed to re-resolve endpoints")
+				}
+				timer.Reset(jitter(interval, percent))
+			}
+		}
+	}()
+}
+
+// reresolveEndpoints updates the endpoints of peers configured by hostname
+// whose address changed. Like wg-quick's reresolve-dns, peers with a
+// handshake within their handshake timeout are left alone as their
+// connection works (and may have roamed).
+func (w *WgMesh) reresolveEndpoints() error {
+	cfg := w.currentConfig()
+
+	device, err := w.deviceClient().Device(cfg.NetworkName)
+	if err != nil {
+		return err
+	}
+
+	devicePeers := make(map[wgtypes.Key]wgtypes.Peer, len(device.Peers))
+	for _, peer := range device.Peers {
+		devicePeers[peer.PublicKey] = peer
+	}
+
+	for _, peer := range cfg.Peers {
+		if peer.IsRoaming() {
+			continue
+		}
+		host, _, err := peer.endpointHostPort()
+		if err != nil {
+			continue
+		}
+		if ip, _ := parseEndpointIP(host); ip != nil {
+			continue
+		}
+
+		pubKey, err := parseKey(peer.PublicKey)
+		if err != nil {
+			continue
+		}
+
+		current, ok := devicePeers[pubKey]
+		if ok && time.Since(current.LastHandshakeTime) < peer.staleAfter() {
+			continue
+		}
+
+		endpoint, err := w.resolveEndpoint(peer)
+		if err != nil {
+			log.Warn().Err(err).Str("peer", peer.Name).Msg("Failed to resolve endpoint")
+			continue
+		}
+		if current.Endpoint != nil && current.Endpoint.String() == endpoint.String() {
+			continue
+		}
+
+		update := wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{{
+				PublicKey:  pubKey,
+				UpdateOnly: true,
+				Endpoint:   endpoint,
+			}},
+		}
+		if err := w.deviceClient().ConfigureDevice(cfg.NetworkName, update); err != nil {
+			log.Error().Err(err).Str("peer", peer.Name).Msg("Failed to update endpoint")
+			continue
+		}
+
+		log.Info().Str("peer", peer.Name).Str("endpoint", endpoint.String()).Msg("Updated peer endpoint")
+	}
+
+	return nil
+}
@@ -0,0 +1,417 @@
+package wgmesh_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// countingResolver resolves every host to ip and counts the lookups.
+type countingResolver struct {
+	mu      sync.Mutex
+	ip      net.IP
+	lookups map[string]int
+}
+
+func (r *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lookups == nil {
+		r.lookups = make(map[string]int)
+	}
+	r.lookups[host]++
+	return []net.IPAddr{{IP: r.ip}}, nil
+}
+
+func (r *countingResolver) Lookups(host string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups[host]
+}
+
+// resolverConfig returns a config with three peers sharing an endpoint
+// hostname and one with a literal IP endpoint.
+func resolverConfig(ttl time.Duration) *wgmesh.Config {
+	cfg := &wgmesh.Config{
+		NetworkName:     "wg0",
+		ListenPort:      51820,
+		PrivateKey:      "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+		ResolveCacheTTL: ttl,
+	}
+	keys := []string{
+		"236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=",
+		"iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=",
+		"WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=",
+	}
+	for i, key := range keys {
+		cfg.Peers = append(cfg.Peers, wgmesh.Peer{
+			Name:      "peer" + string(rune('1'+i)),
+			PublicKey: key,
+			Endpoint:  "vpn.example.com",
+			Port:      51820 + i,
+		})
+	}
+	cfg.Peers = append(cfg.Peers, wgmesh.Peer{
+		Name:      "static",
+		PublicKey: "n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=",
+		Endpoint:  "192.0.2.10:51820",
+	})
+
+	return cfg
+}
+
+func mustMeshFromConfig(t *testing.T, cfg *wgmesh.Config, opts ...wgmesh.Option) *wgmesh.WgMesh {
+	t.Helper()
+	mesh, err := wgmesh.NewWgMeshFromConfig(cfg, opts...)
+	require.NoError(t, err)
+	return mesh
+}
+
+func TestResolverCacheSharedHostname(t *testing.T) {
+	resolver := &countingResolver{ip: net.ParseIP("203.0.113.7")}
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	mesh := mustMeshFromConfig(t, resolverConfig(time.Minute), wgmesh.WithClient(mockClient), wgmesh.WithResolver(resolver))
+	defer mesh.Close()
+
+	require.NoError(t, mesh.RestartTunnel())
+	assert.Equal(t, 1, resolver.Lookups("vpn.example.com"))
+	assert.Equal(t, 0, resolver.Lookups("192.0.2.10"))
+
+	calls := configureCalls(mockClient)
+	applied := calls[len(calls)-1]
+	require.Len(t, applied.Peers, 4)
+	assert.Equal(t, "203.0.113.7:51820", applied.Peers[0].Endpoint.String())
+	assert.Equal(t, "203.0.113.7:51822", applied.Peers[2].Endpoint.String())
+	assert.Equal(t, "192.0.2.10:51820", applied.Peers[3].Endpoint.String())
+}
+
+func TestResolverCacheExpires(t *testing.T) {
+	resolver := &countingResolver{ip: net.ParseIP("203.0.113.7")}
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	mesh := mustMeshFromConfig(t, resolverConfig(time.Millisecond), wgmesh.WithClient(mockClient), wgmesh.WithResolver(resolver))
+	defer mesh.Close()
+
+	require.NoError(t, mesh.RestartTunnel())
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, mesh.RestartTunnel())
+
+	assert.GreaterOrEqual(t, resolver.Lookups("vpn.example.com"), 2)
+}
+
+// srvResolver serves SRV records and resolves their targets from a table.
+type srvResolver struct {
+	records map[string][]*net.SRV
+	hosts   map[string]net.IP
+}
+
+func (r *srvResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip, ok := r.hosts[host]; ok {
+		return []net.IPAddr{{IP: ip}}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *srvResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if records, ok := r.records[name]; ok {
+		return name, records, nil
+	}
+	return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestSRVEndpoint(t *testing.T) {
+	resolver := &srvResolver{
+		records: map[string][]*net.SRV{
+			"_wg._udp.example.com": {
+				{Target: "backup.example.com.", Port: 51900, Priority: 20, Weight: 100},
+				{Target: "primary.example.com.", Port: 51821, Priority: 10, Weight: 0},
+			},
+		},
+		hosts: map[string]net.IP{
+			"primary.example.com": net.ParseIP("203.0.113.1"),
+			"backup.example.com":  net.ParseIP("203.0.113.2"),
+		},
+	}
+	cfg := resolverConfig(time.Minute)
+	cfg.Peers = cfg.Peers[:1]
+	cfg.Peers[0].Endpoint = "srv://_wg._udp.example.com"
+	cfg.Peers[0].Port = 0
+
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	mesh := mustMeshFromConfig(t, cfg, wgmesh.WithClient(mockClient), wgmesh.WithResolver(resolver))
+	defer mesh.Close()
+
+	require.NoError(t, mesh.RestartTunnel())
+
+	calls := configureCalls(mockClient)
+	applied := calls[len(calls)-1]
+	require.Len(t, applied.Peers, 1)
+	// The lowest priority wins regardless of weight
+	assert.Equal(t, "203.0.113.1:51821", applied.Peers[0].Endpoint.String())
+}
+
+func TestSRVEndpointWeights(t *testing.T) {
+	resolver := &srvResolver{
+		records: map[string][]*net.SRV{
+			"_wg._udp.example.com": {
+				{Target: "203.0.113.1", Port: 51820, Priority: 10, Weight: 0},
+				{Target: "203.0.113.2", Port: 51820, Priority: 10, Weight: 100},
+			},
+		},
+	}
+	cfg := resolverConfig(time.Nanosecond)
+	cfg.Peers = cfg.Peers[:1]
+	cfg.Peers[0].Endpoint = "srv://_wg._udp.example.com"
+	cfg.Peers[0].Port = 0
+
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	mesh := mustMeshFromConfig(t, cfg, wgmesh.WithClient(mockClient), wgmesh.WithResolver(resolver))
+	defer mesh.Close()
+
+	// A zero weight record is never picked next to a weighted one
+	for i := 0; i < 20; i++ {
+		require.NoError(t, mesh.RestartTunnel())
+		calls := configureCalls(mockClient)
+		assert.Equal(t, "203.0.113.2:51820", calls[len(calls)-1].Peers[0].Endpoint.String())
+	}
+}
+
+func TestSRVEndpointUnsupportedResolver(t *testing.T) {
+	cfg := resolverConfig(time.Minute)
+	cfg.Peers = cfg.Peers[:1]
+	cfg.Peers[0].Endpoint = "srv://_wg._udp.example.com"
+	cfg.Peers[0].Port = 0
+
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	mesh := mustMeshFromConfig(t, cfg, wgmesh.WithClient(mockClient), wgmesh.WithResolver(&countingResolver{}))
+	defer mesh.Close()
+
+	require.NoError(t, mesh.RestartTunnel())
+	status := mesh.GetStatus().Peers["peer1"]
+	assert.Equal(t, wgmesh.PeerStateError, status.State)
+	assert.Contains(t, status.Error, "can't look up SRV records")
+}
+
+// fakeDNS is a DNS server answering every A query with ip and recording the
+// names asked for.
+type fakeDNS struct {
+	ip net.IP
+
+	mu      sync.Mutex
+	queries []string
+}
+
+// startFakeDNS serves fake DNS on a local UDP port until the test ends.
+func startFakeDNS(t *testing.T, ip net.IP) (*fakeDNS, string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	dns := &fakeDNS{ip: ip.To4()}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := dns.answer(buf[:n]); resp != nil {
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return dns, conn.LocalAddr().String()
+}
+
+// answer builds the response to a query with a single question.
+func (d *fakeDNS) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	var labels []string
+	i := 12
+	for i < len(query) && query[i] != 0 {
+		n := int(query[i])
+		if i+1+n > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[i+1:i+1+n]))
+		i += 1 + n
+	}
+	end := i + 5 // the root label, type and class
+	if end > len(query) {
+		return nil
+	}
+	qtype := uint16(query[i+1])<<8 | uint16(query[i+2])
+
+	d.mu.Lock()
+	d.queries = append(d.queries, strings.Join(labels, "."))
+	d.mu.Unlock()
+
+	resp := append([]byte(nil), query[:end]...)
+	resp[2], resp[3] = 0x81, 0x80 // response, recursion desired and available
+	resp[6], resp[7] = 0, 0       // answers
+	resp[8], resp[9] = 0, 0       // authority records
+	resp[10], resp[11] = 0, 0     // additional records
+	if qtype == 1 {
+		resp[7] = 1
+		resp = append(resp,
+			0xc0, 12, // name, pointing at the question
+			0, 1, // type A
+			0, 1, // class IN
+			0, 0, 0, 60, // ttl
+			0, 4, // length
+		)
+		resp = append(resp, d.ip...)
+	}
+	return resp
+}
+
+func (d *fakeDNS) Queries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.queries...)
+}
+
+func TestConfiguredResolver(t *testing.T) {
+	dns, addr := startFakeDNS(t, net.ParseIP("198.51.100.42"))
+
+	cfg := resolverConfig(time.Minute)
+	cfg.Resolver = addr
+	cfg.Peers[0].Endpoint = "vpn.corp.internal"
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	mesh := mustMeshFromConfig(t, cfg, wgmesh.WithClient(mockClient))
+	defer mesh.Close()
+
+	require.NoError(t, mesh.RestartTunnel())
+	assert.Contains(t, dns.Queries(), "vpn.corp.internal")
+	assert.Contains(t, dns.Queries(), "vpn.example.com")
+
+	calls := configureCalls(mockClient)
+	applied := calls[len(calls)-1]
+	require.Len(t, applied.Peers, 4)
+	assert.Equal(t, "198.51.100.42:51820", applied.Peers[0].Endpoint.String())
+	assert.Equal(t, "198.51.100.42:51821", applied.Peers[1].Endpoint.String())
+	assert.Equal(t, "192.0.2.10:51820", applied.Peers[3].Endpoint.String())
+}
+
+func TestResolverValidation(t *testing.T) {
+	for _, resolver := range []string{"10.0.0.53", "10.0.0.53:5353", "fd00::53", "[fd00::53]:53"} {
+		cfg := resolverConfig(time.Minute)
+		cfg.Resolver = resolver
+		assert.NoError(t, cfg.Validate(), resolver)
+	}
+
+	cfg := resolverConfig(time.Minute)
+	cfg.Resolver = "dns.example.com"
+	assert.ErrorContains(t, cfg.Validate(), `invalid resolver "dns.example.com": must be an IP address`)
+	cfg.Resolver = "10.0.0.53:99999"
+	assert.ErrorContains(t, cfg.Validate(), `invalid resolver "10.0.0.53:99999": invalid port "99999"`)
+}
+
+func TestScopedEndpoint(t *testing.T) {
+	resolver := &countingResolver{ip: net.ParseIP("203.0.113.7")}
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	cfg := resolverConfig(time.Minute)
+	cfg.Peers[0].Endpoint = "[fe80::1%eth0]:51820"
+	cfg.Peers[0].Port = 0
+	cfg.Peers[1].Endpoint = "fe80::2%eth1"
+	cfg.Peers[2].Endpoint = "[fe80::3%eth1]"
+	mesh := mustMeshFromConfig(t, cfg, wgmesh.WithClient(mockClient), wgmesh.WithResolver(resolver))
+	defer mesh.Close()
+
+	require.NoError(t, mesh.RestartTunnel())
+	assert.Equal(t, 0, resolver.Lookups("fe80::1%eth0"))
+	assert.Equal(t, 0, resolver.Lookups("fe80::2%eth1"))
+
+	calls := configureCalls(mockClient)
+	applied := calls[len(calls)-1]
+	require.Len(t, applied.Peers, 4)
+	for i, want := range []*net.UDPAddr{
+		{IP: net.ParseIP("fe80::1"), Port: 51820, Zone: "eth0"},
+		{IP: net.ParseIP("fe80::2"), Port: 51821, Zone: "eth1"},
+		{IP: net.ParseIP("fe80::3"), Port: 51822, Zone: "eth1"},
+	} {
+		assert.Equal(t, want, applied.Peers[i].Endpoint)
+	}
+	assert.Equal(t, "[fe80::1%eth0]:51820", applied.Peers[0].Endpoint.String())
+}
+
+func TestScopedEndpointValidation(t *testing.T) {
+	for _, endpoint := range []string{"[fe80::1%]:51820", "[192.0.2.1%eth0]:51820", "[vpn.example.com%eth0]:51820"} {
+		cfg := resolverConfig(time.Minute)
+		cfg.Peers[0].Endpoint = endpoint
+		cfg.Peers[0].Port = 0
+		assert.ErrorContains(t, cfg.Validate(), "a zone requires an IPv6 address", endpoint)
+	}
+}
+
+func TestReresolveHonorsPeerHandshakeTimeout(t *testing.T) {
+	resolver := &countingResolver{ip: net.ParseIP("203.0.113.7")}
+	cfg := &wgmesh.Config{
+		NetworkName: "wg0",
+		ListenPort:  51820,
+		PrivateKey:  "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+		Peers: []wgmesh.Peer{
+			{
+				Name:             "quick",
+				PublicKey:        "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=",
+				Endpoint:         "quick.example.com:51820",
+				HandshakeTimeout: 30 * time.Second,
+			},
+			{
+				Name:      "default",
+				PublicKey: "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=",
+				Endpoint:  "default.example.com:51820",
+			},
+		},
+	}
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mesh := mustMeshFromConfig(t, cfg, wgmesh.WithClient(mockClient), wgmesh.WithResolver(resolver))
+
+	// Both handshakes are a minute old, stale only for the quick peer
+	old := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820}
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{Peers: []wgtypes.Peer{
+		{PublicKey: mustParseKey(t, cfg.Peers[0].PublicKey), Endpoint: old, LastHandshakeTime: time.Now().Add(-time.Minute)},
+		{PublicKey: mustParseKey(t, cfg.Peers[1].PublicKey), Endpoint: old, LastHandshakeTime: time.Now().Add(-time.Minute)},
+	}}, nil)
+	require.NoError(t, mesh.ReresolveEndpoints())
+
+	assert.Equal(t, 1, resolver.Lookups("quick.example.com"))
+	assert.Zero(t, resolver.Lookups("default.example.com"))
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	require.Len(t, calls[0].Peers, 1)
+	assert.Equal(t, "203.0.113.7:51820", calls[0].Peers[0].Endpoint.String())
+}
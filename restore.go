@@ -0,0 +1,134 @@
+package wgmesh
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeLayout is the timestamp in the names of configuration backups.
+const backupTimeLayout = "20060102_150405"
+
+// BackupInfo describes a backup of the configuration file, as taken before
+// every reload.
+type BackupInfo struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// backupDir returns the directory holding the backups of the configuration
+// file, and the prefix of their names.
+func (w *WgMesh) backupDir() (dir, prefix string, err error) {
+	if w.YamlFilePath == "" {
+		return "", "", errors.New("configuration isn't read from a file, there are no backups")
+	}
+
+	prefix = filepath.Base(w.YamlFilePath) + ".backup_"
+	if dir := w.currentConfig().statePath(stateBackupsDir); dir != "" {
+		return dir, prefix, nil
+	}
+	return filepath.Dir(w.YamlFilePath), prefix, nil
+}
+
+// ListBackups returns the backups of the configuration file, oldest first.
+func (w *WgMesh) ListBackups() ([]BackupInfo, error) {
+	dir, prefix, err := w.backupDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []BackupInfo
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		taken, err := time.ParseInLocation(backupTimeLayout, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, BackupInfo{Name: entry.Name(), Time: taken, Size: info.Size()})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Time.Before(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// RestoreBackup makes the named backup, as listed by ListBackups, the
+// configuration file again. The backup is validated like a reload, in turn
+// with the other reloads and counted in the reload metrics, and the
+// configuration it replaces is backed up first. A running mesh applies it
+// to the device; otherwise it takes effect on the next start.
+func (w *WgMesh) RestoreBackup(name string) error {
+	dir, prefix, err := w.backupDir()
+	if err != nil {
+		return err
+	}
+	if name != filepath.Base(name) || !strings.HasPrefix(name, prefix) {
+		return fmt.Errorf("invalid backup name %q", name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	newConfig, err := parseConfig(w.YamlFilePath, data, w.strictYAML)
+	if err != nil {
+		return fmt.Errorf("failed to load backup %s: %w", name, err)
+	}
+	return w.reloadQueue.run(func() error { return w.restore(name, data, newConfig) })
+}
+
+// restore validates and applies a backup for RestoreBackup.
+func (w *WgMesh) restore(name string, data []byte, newConfig *Config) (err error) {
+	start := time.Now()
+	defer func() { w.recordReload(start, err) }()
+
+	if err := newConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid backup %s: %w", name, err)
+	}
+	if err := w.currentConfig().checkImmutable(newConfig); err != nil {
+		return err
+	}
+	if err := w.checkPeerRemoval(newConfig); err != nil {
+		return err
+	}
+
+	if err := w.backupConfig(); err != nil {
+		return fmt.Errorf("failed to back up configuration: %w", err)
+	}
+
+	w.statusMu.RLock()
+	running := !w.startedAt.IsZero()
+	w.statusMu.RUnlock()
+	if running {
+		if err := w.applyConfig(newConfig); err != nil {
+			return err
+		}
+	} else {
+		w.setConfig(newConfig)
+	}
+
+	// Applied first, so the watcher finds nothing left to change
+	return writeConfigFile(w.YamlFilePath, data, newConfig)
+}
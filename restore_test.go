@@ -0,0 +1,199 @@
+package wgmesh_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const backupConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+
+// writeBackup writes a backup of the configuration of mesh taken at stamp.
+func writeBackup(t *testing.T, dir string, mesh *wgmesh.WgMesh, stamp, data string) string {
+	t.Helper()
+	name := filepath.Base(mesh.YamlFilePath) + ".backup_" + stamp
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600))
+	return name
+}
+
+func TestListBackups(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig)
+	dir := filepath.Dir(mesh.YamlFilePath)
+
+	backups, err := mesh.ListBackups()
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+
+	newest := writeBackup(t, dir, mesh, "20250301_080000", backupConfig)
+	oldest := writeBackup(t, dir, mesh, "20250101_120000", monitorConfig)
+	middle := writeBackup(t, dir, mesh, "20250201_000000", backupConfig)
+	writeBackup(t, dir, mesh, "latest", backupConfig)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.yaml.backup_20250101_120000"), nil, 0o600))
+
+	backups, err = mesh.ListBackups()
+	require.NoError(t, err)
+	require.Len(t, backups, 3)
+	assert.Equal(t, []string{oldest, middle, newest}, []string{backups[0].Name, backups[1].Name, backups[2].Name})
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 0, 0, 0, time.Local), backups[0].Time)
+	assert.Equal(t, int64(len(monitorConfig)), backups[0].Size)
+}
+
+func TestListBackupsStateDir(t *testing.T) {
+	dir := t.TempDir()
+	mesh, _ := newTestMesh(t, fmt.Sprintf(stateDirConfig, dir))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "backups"), 0o700))
+	name := writeBackup(t, filepath.Join(dir, "backups"), mesh, "20250101_120000", backupConfig)
+
+	backups, err := mesh.ListBackups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, name, backups[0].Name)
+}
+
+func TestRestoreBackup(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	name := writeBackup(t, filepath.Dir(mesh.YamlFilePath), mesh, "20250101_120000", backupConfig)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+	initial := len(configureCalls(mockClient))
+
+	require.NoError(t, mesh.RestoreBackup(name))
+
+	require.Len(t, mesh.Config.Peers, 1)
+	assert.Equal(t, "peer2", mesh.Config.Peers[0].Name)
+	assert.Greater(t, len(configureCalls(mockClient)), initial)
+
+	// The backup is the configuration file now, and what it replaced is
+	// backed up in turn
+	data, err := os.ReadFile(mesh.YamlFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, backupConfig, string(data))
+
+	backups, err := mesh.ListBackups()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(backups), 2)
+	assert.Equal(t, name, backups[0].Name)
+}
+
+func TestRestoreBackupNotRunning(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	name := writeBackup(t, filepath.Dir(mesh.YamlFilePath), mesh, "20250101_120000", backupConfig)
+
+	require.NoError(t, mesh.RestoreBackup(name))
+
+	// Applied on the next start only
+	assert.Empty(t, configureCalls(mockClient))
+	assert.Equal(t, "peer2", mesh.Config.Peers[0].Name)
+	data, err := os.ReadFile(mesh.YamlFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, backupConfig, string(data))
+
+	// Restores count as reloads
+	var metrics strings.Builder
+	require.NoError(t, mesh.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), `wgmesh_reload_total{network="wg0",result="success"} 1`+"\n")
+}
+
+func TestRestoreBackupWaitsForPush(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	release := make(chan struct{})
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil).Once()
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Run(func(mock.Arguments) { <-release }).Return(nil).Once()
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	name := writeBackup(t, filepath.Dir(mesh.YamlFilePath), mesh, "20250101_120000", backupConfig)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	// A pushed configuration adding peer2 blocks on the device
+	pushed := make(chan error, 1)
+	go func() {
+		_, err := mesh.PushConfig([]byte(monitorConfig + peer2Entry))
+		pushed <- err
+	}()
+	require.Eventually(t, func() bool { return len(configureCalls(mockClient)) == 2 }, time.Second, time.Millisecond)
+
+	// The restore waits its turn and then replaces the pushed configuration
+	restored := make(chan error, 1)
+	go func() { restored <- mesh.RestoreBackup(name) }()
+	select {
+	case err := <-restored:
+		t.Fatalf("restored during the push: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-pushed)
+	require.NoError(t, <-restored)
+	peers := mesh.FindPeers(wgmesh.PeerFilter{})
+	require.Len(t, peers, 1)
+	assert.Equal(t, "peer2", peers[0].Peer.Name)
+}
+
+func TestRestoreBackupErrors(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig)
+	dir := filepath.Dir(mesh.YamlFilePath)
+	invalid := writeBackup(t, dir, mesh, "20250101_120000", backupConfig+"    endpoint: not-an-endpoint\n")
+
+	assert.ErrorContains(t, mesh.RestoreBackup("../config.yaml.backup_20250101_120000"), "invalid backup name")
+	assert.ErrorContains(t, mesh.RestoreBackup("config.yaml"), "invalid backup name")
+	assert.ErrorContains(t, mesh.RestoreBackup("config.yaml.backup_20240101_000000"), "failed to read backup")
+	assert.ErrorContains(t, mesh.RestoreBackup(invalid), "invalid backup")
+
+	// Nothing changed
+	data, err := os.ReadFile(mesh.YamlFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, monitorConfig, string(data))
+	assert.Equal(t, "peer1", mesh.Config.Peers[0].Name)
+
+	var metrics strings.Builder
+	require.NoError(t, mesh.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), `wgmesh_reload_total{network="wg0",result="failure"} 1`+"\n")
+}
+
+func TestControlRestore(t *testing.T) {
+	socket := controlSocketPath(t)
+	mesh, mockClient := newTestMesh(t, monitorConfig+"control_socket: "+socket+"\n")
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	name := writeBackup(t, filepath.Dir(mesh.YamlFilePath), mesh, "20250101_120000", backupConfig)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	var backups []wgmesh.BackupInfo
+	require.NoError(t, wgmesh.ControlCall(socket, "backups", &backups))
+	require.Len(t, backups, 1)
+	assert.Equal(t, name, backups[0].Name)
+
+	var status wgmesh.MeshStatus
+	require.NoError(t, wgmesh.ControlCallRequest(socket, wgmesh.ControlRequest{Command: "restore", Backup: name}, &status))
+	assert.Contains(t, status.Peers, "peer2")
+
+	err := wgmesh.ControlCall(socket, "restore", nil)
+	assert.EqualError(t, err, "restore requires a backup")
+}
@@ -0,0 +1,48 @@
+package wgmesh
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// endpointHook is a callback registered with OnEndpointChange.
+type endpointHook func(peer, from, to string)
+
+// OnEndpointChange registers fn to be called when the device reports a new
+// endpoint for a peer, as WireGuard follows a roaming peer to wherever its
+// packets come from (or after a reload changed it). fn gets the previous and
+// the new address. It runs on the monitor goroutine after a device poll, so
+// it should return quickly.
+func (w *WgMesh) OnEndpointChange(fn func(peer, from, to string)) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	w.roamHooks = append(w.roamHooks, fn)
+}
+
+// notifyEndpointChanges logs the endpoint changes seen by the poll at now
+// and calls the OnEndpointChange callbacks for them.
+func (w *WgMesh) notifyEndpointChanges(now time.Time) {
+	type change struct {
+		peer, from, to string
+	}
+	var changes []change
+
+	w.statusMu.RLock()
+	for name, status := range w.status.Peers {
+		if status.EndpointChangedAt.Equal(now) {
+			changes = append(changes, change{peer: name, from: status.PreviousEndpoint, to: status.Endpoint})
+		}
+	}
+	hooks := w.roamHooks
+	w.statusMu.RUnlock()
+
+	// Outside the lock, so the callbacks can read the status
+	for _, c := range changes {
+		log.Info().Str("peer", c.peer).Str("from", c.from).Str("to", c.to).Msg("Peer endpoint changed")
+		for _, fn := range hooks {
+			fn(c.peer, c.from, c.to)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package wgmesh_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestEndpointChange(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	key := mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")
+	device := func(endpoint string) *wgtypes.Device {
+		addr, err := net.ResolveUDPAddr("udp", endpoint)
+		require.NoError(t, err)
+		return &wgtypes.Device{Peers: []wgtypes.Peer{{
+			PublicKey:         key,
+			Endpoint:          addr,
+			LastHandshakeTime: time.Now(),
+		}}}
+	}
+	mockClient.On("Device", "wg0").Return(device("192.0.2.1:51820"), nil).Twice()
+	mockClient.On("Device", "wg0").Return(device("198.51.100.7:40000"), nil).Once()
+
+	type change struct{ peer, from, to string }
+	var changes []change
+	mesh.OnEndpointChange(func(peer, from, to string) {
+		changes = append(changes, change{peer, from, to})
+	})
+
+	// The first endpoint seen is no change, nor is seeing it again
+	require.NoError(t, mesh.PollDevice())
+	require.NoError(t, mesh.PollDevice())
+	status := mesh.GetStatus().Peers["peer1"]
+	assert.Equal(t, "192.0.2.1:51820", status.Endpoint)
+	assert.Empty(t, status.PreviousEndpoint)
+	assert.True(t, status.EndpointChangedAt.IsZero())
+	assert.Empty(t, changes)
+
+	// The peer roamed
+	before := time.Now()
+	require.NoError(t, mesh.PollDevice())
+	status = mesh.GetStatus().Peers["peer1"]
+	assert.Equal(t, "198.51.100.7:40000", status.Endpoint)
+	assert.Equal(t, "192.0.2.1:51820", status.PreviousEndpoint)
+	assert.False(t, status.EndpointChangedAt.Before(before))
+	assert.Equal(t, []change{{"peer1", "192.0.2.1:51820", "198.51.100.7:40000"}}, changes)
+}
@@ -0,0 +1,92 @@
+package wgmesh
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+)
+
+// addRoutes routes the allowed IPs of peer through the interface when
+// cfg.ManageRoutes is set. Like wg-quick, wgctrl only sets the allowed IPs
+// and leaves routing to the caller.
+func (w *WgMesh) addRoutes(cfg *Config, peer Peer) error {
+	if !cfg.ManageRoutes {
+		return nil
+	}
+
+	var errs []error
+	for _, cidr := range peer.AllowedIPs {
+		if err := w.CommandRunner.Run("ip", cfg.routeArgs("replace", cidr, peer)...); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add route %s for peer %s: %w", cidr, peer.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// delRoutes removes the routes added by addRoutes with the same cfg. It
+// tries every route even if some fail.
+func (w *WgMesh) delRoutes(cfg *Config, peer Peer) error {
+	if !cfg.ManageRoutes {
+		return nil
+	}
+
+	var errs []error
+	for _, cidr := range peer.AllowedIPs {
+		if err := w.CommandRunner.Run("ip", cfg.routeArgs("del", cidr, peer)...); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove route %s for peer %s: %w", cidr, peer.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reroute moves the routes of old, added with the active configuration, to
+// those of peer in cfg. A route with another metric is another route, so
+// when the effective metric or ManageRoutes changes all routes are replaced;
+// otherwise only those of dropped allowed IPs are removed.
+func (w *WgMesh) reroute(cfg *Config, old, peer Peer) error {
+	same := w.Config.ManageRoutes == cfg.ManageRoutes && w.Config.routeMetric(old) == cfg.routeMetric(peer)
+	if same && slices.Equal(old.AllowedIPs, peer.AllowedIPs) {
+		return nil
+	}
+
+	stale := old
+	if same {
+		stale.AllowedIPs = nil
+		for _, cidr := range old.AllowedIPs {
+			if !slices.Contains(peer.AllowedIPs, cidr) {
+				stale.AllowedIPs = append(stale.AllowedIPs, cidr)
+			}
+		}
+	}
+	if err := w.delRoutes(w.Config, stale); err != nil {
+		log.Warn().Err(err).Msg("Failed to remove routes of peer: " + peer.Name)
+	}
+	return w.addRoutes(cfg, peer)
+}
+
+// routeArgs returns the ip(8) arguments to add or delete the route of cidr.
+// The metric is part of what identifies a route, so deleting needs it too.
+func (c *Config) routeArgs(verb, cidr string, peer Peer) []string {
+	args := []string{"route", verb, cidr, "dev", c.NetworkName}
+	if metric := c.routeMetric(peer); metric > 0 {
+		args = append(args, "metric", strconv.Itoa(metric))
+	}
+	return args
+}
+
+// routeMetric returns the metric of the routes of peer, its own if set.
+func (c *Config) routeMetric(peer Peer) int {
+	if peer.RouteMetric > 0 {
+		return peer.RouteMetric
+	}
+	return c.RouteMetric
+}
+
+// routingChanged reports whether newConfig routes peers differently from c,
+// so that even peers left unchanged need their routes replaced.
+func (c *Config) routingChanged(newConfig *Config) bool {
+	return c.ManageRoutes != newConfig.ManageRoutes || c.RouteMetric != newConfig.RouteMetric
+}
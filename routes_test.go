@@ -0,0 +1,193 @@
+package wgmesh_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const routesConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+manage_routes: %s
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32", "192.168.10.0/24"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`
+
+func TestManageRoutes(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(routesConfig, "true"))
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+	assert.Equal(t, []string{
+		"ip route replace 10.0.0.2/32 dev wg0",
+		"ip route replace 192.168.10.0/24 dev wg0",
+		"ip route replace 10.0.0.3/32 dev wg0",
+	}, runner.Commands())
+
+	// Dropping peer2 and moving peer1 to another subnet updates its routes
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+manage_routes: true
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32", "192.168.20.0/24"]
+`), 0o600))
+	runner.commands = nil
+	require.NoError(t, mesh.Reload())
+	// The route kept by peer1 is only replaced, never removed
+	assert.ElementsMatch(t, []string{
+		"ip route del 10.0.0.3/32 dev wg0",
+		"ip route del 192.168.10.0/24 dev wg0",
+		"ip route replace 10.0.0.2/32 dev wg0",
+		"ip route replace 192.168.20.0/24 dev wg0",
+	}, runner.Commands())
+
+	runner.commands = nil
+	require.NoError(t, mesh.StopTunnel())
+	assert.Equal(t, []string{
+		"ip route del 10.0.0.2/32 dev wg0",
+		"ip route del 192.168.20.0/24 dev wg0",
+	}, runner.Commands())
+}
+
+func TestRouteMetric(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+manage_routes: true
+route_metric: 100
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+    route_metric: 50
+`)
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+	assert.Equal(t, []string{
+		"ip route replace 10.0.0.2/32 dev wg0 metric 100",
+		"ip route replace 10.0.0.3/32 dev wg0 metric 50",
+	}, runner.Commands())
+
+	// A route with another metric is another route, the old one is removed
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+manage_routes: true
+route_metric: 100
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`), 0o600))
+	runner.commands = nil
+	require.NoError(t, mesh.Reload())
+	assert.Equal(t, []string{
+		"ip route del 10.0.0.3/32 dev wg0 metric 50",
+		"ip route replace 10.0.0.3/32 dev wg0 metric 100",
+	}, runner.Commands())
+}
+
+func TestManageRoutesDisabled(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(routesConfig, "false"))
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+	require.NoError(t, mesh.StopTunnel())
+	assert.Empty(t, runner.Commands())
+}
+
+const routingConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+%s
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+    %s
+`
+
+func TestRoutingChangeReroutesPeers(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(routingConfig, "manage_routes: true\nroute_metric: 100", "route_metric: 100"))
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+
+	reload := func(settings, peer2 string) []string {
+		t.Helper()
+		require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(fmt.Sprintf(routingConfig, settings, peer2)), 0o600))
+		runner.commands = nil
+		require.NoError(t, mesh.Reload())
+		return runner.Commands()
+	}
+
+	// peer2 dropping its own metric keeps the same effective metric
+	assert.Empty(t, reload("manage_routes: true\nroute_metric: 100", ""))
+
+	// The mesh metric moves the routes of the unchanged peers
+	assert.ElementsMatch(t, []string{
+		"ip route del 10.0.0.2/32 dev wg0 metric 100",
+		"ip route replace 10.0.0.2/32 dev wg0 metric 200",
+		"ip route del 10.0.0.3/32 dev wg0 metric 100",
+		"ip route replace 10.0.0.3/32 dev wg0 metric 200",
+	}, reload("manage_routes: true\nroute_metric: 200", ""))
+
+	// Turning manage_routes off removes the routes, turning it on adds them
+	assert.ElementsMatch(t, []string{
+		"ip route del 10.0.0.2/32 dev wg0 metric 200",
+		"ip route del 10.0.0.3/32 dev wg0 metric 200",
+	}, reload("route_metric: 200", ""))
+	assert.ElementsMatch(t, []string{
+		"ip route replace 10.0.0.2/32 dev wg0",
+		"ip route replace 10.0.0.3/32 dev wg0 metric 50",
+	}, reload("manage_routes: true", "route_metric: 50"))
+}
@@ -0,0 +1,93 @@
+package wgmesh
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ReloadSelective reloads the configuration like Reload, but only applies
+// the peer changes of peers matching selector, for staged rollouts. Old and
+// new peers are paired like Reload pairs them, by public key first, and a
+// peer is selected when its old or its new definition matches. Unselected
+// peers keep their running definition, device configuration and status even
+// if the source changed them, and everything but the peers stays as it is.
+func (w *WgMesh) ReloadSelective(selector PeerFilter) error {
+	return w.reloadQueue.run(func() error { return w.reloadSelective(selector) })
+}
+
+// reloadSelective loads and applies the configuration for ReloadSelective.
+func (w *WgMesh) reloadSelective(selector PeerFilter) (err error) {
+	start := time.Now()
+	defer func() { w.recordReload(start, err) }()
+
+	newConfig, err := w.source.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load updated configuration: %w", err)
+	}
+	if err := newConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	cfg := w.currentConfig()
+	merged := w.selectPeers(cfg, newConfig, selector)
+	if err := merged.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := cfg.checkImmutable(merged); err != nil {
+		log.Error().Err(err).Msg("Ignoring configuration change")
+		return err
+	}
+	if err := w.checkPeerRemoval(merged); err != nil {
+		return err
+	}
+
+	return w.applyConfig(merged)
+}
+
+// selectPeers returns cfg with the peer changes from cfg to newConfig that
+// are selected by selector applied.
+func (w *WgMesh) selectPeers(cfg, newConfig *Config, selector PeerFilter) *Config {
+	status := w.GetStatus()
+	selected := func(peer Peer) bool {
+		return selector.matches(PeerView{Peer: peer, Status: status.Peers[peer.Name]})
+	}
+
+	added, removed, updated := diffPeers(cfg.Peers, newConfig.Peers)
+	var applied []string
+	dropped := make(map[string]bool) // by old name
+	for _, peer := range removed {
+		if selected(peer) {
+			dropped[peer.Name] = true
+			applied = append(applied, peer.Name)
+		}
+	}
+	replaced := make(map[string]Peer) // by old name
+	for _, diff := range updated {
+		if selected(diff.New) || selected(diff.Old) {
+			replaced[diff.Old.Name] = diff.New
+			applied = append(applied, diff.New.Name)
+		}
+	}
+
+	merged := *cfg
+	merged.Peers = nil
+	for _, peer := range cfg.Peers {
+		if next, ok := replaced[peer.Name]; ok {
+			peer = next
+		} else if dropped[peer.Name] {
+			continue
+		}
+		merged.Peers = append(merged.Peers, peer)
+	}
+	for _, peer := range added {
+		if selected(peer) {
+			merged.Peers = append(merged.Peers, peer)
+			applied = append(applied, peer.Name)
+		}
+	}
+
+	log.Info().Strs("peers", applied).Msg("Applying selected peers only")
+	return &merged
+}
@@ -0,0 +1,168 @@
+package wgmesh_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const selectiveConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    tags: [canary]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+  - name: peer3
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.4/32"]
+`
+
+func TestReloadSelective(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, selectiveConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+	mesh.UpdatePeerState("peer2", wgmesh.PeerStateUp, nil)
+
+	// Every peer changes, but only the canary is rolled out
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51821
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.1.2/32"]
+    tags: [canary]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.1.3/32"]
+  - name: peer4
+    public_key: n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=
+    allowed_ips: ["10.0.1.5/32"]
+`), 0o600))
+	before := len(configureCalls(mockClient))
+	require.NoError(t, mesh.ReloadSelective(wgmesh.PeerFilter{Tags: []string{"canary"}}))
+
+	calls := configureCalls(mockClient)[before:]
+	var touched []wgtypes.Key
+	for _, call := range calls {
+		for _, peer := range call.Peers {
+			touched = append(touched, peer.PublicKey)
+		}
+	}
+	assert.Equal(t, []wgtypes.Key{mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")}, touched)
+
+	peers := make(map[string]wgmesh.Peer)
+	for _, view := range mesh.FindPeers(wgmesh.PeerFilter{}) {
+		peers[view.Peer.Name] = view.Peer
+	}
+	assert.Equal(t, []string{"10.0.1.2/32"}, peers["peer1"].AllowedIPs)
+	assert.Equal(t, []string{"10.0.0.3/32"}, peers["peer2"].AllowedIPs)
+	assert.Contains(t, peers, "peer3")
+	assert.NotContains(t, peers, "peer4")
+	assert.Equal(t, 51820, mesh.Config.ListenPort)
+	assert.Equal(t, wgmesh.PeerStateUp, mesh.GetStatus().Peers["peer2"].State)
+
+	// A full reload rolls out the rest
+	require.NoError(t, mesh.Reload())
+	assert.Len(t, mesh.FindPeers(wgmesh.PeerFilter{Name: "peer4"}), 1)
+	assert.Empty(t, mesh.FindPeers(wgmesh.PeerFilter{Name: "peer3"}))
+}
+
+func TestReloadSelectiveRemoval(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, selectiveConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    tags: [canary]
+`), 0o600))
+
+	// Selected by name, only peer3 is removed
+	require.NoError(t, mesh.ReloadSelective(wgmesh.PeerFilter{Name: "peer3"}))
+	var names []string
+	for _, view := range mesh.FindPeers(wgmesh.PeerFilter{}) {
+		names = append(names, view.Peer.Name)
+	}
+	assert.Equal(t, []string{"peer1", "peer2"}, names)
+}
+
+func TestReloadSelectivePairsByKey(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, selectiveConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+
+	// peer2 is renamed, which is an update of the same peer
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    tags: [canary]
+  - name: gateway
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+  - name: peer3
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.4/32"]
+`), 0o600))
+	require.NoError(t, mesh.ReloadSelective(wgmesh.PeerFilter{Name: "gateway"}))
+
+	var names []string
+	for _, view := range mesh.FindPeers(wgmesh.PeerFilter{}) {
+		names = append(names, view.Peer.Name)
+	}
+	assert.ElementsMatch(t, []string{"peer1", "gateway", "peer3"}, names)
+}
+
+func TestReloadSelectiveImmutable(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, "immutable_fields: [peers]\n"+selectiveConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte("immutable_fields: [peers]\n"+selectiveConfig+`
+  - name: peer4
+    public_key: n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=
+    allowed_ips: ["10.0.0.5/32"]
+`), 0o600))
+	err := mesh.ReloadSelective(wgmesh.PeerFilter{})
+	assert.EqualError(t, err, "configuration changes immutable fields: peers")
+	assert.Empty(t, configureCalls(mockClient))
+
+	// Counted like any other reload
+	var metrics strings.Builder
+	require.NoError(t, mesh.WriteMetrics(&metrics))
+	assert.Contains(t, metrics.String(), `wgmesh_reload_total{network="wg0",result="failure"} 1`+"\n")
+}
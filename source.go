@@ -0,0 +1,219 @@
+package wgmesh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigSource provides the mesh configuration and notifies about changes to
+// it. The default source is a YAML file watched with fsnotify; other
+// backends (etcd, consul, ...) can be plugged in with NewWgMeshFromSource.
+type ConfigSource interface {
+	// Load returns the current configuration.
+	Load() (*Config, error)
+
+	// Watch returns a channel receiving every new configuration until ctx
+	// is done. A source that never changes may return a nil channel.
+	Watch(ctx context.Context) (<-chan *Config, error)
+}
+
+// FileConfigSource reads the configuration from a YAML file and watches it,
+// and its peers file if any, for writes. A burst of writes is loaded once it
+// settles. Unknown keys are ignored unless Strict is set or the file sets
+// strict_yaml.
+type FileConfigSource struct {
+	Path   string
+	Strict bool
+
+	// OnLoadError, if set, is called when a changed file fails to load.
+	OnLoadError func(error)
+}
+
+func (s *FileConfigSource) String() string {
+	return s.Path
+}
+
+func (s *FileConfigSource) Load() (*Config, error) {
+	return loadConfigFile(s.Path, s.Strict)
+}
+
+func (s *FileConfigSource) Watch(ctx context.Context) (<-chan *Config, error) {
+	watcher, err := newWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize file watcher: %w", err)
+	}
+
+	// Watch the directory rather than the file: a file replaced by a rename,
+	// as done by WriteCurrentConfig and many editors, would drop the watch
+	if _, err := os.Stat(s.Path); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch YAML file: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(s.Path)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch YAML file: %w", err)
+	}
+
+	// The peers file may live elsewhere, and its path change with the
+	// configuration
+	files := map[string]bool{filepath.Clean(s.Path): true}
+	watchPeersFile := func(cfg *Config) {
+		path := cfg.peersFilePath(s.Path)
+		if path == "" || files[filepath.Clean(path)] {
+			return
+		}
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			log.Error().Err(err).Str("path", path).Msg("Failed to watch peers file")
+			return
+		}
+		files[filepath.Clean(path)] = true
+	}
+	if cfg, err := s.Load(); err == nil {
+		watchPeersFile(cfg)
+	}
+
+	log.Info().Msg("File watcher started for YAML file: " + s.Path)
+
+	configs := make(chan *Config)
+	go func() {
+		defer close(configs)
+		defer watcher.Close()
+
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !files[filepath.Clean(event.Name)] || !event.Op.Has(fsnotify.Write) && !event.Op.Has(fsnotify.Create) {
+					continue
+				}
+				// A save is often several events, e.g. a truncate and a
+				// write, load the file once they stop
+				settled = time.After(watchSettleDelay)
+			case <-settled:
+				settled = nil
+
+				log.Info().Msg("Detected YAML file change")
+				config, err := s.Load()
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to load updated configuration")
+					if s.OnLoadError != nil {
+						s.OnLoadError(err)
+					}
+					continue
+				}
+				watchPeersFile(config)
+
+				select {
+				case configs <- config:
+				case <-ctx.Done():
+					return
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Err(err).Msg("Error watching file")
+			}
+		}
+	}()
+
+	return configs, nil
+}
+
+// watchSettleDelay is how long a file source waits for more writes before
+// loading a changed file.
+const watchSettleDelay = 20 * time.Millisecond
+
+// newWatcher creates the watchers of file sources. Tests replace it.
+var newWatcher = fsnotify.NewWatcher
+
+func loadConfigFile(path string, strict bool) (*Config, error) {
+	if !strict {
+		config, ok, err := streamConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// A file opting into strict decoding is decoded again below
+		if ok && !config.StrictYAML {
+			return prepareConfig(path, config)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(path, data, strict)
+}
+
+// parseConfig decodes and resolves the configuration read from path.
+func parseConfig(path string, data []byte, strict bool) (*Config, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	// The file may opt in itself, so strictness is only known once decoded
+	if strict || config.StrictYAML {
+		config = Config{}
+		if err := yaml.UnmarshalStrict(data, &config); err != nil {
+			return nil, fmt.Errorf("strict YAML decoding of %s failed: %w", path, err)
+		}
+	}
+	return prepareConfig(path, &config)
+}
+
+// prepareConfig completes a configuration decoded from path: it reads the
+// files it references and resolves peer defaults and pool addresses.
+func prepareConfig(path string, config *Config) (*Config, error) {
+	if err := config.loadPeersFile(path); err != nil {
+		return nil, err
+	}
+	if err := config.loadTrustedKeysFile(path); err != nil {
+		return nil, err
+	}
+	if err := config.resolve(); err != nil {
+		return nil, err
+	}
+	if err := config.assignPoolAddresses(path); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// sourceName describes src for the status. Sources can name themselves by
+// implementing fmt.Stringer.
+func sourceName(src ConfigSource) string {
+	if s, ok := src.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", src)
+}
+
+// staticSource serves a fixed configuration and never changes.
+type staticSource struct {
+	config *Config
+}
+
+func (s *staticSource) String() string {
+	return "static"
+}
+
+func (s *staticSource) Load() (*Config, error) {
+	return s.config, nil
+}
+
+func (s *staticSource) Watch(ctx context.Context) (<-chan *Config, error) {
+	return nil, nil
+}
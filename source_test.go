@@ -0,0 +1,307 @@
+package wgmesh_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// memSource is an in-memory ConfigSource; configs sent on updates are
+// delivered to the watcher.
+type memSource struct {
+	config  *wgmesh.Config
+	updates chan *wgmesh.Config
+}
+
+func (s *memSource) Load() (*wgmesh.Config, error) {
+	return s.config, nil
+}
+
+func (s *memSource) Watch(ctx context.Context) (<-chan *wgmesh.Config, error) {
+	return s.updates, nil
+}
+
+func TestConfigSourceReload(t *testing.T) {
+	src := &memSource{
+		config: &wgmesh.Config{
+			NetworkName: "wg0",
+			ListenPort:  51820,
+			PrivateKey:  "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+		},
+		updates: make(chan *wgmesh.Config),
+	}
+
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	mesh, err := wgmesh.NewWgMeshFromSource(src, wgmesh.WithClient(mockClient))
+	require.NoError(t, err)
+	require.NoError(t, mesh.Start())
+
+	src.updates <- &wgmesh.Config{
+		NetworkName: "wg0",
+		ListenPort:  51820,
+		PrivateKey:  "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+		Peers: []wgmesh.Peer{{
+			Name:       "peer1",
+			PublicKey:  "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=",
+			AllowedIPs: []string{"10.0.0.2/32"},
+		}},
+	}
+
+	// The initial configuration plus the added peer
+	require.Eventually(t, func() bool {
+		return len(configureCalls(mockClient)) == 2
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, mesh.Close())
+
+	added := configureCalls(mockClient)[1]
+	require.Len(t, added.Peers, 1)
+	assert.Equal(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=", added.Peers[0].PublicKey.String())
+	require.Len(t, mesh.Config.Peers, 1)
+	assert.Equal(t, "peer1", mesh.Config.Peers[0].Name)
+}
+
+func TestConfigLoadedAtUpdatesOnReload(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers: []
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	status := mesh.GetStatus()
+	assert.Equal(t, mesh.YamlFilePath, status.ConfigSource)
+	loadedAt := status.ConfigLoadedAt
+	assert.False(t, loadedAt.IsZero())
+
+	time.Sleep(time.Millisecond)
+	require.NoError(t, mesh.Reload())
+
+	status = mesh.GetStatus()
+	assert.True(t, status.ConfigLoadedAt.After(loadedAt))
+	assert.Equal(t, mesh.YamlFilePath, status.ConfigSource)
+}
+
+func TestConfigSourceName(t *testing.T) {
+	mockClient := &MockWireguardClient{}
+
+	mesh, err := wgmesh.NewWgMeshFromConfig(&wgmesh.Config{
+		NetworkName: "wg0",
+		PrivateKey:  "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+	}, wgmesh.WithClient(mockClient))
+	require.NoError(t, err)
+	assert.Equal(t, "static", mesh.GetStatus().ConfigSource)
+
+	mesh, err = wgmesh.NewWgMeshFromSource(&memSource{config: &wgmesh.Config{
+		NetworkName: "wg0",
+		PrivateKey:  "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+	}}, wgmesh.WithClient(mockClient))
+	require.NoError(t, err)
+	assert.Equal(t, "*wgmesh_test.memSource", mesh.GetStatus().ConfigSource)
+}
+
+func TestFileWatcherFollowsReplacedFile(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers: []
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	// Wait for file watcher to start
+	time.Sleep(100 * time.Millisecond)
+
+	// Replace the file twice by renaming, the watch must survive the first
+	for _, name := range []string{"peer1", "peer2"} {
+		tmp := filepath.Join(filepath.Dir(mesh.YamlFilePath), "new.yaml")
+		require.NoError(t, os.WriteFile(tmp, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: `+name+`
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600))
+		require.NoError(t, os.Rename(tmp, mesh.YamlFilePath))
+
+		require.Eventually(t, func() bool {
+			return len(mesh.FindPeers(wgmesh.PeerFilter{Name: name})) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestFileWatcherSettles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(monitorConfig), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	configs, err := (&wgmesh.FileConfigSource{Path: path}).Watch(ctx)
+	require.NoError(t, err)
+
+	// Saved in pieces, the half-written file is never loaded
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(monitorConfig)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = f.WriteString(peer2Entry)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	select {
+	case cfg := <-configs:
+		assert.Len(t, cfg.Peers, 2)
+	case <-time.After(5 * time.Second):
+		t.Fatal("change was not loaded")
+	}
+	select {
+	case cfg := <-configs:
+		t.Fatalf("loaded the change again, with %d peers", len(cfg.Peers))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFileWatcherRetries(t *testing.T) {
+	defer wgmesh.SetWatchRetryDelay(time.Millisecond)()
+	var attempts atomic.Int32
+	established := make(chan struct{})
+	defer wgmesh.SetNewWatcher(func() (*fsnotify.Watcher, error) {
+		if attempts.Add(1) <= 2 {
+			return nil, errors.New("too many open files")
+		}
+		defer close(established)
+		return fsnotify.NewWatcher()
+	})()
+
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers: []
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	select {
+	case <-established:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher was not re-established")
+	}
+	assert.Equal(t, int32(3), attempts.Load())
+
+	// Auto-reload works once the watcher is up
+	require.Eventually(t, func() bool {
+		err := os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600)
+		return err == nil && len(mesh.FindPeers(wgmesh.PeerFilter{Name: "peer1"})) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestFileWatcherGivesUp(t *testing.T) {
+	defer wgmesh.SetWatchRetryDelay(time.Millisecond)()
+	var attempts atomic.Int32
+	defer wgmesh.SetNewWatcher(func() (*fsnotify.Watcher, error) {
+		attempts.Add(1)
+		return nil, errors.New("too many open files")
+	})()
+
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+watch_retries: 3
+peers: []
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	// The first attempt and three retries
+	require.Eventually(t, func() bool { return attempts.Load() == 4 }, 5*time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(4), attempts.Load())
+}
+
+func TestStrictYAML(t *testing.T) {
+	const typo = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowd_ips: ["10.0.0.2/32"]
+`
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Run("lenient", func(t *testing.T) {
+		cfg, err := (&wgmesh.FileConfigSource{Path: write(t, typo)}).Load()
+		require.NoError(t, err)
+		assert.Empty(t, cfg.Peers[0].AllowedIPs)
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		_, err := (&wgmesh.FileConfigSource{Path: write(t, typo), Strict: true}).Load()
+		assert.ErrorContains(t, err, "field allowd_ips not found")
+	})
+
+	t.Run("config", func(t *testing.T) {
+		_, err := (&wgmesh.FileConfigSource{Path: write(t, typo+"strict_yaml: true\n")}).Load()
+		assert.ErrorContains(t, err, "field allowd_ips not found")
+	})
+
+	t.Run("option", func(t *testing.T) {
+		_, err := wgmesh.NewWgMesh(write(t, typo), wgmesh.WithClient(&MockWireguardClient{}), wgmesh.WithStrictYAML())
+		assert.ErrorContains(t, err, "field allowd_ips not found")
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		cfg, err := (&wgmesh.FileConfigSource{Path: write(t, strings.Replace(typo, "allowd_ips", "allowed_ips", 1)), Strict: true}).Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.2/32"}, cfg.Peers[0].AllowedIPs)
+	})
+
+	t.Run("allowed ips string", func(t *testing.T) {
+		cfg, err := (&wgmesh.FileConfigSource{Path: write(t, strings.Replace(typo, `allowd_ips: ["10.0.0.2/32"]`, `allowed_ips: "10.0.0.2/32, 10.0.1.0/24"`, 1)), Strict: true}).Load()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.2/32", "10.0.1.0/24"}, cfg.Peers[0].AllowedIPs)
+	})
+}
@@ -0,0 +1,146 @@
+package wgmesh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Layout of Config.StateDir. Everything wgmesh writes at runtime lives
+// below it when it is set:
+//
+//	status.json       status of the mesh after the last monitor poll
+//	allocations.json  addresses handed out from address_pool
+//	audit.log         one JSON line per applied configuration change
+//	backups/          copies of the configuration file taken before reloads
+const (
+	stateStatusFile      = "status.json"
+	stateAllocationsFile = "allocations.json"
+	stateAuditLog        = "audit.log"
+	stateBackupsDir      = "backups"
+)
+
+// statePath returns the path of name in the state directory, or "" when
+// there is none.
+func (c *Config) statePath(name string) string {
+	if c.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(c.StateDir, name)
+}
+
+// ensureStateDir creates the state directory and its backups directory,
+// private to the daemon as they hold keys and addresses. Existing ones are
+// left as they are, it runs on every write.
+func (c *Config) ensureStateDir() error {
+	if c.StateDir == "" {
+		return nil
+	}
+
+	for _, dir := range []string{c.StateDir, c.statePath(stateBackupsDir)} {
+		if _, err := os.Stat(dir); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("failed to create state directory: %w", err)
+		}
+		// MkdirAll is subject to the umask
+		if err := os.Chmod(dir, 0o700); err != nil {
+			return fmt.Errorf("failed to restrict state directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// saveStatus writes the current status to the state directory, if any.
+func (w *WgMesh) saveStatus() {
+	cfg := w.currentConfig()
+	path := cfg.statePath(stateStatusFile)
+	if path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(w.GetStatus(), "", "  ")
+	if err == nil {
+		err = cfg.ensureStateDir()
+	}
+	if err == nil {
+		err = writeFileAtomic(path, data, 0o600, nil)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to save status")
+	}
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Network string    `json:"network"`
+	Added   []string  `json:"added,omitempty"`
+	Removed []string  `json:"removed,omitempty"`
+	Updated []string  `json:"updated,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// audit appends a configuration change to the audit log in the state
+// directory, if any. applyErr is the result of applying it.
+func (w *WgMesh) audit(added, removed, updated []Peer, applyErr error) {
+	cfg := w.currentConfig()
+	path := cfg.statePath(stateAuditLog)
+	if path == "" || len(added)+len(removed)+len(updated) == 0 && applyErr == nil {
+		return
+	}
+
+	entry := auditEntry{
+		Time:    time.Now(),
+		Network: cfg.NetworkName,
+		Added:   peerNames(added),
+		Removed: peerNames(removed),
+		Updated: peerNames(updated),
+	}
+	if applyErr != nil {
+		entry.Error = applyErr.Error()
+	}
+
+	if err := appendAuditEntry(cfg, path, entry); err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to write audit log")
+	}
+}
+
+func appendAuditEntry(cfg *Config, path string, entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := cfg.ensureStateDir(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// peerNames returns the sorted names of peers, nil when there are none.
+func peerNames(peers []Peer) []string {
+	if len(peers) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		names = append(names, peer.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,112 @@
+package wgmesh_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const stateDirConfig = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+state_dir: %s
+address_pool: 10.9.0.0/29
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+`
+
+func TestStateDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(stateDirConfig, dir)+"monitor_interval: 5ms\n")
+	pollOnce(t, mesh, mockClient, &wgtypes.Device{})
+
+	for _, path := range []string{dir, filepath.Join(dir, "backups")} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+		assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+	}
+
+	// Pool allocations of the applied configuration are kept in the state
+	// directory, not next to the configuration file
+	var allocations map[string]string
+	data, err := os.ReadFile(filepath.Join(dir, "allocations.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &allocations))
+	assert.Equal(t, map[string]string{"peer1": "10.9.0.1"}, allocations)
+	assert.NoFileExists(t, mesh.YamlFilePath+".allocations")
+
+	var status wgmesh.MeshStatus
+	data, err = os.ReadFile(filepath.Join(dir, "status.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &status))
+	assert.Equal(t, "wg0", status.NetworkName)
+	assert.Contains(t, status.Peers, "peer1")
+}
+
+func TestStateDirKeepsPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "backups"), 0o700))
+	require.NoError(t, os.Chmod(dir, 0o750))
+
+	// The operator's permissions survive the writes of every poll
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(stateDirConfig, dir)+"monitor_interval: 5ms\n")
+	pollOnce(t, mesh, mockClient, &wgtypes.Device{})
+	require.FileExists(t, filepath.Join(dir, "status.json"))
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+}
+
+func TestStateDirBackupsAndAudit(t *testing.T) {
+	dir := t.TempDir()
+	mesh, mockClient := newTestMesh(t, fmt.Sprintf(stateDirConfig, dir))
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	// Wait for file watcher to start
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(fmt.Sprintf(stateDirConfig, dir)+`
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+`), 0o600))
+
+	auditLog := filepath.Join(dir, "audit.log")
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(auditLog)
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+
+	backups, err := os.ReadDir(filepath.Join(dir, "backups"))
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.True(t, strings.HasPrefix(backups[0].Name(), "config.yaml.backup_"))
+
+	data, err := os.ReadFile(auditLog)
+	require.NoError(t, err)
+	var entry struct {
+		Network string   `json:"network"`
+		Added   []string `json:"added"`
+	}
+	require.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "wg0", entry.Network)
+	assert.Equal(t, []string{"peer2"}, entry.Added)
+}
@@ -0,0 +1,58 @@
+package wgmesh
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// peerStats is the line written to the stats sink for every peer on every
+// monitor poll.
+type peerStats struct {
+	Time         time.Time `json:"time"`
+	Peer         string    `json:"peer"`
+	State        PeerState `json:"state"`
+	RxBytes      int64     `json:"rx_bytes"`
+	TxBytes      int64     `json:"tx_bytes"`
+	HandshakeAge float64   `json:"handshake_age_seconds"` // -1 when there never was one
+}
+
+func newPeerStats(name string, state PeerState, peer wgtypes.Peer, now time.Time) peerStats {
+	age := -1.0
+	if !peer.LastHandshakeTime.IsZero() {
+		age = now.Sub(peer.LastHandshakeTime).Seconds()
+	}
+
+	return peerStats{
+		Time:         now,
+		Peer:         name,
+		State:        state,
+		RxBytes:      peer.ReceiveBytes,
+		TxBytes:      peer.TransmitBytes,
+		HandshakeAge: age,
+	}
+}
+
+// writeStats writes stats to the sink as JSON lines in a single write, so
+// lines of one poll aren't interleaved with other writers.
+func (w *WgMesh) writeStats(stats []peerStats) {
+	if w.statsSink == nil || len(stats) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, s := range stats {
+		if err := enc.Encode(s); err != nil {
+			log.Error().Err(err).Msg("Failed to encode peer stats")
+			return
+		}
+	}
+
+	if _, err := w.statsSink.Write(buf.Bytes()); err != nil {
+		log.Error().Err(err).Msg("Failed to write peer stats")
+	}
+}
@@ -0,0 +1,78 @@
+package wgmesh_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestStatsSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(monitorConfig+`
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`), 0o600))
+
+	var sink bytes.Buffer
+	mockClient := &MockWireguardClient{}
+	mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(mockClient), wgmesh.WithStatsSink(&sink))
+	require.NoError(t, err)
+
+	pollOnce(t, mesh, mockClient, &wgtypes.Device{
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+				LastHandshakeTime: time.Now().Add(-30 * time.Second),
+				ReceiveBytes:      1024,
+				TransmitBytes:     2048,
+			},
+			{PublicKey: mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=")},
+			// Not configured, so not reported
+			{PublicKey: mustParseKey(t, "n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=")},
+		},
+	})
+
+	type line struct {
+		Time         time.Time `json:"time"`
+		Peer         string    `json:"peer"`
+		State        string    `json:"state"`
+		RxBytes      int64     `json:"rx_bytes"`
+		TxBytes      int64     `json:"tx_bytes"`
+		HandshakeAge float64   `json:"handshake_age_seconds"`
+	}
+
+	var lines []line
+	scanner := bufio.NewScanner(&sink)
+	for scanner.Scan() {
+		var l line
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &l), "invalid JSON line %q", scanner.Text())
+		lines = append(lines, l)
+	}
+
+	// At least one full poll, two lines each
+	require.GreaterOrEqual(t, len(lines), 2)
+	require.Zero(t, len(lines)%2)
+
+	peer1, peer2 := lines[0], lines[1]
+	assert.Equal(t, "peer1", peer1.Peer)
+	assert.Equal(t, "up", peer1.State)
+	assert.Equal(t, int64(1024), peer1.RxBytes)
+	assert.Equal(t, int64(2048), peer1.TxBytes)
+	assert.InDelta(t, 30, peer1.HandshakeAge, 5)
+	assert.False(t, peer1.Time.IsZero())
+
+	assert.Equal(t, "peer2", peer2.Peer)
+	// Still within the startup grace period
+	assert.Equal(t, "configuring", peer2.State)
+	assert.Equal(t, -1.0, peer2.HandshakeAge)
+}
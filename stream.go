@@ -0,0 +1,157 @@
+package wgmesh
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// streamThreshold is the size from which configuration files are decoded
+// with streamConfig rather than at once. Tests lower it.
+var streamThreshold int64 = 1 << 20
+
+// errNotStreamable reports a file streamConfig can't decode piecewise, such
+// as one with a flow style peers list.
+var errNotStreamable = errors.New("configuration can't be streamed")
+
+// streamBatch is how many peers streamConfig decodes at a time. Decoding
+// them one by one would spend more on setting up parsers than it saves.
+const streamBatch = 256
+
+// streamConfig decodes a configuration a few peers at a time. Decoding a
+// file at once holds it along with the node tree of every peer in memory,
+// which with thousands of peers dwarfs the resulting Config. Here the
+// top-level settings are collected and decoded separately, while the items
+// of a block style peers list are decoded in batches as soon as they are
+// complete, so only the text and nodes of one batch are alive at a time.
+//
+// Anything not laid out that way (flow style peers, aliases to anchors
+// outside the batch, several documents) yields an error; the caller then
+// decodes the file at once, which reports real syntax errors the usual way.
+func streamConfig(r io.Reader) (*Config, error) {
+	var (
+		rest    bytes.Buffer // everything but the peers list
+		batch   bytes.Buffer // the peers read since the last flush
+		pending int          // number of peers in batch
+		peers   []Peer
+		inPeers bool
+		indent  = -1 // of the "-" starting every peer
+	)
+
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
+		var decoded []Peer
+		if err := yaml.Unmarshal(batch.Bytes(), &decoded); err != nil || len(decoded) != pending {
+			return errNotStreamable
+		}
+		peers = append(peers, decoded...)
+		batch.Reset()
+		pending = 0
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimLeft(line, " ")
+		topLevel := len(trimmed) == len(line) && trimmed != "" && !strings.HasPrefix(trimmed, "#")
+
+		if topLevel && (strings.HasPrefix(line, "---") || strings.HasPrefix(line, "...")) {
+			return nil, errNotStreamable
+		}
+
+		if inPeers {
+			if topLevel && !strings.HasPrefix(line, "-") {
+				// The next top-level key ends the list
+				if err := flush(); err != nil {
+					return nil, err
+				}
+				inPeers = false
+			} else {
+				if strings.HasPrefix(trimmed, "-") {
+					if indent < 0 {
+						indent = len(line) - len(trimmed)
+					}
+					if len(line)-len(trimmed) == indent {
+						if pending == streamBatch {
+							if err := flush(); err != nil {
+								return nil, err
+							}
+						}
+						pending++
+					}
+				}
+				if pending > 0 {
+					batch.WriteString(line)
+					batch.WriteByte('\n')
+				}
+				continue
+			}
+		}
+
+		if topLevel && strings.HasPrefix(line, "peers:") {
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "peers:"), "#")
+			if strings.TrimSpace(value) != "" {
+				return nil, errNotStreamable
+			}
+			if peers != nil {
+				// Duplicate key, leave it to the full decoder
+				return nil, errNotStreamable
+			}
+			inPeers = true
+			peers = []Peer{}
+			continue
+		}
+
+		rest.WriteString(line)
+		rest.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errNotStreamable
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(rest.Bytes(), &config); err != nil || config.Peers != nil {
+		return nil, errNotStreamable
+	}
+	if len(peers) > 0 {
+		config.Peers = peers
+	}
+	return &config, nil
+}
+
+// streamConfigFile decodes the file at path with streamConfig if it is
+// large enough to be worth it. ok is false when the caller should decode
+// the file at once instead.
+func streamConfigFile(path string) (config *Config, ok bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	if info.Size() < streamThreshold {
+		return nil, false, nil
+	}
+
+	config, err = streamConfig(bufio.NewReader(f))
+	if err != nil {
+		return nil, false, nil
+	}
+	return config, true, nil
+}
@@ -0,0 +1,164 @@
+package wgmesh_test
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v2"
+)
+
+// largeConfig returns a configuration with n peers, with the comments,
+// blank lines and settings after the peers a hand-kept file has.
+func largeConfig(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`# generated
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+
+peers:
+  # one per site
+`)
+	for i := 0; i < n; i++ {
+		key := wgtypes.Key{byte(i), byte(i >> 8), 1}
+		fmt.Fprintf(&buf, `  - name: peer%d
+    public_key: %s
+    allowed_ips: ["10.%d.%d.0/24"]
+    endpoint: host%d.example.com:51820
+
+    tags: [site, "rack %d"]
+`, i, key, i>>8, i&0xff, i, i%10)
+	}
+	buf.WriteString(`
+monitor_interval: 30s
+`)
+	return buf.Bytes()
+}
+
+func TestStreamConfig(t *testing.T) {
+	data := largeConfig(1000)
+
+	var want wgmesh.Config
+	require.NoError(t, yaml.Unmarshal(data, &want))
+	got, err := wgmesh.StreamConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, &want, got)
+	assert.Len(t, got.Peers, 1000)
+	assert.Equal(t, 30*time.Second, got.MonitorInterval)
+
+	// Peers at the indentation of the key
+	got, err = wgmesh.StreamConfig(strings.NewReader(`
+network_name: wg0
+peers:
+- name: peer1
+  public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+- name: peer2
+  public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+listen_port: 51820
+`))
+	require.NoError(t, err)
+	require.Len(t, got.Peers, 2)
+	assert.Equal(t, "peer2", got.Peers[1].Name)
+	assert.Equal(t, 51820, got.ListenPort)
+
+	// Layouts that can't be streamed are left to the full decoder
+	for name, data := range map[string]string{
+		"flow style": "peers: [{name: peer1}]\n",
+		"aliases":    "x-peer: &p {name: peer1}\npeers:\n  - *p\n",
+		"documents":  "network_name: wg0\n---\nnetwork_name: wg1\n",
+	} {
+		_, err := wgmesh.StreamConfig(strings.NewReader(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadLargeConfig(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig)
+	path := filepath.Join(t.TempDir(), "large.yaml")
+	require.NoError(t, os.WriteFile(path, largeConfig(10000), 0o600))
+
+	restore := wgmesh.SetStreamThreshold(1)
+	streamed, err := mesh.LoadConfig(path)
+	restore()
+	require.NoError(t, err)
+	require.Len(t, streamed.Peers, 10000)
+	assert.Equal(t, "peer9999", streamed.Peers[9999].Name)
+	assert.Equal(t, []string{"10.39.15.0/24"}, streamed.Peers[9999].AllowedIPs)
+	assert.Equal(t, []string{"site", "rack 9"}, streamed.Peers[9999].Tags)
+
+	// Not streamed, the result is the same
+	restore = wgmesh.SetStreamThreshold(math.MaxInt64)
+	full, err := mesh.LoadConfig(path)
+	restore()
+	require.NoError(t, err)
+	assert.Equal(t, full, streamed)
+}
+
+// BenchmarkLoadConfig compares loading 10000 peers at once and streamed.
+// Besides the allocations it reports the peak heap, which streaming keeps
+// well below the full decode that holds the whole node tree at once.
+func BenchmarkLoadConfig(b *testing.B) {
+	mesh, _ := newTestMesh(b, monitorConfig)
+	path := filepath.Join(b.TempDir(), "large.yaml")
+	require.NoError(b, os.WriteFile(path, largeConfig(10000), 0o600))
+
+	for name, threshold := range map[string]int64{"full": math.MaxInt64, "streaming": 1} {
+		b.Run(name, func(b *testing.B) {
+			defer wgmesh.SetStreamThreshold(threshold)()
+			b.ReportAllocs()
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				peak = max(peak, peakHeap(func() {
+					if _, err := mesh.LoadConfig(path); err != nil {
+						b.Fatal(err)
+					}
+				}))
+			}
+			b.ReportMetric(float64(peak), "peak-heap-B")
+		})
+	}
+}
+
+// peakHeap returns the largest growth of the live heap seen while f runs.
+func peakHeap(f func()) uint64 {
+	runtime.GC()
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	base := sample[0].Value.Uint64()
+
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		s := []metrics.Sample{{Name: sample[0].Name}}
+		for {
+			metrics.Read(s)
+			if v := s[0].Value.Uint64(); v > base && v-base > peak.Load() {
+				peak.Store(v - base)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Microsecond):
+			}
+		}
+	}()
+	f()
+	close(done)
+	<-stopped
+	return peak.Load()
+}
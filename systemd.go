@@ -0,0 +1,169 @@
+package wgmesh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sdNotify sends state to the service manager as described in sd_notify(3).
+// It does nothing when not started by systemd with a notify socket.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// Abstract socket
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to notify service manager: %w", err)
+	}
+	return nil
+}
+
+// watchdogInterval returns how often the watchdog must be pinged, half the
+// timeout systemd passes in WATCHDOG_USEC, or 0 when it isn't enabled for
+// this process.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// monitorStallTolerance is how late the monitor loop may be for its next
+// poll before the mesh counts as hung and the watchdog pings stop.
+var monitorStallTolerance = 30 * time.Second
+
+// notifyReady tells systemd that the mesh is up and starts pinging its
+// watchdog, if enabled. Meshes of a DirManager leave this to the manager.
+func (w *WgMesh) notifyReady() {
+	if os.Getenv("NOTIFY_SOCKET") == "" || w.dirManaged {
+		return
+	}
+
+	if err := sdNotify("READY=1\n" + w.notifyStatus()); err != nil {
+		log.Warn().Err(err).Msg("Failed to notify systemd")
+	}
+	startWatchdog(w.ctx, &w.wg, w.responsive, w.notifyStatus)
+}
+
+// expectPoll records that the monitor loop polls the device again after
+// delay, see responsive, and returns delay.
+func (w *WgMesh) expectPoll(delay time.Duration) time.Duration {
+	w.monitorDue.Store(time.Now().Add(delay).UnixNano())
+	return delay
+}
+
+// responsive reports whether the monitor loop keeps polling the device, or
+// isn't running at all as on a replica.
+func (w *WgMesh) responsive() bool {
+	due := w.monitorDue.Load()
+	return due == 0 || time.Now().Before(time.Unix(0, due).Add(monitorStallTolerance))
+}
+
+// startWatchdog pings the systemd watchdog, if enabled, until ctx is done.
+// Pings are only sent while alive reports progress, so systemd restarts a
+// hung daemon; status is sent along with them.
+func startWatchdog(ctx context.Context, wg *sync.WaitGroup, alive func() bool, status func() string) {
+	interval := watchdogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !alive() {
+					log.Error().Msg("Peer monitor stalled, not pinging systemd watchdog")
+					continue
+				}
+				// Reading the status blocks if the daemon deadlocked, which
+				// stops the pings too
+				if err := sdNotify("WATCHDOG=1\n" + status()); err != nil {
+					log.Warn().Err(err).Msg("Failed to ping systemd watchdog")
+				}
+			}
+		}
+	}()
+}
+
+// notifyStatus renders the mesh status for systemctl status.
+func (w *WgMesh) notifyStatus() string {
+	status := w.GetStatus()
+	return fmt.Sprintf("STATUS=mesh %s, %d/%d peers up", status.Status, peersUp(status), len(status.Peers))
+}
+
+// peersUp returns the number of peers up in status.
+func peersUp(status MeshStatus) int {
+	up := 0
+	for _, peer := range status.Peers {
+		if peer.State == PeerStateUp {
+			up++
+		}
+	}
+	return up
+}
+
+// notifyReady tells systemd that the manager started, even with no meshes
+// to run, and pings the watchdog for the whole process, if enabled.
+func (d *DirManager) notifyReady() {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+
+	if err := sdNotify("READY=1\n" + d.notifyStatus()); err != nil {
+		log.Warn().Err(err).Msg("Failed to notify systemd")
+	}
+	startWatchdog(d.ctx, &d.wg, d.responsive, d.notifyStatus)
+}
+
+// responsive reports whether all meshes keep polling their devices.
+func (d *DirManager) responsive() bool {
+	for _, mesh := range d.running() {
+		if !mesh.responsive() {
+			return false
+		}
+	}
+	return true
+}
+
+// notifyStatus renders the status of all meshes for systemctl status.
+func (d *DirManager) notifyStatus() string {
+	meshes := d.running()
+	up, peers := 0, 0
+	for _, mesh := range meshes {
+		status := mesh.GetStatus()
+		up += peersUp(status)
+		peers += len(status.Peers)
+	}
+	return fmt.Sprintf("STATUS=%d meshes, %d/%d peers up", len(meshes), up, peers)
+}
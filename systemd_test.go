@@ -0,0 +1,180 @@
+package wgmesh_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// notifySocket listens on a fake systemd notify socket and returns the
+// received messages.
+func notifySocket(t *testing.T) <-chan string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	messages := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	return messages
+}
+
+func receive(t *testing.T, messages <-chan string) string {
+	t.Helper()
+
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+		return ""
+	}
+}
+
+func TestSystemdNotify(t *testing.T) {
+	messages := notifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	ready := receive(t, messages)
+	assert.True(t, strings.HasPrefix(ready, "READY=1\n"), ready)
+	assert.Contains(t, ready, "STATUS=mesh ")
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, strings.HasPrefix(receive(t, messages), "WATCHDOG=1\n"))
+	}
+}
+
+func TestSystemdNotifyWithoutWatchdog(t *testing.T) {
+	messages := notifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "")
+
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+
+	assert.True(t, strings.HasPrefix(receive(t, messages), "READY=1\n"))
+	select {
+	case msg := <-messages:
+		t.Fatalf("unexpected notification %q", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// quiet fails if a message arrives within d.
+func quiet(t *testing.T, messages <-chan string, d time.Duration) {
+	t.Helper()
+
+	select {
+	case msg := <-messages:
+		t.Fatalf("unexpected notification %q", msg)
+	case <-time.After(d):
+	}
+}
+
+func TestSystemdWatchdogStalledMonitor(t *testing.T) {
+	messages := notifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	defer wgmesh.SetMonitorStallTolerance(20 * time.Millisecond)()
+
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	release := make(chan struct{})
+	var stalled atomic.Bool
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Run(func(mock.Arguments) {
+		if stalled.Load() {
+			<-release
+		}
+	}).Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+	defer mesh.Close()
+	defer close(release)
+
+	assert.True(t, strings.HasPrefix(receive(t, messages), "READY=1\n"))
+	assert.True(t, strings.HasPrefix(receive(t, messages), "WATCHDOG=1\n"))
+
+	// A monitor stuck reading the device stops the pings
+	stalled.Store(true)
+	time.Sleep(100 * time.Millisecond)
+	for len(messages) > 0 {
+		<-messages
+	}
+	quiet(t, messages, 100*time.Millisecond)
+}
+
+func TestDirManagerSystemdNotify(t *testing.T) {
+	messages := notifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	dir := t.TempDir()
+
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", mock.Anything, mock.Anything).Return(nil)
+	mockClient.On("Device", mock.Anything).Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+
+	// An empty directory is ready too, and kept alive
+	manager := wgmesh.NewDirManager(dir, wgmesh.WithClient(mockClient))
+	require.NoError(t, manager.Start())
+	defer manager.Close()
+	assert.Equal(t, "READY=1\nSTATUS=0 meshes, 0/0 peers up", receive(t, messages))
+	assert.True(t, strings.HasPrefix(receive(t, messages), "WATCHDOG=1\n"))
+
+	// Meshes leave notifying to the manager
+	wg0 := filepath.Join(dir, "wg0.yaml")
+	require.NoError(t, os.WriteFile(wg0, networkConfig("wg0"), 0o600))
+	require.Eventually(t, func() bool {
+		_, ok := manager.Mesh(wg0)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		msg := receive(t, messages)
+		assert.NotContains(t, msg, "READY=1")
+		return msg == "WATCHDOG=1\nSTATUS=1 meshes, 0/1 peers up"
+	}, 5*time.Second, time.Millisecond)
+
+	// Removing the last mesh doesn't stop the pings
+	require.NoError(t, os.Remove(wg0))
+	require.Eventually(t, func() bool {
+		msg := receive(t, messages)
+		assert.NotContains(t, msg, "READY=1")
+		return msg == "WATCHDOG=1\nSTATUS=0 meshes, 0/0 peers up"
+	}, 5*time.Second, time.Millisecond)
+	for range 3 {
+		assert.True(t, strings.HasPrefix(receive(t, messages), "WATCHDOG=1\n"))
+	}
+}
@@ -0,0 +1,50 @@
+package wgmesh
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"text/template"
+)
+
+// templateFuncs are available to configuration templates besides the
+// text/template builtins.
+var templateFuncs = template.FuncMap{
+	// add sums integers, e.g. to derive ports or addresses from an index
+	"add": func(a, b int) int { return a + b },
+	// env returns the value of an environment variable, "" if unset
+	"env": os.Getenv,
+	// default returns def when value is empty: {{ env "REGION" | default "eu" }}
+	"default": func(def, value any) any {
+		if value == nil {
+			return def
+		}
+		if v := reflect.ValueOf(value); v.IsZero() || (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0 {
+			return def
+		}
+		return value
+	},
+}
+
+// LoadConfigTemplate renders the file at path with text/template and data,
+// e.g. a hostname, index or region, and parses the result like a plain
+// configuration file. Templates can read environment variables with env and
+// fall back with default. The rendered configuration isn't watched.
+func LoadConfigTemplate(path string, data any) (*Config, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(path).Funcs(templateFuncs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config template: %w", err)
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, fmt.Errorf("failed to render config template: %w", err)
+	}
+
+	return parseConfig(path, rendered.Bytes(), false)
+}
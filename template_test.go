@@ -0,0 +1,79 @@
+package wgmesh_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const meshTemplate = `
+network_name: wg-{{ .Region }}
+listen_port: {{ add 51820 .Index }}
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+mtu: {{ env "WGMESH_TEST_MTU" | default 1420 }}
+peers:
+{{- range $i, $peer := .Peers }}
+  - name: {{ $peer.Name }}
+    public_key: {{ $peer.Key }}
+    allowed_ips: ["10.{{ $.Index }}.0.{{ add 2 $i }}/32"]
+{{- end }}
+`
+
+type templatePeer struct {
+	Name string
+	Key  string
+}
+
+func writeTemplate(t *testing.T, text string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml.tmpl")
+	require.NoError(t, os.WriteFile(path, []byte(text), 0o600))
+	return path
+}
+
+func TestLoadConfigTemplate(t *testing.T) {
+	t.Setenv("WGMESH_TEST_MTU", "1380")
+	data := map[string]any{
+		"Region": "eu",
+		"Index":  3,
+		"Peers": []templatePeer{
+			{"peer1", "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="},
+			{"peer2", "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="},
+		},
+	}
+
+	cfg, err := wgmesh.LoadConfigTemplate(writeTemplate(t, meshTemplate), data)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	assert.Equal(t, "wg-eu", cfg.NetworkName)
+	assert.Equal(t, 51823, cfg.ListenPort)
+	assert.Equal(t, 1380, cfg.MTU)
+	require.Len(t, cfg.Peers, 2)
+	assert.Equal(t, "peer1", cfg.Peers[0].Name)
+	assert.Equal(t, []string{"10.3.0.2/32"}, cfg.Peers[0].AllowedIPs)
+	assert.Equal(t, "peer2", cfg.Peers[1].Name)
+	assert.Equal(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=", cfg.Peers[1].PublicKey)
+	assert.Equal(t, []string{"10.3.0.3/32"}, cfg.Peers[1].AllowedIPs)
+}
+
+func TestLoadConfigTemplateDefault(t *testing.T) {
+	t.Setenv("WGMESH_TEST_MTU", "")
+	cfg, err := wgmesh.LoadConfigTemplate(writeTemplate(t, meshTemplate), map[string]any{"Region": "us", "Index": 0, "Peers": nil})
+	require.NoError(t, err)
+	assert.Equal(t, 1420, cfg.MTU)
+	assert.Empty(t, cfg.Peers)
+}
+
+func TestLoadConfigTemplateErrors(t *testing.T) {
+	_, err := wgmesh.LoadConfigTemplate(writeTemplate(t, "network_name: {{ .Region"), nil)
+	assert.ErrorContains(t, err, "failed to parse config template")
+
+	_, err = wgmesh.LoadConfigTemplate(writeTemplate(t, meshTemplate), map[string]any{"Index": 0})
+	assert.ErrorContains(t, err, "failed to render config template")
+}
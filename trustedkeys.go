@@ -0,0 +1,86 @@
+package wgmesh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Modes of the trusted key check.
+const (
+	// TrustedKeysEnforce rejects configurations with untrusted peers.
+	TrustedKeysEnforce = "enforce"
+	// TrustedKeysLearn only warns about untrusted peers, to build up the
+	// list of trusted keys while migrating.
+	TrustedKeysLearn = "learn"
+)
+
+// trustedKeysFilePath returns the path of the trusted keys file, relative
+// paths being taken from the directory of the configuration file.
+func (c *Config) trustedKeysFilePath(configPath string) string {
+	if c.TrustedKeysFile == "" || filepath.IsAbs(c.TrustedKeysFile) || configPath == "" {
+		return c.TrustedKeysFile
+	}
+	return filepath.Join(filepath.Dir(configPath), c.TrustedKeysFile)
+}
+
+// loadTrustedKeysFile reads the trusted keys file, if any: one base64
+// public key per line, empty lines and lines starting with # are skipped.
+func (c *Config) loadTrustedKeysFile(configPath string) error {
+	path := c.trustedKeysFilePath(configPath)
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read trusted keys file: %w", err)
+	}
+
+	c.fileTrustedKeys = nil
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		c.fileTrustedKeys = append(c.fileTrustedKeys, text)
+	}
+	return scanner.Err()
+}
+
+// restrictsKeys reports whether only trusted keys may be used by peers.
+func (c *Config) restrictsKeys() bool {
+	return len(c.TrustedKeys) > 0 || c.TrustedKeysFile != ""
+}
+
+// trustedKeys returns the trusted public keys, inline and from the file.
+func (c *Config) trustedKeys() map[string]bool {
+	keys := make(map[string]bool, len(c.TrustedKeys)+len(c.fileTrustedKeys))
+	for _, key := range c.TrustedKeys {
+		keys[key] = true
+	}
+	for _, key := range c.fileTrustedKeys {
+		keys[key] = true
+	}
+	return keys
+}
+
+// untrustedPeers returns the peers whose public key isn't trusted.
+func (c *Config) untrustedPeers() []Peer {
+	if !c.restrictsKeys() {
+		return nil
+	}
+
+	trusted := c.trustedKeys()
+	var untrusted []Peer
+	for _, peer := range c.Peers {
+		if !trusted[peer.PublicKey] {
+			untrusted = append(untrusted, peer)
+		}
+	}
+	return untrusted
+}
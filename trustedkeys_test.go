@@ -0,0 +1,69 @@
+package wgmesh_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedKeys(t *testing.T) {
+	t.Run("enforce", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.TrustedKeys = []string{"236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="}
+
+		err := cfg.Validate()
+		require.Error(t, err)
+		assert.EqualError(t, err, "public key iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk= of peer peer2 is not trusted")
+
+		cfg.TrustedKeys = append(cfg.TrustedKeys, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=")
+		assert.NoError(t, cfg.Validate())
+	})
+
+	t.Run("learn", func(t *testing.T) {
+		var buf bytes.Buffer
+		oldLogger := log.Logger
+		log.Logger = zerolog.New(&buf)
+		defer func() { log.Logger = oldLogger }()
+
+		cfg := validConfig()
+		cfg.TrustedKeys = []string{"236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="}
+		cfg.TrustedKeysMode = wgmesh.TrustedKeysLearn
+
+		require.NoError(t, cfg.Validate())
+		assert.Contains(t, buf.String(), "public key iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk= of peer peer2 is not trusted")
+		assert.NotContains(t, buf.String(), "peer peer1")
+	})
+}
+
+func TestTrustedKeysFile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "trusted"), []byte(`
+# approved by security
+236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+`), 0o600))
+	path := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+trusted_keys_file: trusted
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`), 0o600))
+
+	_, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(&MockWireguardClient{}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "peer peer2 is not trusted")
+}
@@ -0,0 +1,70 @@
+package wgmesh
+
+import (
+	"reflect"
+	"time"
+)
+
+// StatusUpdates returns a channel receiving a snapshot of the mesh status
+// whenever it changes. Updates are coalesced: a slow receiver only gets the
+// latest snapshot. The channel is closed by Close.
+func (w *WgMesh) StatusUpdates() <-chan MeshStatus {
+	ch := make(chan MeshStatus, 1)
+
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	if w.ctx.Err() != nil {
+		close(ch)
+		return ch
+	}
+	w.subscribers = append(w.subscribers, ch)
+	return ch
+}
+
+// snapshotLocked returns a copy of the status. The caller must hold statusMu.
+func (w *WgMesh) snapshotLocked() MeshStatus {
+	status := w.status
+	status.Peers = make(map[string]PeerStatus, len(w.status.Peers))
+	for name, peer := range w.status.Peers {
+		status.Peers[name] = peer
+	}
+	return status
+}
+
+// publishStatus sends the status to subscribers if it changed since the last
+// update, replacing snapshots they haven't received yet. The caller must hold
+// statusMu.
+func (w *WgMesh) publishStatus() {
+	if len(w.subscribers) == 0 {
+		return
+	}
+
+	// LastUpdate changes on every poll, it's not a change by itself
+	current := w.snapshotLocked()
+	compare := current
+	compare.LastUpdate = time.Time{}
+	if reflect.DeepEqual(compare, w.published) {
+		return
+	}
+	w.published = compare
+
+	for _, ch := range w.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- current
+	}
+}
+
+// closeSubscribers closes all StatusUpdates channels.
+func (w *WgMesh) closeSubscribers() {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	for _, ch := range w.subscribers {
+		close(ch)
+	}
+	w.subscribers = nil
+}
@@ -0,0 +1,58 @@
+package wgmesh_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receiveStatus(t *testing.T, updates <-chan wgmesh.MeshStatus) wgmesh.MeshStatus {
+	t.Helper()
+	select {
+	case status, ok := <-updates:
+		require.True(t, ok, "updates channel closed")
+		return status
+	case <-time.After(time.Second):
+		t.Fatal("no status update received")
+		return wgmesh.MeshStatus{}
+	}
+}
+
+func TestStatusUpdates(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, monitorConfig)
+	mockClient.On("Close").Return(nil)
+
+	updates := mesh.StatusUpdates()
+
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	status := receiveStatus(t, updates)
+	assert.Equal(t, wgmesh.MeshStateUp, status.Status)
+	assert.Equal(t, wgmesh.PeerStateUp, status.Peers["peer1"].State)
+
+	// Nobody reads while the peer flaps, only the latest state is kept
+	for i := 0; i < 10; i++ {
+		mesh.UpdatePeerState("peer1", wgmesh.PeerStateError, errors.New("flap"))
+		mesh.UpdatePeerState("peer1", wgmesh.PeerStateDown, nil)
+	}
+	status = receiveStatus(t, updates)
+	assert.Equal(t, wgmesh.PeerStateDown, status.Peers["peer1"].State)
+	assert.Equal(t, 10, status.Peers["peer1"].ErrorCount)
+
+	select {
+	case <-updates:
+		t.Fatal("intermediate update was not coalesced")
+	default:
+	}
+
+	require.NoError(t, mesh.Close())
+	_, ok := <-updates
+	assert.False(t, ok, "updates channel should be closed")
+
+	// Subscribing after Close yields a closed channel
+	_, ok = <-mesh.StatusUpdates()
+	assert.False(t, ok)
+}
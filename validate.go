@@ -0,0 +1,434 @@
+package wgmesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Severity tells validation errors, which prevent a configuration from
+// being applied, from warnings about likely mistakes.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// ValidationIssue is a single problem found in a configuration. Field is the
+// YAML name of the offending setting and Peer the name of the peer it
+// belongs to, if any.
+type ValidationIssue struct {
+	Severity Severity `json:"severity"`
+	Field    string   `json:"field,omitempty"`
+	Peer     string   `json:"peer,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (i ValidationIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.Severity, i.Message)
+}
+
+// issues collects validation issues.
+type issues []ValidationIssue
+
+func (is *issues) add(severity Severity, field, peer string, err error) {
+	*is = append(*is, ValidationIssue{Severity: severity, Field: field, Peer: peer, Message: err.Error()})
+}
+
+func (is *issues) errorf(field, peer, format string, args ...any) {
+	is.add(SeverityError, field, peer, fmt.Errorf(format, args...))
+}
+
+func (is *issues) warnf(field, peer, format string, args ...any) {
+	is.add(SeverityWarning, field, peer, fmt.Errorf(format, args...))
+}
+
+// ValidateDetailed returns every problem of the configuration, the errors
+// Validate reports as well as the warnings of Lint, for API and tooling
+// consumers.
+func (c *Config) ValidateDetailed() []ValidationIssue {
+	return append(c.validationErrors(), c.lintIssues()...)
+}
+
+// Validate checks the configuration for errors that would prevent it from
+// being applied. All problems found are returned together. Suspicious but
+// valid settings reported by Lint are logged as warnings.
+func (c *Config) Validate() error {
+	var errs []error
+	for _, issue := range c.ValidateDetailed() {
+		switch issue.Severity {
+		case SeverityError:
+			errs = append(errs, errors.New(issue.Message))
+		case SeverityWarning:
+			log.Warn().Str("network", c.NetworkName).Msg(issue.Message)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Config) validationErrors() []ValidationIssue {
+	var errs issues
+
+	if c.NetworkName == "" {
+		errs.errorf("network_name", "", "network_name is required")
+	}
+	if c.ListenPort < 0 || c.ListenPort > 65535 {
+		errs.errorf("listen_port", "", "listen_port %d is out of range", c.ListenPort)
+	}
+	if !c.ObserveOnly && c.ReplicaOf == "" {
+		if _, err := parseKey(c.PrivateKey); err != nil {
+			errs.errorf("private_key", "", "invalid private key: %w", err)
+		}
+	}
+	if c.Address != "" {
+		if _, _, err := net.ParseCIDR(c.Address); err != nil {
+			errs.errorf("address", "", "invalid address: %w", err)
+		}
+	}
+
+	if c.AddressPool != "" {
+		if _, _, err := net.ParseCIDR(c.AddressPool); err != nil {
+			errs.errorf("address_pool", "", "invalid address pool: %w", err)
+		}
+	}
+
+	for _, name := range c.ImmutableFields {
+		if _, ok := configField(c, name); !ok {
+			errs.errorf("immutable_fields", "", "unknown immutable field %q", name)
+		}
+	}
+
+	if err := validateDNS(c.DNS); err != nil {
+		errs.add(SeverityError, "dns", "", err)
+	}
+
+	if c.Resolver != "" {
+		host, port, err := net.SplitHostPort(resolverAddress(c.Resolver))
+		if err != nil || net.ParseIP(host) == nil {
+			errs.errorf("resolver", "", "invalid resolver %q: must be an IP address with an optional port", c.Resolver)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			errs.errorf("resolver", "", "invalid resolver %q: invalid port %q", c.Resolver, port)
+		}
+	}
+
+	switch c.LinkManager {
+	case "", LinkManagerIP, LinkManagerNetlink:
+	default:
+		errs.errorf("link_manager", "", "unknown link_manager %q", c.LinkManager)
+	}
+	switch c.StartupMode {
+	case "", StartupModeBestEffort, StartupModeFailFast:
+	default:
+		errs.errorf("startup_mode", "", "unknown startup_mode %q", c.StartupMode)
+	}
+	if c.RouteMetric < 0 {
+		errs.errorf("route_metric", "", "route_metric %d must not be negative", c.RouteMetric)
+	}
+	if c.PeerRemovalGrace < 0 {
+		errs.errorf("peer_removal_grace", "", "peer_removal_grace %s must not be negative", c.PeerRemovalGrace)
+	}
+	if c.MaxPeerRemovalPercent < 0 || c.MaxPeerRemovalPercent > 100 {
+		errs.errorf("max_peer_removal_percent", "", "max_peer_removal_percent %d must be between 0 and 100", c.MaxPeerRemovalPercent)
+	}
+	if c.PeerHookInterval < 0 {
+		errs.errorf("peer_hook_interval", "", "peer_hook_interval %s must not be negative", c.PeerHookInterval)
+	}
+	if c.JitterPercent > maxJitterPercent {
+		errs.errorf("jitter_percent", "", "jitter_percent %d must be at most %d", c.JitterPercent, maxJitterPercent)
+	}
+	switch c.TrustedKeysMode {
+	case "", TrustedKeysEnforce:
+		for _, peer := range c.untrustedPeers() {
+			errs.errorf("public_key", peer.Name, "public key %s of peer %s is not trusted", peer.PublicKey, peer.Name)
+		}
+	case TrustedKeysLearn:
+	default:
+		errs.errorf("trusted_keys_mode", "", "unknown trusted_keys_mode %q", c.TrustedKeysMode)
+	}
+	for _, key := range c.TrustedKeys {
+		if _, err := parseKey(key); err != nil {
+			errs.errorf("trusted_keys", "", "invalid trusted key %s: %w", key, err)
+		}
+	}
+	if c.WatchRetries < 0 {
+		errs.errorf("watch_retries", "", "watch_retries %d must not be negative", c.WatchRetries)
+	}
+	if c.MonitorWorkers < 0 {
+		errs.errorf("monitor_workers", "", "monitor_workers %d must not be negative", c.MonitorWorkers)
+	}
+	if (c.HTTPTLSCert == "") != (c.HTTPTLSKey == "") {
+		errs.errorf("http_tls_cert", "", "http_tls_cert and http_tls_key must be set together")
+	}
+	if c.HTTPTLSClientCA != "" && c.HTTPTLSCert == "" {
+		errs.errorf("http_tls_client_ca", "", "http_tls_client_ca requires http_tls_cert and http_tls_key")
+	}
+	if c.APIToken != "" && c.APITokenFile != "" {
+		errs.errorf("api_token", "", "api_token and api_token_file are mutually exclusive")
+	}
+	if c.MTU < 0 || (c.MTU > 0 && c.MTU < 576) || c.MTU > 65535 {
+		errs.errorf("mtu", "", "mtu %d is out of range", c.MTU)
+	}
+
+	if c.BackupFileMode&^0o777 != 0 {
+		errs.errorf("backup_file_mode", "", "invalid backup file mode %s", c.BackupFileMode)
+	}
+
+	if d := c.PeerDefaults; d.Name != "" || d.IP != "" || d.PublicKey != "" || d.PrivateKey != "" || d.PresharedKey != "" || d.Endpoint != "" {
+		errs.errorf("peer_defaults", "", "peer_defaults can't set name, ip, keys or endpoint")
+	}
+
+	for _, prefix := range c.ForbiddenAllowedIPs {
+		if _, err := parseAllowedIP(prefix); err != nil {
+			errs.errorf("forbidden_allowed_ips", "", "invalid forbidden allowed IP: %w", err)
+		}
+	}
+
+	names := make(map[string]bool, len(c.Peers))
+	keys := make(map[string]string, len(c.Peers))
+	for i, peer := range c.Peers {
+		if peer.Name == "" {
+			errs.errorf("name", "", "peer #%d has no name", i+1)
+		} else if names[peer.Name] {
+			errs.errorf("name", peer.Name, "duplicate peer name %s", peer.Name)
+		}
+		names[peer.Name] = true
+
+		// WireGuard identifies peers by key alone
+		if other, ok := keys[peer.PublicKey]; ok && peer.PublicKey != "" {
+			errs.errorf("public_key", peer.Name, "peers %s and %s have the same public key", other, peer.Name)
+		} else {
+			keys[peer.PublicKey] = peer.Name
+		}
+
+		errs = append(errs, peer.validate()...)
+		for _, ip := range peer.AllowedIPs {
+			n, err := parseAllowedIP(ip)
+			if err != nil {
+				// Reported by peer.validate
+				continue
+			}
+			if prefix := c.forbiddenPrefix(*n); prefix != "" {
+				errs.errorf("allowed_ips", peer.Name, "allowed IP %s of peer %s overlaps forbidden prefix %s", ip, peer.Name, prefix)
+			}
+		}
+	}
+
+	return errs
+}
+
+// forbiddenPrefix returns the first of ForbiddenAllowedIPs that overlaps n,
+// or "" if none does.
+func (c *Config) forbiddenPrefix(n net.IPNet) string {
+	for _, prefix := range c.ForbiddenAllowedIPs {
+		if f, err := parseAllowedIP(prefix); err == nil && overlaps(*f, n) {
+			return f.String()
+		}
+	}
+	return ""
+}
+
+// Lint returns warnings about settings that are valid but most likely
+// mistakes, such as a peer endpoint pointing at the local host. Endpoint
+// hostnames aren't resolved, see LintResolved.
+func (c *Config) Lint() []string {
+	var warnings []string
+	for _, issue := range c.lintIssues() {
+		warnings = append(warnings, issue.Message)
+	}
+	return warnings
+}
+
+// lintResolveTimeout bounds a single endpoint lookup of LintResolved.
+const lintResolveTimeout = 5 * time.Second
+
+// LintResolved returns the warnings of Lint and also resolves the endpoint
+// hostnames with r, warning about those resolving to an address that can't
+// reach a remote peer. A nil r resolves like the mesh would, through
+// Config.Resolver if set. Hostnames that fail to resolve and srv://
+// endpoints are skipped.
+func (c *Config) LintResolved(ctx context.Context, r Resolver) []string {
+	warnings := c.Lint()
+	if c.AllowLoopbackEndpoints {
+		return warnings
+	}
+	if r == nil {
+		r = net.DefaultResolver
+		if c.Resolver != "" {
+			r = dnsServerResolver(resolverAddress(c.Resolver))
+		}
+	}
+
+	for _, peer := range c.Peers {
+		if peer.IsRoaming() || strings.HasPrefix(peer.Endpoint, srvScheme) {
+			continue
+		}
+		host, _, err := peer.endpointHostPort()
+		if err != nil || unroutableKind(host) != "" {
+			continue
+		}
+		if ip, _ := parseEndpointIP(host); ip != nil {
+			continue
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, lintResolveTimeout)
+		addrs, err := r.LookupIPAddr(lookupCtx, host)
+		cancel()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if kind := unroutableKind(addr.String()); kind != "" {
+				warnings = append(warnings, fmt.Sprintf("endpoint %s of peer %s resolves to %s, %s, and can't reach a remote peer", peer.Endpoint, peer.Name, addr.String(), kind))
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+func (c *Config) lintIssues() []ValidationIssue {
+	var warnings issues
+
+	for _, peer := range c.Peers {
+		if peer.IsRoaming() || c.AllowLoopbackEndpoints {
+			continue
+		}
+		host, _, err := peer.endpointHostPort()
+		if err != nil {
+			continue
+		}
+		if kind := unroutableKind(host); kind != "" {
+			warnings.warnf("endpoint", peer.Name, "endpoint %s of peer %s is %s and can't reach a remote peer", peer.Endpoint, peer.Name, kind)
+		}
+	}
+
+	if c.TrustedKeysMode == TrustedKeysLearn {
+		for _, peer := range c.untrustedPeers() {
+			warnings.warnf("public_key", peer.Name, "public key %s of peer %s is not trusted", peer.PublicKey, peer.Name)
+		}
+	}
+
+	return append(warnings, c.lintAllowedIPs()...)
+}
+
+// lintAllowedIPs warns about allowed IPs of different peers that overlap.
+// WireGuard routes to the most specific prefix, so a gateway peer may route a
+// whole remote subnet while other peers own single hosts in it. Two subnets
+// overlapping are most likely a mistake though, and an identical prefix is
+// silently moved to the last peer configured with it.
+func (c *Config) lintAllowedIPs() []ValidationIssue {
+	type prefix struct {
+		peer string
+		net  net.IPNet
+	}
+
+	var (
+		prefixes []prefix
+		warnings issues
+	)
+	for _, peer := range c.Peers {
+		nets, err := peer.ParsedAllowedIPs()
+		if err != nil {
+			// Reported by Validate
+			continue
+		}
+		for _, n := range nets {
+			for _, other := range prefixes {
+				if other.peer == peer.Name || !overlaps(other.net, n) {
+					continue
+				}
+				switch {
+				case other.net.String() == n.String():
+					warnings.warnf("allowed_ips", peer.Name, "allowed IP %s is assigned to both peer %s and peer %s", n.String(), other.peer, peer.Name)
+				case !isHostPrefix(other.net) && !isHostPrefix(n):
+					warnings.warnf("allowed_ips", peer.Name, "allowed IPs %s of peer %s and %s of peer %s overlap", other.net.String(), other.peer, n.String(), peer.Name)
+				}
+			}
+			prefixes = append(prefixes, prefix{peer: peer.Name, net: n})
+		}
+	}
+
+	return warnings
+}
+
+// overlaps reports whether the two prefixes share any address. Prefixes
+// either nest or are disjoint, so checking the network addresses suffices.
+func overlaps(a, b net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// isHostPrefix reports whether n covers a single address.
+func isHostPrefix(n net.IPNet) bool {
+	ones, bits := n.Mask.Size()
+	return ones == bits
+}
+
+// unroutableKind describes the kind of address when host can't be the
+// address of a remote peer, or returns "" if it can. Hostnames other than
+// localhost aren't resolved. Link-local addresses with a zone, as in
+// fe80::1%eth0, name the link the peer is on and are fine.
+func unroutableKind(host string) string {
+	if host == "localhost" {
+		return "a loopback address"
+	}
+
+	ip, zone := parseEndpointIP(host)
+	switch {
+	case ip == nil:
+		return ""
+	case zone != "" && ip.IsLinkLocalUnicast():
+		return ""
+	case ip.IsLoopback():
+		return "a loopback address"
+	case ip.IsUnspecified():
+		return "an unspecified address"
+	case ip.IsLinkLocalUnicast():
+		return "a link-local address"
+	}
+	return ""
+}
+
+func (p *Peer) validate() []ValidationIssue {
+	var errs issues
+
+	if _, err := parseKey(p.PublicKey); err != nil {
+		errs.errorf("public_key", p.Name, "invalid public key for peer %s: %w", p.Name, err)
+	}
+	if p.PresharedKey != "" {
+		if _, err := parseKey(p.PresharedKey); err != nil {
+			errs.errorf("preshared_key", p.Name, "invalid preshared key for peer %s: %w", p.Name, err)
+		}
+	}
+	for _, ip := range p.AllowedIPs {
+		if _, err := parseAllowedIP(ip); err != nil {
+			errs.errorf("allowed_ips", p.Name, "invalid allowed IP for peer %s: %w", p.Name, err)
+		}
+	}
+	if !p.IsRoaming() {
+		if _, _, err := p.endpointHostPort(); err != nil {
+			errs.errorf("endpoint", p.Name, "invalid endpoint for peer %s: %w", p.Name, err)
+		}
+	}
+	if p.Port < 0 || p.Port > 65535 {
+		errs.errorf("port", p.Name, "port %d for peer %s is out of range", p.Port, p.Name)
+	}
+	if p.HandshakeTimeout < 0 {
+		errs.errorf("handshake_timeout", p.Name, "handshake_timeout %s for peer %s must be positive", p.HandshakeTimeout, p.Name)
+	}
+	if p.RouteMetric < 0 {
+		errs.errorf("route_metric", p.Name, "route_metric %d for peer %s must not be negative", p.RouteMetric, p.Name)
+	}
+	if p.PersistentKeepalive < 0 || p.PersistentKeepalive > 65535 {
+		errs.errorf("persistent_keepalive", p.Name, "persistent_keepalive %d for peer %s is out of range", p.PersistentKeepalive, p.Name)
+	}
+
+	return errs
+}
@@ -0,0 +1,244 @@
+package wgmesh_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pilab-cloud/wgmesh"
+	"github.com/stretchr/testify/assert"
+)
+
+func validConfig() *wgmesh.Config {
+	return &wgmesh.Config{
+		NetworkName: "wg0",
+		ListenPort:  51820,
+		PrivateKey:  "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+		Peers: []wgmesh.Peer{
+			{
+				Name:       "peer1",
+				PublicKey:  "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=",
+				AllowedIPs: []string{"10.0.0.2/32"},
+			},
+			{
+				Name:       "peer2",
+				PublicKey:  "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=",
+				AllowedIPs: []string{"10.0.0.3/32"},
+			},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*wgmesh.Config)
+		wantErr string
+	}{
+		{"valid", func(*wgmesh.Config) {}, ""},
+		{"missing network name", func(c *wgmesh.Config) { c.NetworkName = "" }, "network_name is required"},
+		{"bad listen port", func(c *wgmesh.Config) { c.ListenPort = 70000 }, "listen_port"},
+		{"bad private key", func(c *wgmesh.Config) { c.PrivateKey = "abc" }, "invalid private key"},
+		{"observe only without key", func(c *wgmesh.Config) { c.ObserveOnly = true; c.PrivateKey = "" }, ""},
+		{"duplicate peer name", func(c *wgmesh.Config) { c.Peers[1].Name = "peer1" }, "duplicate peer name peer1"},
+		{"duplicate public key", func(c *wgmesh.Config) { c.Peers[1].PublicKey = c.Peers[0].PublicKey }, "peers peer1 and peer2 have the same public key"},
+		{"bad public key", func(c *wgmesh.Config) { c.Peers[0].PublicKey = "abc" }, "invalid public key for peer peer1"},
+		{"bad allowed IP", func(c *wgmesh.Config) { c.Peers[1].AllowedIPs = []string{"10.0.0.300/32"} }, "invalid allowed IP for peer peer2"},
+		{"bad address", func(c *wgmesh.Config) { c.Address = "10.0.0.1" }, "invalid address"},
+		{"tls cert without key", func(c *wgmesh.Config) { c.HTTPTLSCert = "server.crt" }, "http_tls_cert and http_tls_key must be set together"},
+		{"client ca without cert", func(c *wgmesh.Config) { c.HTTPTLSClientCA = "ca.crt" }, "http_tls_client_ca requires http_tls_cert"},
+		{"api token and file", func(c *wgmesh.Config) { c.APIToken = "a"; c.APITokenFile = "token" }, "api_token and api_token_file are mutually exclusive"},
+		{"negative handshake timeout", func(c *wgmesh.Config) { c.Peers[1].HandshakeTimeout = -time.Minute }, "handshake_timeout -1m0s for peer peer2 must be positive"},
+		{"too much jitter", func(c *wgmesh.Config) { c.JitterPercent = 60 }, "jitter_percent 60 must be at most 50"},
+		{"bad trusted key", func(c *wgmesh.Config) { c.TrustedKeys = []string{"abc"} }, "invalid trusted key abc"},
+		{"unknown trusted keys mode", func(c *wgmesh.Config) { c.TrustedKeysMode = "audit" }, `unknown trusted_keys_mode "audit"`},
+		{"negative watch retries", func(c *wgmesh.Config) { c.WatchRetries = -1 }, "watch_retries -1 must not be negative"},
+		{"forbidden allowed IP", func(c *wgmesh.Config) {
+			c.ForbiddenAllowedIPs = []string{"192.168.100.0/24"}
+			c.Peers[1].AllowedIPs = []string{"10.0.0.3/32", "192.168.0.0/16"}
+		}, "allowed IP 192.168.0.0/16 of peer peer2 overlaps forbidden prefix 192.168.100.0/24"},
+		{"forbidden host", func(c *wgmesh.Config) {
+			c.ForbiddenAllowedIPs = []string{"10.0.0.2"}
+		}, "allowed IP 10.0.0.2/32 of peer peer1 overlaps forbidden prefix 10.0.0.2/32"},
+		{"no forbidden overlap", func(c *wgmesh.Config) { c.ForbiddenAllowedIPs = []string{"192.168.100.0/24", "10.0.1.0/24"} }, ""},
+		{"bad forbidden allowed IP", func(c *wgmesh.Config) { c.ForbiddenAllowedIPs = []string{"10.0.0.300/24"} }, "invalid forbidden allowed IP"},
+		{"negative peer hook interval", func(c *wgmesh.Config) { c.PeerHookInterval = -time.Second }, "peer_hook_interval -1s must not be negative"},
+		{"negative peer removal grace", func(c *wgmesh.Config) { c.PeerRemovalGrace = -time.Second }, "peer_removal_grace -1s must not be negative"},
+		{"peer removal percent over 100", func(c *wgmesh.Config) { c.MaxPeerRemovalPercent = 150 }, "max_peer_removal_percent 150 must be between 0 and 100"},
+		{"negative route metric", func(c *wgmesh.Config) { c.RouteMetric = -1 }, "route_metric -1 must not be negative"},
+		{"negative peer route metric", func(c *wgmesh.Config) { c.Peers[0].RouteMetric = -1 }, "route_metric -1 for peer peer1 must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLintEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		port     int
+		want     string
+	}{
+		{"loopback", "127.0.0.1:51820", 0, "endpoint 127.0.0.1:51820 of peer peer1 is a loopback address"},
+		{"loopback v6", "[::1]:51820", 0, "is a loopback address"},
+		{"localhost", "localhost", 51820, "is a loopback address"},
+		{"unspecified", "0.0.0.0:51820", 0, "is an unspecified address"},
+		{"link-local", "169.254.10.1:51820", 0, "is a link-local address"},
+		{"link-local v6", "fe80::1", 51820, "is a link-local address"},
+		{"scoped link-local v6", "[fe80::1%eth0]:51820", 0, ""},
+		{"public", "198.51.100.7:51820", 0, ""},
+		{"hostname", "vpn.example.com:51820", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.Peers[0].Endpoint = tt.endpoint
+			cfg.Peers[0].Port = tt.port
+
+			// Only a warning, the config stays valid
+			assert.NoError(t, cfg.Validate())
+
+			warnings := cfg.Lint()
+			if tt.want == "" {
+				assert.Empty(t, warnings)
+				return
+			}
+			if assert.Len(t, warnings, 1) {
+				assert.Contains(t, warnings[0], tt.want)
+			}
+
+			cfg.AllowLoopbackEndpoints = true
+			assert.Empty(t, cfg.Lint())
+		})
+	}
+}
+
+func TestLintResolvedEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver wgmesh.Resolver
+		want     []string
+	}{
+		{"loopback", &countingResolver{ip: net.ParseIP("127.0.1.1")}, []string{
+			"endpoint myhost:51820 of peer peer1 resolves to 127.0.1.1, a loopback address, and can't reach a remote peer",
+		}},
+		{"link-local", &countingResolver{ip: net.ParseIP("169.254.0.9")}, []string{
+			"endpoint myhost:51820 of peer peer1 resolves to 169.254.0.9, a link-local address, and can't reach a remote peer",
+		}},
+		{"public", &countingResolver{ip: net.ParseIP("198.51.100.7")}, nil},
+		{"unresolvable", failingResolver{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.Peers[0].Endpoint = "myhost:51820"
+
+			// Lint alone doesn't resolve
+			assert.Empty(t, cfg.Lint())
+			assert.Equal(t, tt.want, cfg.LintResolved(context.Background(), tt.resolver))
+
+			cfg.AllowLoopbackEndpoints = true
+			assert.Empty(t, cfg.LintResolved(context.Background(), tt.resolver))
+		})
+	}
+}
+
+func TestLintAllowedIPsOverlap(t *testing.T) {
+	peer := func(name, key string, allowedIPs ...string) wgmesh.Peer {
+		return wgmesh.Peer{Name: name, PublicKey: key, AllowedIPs: allowedIPs}
+	}
+
+	tests := []struct {
+		name  string
+		peers []wgmesh.Peer
+		want  []string
+	}{
+		{
+			name: "gateway and hosts",
+			peers: []wgmesh.Peer{
+				peer("gateway", "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=", "10.1.0.0/16", "10.0.0.1/32"),
+				peer("client1", "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=", "10.1.5.10/32", "10.0.0.2/32"),
+				peer("client2", "WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=", "10.1.5.11"),
+			},
+		},
+		{
+			name: "overlapping subnets",
+			peers: []wgmesh.Peer{
+				peer("gateway", "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=", "10.1.0.0/16"),
+				peer("site", "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=", "10.1.5.0/24"),
+			},
+			want: []string{"allowed IPs 10.1.0.0/16 of peer gateway and 10.1.5.0/24 of peer site overlap"},
+		},
+		{
+			name: "same host",
+			peers: []wgmesh.Peer{
+				peer("peer1", "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=", "10.0.0.2/32"),
+				peer("peer2", "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=", "10.0.0.2"),
+			},
+			want: []string{"allowed IP 10.0.0.2/32 is assigned to both peer peer1 and peer peer2"},
+		},
+		{
+			name: "disjoint subnets",
+			peers: []wgmesh.Peer{
+				peer("site1", "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=", "10.1.0.0/16", "fd00:1::/48"),
+				peer("site2", "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=", "10.2.0.0/16", "fd00:2::/48"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.Peers = tt.peers
+
+			assert.NoError(t, cfg.Validate())
+			assert.Equal(t, tt.want, cfg.Lint())
+		})
+	}
+}
+
+func TestValidateDetailed(t *testing.T) {
+	cfg := validConfig()
+	cfg.Peers[0].PublicKey = "abc"
+	cfg.Peers[1].Endpoint = "127.0.0.1:51820"
+
+	issues := cfg.ValidateDetailed()
+	assert.Equal(t, []wgmesh.ValidationIssue{
+		{
+			Severity: wgmesh.SeverityError,
+			Field:    "public_key",
+			Peer:     "peer1",
+			Message:  "invalid public key for peer peer1: expected a base64 key of 44 characters, a hex key of 64 characters or @/path of a key file: wgtypes: failed to parse base64-encoded key: illegal base64 data at input byte 0",
+		},
+		{
+			Severity: wgmesh.SeverityWarning,
+			Field:    "endpoint",
+			Peer:     "peer2",
+			Message:  "endpoint 127.0.0.1:51820 of peer peer2 is a loopback address and can't reach a remote peer",
+		},
+	}, issues)
+
+	// Only the error fails validation
+	assert.EqualError(t, cfg.Validate(), issues[0].Message)
+
+	cfg.Peers[0].PublicKey = "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="
+	assert.NoError(t, cfg.Validate())
+	assert.Len(t, cfg.ValidateDetailed(), 1)
+}
@@ -2,17 +2,20 @@ package wgmesh
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog/log"
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -30,6 +33,235 @@ type Config struct {
 	Peers       []Peer `yaml:"peers"`
 	ListenPort  int    `yaml:"listen_port"`
 	PrivateKey  string `yaml:"private_key"`
+
+	// Address is the local address of the interface in CIDR form. It is
+	// assigned when the tunnel starts and removed when it stops.
+	Address string `yaml:"address,omitempty"`
+
+	// Hooks are shell commands run around bringing the tunnel up and down,
+	// similar to wg-quick. %i is replaced with the interface name.
+	PreUp    []string `yaml:"pre_up,omitempty"`
+	PostUp   []string `yaml:"post_up,omitempty"`
+	PreDown  []string `yaml:"pre_down,omitempty"`
+	PostDown []string `yaml:"post_down,omitempty"`
+
+	// ObserveOnly makes wgmesh monitor an interface managed by something
+	// else (e.g. wg-quick) without ever configuring the device.
+	ObserveOnly bool `yaml:"observe_only,omitempty"`
+
+	// StartupMode decides what happens when some peers can't be configured
+	// on start, e.g. as their endpoint doesn't resolve: "best-effort"
+	// (default) configures the others, "fail-fast" refuses to start without
+	// touching the device.
+	StartupMode string `yaml:"startup_mode,omitempty"`
+
+	// AdoptDevice takes over an interface a previous process left
+	// configured with the same private key, e.g. across a binary upgrade:
+	// only the peers differing from the configuration are changed, so the
+	// others don't flap, and the up hooks aren't run again.
+	AdoptDevice bool `yaml:"adopt_device,omitempty"`
+
+	// ReplicaOf is the control socket of a leader whose status this
+	// instance mirrors and serves, e.g. on an HA standby. Like ObserveOnly,
+	// a replica never configures the device.
+	ReplicaOf string `yaml:"replica_of,omitempty"`
+
+	// MonitorInterval is how often the device is polled for peer status.
+	// Defaults to 10 seconds.
+	MonitorInterval time.Duration `yaml:"monitor_interval,omitempty"`
+
+	// MonitorWorkers is how many goroutines update the peer statuses after
+	// each device read. Only worth raising for meshes with thousands of
+	// peers; defaults to 1.
+	MonitorWorkers int `yaml:"monitor_workers,omitempty"`
+
+	// StartupGrace is how long after the tunnel starts peers without any
+	// handshake stay configuring instead of down. Defaults to twice the
+	// handshake timeout.
+	StartupGrace time.Duration `yaml:"startup_grace,omitempty"`
+
+	// RoleTemplates holds shared peer settings by role name. They fill in
+	// the fields a peer with that role leaves empty.
+	RoleTemplates map[string]PeerTemplate `yaml:"role_templates,omitempty"`
+
+	// PeersFile is a file listing further peers, one per line as
+	// "name public_key allowed_ips [endpoint]" with comma-separated allowed
+	// IPs, e.g. generated by an inventory. Relative paths are taken from the
+	// directory of the configuration file. Changes to it trigger a reload.
+	PeersFile string `yaml:"peers_file,omitempty"`
+
+	// TrustedKeys restricts peers to these public keys, for zero-trust
+	// setups where keys are approved out of band. TrustedKeysFile lists
+	// further keys, one per line, and is read on every load. In the default
+	// enforce TrustedKeysMode a configuration with other peers is rejected,
+	// in learn mode they are only logged.
+	TrustedKeys     []string `yaml:"trusted_keys,omitempty"`
+	TrustedKeysFile string   `yaml:"trusted_keys_file,omitempty"`
+	TrustedKeysMode string   `yaml:"trusted_keys_mode,omitempty"`
+
+	// PeerDefaults holds settings for every peer that leaves them empty:
+	// role, allowed_ips, port, persistent_keepalive, handshake_timeout, nat
+	// and required. The peer's own values win over its role template, which
+	// wins over these.
+	PeerDefaults Peer `yaml:"peer_defaults,omitempty"`
+
+	// ImmutableFields lists configuration fields by their YAML name (e.g.
+	// private_key, listen_port) that a reload must not change. A reload
+	// changing one is rejected and the active configuration kept.
+	ImmutableFields []string `yaml:"immutable_fields,omitempty"`
+
+	// PeerHookInterval is the least time between two runs of the on_down or
+	// on_up hooks of a peer, one minute when zero.
+	PeerHookInterval time.Duration `yaml:"peer_hook_interval,omitempty"`
+
+	// WatchRetries is how many times in a row a failed configuration watch
+	// is re-established before auto-reload gives up, 10 when zero.
+	WatchRetries int `yaml:"watch_retries,omitempty"`
+
+	// StrictYAML rejects keys this version doesn't know, such as a typo'd
+	// "allowd_ips" that would otherwise leave a peer without allowed IPs.
+	StrictYAML bool `yaml:"strict_yaml,omitempty"`
+
+	// ClientTimeout bounds every read and write of the device, so a hung
+	// kernel module can't block the daemon forever. Defaults to 30 seconds.
+	ClientTimeout time.Duration `yaml:"client_timeout,omitempty"`
+
+	// ResolveCacheTTL is how long a resolved endpoint hostname is reused.
+	// Defaults to 30 seconds.
+	ResolveCacheTTL time.Duration `yaml:"resolve_cache_ttl,omitempty"`
+
+	// Resolver is the DNS server (ip or ip:port, port 53 by default)
+	// endpoint hostnames are resolved with, e.g. for split DNS. The system
+	// resolver is used when unset.
+	Resolver string `yaml:"resolver,omitempty"`
+
+	// JitterPercent randomly spreads the monitor and resolve intervals by up
+	// to this percentage in either direction, 10 when zero. Negative values
+	// disable the jitter.
+	JitterPercent int `yaml:"jitter_percent,omitempty"`
+
+	// ResolveInterval enables re-resolving endpoint hostnames of peers
+	// without a recent handshake at this interval. Off when zero.
+	ResolveInterval time.Duration `yaml:"resolve_interval,omitempty"`
+
+	// ReassertMissingPeers adds configured peers that the monitor finds
+	// missing from the device back to it. Either way they are reported as
+	// errored.
+	ReassertMissingPeers bool `yaml:"reassert_missing_peers,omitempty"`
+
+	// PeerRemovalGrace delays removing peers dropped from the configuration
+	// from the device: they are quarantined at once and only removed after
+	// this long, unless a reload brings them back meanwhile, e.g. as the
+	// file was saved mid-edit. Off when zero.
+	PeerRemovalGrace time.Duration `yaml:"peer_removal_grace,omitempty"`
+
+	// MaxPeerRemovalPercent rejects a reload removing more than this
+	// percentage of the active peers, e.g. as an edit emptied the peers
+	// list by accident, unless overridden with WithAllowPeerRemoval or
+	// WGMESH_ALLOW_PEER_REMOVAL=1. The limit of the active configuration
+	// applies. Off when zero.
+	MaxPeerRemovalPercent int `yaml:"max_peer_removal_percent,omitempty"`
+
+	// ReconcileInterval enables checking the device for drift at this
+	// interval and re-applying the configuration when it was changed out of
+	// band. Off when zero.
+	ReconcileInterval time.Duration `yaml:"reconcile_interval,omitempty"`
+
+	// PathMTUProbeInterval enables measuring the path MTU to every peer that
+	// is up at this interval, warning when it is below the interface MTU.
+	// Peers need an IP to be probed. Off when zero.
+	PathMTUProbeInterval time.Duration `yaml:"path_mtu_probe_interval,omitempty"`
+
+	// AllowLoopbackEndpoints silences the warning for peer endpoints on
+	// loopback, link-local or unspecified addresses, for test setups.
+	AllowLoopbackEndpoints bool `yaml:"allow_loopback_endpoints,omitempty"`
+
+	// MetricsTextfile is where Prometheus metrics are written for the
+	// node_exporter textfile collector, every MetricsTextfileInterval
+	// (default 15s). Disabled when empty.
+	MetricsTextfile         string        `yaml:"metrics_textfile,omitempty"`
+	MetricsTextfileInterval time.Duration `yaml:"metrics_textfile_interval,omitempty"`
+
+	// LinkManager selects how the interface is managed: "ip" (default) runs
+	// ip(8), "netlink" talks to the kernel directly.
+	LinkManager string `yaml:"link_manager,omitempty"`
+
+	// CreateInterface creates the WireGuard interface and sets it up on
+	// start, and deletes it on stop. Otherwise it must already exist.
+	CreateInterface bool `yaml:"create_interface,omitempty"`
+
+	// MTU of the interface, left unchanged when zero.
+	MTU int `yaml:"mtu,omitempty"`
+
+	// ManageRoutes adds a route through the interface for the allowed IPs of
+	// every peer. When false, routing is left to the operator.
+	ManageRoutes bool `yaml:"manage_routes,omitempty"`
+
+	// ForbiddenAllowedIPs are prefixes, such as the management network,
+	// that must never be routed over the tunnel. A peer with an allowed IP
+	// overlapping one is rejected.
+	ForbiddenAllowedIPs []string `yaml:"forbidden_allowed_ips,omitempty"`
+
+	// RouteMetric is the metric of the managed routes, to prefer or
+	// deprioritize them against other interfaces routing the same prefixes.
+	// Peers can override it. The kernel default applies when zero.
+	RouteMetric int `yaml:"route_metric,omitempty"`
+
+	// DNS servers registered for the interface with resolvconf on start and
+	// removed on stop.
+	DNS []string `yaml:"dns,omitempty"`
+
+	// ControlSocket is the path of a Unix socket on which the daemon answers
+	// status, reload, list and drift requests. Disabled when empty.
+	ControlSocket string `yaml:"control_socket,omitempty"`
+
+	// HTTPListen is the address of an HTTP server serving /metrics and
+	// /status, e.g. "127.0.0.1:9586". Disabled when empty.
+	HTTPListen string `yaml:"http_listen,omitempty"`
+
+	// HTTPTLSCert and HTTPTLSKey are the PEM certificate and key files of
+	// the HTTP server, which then only serves HTTPS.
+	HTTPTLSCert string `yaml:"http_tls_cert,omitempty"`
+	HTTPTLSKey  string `yaml:"http_tls_key,omitempty"`
+
+	// HTTPTLSClientCA is a PEM file of the CAs that sign client
+	// certificates. When set, the HTTP server rejects clients without one.
+	HTTPTLSClientCA string `yaml:"http_tls_client_ca,omitempty"`
+
+	// APIToken, when set, is required as "Authorization: Bearer <token>" on
+	// every HTTP endpoint but /healthz. APITokenFile reads it from a file
+	// instead, to keep it out of the configuration.
+	APIToken     string `yaml:"api_token,omitempty"`
+	APITokenFile string `yaml:"api_token_file,omitempty"`
+
+	// Pprof adds the net/http/pprof profiles to the HTTP server, which then
+	// listens on 127.0.0.1:9586 unless HTTPListen is set. Profiles expose
+	// internals of the daemon, keep the server private.
+	Pprof bool `yaml:"pprof,omitempty"`
+
+	// StateDir holds everything wgmesh writes at runtime: the status, pool
+	// allocations, audit log and configuration backups (see statedir.go for
+	// the layout). Without it they are kept next to the configuration file
+	// and status and audit log aren't written.
+	StateDir string `yaml:"state_dir,omitempty"`
+
+	// AddressPool is a CIDR from which peers without an IP get a host
+	// address. The assignments are kept in AllocationsFile, by default in
+	// the state directory or next to the configuration file, so they stay
+	// stable.
+	AddressPool     string `yaml:"address_pool,omitempty"`
+	AllocationsFile string `yaml:"allocations_file,omitempty"`
+
+	// BackupFileMode is the permission of written configs and backups,
+	// 0600 when unset. BackupOwner and BackupGroup optionally chown them and
+	// accept names or numeric IDs.
+	BackupFileMode FileMode `yaml:"backup_file_mode,omitempty"`
+	BackupOwner    string   `yaml:"backup_owner,omitempty"`
+	BackupGroup    string   `yaml:"backup_group,omitempty"`
+
+	fileTrustedKeys []string          // read from TrustedKeysFile
+	allocations     map[string]string // pool addresses to save once applied
+	allocationsPath string
 }
 
 type Peer struct {
@@ -41,6 +273,47 @@ type Peer struct {
 	Endpoint   string   `yaml:"endpoint,omitempty"`
 	Port       int      `yaml:"port,omitempty"`
 	NAT        bool     `yaml:"nat,omitempty"`
+
+	// PersistentKeepalive is the keepalive interval in seconds, 0 disables it
+	// unless NAT is set, which defaults it to defaultNATKeepalive.
+	PersistentKeepalive int `yaml:"persistent_keepalive,omitempty"`
+
+	// PresharedKey is an optional symmetric key mixed into the handshake
+	// with this peer, see RotatePeerPSK.
+	PresharedKey string `yaml:"preshared_key,omitempty"`
+
+	// Role selects a template from Config.RoleTemplates.
+	Role string `yaml:"role,omitempty"`
+
+	// Required peers are essential for the mesh (e.g. the hub in a
+	// hub-and-spoke setup): the mesh is down whenever one of them is.
+	Required bool `yaml:"required,omitempty"`
+
+	// Tags are free-form labels for selecting peers, e.g. with FindPeers.
+	Tags []string `yaml:"tags,omitempty"`
+
+	// RouteMetric overrides Config.RouteMetric for the routes of this peer.
+	RouteMetric int `yaml:"route_metric,omitempty"`
+
+	fromFile bool // read from Config.PeersFile
+
+	// OnDown and OnUp are shell commands run when the peer goes down or
+	// recovers, with WGMESH_PEER and WGMESH_STATE set, e.g. to page someone.
+	OnDown []string `yaml:"on_down,omitempty"`
+	OnUp   []string `yaml:"on_up,omitempty"`
+
+	// HandshakeTimeout is how old the last handshake may get before the peer
+	// is considered down, for peers idle longer or expected to be chattier
+	// than usual. Three minutes when zero.
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout,omitempty"`
+}
+
+// staleAfter returns the handshake age after which the peer is down.
+func (p *Peer) staleAfter() time.Duration {
+	if p.HandshakeTimeout > 0 {
+		return p.HandshakeTimeout
+	}
+	return handshakeTimeout
 }
 
 type PeerState string
@@ -49,15 +322,96 @@ const (
 	PeerStateUp    PeerState = "up"
 	PeerStateDown  PeerState = "down"
 	PeerStateError PeerState = "error"
+
+	// PeerStateConfiguring is set when a peer was just configured and hasn't
+	// completed a handshake yet.
+	PeerStateConfiguring PeerState = "configuring"
 )
 
 type PeerStatus struct {
-	Name      string    `yaml:"name"`
-	State     PeerState `yaml:"status"` // "up", "down", "error"
-	LastSeen  time.Time `yaml:"last_seen,omitempty"`
-	Error     string    `yaml:"error,omitempty"`
-	BytesSent uint64    `yaml:"bytes_sent"`
-	BytesRecv uint64    `yaml:"bytes_recv"`
+	Name      string    `yaml:"name" json:"name"`
+	State     PeerState `yaml:"status" json:"status"` // "up", "down", "error"
+	LastSeen  time.Time `yaml:"last_seen,omitempty" json:"last_seen,omitempty"`
+	Error     string    `yaml:"error,omitempty" json:"error,omitempty"`
+	BytesSent uint64    `yaml:"bytes_sent" json:"bytes_sent"`
+	BytesRecv uint64    `yaml:"bytes_recv" json:"bytes_recv"`
+
+	// RxBitsPerSec and TxBitsPerSec are the throughput between the last two
+	// device reads. A counter reset, e.g. after the peer was re-added,
+	// reads as zero.
+	RxBitsPerSec float64   `yaml:"rx_bits_per_sec" json:"rx_bits_per_sec"`
+	TxBitsPerSec float64   `yaml:"tx_bits_per_sec" json:"tx_bits_per_sec"`
+	sampledAt    time.Time // when the byte counters were read
+	skewWarnedAt time.Time // when clock skew was last logged
+
+	// LastErrorTime is when Error was last set and ErrorCount how many
+	// errors occurred since the peer was last up.
+	LastErrorTime time.Time `yaml:"last_error_time,omitempty" json:"last_error_time,omitempty"`
+	ErrorCount    int       `yaml:"error_count,omitempty" json:"error_count,omitempty"`
+
+	// UpSince is when the peer was last seen coming up, zero while it isn't.
+	UpSince time.Time `yaml:"up_since,omitempty" json:"up_since,omitempty"`
+
+	// Quarantined is set while the peer is cut off by QuarantinePeer.
+	Quarantined bool `yaml:"quarantined,omitempty" json:"quarantined,omitempty"`
+
+	// PathMTU is the largest packet that last reached the peer unfragmented,
+	// when path MTU probing is enabled. Zero if unknown or nothing got
+	// through.
+	PathMTU int `yaml:"path_mtu,omitempty" json:"path_mtu,omitempty"`
+
+	// Endpoint is the address the device last reported for the peer. When it
+	// changed, e.g. as the peer roamed, PreviousEndpoint is the address
+	// before and EndpointChangedAt when the change was seen.
+	Endpoint          string    `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	PreviousEndpoint  string    `yaml:"previous_endpoint,omitempty" json:"previous_endpoint,omitempty"`
+	EndpointChangedAt time.Time `yaml:"endpoint_changed_at,omitempty" json:"endpoint_changed_at,omitempty"`
+
+	// HandshakesObserved and StalePolls count the monitor polls that found a
+	// fresh handshake of the peer and those that didn't. They keep counting
+	// across reloads and state changes and only start over when the peer is
+	// removed from the configuration, its public key changes or wgmesh
+	// restarts.
+	HandshakesObserved uint64 `yaml:"handshakes_observed" json:"handshakes_observed"`
+	StalePolls         uint64 `yaml:"stale_polls" json:"stale_polls"`
+
+	// Events are the last state transitions of the peer, oldest first.
+	Events []PeerEvent `yaml:"events,omitempty" json:"events,omitempty"`
+}
+
+// maxPeerEvents bounds the transitions kept per peer.
+const maxPeerEvents = 32
+
+// PeerEvent is a state transition of a peer. From is empty when the peer
+// was first seen.
+type PeerEvent struct {
+	Time time.Time `yaml:"time" json:"time"`
+	From PeerState `yaml:"from,omitempty" json:"from,omitempty"`
+	To   PeerState `yaml:"to" json:"to"`
+}
+
+// setState changes the state of the peer, tracking when it came up and
+// recording the transition.
+func (s *PeerStatus) setState(state PeerState, now time.Time) {
+	switch {
+	case state != PeerStateUp:
+		s.UpSince = time.Time{}
+	case s.State != PeerStateUp || s.UpSince.IsZero():
+		s.UpSince = now
+	}
+	if state != s.State {
+		// Always copy, snapshots handed out share the old slice
+		events := s.Events[max(0, len(s.Events)-maxPeerEvents+1):]
+		s.Events = append(slices.Clip(events), PeerEvent{Time: now, From: s.State, To: state})
+	}
+	s.State = state
+}
+
+// recordError sets the error of the peer and counts it.
+func (s *PeerStatus) recordError(msg string, now time.Time) {
+	s.Error = msg
+	s.LastErrorTime = now
+	s.ErrorCount++
 }
 
 type MeshState string
@@ -69,49 +423,146 @@ const (
 )
 
 type MeshStatus struct {
-	NetworkName string                `yaml:"network_name"`
-	Status      MeshState             `yaml:"status"` // "up", "partial", "down"
-	Peers       map[string]PeerStatus `yaml:"peers"`
-	LastUpdate  time.Time             `yaml:"last_update"`
+	NetworkName string                `yaml:"network_name" json:"network_name"`
+	Status      MeshState             `yaml:"status" json:"status"` // "up", "partial", "down"
+	Peers       map[string]PeerStatus `yaml:"peers" json:"peers"`
+	LastUpdate  time.Time             `yaml:"last_update" json:"last_update"`
+
+	// ConfigLoadedAt is when the active configuration was applied and
+	// ConfigSource where it came from, e.g. the path of the config file.
+	ConfigLoadedAt time.Time `yaml:"config_loaded_at" json:"config_loaded_at"`
+	ConfigSource   string    `yaml:"config_source" json:"config_source"`
+
+	// Implementation is whether the kernel module or a userspace device
+	// backs the interface, once it was read.
+	Implementation Implementation `yaml:"implementation,omitempty" json:"implementation,omitempty"`
 }
 
 type WgMesh struct {
-	Config       *Config
-	YamlFilePath string
-	status       MeshStatus
-	statusMu     sync.RWMutex
-	Client       WireGuardClient
-	ctx          context.Context
-	cancel       context.CancelFunc
-	wg           sync.WaitGroup
-}
-
-func NewWgMesh(yamlPath string) (*WgMesh, error) {
-	client, err := wgctrl.New()
+	Config        *Config
+	YamlFilePath  string
+	status        MeshStatus
+	statusMu      sync.RWMutex
+	subscribers   []chan MeshStatus
+	published     MeshStatus // last status sent to subscribers
+	startedAt     time.Time  // when the tunnel was last started, guarded by statusMu
+	lastPoll      time.Time  // when the monitor last read the device, guarded by statusMu
+	client        WireGuardClient
+	clientMu      sync.RWMutex
+	CommandRunner CommandRunner
+	source        ConfigSource
+	resolver      *resolverCache
+	prober        PathMTUProber        // nil probes with ping(8)
+	replica       StatusSource         // leader set with WithReplicaOf, see replicaSource
+	pprof         bool                 // set with WithPprof, see pprofEnabled
+	strictYAML    bool                 // set with WithStrictYAML
+	allowRemoval  bool                 // set with WithAllowPeerRemoval
+	peerHookRuns  map[string]time.Time // last on_down/on_up runs, guarded by statusMu
+	downAlerts    []*downAlert         // registered with OnPeerDownFor, guarded by statusMu
+	roamHooks     []endpointHook       // registered with OnEndpointChange, guarded by statusMu
+	statsSink     io.Writer
+	links         LinkManager  // nil selects Config.LinkManager
+	checkPrivs    func() error // run before programming the device, nil skips it
+	configMu      sync.RWMutex // guards swapping Config while goroutines read it
+	reconfiguring atomic.Int32 // applyConfig calls in progress, the monitor skips polls meanwhile
+	monitorDue    atomic.Int64 // when the monitor polls next in Unix nanoseconds, see responsive
+	dirManaged    bool         // started by a DirManager, which notifies systemd instead
+	removalMu     sync.Mutex
+	removals      map[wgtypes.Key]*pendingRemoval // peers removed within Config.PeerRemovalGrace, guarded by removalMu
+	reloads       reloadStats
+	reloadQueue   reloadQueue // serializes and coalesces Reload calls
+	applyMu       sync.Mutex  // serializes applyConfig calls
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+// NewWgMesh creates a mesh configured from the YAML file at yamlPath. The
+// file is watched and changes are applied while the mesh is running.
+func NewWgMesh(yamlPath string, opts ...Option) (*WgMesh, error) {
+	src := &FileConfigSource{Path: yamlPath}
+	m, err := NewWgMeshFromSource(src, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create wireguard client: %w", err)
+		return nil, err
 	}
+	m.YamlFilePath = yamlPath
+	src.OnLoadError = func(err error) { m.recordReload(time.Now(), err) }
 
+	return m, nil
+}
+
+// NewWgMeshFromConfig creates a mesh from a configuration built in code. No
+// file is read and nothing is watched; the configuration is validated like
+// one loaded from a file.
+func NewWgMeshFromConfig(cfg *Config, opts ...Option) (*WgMesh, error) {
+	if err := cfg.loadPeersFile(""); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadTrustedKeysFile(""); err != nil {
+		return nil, err
+	}
+	if err := cfg.resolve(); err != nil {
+		return nil, err
+	}
+	if err := cfg.assignPoolAddresses(""); err != nil {
+		return nil, err
+	}
+
+	return NewWgMeshFromSource(&staticSource{config: cfg}, opts...)
+}
+
+// NewWgMeshFromSource creates a mesh configured from src. Configurations
+// delivered by the source's Watch channel are applied while the mesh is
+// running.
+func NewWgMeshFromSource(src ConfigSource, opts ...Option) (*WgMesh, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	m := &WgMesh{
-		YamlFilePath: yamlPath,
 		status: MeshStatus{
 			Peers: make(map[string]PeerStatus),
 		},
-		Client: client,
-		ctx:    ctx,
-		cancel: cancel,
+		CommandRunner: execRunner{},
+		source:        src,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+
+	m.resolver = newResolverCache(configResolver{m})
+	for _, opt := range opts {
+		opt(m)
 	}
 
-	config, err := m.LoadConfig(yamlPath)
+	// A client passed in with WithClient belongs to the caller
+	ownClient := m.client == nil
+	if ownClient {
+		client, err := wgctrl.New()
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create wireguard client: %w", err)
+		}
+		m.client = client
+
+		// An injected client may not need privileges, e.g. a userspace one
+		if m.checkPrivs == nil {
+			m.checkPrivs = checkNetAdmin
+		}
+	}
+
+	config, err := src.Load()
+	if err == nil {
+		err = config.Validate()
+	}
 	if err != nil {
 		cancel()
-		client.Close()
+		if ownClient {
+			m.client.Close()
+		}
 		return nil, err
 	}
 	m.Config = config
 	m.status.NetworkName = config.NetworkName
+	m.status.ConfigLoadedAt = time.Now()
+	m.status.ConfigSource = sourceName(src)
 
 	return m, nil
 }
@@ -120,50 +571,204 @@ func NewWgMesh(yamlPath string) (*WgMesh, error) {
 func (w *WgMesh) Close() error {
 	w.cancel()  // Signal all goroutines to stop
 	w.wg.Wait() // Wait for all goroutines to finish
-	return w.Client.Close()
+	w.closeSubscribers()
+	return w.Client().Close()
+}
+
+// Client returns the WireGuard client used to talk to the device.
+func (w *WgMesh) Client() WireGuardClient {
+	w.clientMu.RLock()
+	defer w.clientMu.RUnlock()
+	return w.client
 }
 
+// SetClient replaces the WireGuard client, e.g. after the old one failed. It
+// is safe to call while the mesh is running; the old client is not closed.
+// Prefer WithClient when the client is known at construction time.
+func (w *WgMesh) SetClient(client WireGuardClient) {
+	w.clientMu.Lock()
+	defer w.clientMu.Unlock()
+	w.client = client
+}
+
+// currentConfig returns the active configuration. Goroutines other than the
+// one applying reloads must use it instead of reading Config directly.
+func (w *WgMesh) currentConfig() *Config {
+	w.configMu.RLock()
+	defer w.configMu.RUnlock()
+	return w.Config
+}
+
+func (w *WgMesh) setConfig(config *Config) {
+	w.statusMu.Lock()
+	w.status.ConfigLoadedAt = time.Now()
+	w.publishStatus()
+	w.statusMu.Unlock()
+
+	w.configMu.Lock()
+	defer w.configMu.Unlock()
+	w.Config = config
+}
+
+// GetStatus returns a snapshot of the mesh status.
 func (w *WgMesh) GetStatus() MeshStatus {
 	w.statusMu.RLock()
 	defer w.statusMu.RUnlock()
-	return w.status
+
+	return w.snapshotLocked()
+}
+
+// PeerEvents returns the last state transitions of the peer, oldest first,
+// or nil for an unknown peer.
+func (w *WgMesh) PeerEvents(name string) []PeerEvent {
+	w.statusMu.RLock()
+	defer w.statusMu.RUnlock()
+
+	return slices.Clone(w.status.Peers[name].Events)
 }
 
 func (w *WgMesh) updatePeerState(name string, state PeerState, err error) {
 	w.statusMu.Lock()
 	defer w.statusMu.Unlock()
 
+	now := time.Now()
 	peerStatus := w.status.Peers[name]
+	w.peerStateChanged(name, peerStatus.State, state, now)
 	peerStatus.Name = name
-	peerStatus.State = state
+	peerStatus.setState(state, now)
 	if err != nil {
-		peerStatus.Error = err.Error()
+		peerStatus.recordError(err.Error(), now)
 	} else {
 		peerStatus.Error = ""
 	}
-	peerStatus.LastSeen = time.Now()
+	if state == PeerStateUp {
+		peerStatus.ErrorCount = 0
+	}
+	peerStatus.LastSeen = now
 	w.status.Peers[name] = peerStatus
 
-	// Update overall mesh status
+	w.updateMeshState()
+}
+
+// updateMeshState recomputes the overall mesh state from the peer states.
+// The caller must hold statusMu.
+func (w *WgMesh) updateMeshState() {
+	required := make(map[string]bool)
+	for _, peer := range w.currentConfig().Peers {
+		if peer.Required {
+			required[peer.Name] = true
+		}
+	}
+
+	w.status.Status = aggregateMeshState(w.status.Peers, required)
+	w.status.LastUpdate = time.Now()
+	w.publishStatus()
+}
+
+// aggregateMeshState computes the mesh state from the peer states. When some
+// peers are required, the mesh is down if any of them is down or errored,
+// partial while any other peer is not up, and up only when all are up.
+// Without required peers every peer counts the same: the mesh is up or down
+// only if all peers are, and partial otherwise.
+func aggregateMeshState(peers map[string]PeerStatus, required map[string]bool) MeshState {
+	if len(required) == 0 {
+		allUp := true
+		allDown := true
+		for _, p := range peers {
+			if p.State != PeerStateUp {
+				allUp = false
+			}
+			if p.State != PeerStateDown {
+				allDown = false
+			}
+		}
+
+		switch {
+		case allUp:
+			return MeshStateUp
+		case allDown:
+			return MeshStateDown
+		default:
+			return MeshStatePartial
+		}
+	}
+
 	allUp := true
-	allDown := true
-	for _, p := range w.status.Peers {
-		if p.State != "up" {
+	for name := range required {
+		switch peers[name].State {
+		case PeerStateDown, PeerStateError:
+			return MeshStateDown
+		case PeerStateUp:
+		default:
 			allUp = false
 		}
-		if p.State != "down" {
-			allDown = false
+	}
+	for _, p := range peers {
+		if p.State != PeerStateUp {
+			allUp = false
 		}
 	}
 
 	if allUp {
-		w.status.Status = "up"
-	} else if allDown {
-		w.status.Status = "down"
-	} else {
-		w.status.Status = "partial"
+		return MeshStateUp
+	}
+	return MeshStatePartial
+}
+
+// initPeerStatus marks a newly configured peer as configuring. A peer that
+// was only updated keeps its status, counters and uptime, unless it failed
+// before: the new configuration gets a fresh start.
+func (w *WgMesh) initPeerStatus(name string) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	status, ok := w.status.Peers[name]
+	if ok && status.State != PeerStateError {
+		return
+	}
+	status.Name = name
+	status.setState(PeerStateConfiguring, time.Now())
+	status.Error = ""
+	w.status.Peers[name] = status
+
+	w.updateMeshState()
+}
+
+// pruneStatus drops the status of peers that are gone from newConfig or
+// became a different peer, i.e. changed their public key. The status of a
+// renamed peer, one keeping its key under a new name, moves to the new name.
+func (w *WgMesh) pruneStatus(newConfig *Config) {
+	keys := make(map[string]string, len(newConfig.Peers))
+	names := make(map[string]string, len(newConfig.Peers))
+	for _, peer := range newConfig.Peers {
+		keys[peer.Name] = peer.PublicKey
+		names[peer.PublicKey] = peer.Name
+	}
+
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+
+	pruned := false
+	renamed := make(map[string]PeerStatus)
+	for _, peer := range w.Config.Peers {
+		if key, ok := keys[peer.Name]; ok && key == peer.PublicKey {
+			continue
+		}
+		if name, ok := names[peer.PublicKey]; ok && peer.PublicKey != "" {
+			if status, ok := w.status.Peers[peer.Name]; ok {
+				status.Name = name
+				renamed[name] = status
+			}
+		}
+		delete(w.status.Peers, peer.Name)
+		pruned = true
+	}
+	for name, status := range renamed {
+		w.status.Peers[name] = status
+	}
+	if pruned {
+		w.updateMeshState()
 	}
-	w.status.LastUpdate = time.Now()
 }
 
 func (w *WgMesh) handlePeerError(peer Peer, err error) {
@@ -176,124 +781,336 @@ func (w *WgMesh) handlePeerError(peer Peer, err error) {
 }
 
 func (w *WgMesh) Start() error {
-	// Start the WireGuard tunnel
-	if err := w.StartTunnel(); err != nil {
+	if err := w.Config.ensureStateDir(); err != nil {
+		return err
+	}
+
+	if src := w.replicaSource(); src != nil {
+		if err := w.startReplica(src); err != nil {
+			return fmt.Errorf("failed to follow leader: %w", err)
+		}
+	} else if w.Config.ObserveOnly {
+		// Leave the device alone, only collect its status
+		log.Info().Msg("Running in observe-only mode")
+		w.startMonitor()
+	} else if err := w.StartTunnel(); err != nil {
 		return fmt.Errorf("failed to start WireGuard tunnel: %w", err)
 	}
 
-	// Start the file watcher in a separate goroutine
+	if err := w.startControl(); err != nil {
+		return err
+	}
+	if err := w.startHTTP(); err != nil {
+		return err
+	}
+	w.startTextfileMetrics()
+
+	// Start watching the configuration in a separate goroutine
 	w.wg.Add(1)
 	go func() {
 		defer w.wg.Done()
-		if err := w.startFileWatcher(); err != nil {
-			log.Error().Err(err).Msg("File watcher stopped with error")
+		if err := w.watchConfig(); err != nil {
+			log.Error().Err(err).Msg("Configuration watcher stopped with error")
 		}
 	}()
 
+	w.notifyReady()
 	return nil
 }
 
-func (w *WgMesh) startFileWatcher() error {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return fmt.Errorf("failed to initialize file watcher: %w", err)
-	}
-	defer watcher.Close()
+// defaultWatchRetries is used when Config.WatchRetries is unset.
+const defaultWatchRetries = 10
 
-	// Add the YAML file to the watcher
-	if err := watcher.Add(w.YamlFilePath); err != nil {
-		return fmt.Errorf("failed to watch YAML file: %w", err)
-	}
+// watchRetryDelay is the delay before re-establishing a failed
+// configuration watch the first time, doubled with every further failure.
+var watchRetryDelay = time.Second
 
-	log.Info().Msg("File watcher started for YAML file: " + w.YamlFilePath)
+func (c *Config) watchRetries() int {
+	if c.WatchRetries > 0 {
+		return c.WatchRetries
+	}
+	return defaultWatchRetries
+}
 
+// watchConfig applies the configurations delivered by the source. A watch
+// that can't be established or ends early is retried with a growing delay,
+// up to Config.WatchRetries times in a row, so auto-reload survives
+// transient failures such as exhausted inotify watches.
+func (w *WgMesh) watchConfig() error {
+	failures := 0
 	for {
+		configs, err := w.source.Watch(w.ctx)
+		if err == nil {
+			failures = 0
+			w.applyConfigs(configs)
+			if w.ctx.Err() != nil {
+				return nil
+			}
+			err = errors.New("configuration watch ended")
+		}
+
+		failures++
+		if retries := w.currentConfig().watchRetries(); failures > retries {
+			return fmt.Errorf("giving up watching the configuration after %d attempts: %w", failures, err)
+		}
+		delay := monitorBackoff(watchRetryDelay, failures-1)
+		log.Warn().
+			Err(err).
+			Int("attempt", failures).
+			Dur("retry_in", delay).
+			Msg("Failed to watch the configuration, retrying")
+
 		select {
 		case <-w.ctx.Done():
 			return nil
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return nil
-			}
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				log.Info().Msg("Detected YAML file change")
-				w.handleConfigChange()
-			}
-		case err, ok := <-watcher.Errors:
+		case <-time.After(delay):
+		}
+	}
+}
+
+// applyConfigs applies the configurations received on configs until it is
+// closed or the mesh is closed. They take their turn in the reload queue like
+// Reload; one arriving while a reload is pending is picked up by that reload
+// instead.
+func (w *WgMesh) applyConfigs(configs <-chan *Config) {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case config, ok := <-configs:
 			if !ok {
-				return nil
+				return
 			}
-			log.Error().Err(err).Msg("Error watching file")
+			w.reloadQueue.do(func() (err error) {
+				start := time.Now()
+				defer func() { w.recordReload(start, err) }()
+				return w.handleConfigChange(config)
+			})
 		}
 	}
 }
 
-func (w *WgMesh) handleConfigChange() {
-	// Backup the current YAML file
-	err := w.backupConfig()
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to backup configuration file")
-		return
+// handleConfigChange applies a configuration received from the source.
+func (w *WgMesh) handleConfigChange(newConfig *Config) error {
+	if err := newConfig.Validate(); err != nil {
+		log.Error().Err(err).Msg("Ignoring invalid configuration")
+		return err
+	}
+	if err := w.Config.checkImmutable(newConfig); err != nil {
+		log.Error().Err(err).Msg("Ignoring configuration change")
+		return err
+	}
+	if err := w.checkPeerRemoval(newConfig); err != nil {
+		return err
 	}
 
-	// Load the new configuration
-	newConfig, err := w.LoadConfig(w.YamlFilePath)
+	if w.YamlFilePath != "" {
+		// Backup the current YAML file
+		if err := w.backupConfig(); err != nil {
+			log.Error().Err(err).Msg("Failed to backup configuration file")
+			return err
+		}
+	}
+
+	if err := w.applyConfig(newConfig); err != nil {
+		log.Error().Err(err).Msg("Failed to apply configuration change")
+		return err
+	}
+	return nil
+}
+
+// Reload loads the configuration from its source again and applies the
+// differences to the device. When some of them fail, the combined errors
+// are returned and the previous configuration stays active. Only one reload
+// runs at a time: calls made while one runs share a single follow-up reload
+// and its result.
+func (w *WgMesh) Reload() error {
+	return w.reloadQueue.do(w.reload)
+}
+
+// reload loads and applies the configuration for Reload.
+func (w *WgMesh) reload() (err error) {
+	start := time.Now()
+	defer func() { w.recordReload(start, err) }()
+
+	newConfig, err := w.source.Load()
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to load updated configuration")
-		return
+		return fmt.Errorf("failed to load updated configuration: %w", err)
+	}
+	if err := newConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := w.Config.checkImmutable(newConfig); err != nil {
+		log.Error().Err(err).Msg("Ignoring configuration change")
+		return err
+	}
+	if err := w.checkPeerRemoval(newConfig); err != nil {
+		return err
+	}
+
+	return w.applyConfig(newConfig)
+}
+
+// applyConfig applies the differences between the active configuration and
+// newConfig to the device. newConfig only becomes active when every change
+// was applied; otherwise the combined errors are returned and the failed
+// changes are retried by the next reload (or reverted by the reconciler).
+func (w *WgMesh) applyConfig(newConfig *Config) (err error) {
+	w.applyMu.Lock()
+	defer w.applyMu.Unlock()
+	defer func() {
+		if err == nil {
+			w.saveAllocations(newConfig)
+		}
+	}()
+
+	// The device is half-configured until all changes are applied, don't let
+	// the monitor report peers as down meanwhile.
+	w.reconfiguring.Add(1)
+	defer w.reconfiguring.Add(-1)
+
+	w.pruneStatus(newConfig)
+
+	if w.Config.ObserveOnly || newConfig.ObserveOnly || w.replicaSource() != nil {
+		// Only the peer names used for status correlation need refreshing
+		w.setConfig(newConfig)
+		return nil
 	}
 
 	// Compute mesh diffs
 	addedPeers, removedPeers, updatedPeers := w.diffMesh(w.Config.Peers, newConfig.Peers)
+	w.logConfigDiff(addedPeers, removedPeers, updatedPeers)
+	defer func() {
+		w.audit(addedPeers, removedPeers, updatedPeers, err)
+		if err == nil {
+			w.recordReloadPeers(len(addedPeers), len(removedPeers), len(updatedPeers))
+		}
+	}()
+
+	grace := newConfig.PeerRemovalGrace
+	if len(newConfig.Peers) == 0 && len(removedPeers) > 0 && grace <= 0 {
+		if err := w.removeAllPeers(removedPeers); err != nil {
+			return err
+		}
+		w.setConfig(newConfig)
+		return nil
+	}
+
+	var errs []error
 
 	// Apply changes for added peers
 	for _, peer := range addedPeers {
-		log.Info().Msg("Adding new peer: " + peer.Name)
-		err := w.addPeer(peer)
-		if err != nil {
+		w.cancelRemoval(peer)
+		if err := w.addPeer(newConfig, peer); err != nil {
 			log.Error().Err(err).Msg("Failed to add peer: " + peer.Name)
+			errs = append(errs, fmt.Errorf("failed to add peer %s: %w", peer.Name, err))
 		}
 	}
 
 	// Apply changes for removed peers
 	for _, peer := range removedPeers {
-		log.Info().Msg("Removing peer: " + peer.Name)
-		err := w.removePeer(peer)
-		if err != nil {
+		if grace > 0 {
+			if err := w.deferRemoval(peer, grace); err != nil {
+				log.Error().Err(err).Msg("Failed to remove peer: " + peer.Name)
+				errs = append(errs, fmt.Errorf("failed to remove peer %s: %w", peer.Name, err))
+			}
+			continue
+		}
+		if err := w.removePeer(peer); err != nil {
 			log.Error().Err(err).Msg("Failed to remove peer: " + peer.Name)
+			errs = append(errs, fmt.Errorf("failed to remove peer %s: %w", peer.Name, err))
 		}
 	}
 
 	// Apply changes for updated peers
 	for _, peer := range updatedPeers {
 		log.Info().Msg("Updating peer: " + peer.Name)
-		err := w.updatePeer(peer)
-		if err != nil {
+		if err := w.updatePeer(newConfig, peer); err != nil {
 			log.Error().Err(err).Msg("Failed to update peer: " + peer.Name)
+			errs = append(errs, fmt.Errorf("failed to update peer %s: %w", peer.Name, err))
 		}
 	}
 
+	// A new route_metric or manage_routes reroutes the unchanged peers too
+	if w.Config.routingChanged(newConfig) {
+		changed := make(map[string]bool, len(addedPeers)+len(updatedPeers))
+		for _, peer := range slices.Concat(addedPeers, updatedPeers) {
+			changed[peer.Name] = true
+		}
+		for _, peer := range newConfig.Peers {
+			if changed[peer.Name] {
+				continue
+			}
+			if err := w.reroute(newConfig, peer, peer); err != nil {
+				log.Error().Err(err).Msg("Failed to reroute peer: " + peer.Name)
+				errs = append(errs, fmt.Errorf("failed to reroute peer %s: %w", peer.Name, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		log.Warn().Int("failed", len(errs)).Msg("Keeping previous configuration, not all changes could be applied")
+		return errors.Join(errs...)
+	}
+
 	// Update the in-memory configuration
-	w.Config = newConfig
+	w.setConfig(newConfig)
+	return nil
+}
+
+// logConfigDiff emits a single structured event describing a reload.
+func (w *WgMesh) logConfigDiff(addedPeers, removedPeers, updatedPeers []Peer) {
+	added := make([]string, 0, len(addedPeers))
+	for _, peer := range addedPeers {
+		added = append(added, peer.Name)
+	}
+	sort.Strings(added)
+
+	removed := make([]string, 0, len(removedPeers))
+	for _, peer := range removedPeers {
+		removed = append(removed, peer.Name)
+	}
+	sort.Strings(removed)
+
+	updated := make(map[string][]PeerChange, len(updatedPeers))
+	for _, peer := range updatedPeers {
+		if old, ok := w.previousPeer(peer); ok {
+			updated[peer.Name] = getChanges(old, peer)
+		}
+	}
+
+	log.Info().
+		Str("network", w.Config.NetworkName).
+		Strs("added", added).
+		Strs("removed", removed).
+		Interface("updated", updated).
+		Msg("Configuration reloaded")
 }
 
 func (w *WgMesh) backupConfig() error {
-	backupPath := w.YamlFilePath + ".backup_" + time.Now().Format("20060102_150405")
+	backupPath := w.YamlFilePath + ".backup_" + time.Now().Format(backupTimeLayout)
+	if dir := w.Config.statePath(stateBackupsDir); dir != "" {
+		if err := w.Config.ensureStateDir(); err != nil {
+			return err
+		}
+		backupPath = filepath.Join(dir, filepath.Base(backupPath))
+	}
 
 	return w.WriteCurrentConfig(backupPath)
 }
 
 func (w *WgMesh) WriteCurrentConfig(path string) error {
-	data, err := yaml.Marshal(w.Config)
+	data, err := yaml.Marshal(w.Config.withoutFilePeers())
 	if err != nil {
 		return err
 	}
 
-	// While it's containing sensitive data, it should be 600
-	return os.WriteFile(path, data, 0o600)
+	return writeConfigFile(path, data, w.Config)
 }
 
-func (w *WgMesh) addPeer(peer Peer) error {
+// addPeer adds peer to the device, routed as config says. config is the
+// configuration being applied, which isn't active yet during a reload.
+func (w *WgMesh) addPeer(config *Config, peer Peer) error {
 	log.Info().Msg("Adding peer: " + peer.Name)
 
 	peerConfig, err := w.createPeerConfig(peer)
@@ -307,12 +1124,17 @@ func (w *WgMesh) addPeer(peer Peer) error {
 		Peers: []wgtypes.PeerConfig{peerConfig},
 	}
 
-	if err := w.Client.ConfigureDevice(w.Config.NetworkName, cfg); err != nil {
+	if err := w.deviceClient().ConfigureDevice(w.Config.NetworkName, cfg); err != nil {
 		w.handlePeerError(peer, err)
 		return fmt.Errorf("failed to add peer %s: %w", peer.Name, err)
 	}
 
-	w.updatePeerState(peer.Name, "configuring", nil)
+	if err := w.addRoutes(config, peer); err != nil {
+		w.handlePeerError(peer, err)
+		return err
+	}
+
+	w.initPeerStatus(peer.Name)
 	log.Info().Msg("Successfully added peer: " + peer.Name)
 	return nil
 }
@@ -320,113 +1142,277 @@ func (w *WgMesh) addPeer(peer Peer) error {
 func (w *WgMesh) removePeer(peer Peer) error {
 	log.Info().Msg("Removing peer: " + peer.Name)
 
-	// Remove the peer using wg (WireGuard command-line tool)
-	// args := []string{"set", w.Config.NetworkName, "peer", peer.PublicKey, "remove"}
-	// err := w.CommandRunner.Run("wg", args...)
-	// if err != nil {
-	// 	log.Error().Err(err).Msg("Failed to remove peer: " + peer.Name)
-	// 	return err
-	// }
+	pubKey, err := parseKey(peer.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key for peer %s: %w", peer.Name, err)
+	}
 
-	// Optionally bring down the interface for the removed peer
+	// Remove only this peer, the rest of the mesh stays untouched
+	cfg := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{PublicKey: pubKey, Remove: true}},
+	}
 
-	if err := w.StopTunnel(); err != nil {
-		log.Error().Err(err).Msg("Failed to stop tunnel for peer: " + peer.Name)
-		return err
+	if err := w.deviceClient().ConfigureDevice(w.Config.NetworkName, cfg); err != nil {
+		log.Error().Err(err).Msg("Failed to remove peer: " + peer.Name)
+		return fmt.Errorf("failed to remove peer %s: %w", peer.Name, err)
+	}
+
+	if err := w.delRoutes(w.Config, peer); err != nil {
+		log.Warn().Err(err).Msg("Failed to remove routes of peer: " + peer.Name)
 	}
 
 	log.Info().Msg("Successfully removed peer: " + peer.Name)
 	return nil
 }
 
-func (w *WgMesh) updatePeer(peer Peer) error {
-	// Remove the old peer first
-	if err := w.removePeer(peer); err != nil {
-		log.Warn().Err(err).Msgf("Failed to remove old peer %s before update", peer.Name)
+// removeAllPeers clears the device with a single call when a reload leaves no
+// peers. The interface and its address stay up.
+func (w *WgMesh) removeAllPeers(peers []Peer) error {
+	cfg := wgtypes.Config{ReplacePeers: true}
+	if err := w.deviceClient().ConfigureDevice(w.Config.NetworkName, cfg); err != nil {
+		log.Error().Err(err).Msg("Failed to remove all peers")
+		return fmt.Errorf("failed to remove all peers: %w", err)
 	}
 
-	// Add the peer with new configuration
-	return w.addPeer(peer)
+	for _, peer := range peers {
+		if err := w.delRoutes(w.Config, peer); err != nil {
+			log.Warn().Err(err).Msg("Failed to remove routes of peer: " + peer.Name)
+		}
+	}
+
+	log.Info().Int("peers", len(peers)).Msg("Removed all peers, interface left without peers")
+	return nil
 }
 
-func (w *WgMesh) LoadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+// updatePeer applies the changes to an existing peer. Only the device
+// fields that changed are set, so e.g. a keepalive change leaves the allowed
+// IPs (and the traffic using them) alone. A key change, or an endpoint
+// removed, can't be expressed as an update and replaces the peer. The
+// routes follow the effective metric of the peer in config.
+func (w *WgMesh) updatePeer(config *Config, peer Peer) error {
+	old, ok := w.previousPeer(peer)
+	if !ok {
+		return w.addPeer(config, peer)
+	}
+
+	changed := make(map[string]bool)
+	for _, change := range getChanges(old, peer) {
+		changed[change.Field] = true
+	}
+
+	if changed["PublicKey"] || !old.IsRoaming() && peer.IsRoaming() {
+		// Remove the old peer first, with its old key and routes
+		if err := w.removePeer(old); err != nil {
+			log.Warn().Err(err).Msgf("Failed to remove old peer %s before update", peer.Name)
+		}
+		return w.addPeer(config, peer)
 	}
-	var config Config
-	err = yaml.Unmarshal(data, &config)
+
+	peerConfig, err := w.createPeerConfig(peer)
 	if err != nil {
-		return nil, err
+		w.handlePeerError(peer, err)
+		return err
+	}
+
+	update := wgtypes.PeerConfig{
+		PublicKey:  peerConfig.PublicKey,
+		UpdateOnly: true,
+	}
+	if changed["Endpoint"] || changed["Port"] {
+		update.Endpoint = peerConfig.Endpoint
+	}
+	if changed["PersistentKeepalive"] || changed["NAT"] {
+		// A nil interval leaves the keepalive as is, so disabling it must
+		// be explicit
+		keepalive := time.Duration(peer.keepalive()) * time.Second
+		update.PersistentKeepaliveInterval = &keepalive
+	}
+	if changed["AllowedIPs"] {
+		update.AllowedIPs = peerConfig.AllowedIPs
+		update.ReplaceAllowedIPs = true
+	}
+	if changed["PresharedKey"] {
+		// A nil key leaves the old one in place, the zero key removes it
+		update.PresharedKey = peerConfig.PresharedKey
+		if update.PresharedKey == nil {
+			update.PresharedKey = &wgtypes.Key{}
+		}
 	}
-	return &config, nil
+
+	if update.Endpoint != nil || update.PersistentKeepaliveInterval != nil || update.ReplaceAllowedIPs || update.PresharedKey != nil {
+		cfg := wgtypes.Config{Peers: []wgtypes.PeerConfig{update}}
+		if err := w.deviceClient().ConfigureDevice(w.Config.NetworkName, cfg); err != nil {
+			w.handlePeerError(peer, err)
+			return fmt.Errorf("failed to update peer %s: %w", peer.Name, err)
+		}
+	}
+
+	if err := w.reroute(config, old, peer); err != nil {
+		w.handlePeerError(peer, err)
+		return err
+	}
+
+	w.initPeerStatus(peer.Name)
+	return nil
+}
+
+func (w *WgMesh) LoadConfig(path string) (*Config, error) {
+	return loadConfigFile(path, w.strictYAML)
 }
 
 func (w *WgMesh) diffMesh(oldPeers, newPeers []Peer) ([]Peer, []Peer, []Peer) {
-	var addedPeers, removedPeers, updatedPeers []Peer
-	oldPeerMap := make(map[string]Peer)
-	newPeerMap := make(map[string]Peer)
+	addedPeers, removedPeers, diffs := diffPeers(oldPeers, newPeers)
 
-	// Create a map of old peers
-	for _, peer := range oldPeers {
-		oldPeerMap[peer.Name] = peer
+	var updatedPeers []Peer
+	for _, diff := range diffs {
+		updatedPeers = append(updatedPeers, diff.New)
 	}
+	return addedPeers, removedPeers, updatedPeers
+}
 
-	// Create a map of new peers
-	for _, peer := range newPeers {
-		newPeerMap[peer.Name] = peer
+// diffPeers returns the peers only in newPeers, those only in oldPeers and
+// those that changed, in the order of newPeers.
+func diffPeers(oldPeers, newPeers []Peer) (addedPeers, removedPeers []Peer, updatedPeers []PeerDiff) {
+	// Peers are matched by public key first, so renaming a peer is an update
+	// rather than a remove and add that would drop its session. Peers not
+	// matched by key (e.g. after a key change) are matched by name.
+	oldByKey := make(map[string]int, len(oldPeers))
+	oldByName := make(map[string]int, len(oldPeers))
+	for i, peer := range oldPeers {
+		if _, ok := oldByKey[peer.PublicKey]; !ok && peer.PublicKey != "" {
+			oldByKey[peer.PublicKey] = i
+		}
+		oldByName[peer.Name] = i
 	}
 
-	// Compare old and new peers
-	for name, oldPeer := range oldPeerMap {
-		newPeer, ok := newPeerMap[name]
-		if !ok {
-			// Peer is in old configuration but not in new configuration
-			removedPeers = append(removedPeers, oldPeer)
-		} else if !reflect.DeepEqual(oldPeer, newPeer) {
-			// Peer is in both configurations but with changes
-			updatedPeers = append(updatedPeers, newPeer)
+	matches := make([]int, len(newPeers))
+	matched := make(map[int]bool, len(oldPeers))
+	for i, peer := range newPeers {
+		matches[i] = -1
+		if j, ok := oldByKey[peer.PublicKey]; ok && !matched[j] {
+			matches[i] = j
+			matched[j] = true
+		}
+	}
+	for i, peer := range newPeers {
+		if j, ok := oldByName[peer.Name]; ok && matches[i] < 0 && !matched[j] {
+			matches[i] = j
+			matched[j] = true
 		}
 	}
 
-	// Find added peers
-	for name, newPeer := range newPeerMap {
-		_, ok := oldPeerMap[name]
-		if !ok {
+	for i, newPeer := range newPeers {
+		switch j := matches[i]; {
+		case j < 0:
 			// Peer is in new configuration but not in old configuration
 			addedPeers = append(addedPeers, newPeer)
+		case !reflect.DeepEqual(oldPeers[j], newPeer):
+			// Peer is in both configurations but with changes
+			updatedPeers = append(updatedPeers, PeerDiff{
+				Old:     oldPeers[j],
+				New:     newPeer,
+				Changes: getChanges(oldPeers[j], newPeer),
+			})
+		}
+	}
+
+	// Peers in old configuration but not in new configuration
+	for i, oldPeer := range oldPeers {
+		if !matched[i] {
+			removedPeers = append(removedPeers, oldPeer)
 		}
 	}
 
 	return addedPeers, removedPeers, updatedPeers
 }
 
-func getChanges(oldPeer, newPeer Peer) string {
-	var changes []string
+// previousPeer returns the active configuration of an updated peer, matched
+// like diffMesh does: by public key, else by name.
+func (w *WgMesh) previousPeer(peer Peer) (Peer, bool) {
+	for _, old := range w.Config.Peers {
+		if old.PublicKey == peer.PublicKey && peer.PublicKey != "" {
+			return old, true
+		}
+	}
+	if i := w.Config.peerIndex(peer.Name); i >= 0 {
+		return w.Config.Peers[i], true
+	}
+	return Peer{}, false
+}
+
+// redacted replaces secret values in change sets and logs.
+const redacted = "<redacted>"
 
+// PeerChange describes a single field that differs between two versions of a
+// peer. Secret values are redacted.
+type PeerChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+func (c PeerChange) String() string {
+	return c.Field + ": " + c.From + " -> " + c.To
+}
+
+func getChanges(oldPeer, newPeer Peer) []PeerChange {
+	var changes []PeerChange
+
+	if oldPeer.Name != newPeer.Name {
+		changes = append(changes, PeerChange{"Name", oldPeer.Name, newPeer.Name})
+	}
 	if oldPeer.IP != newPeer.IP {
-		changes = append(changes, "IP: "+oldPeer.IP+" -> "+newPeer.IP)
+		changes = append(changes, PeerChange{"IP", oldPeer.IP, newPeer.IP})
 	}
 	if oldPeer.PrivateKey != newPeer.PrivateKey {
-		changes = append(changes, "PrivateKey: "+oldPeer.PrivateKey+" -> "+newPeer.PrivateKey)
+		changes = append(changes, PeerChange{"PrivateKey", redacted, redacted})
 	}
 	if oldPeer.PublicKey != newPeer.PublicKey {
-		changes = append(changes, "PublicKey: "+oldPeer.PublicKey+" -> "+newPeer.PublicKey)
+		changes = append(changes, PeerChange{"PublicKey", oldPeer.PublicKey, newPeer.PublicKey})
+	}
+	if oldPeer.PresharedKey != newPeer.PresharedKey {
+		changes = append(changes, PeerChange{"PresharedKey", redacted, redacted})
 	}
 	if !reflect.DeepEqual(oldPeer.AllowedIPs, newPeer.AllowedIPs) {
-		changes = append(changes, "AllowedIPs: "+strings.Join(oldPeer.AllowedIPs, ",")+" -> "+strings.Join(newPeer.AllowedIPs, ","))
+		changes = append(changes, PeerChange{"AllowedIPs", strings.Join(oldPeer.AllowedIPs, ","), strings.Join(newPeer.AllowedIPs, ",")})
 	}
 	if oldPeer.Endpoint != newPeer.Endpoint {
-		changes = append(changes, "Endpoint: "+oldPeer.Endpoint+" -> "+newPeer.Endpoint)
+		changes = append(changes, PeerChange{"Endpoint", oldPeer.Endpoint, newPeer.Endpoint})
 	}
 	if oldPeer.Port != newPeer.Port {
-		changes = append(changes, "Port: "+strconv.Itoa(oldPeer.Port)+" -> "+strconv.Itoa(newPeer.Port))
+		changes = append(changes, PeerChange{"Port", strconv.Itoa(oldPeer.Port), strconv.Itoa(newPeer.Port)})
 	}
 	if oldPeer.NAT != newPeer.NAT {
-		changes = append(changes, "NAT: "+strconv.FormatBool(oldPeer.NAT)+" -> "+strconv.FormatBool(newPeer.NAT))
+		changes = append(changes, PeerChange{"NAT", strconv.FormatBool(oldPeer.NAT), strconv.FormatBool(newPeer.NAT)})
+	}
+	if oldPeer.PersistentKeepalive != newPeer.PersistentKeepalive {
+		changes = append(changes, PeerChange{"PersistentKeepalive", strconv.Itoa(oldPeer.PersistentKeepalive), strconv.Itoa(newPeer.PersistentKeepalive)})
+	}
+	if oldPeer.RouteMetric != newPeer.RouteMetric {
+		changes = append(changes, PeerChange{"RouteMetric", strconv.Itoa(oldPeer.RouteMetric), strconv.Itoa(newPeer.RouteMetric)})
+	}
+	if oldPeer.Role != newPeer.Role {
+		changes = append(changes, PeerChange{"Role", oldPeer.Role, newPeer.Role})
+	}
+	if oldPeer.Required != newPeer.Required {
+		changes = append(changes, PeerChange{"Required", strconv.FormatBool(oldPeer.Required), strconv.FormatBool(newPeer.Required)})
+	}
+	if oldPeer.HandshakeTimeout != newPeer.HandshakeTimeout {
+		changes = append(changes, PeerChange{"HandshakeTimeout", oldPeer.HandshakeTimeout.String(), newPeer.HandshakeTimeout.String()})
+	}
+	if !slices.Equal(oldPeer.Tags, newPeer.Tags) {
+		changes = append(changes, PeerChange{"Tags", strings.Join(oldPeer.Tags, ","), strings.Join(newPeer.Tags, ",")})
 	}
 
-	return strings.Join(changes, ", ")
+	return changes
+}
+
+func formatChanges(changes []PeerChange) string {
+	parts := make([]string, 0, len(changes))
+	for _, change := range changes {
+		parts = append(parts, change.String())
+	}
+	return strings.Join(parts, ", ")
 }
 
 func (w *WgMesh) applyConfigurationChanges(addedPeers, removedPeers []Peer, updatedPeers map[string]Peer) error {
@@ -454,7 +1440,7 @@ func (w *WgMesh) applyConfigurationChanges(addedPeers, removedPeers []Peer, upda
 				break
 			}
 		}
-		changes := getChanges(oldPeer, newPeer)
+		changes := formatChanges(getChanges(oldPeer, newPeer))
 		log.Info().
 			Str("peer", name).
 			Str("changes", changes).
@@ -470,10 +1456,10 @@ func (w *WgMesh) applyConfigurationChanges(addedPeers, removedPeers []Peer, upda
 			continue
 		}
 		peerConfigs = append(peerConfigs, peerConfig)
-		w.updatePeerState(peer.Name, "configuring", nil)
+		w.updatePeerState(peer.Name, PeerStateConfiguring, nil)
 	}
 
-	pk, err := wgtypes.ParseKey(w.Config.PrivateKey)
+	pk, err := parseKey(w.Config.PrivateKey)
 	if err != nil {
 		return fmt.Errorf("invalid private key: %w", err)
 	}
@@ -486,7 +1472,7 @@ func (w *WgMesh) applyConfigurationChanges(addedPeers, removedPeers []Peer, upda
 	}
 
 	// Apply configuration
-	if err := w.Client.ConfigureDevice(w.Config.NetworkName, cfg); err != nil {
+	if err := w.deviceClient().ConfigureDevice(w.Config.NetworkName, cfg); err != nil {
 		log.Error().Err(err).Msg("Failed to configure WireGuard device")
 		// Mark all peers as error
 		for _, peer := range w.Config.Peers {
@@ -499,120 +1485,273 @@ func (w *WgMesh) applyConfigurationChanges(addedPeers, removedPeers []Peer, upda
 }
 
 func (w *WgMesh) StartTunnel() error {
+	if err := w.bringUp(); err != nil {
+		return err
+	}
+
+	w.startMonitor()
+	w.startResolver()
+	w.startReconciler()
+	w.startPathMTUProber()
+
+	return nil
+}
+
+// RunOnce brings the tunnel up, reverts any drift, polls the device once and
+// returns the resulting status. Unlike Start it leaves no goroutines
+// running, for cron-driven use.
+func (w *WgMesh) RunOnce() (MeshStatus, error) {
+	if err := w.bringUp(); err != nil {
+		return MeshStatus{}, err
+	}
+	if _, err := w.reconcile(); err != nil {
+		return MeshStatus{}, fmt.Errorf("failed to reconcile device: %w", err)
+	}
+	if err := w.pollDevice(); err != nil {
+		return MeshStatus{}, fmt.Errorf("failed to get device status: %w", err)
+	}
+	return w.GetStatus(), nil
+}
+
+// Startup modes for Config.StartupMode.
+const (
+	StartupModeBestEffort = "best-effort"
+	StartupModeFailFast   = "fail-fast"
+)
+
+// checkPeers builds the device configuration of every peer, resolving
+// endpoints, and returns the combined errors of the ones that fail.
+func (w *WgMesh) checkPeers() error {
+	var errs []error
+	for _, peer := range w.Config.Peers {
+		if _, err := w.createPeerConfig(peer); err != nil {
+			w.handlePeerError(peer, err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// bringUp configures the interface and its peers and runs the up hooks.
+func (w *WgMesh) bringUp() error {
+	if w.checkPrivs != nil {
+		if err := w.checkPrivs(); err != nil {
+			return err
+		}
+	}
+
+	if w.Config.StartupMode == StartupModeFailFast {
+		if err := w.checkPeers(); err != nil {
+			return fmt.Errorf("refusing to start with invalid peers: %w", err)
+		}
+	}
+
+	// A device left behind by a previous process, e.g. across an upgrade,
+	// is taken over rather than configured and hooked up again
+	adopted := w.adoptableDevice()
+
+	if adopted == nil {
+		if err := w.runHooks(w.Config.PreUp); err != nil {
+			return fmt.Errorf("pre_up hook failed: %w", err)
+		}
+	}
+
+	w.statusMu.Lock()
+	w.startedAt = time.Now()
+	w.statusMu.Unlock()
+
+	links, err := w.linkManager()
+	if err != nil {
+		return err
+	}
+	if w.Config.CreateInterface {
+		if err := links.EnsureLink(w.Config.NetworkName); err != nil {
+			return fmt.Errorf("failed to create interface %s: %w", w.Config.NetworkName, err)
+		}
+	}
+	if w.Config.MTU > 0 {
+		if err := links.SetMTU(w.Config.NetworkName, w.Config.MTU); err != nil {
+			return fmt.Errorf("failed to set MTU %d: %w", w.Config.MTU, err)
+		}
+	}
+
 	// Apply initial configuration
-	if err := w.applyConfigurationChanges(w.Config.Peers, nil, nil); err != nil {
+	if adopted != nil {
+		if err := w.adoptDevice(adopted); err != nil {
+			return fmt.Errorf("failed to adopt device: %w", err)
+		}
+	} else if err := w.applyConfigurationChanges(w.Config.Peers, nil, nil); err != nil {
 		return fmt.Errorf("failed to apply initial configuration: %w", err)
 	}
+	w.saveAllocations(w.Config)
 
-	// Start monitoring goroutine
-	w.wg.Add(1)
-	go func() {
-		defer w.wg.Done()
-		w.monitorPeers()
-	}()
+	if w.Config.Address != "" {
+		if err := links.ReplaceAddress(w.Config.NetworkName, w.Config.Address); err != nil {
+			return fmt.Errorf("failed to assign address %s: %w", w.Config.Address, err)
+		}
+	}
+	if w.Config.CreateInterface {
+		if err := links.SetUp(w.Config.NetworkName); err != nil {
+			return fmt.Errorf("failed to set interface %s up: %w", w.Config.NetworkName, err)
+		}
+	}
+
+	for _, peer := range w.Config.Peers {
+		if err := w.addRoutes(w.Config, peer); err != nil {
+			return err
+		}
+	}
+
+	if err := w.setDNS(); err != nil {
+		return err
+	}
+
+	if adopted == nil {
+		if err := w.runHooks(w.Config.PostUp); err != nil {
+			return fmt.Errorf("post_up hook failed: %w", err)
+		}
+	}
 
 	return nil
 }
 
+// defaultNATKeepalive is the keepalive in seconds for NAT peers without an
+// explicit one, short enough to keep common NAT mappings open.
+const defaultNATKeepalive = 25
+
+// IsRoaming reports whether the peer has no fixed endpoint, like a laptop or
+// phone connecting from wherever it is. WireGuard learns its address from
+// its first handshake, so nothing is resolved or set for it.
+func (p Peer) IsRoaming() bool {
+	return p.Endpoint == ""
+}
+
+// keepalive returns the effective persistent keepalive interval in seconds.
+func (p Peer) keepalive() int {
+	if p.PersistentKeepalive == 0 && p.NAT {
+		return defaultNATKeepalive
+	}
+	return p.PersistentKeepalive
+}
+
 func (w *WgMesh) createPeerConfig(peer Peer) (wgtypes.PeerConfig, error) {
-	pubKey, err := wgtypes.ParseKey(peer.PublicKey)
+	pubKey, err := parseKey(peer.PublicKey)
 	if err != nil {
 		return wgtypes.PeerConfig{}, fmt.Errorf("invalid public key for peer %s: %w", peer.Name, err)
 	}
 
 	var endpoint *net.UDPAddr
-	if peer.Endpoint != "" {
-		endpoint, err = net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", peer.Endpoint, peer.Port))
+	if !peer.IsRoaming() {
+		endpoint, err = w.resolveEndpoint(peer)
 		if err != nil {
 			return wgtypes.PeerConfig{}, fmt.Errorf("invalid endpoint for peer %s: %w", peer.Name, err)
 		}
 	}
 
-	allowedIPs := make([]net.IPNet, 0, len(peer.AllowedIPs))
-	for _, ip := range peer.AllowedIPs {
-		_, ipNet, err := net.ParseCIDR(ip)
+	allowedIPs, err := peer.ParsedAllowedIPs()
+	if err != nil {
+		return wgtypes.PeerConfig{}, err
+	}
+	if w.isQuarantined(peer.Name) {
+		allowedIPs = nil
+	}
+
+	var keepalive *time.Duration
+	if seconds := peer.keepalive(); seconds > 0 {
+		interval := time.Duration(seconds) * time.Second
+		keepalive = &interval
+	}
+
+	var presharedKey *wgtypes.Key
+	if peer.PresharedKey != "" {
+		key, err := parseKey(peer.PresharedKey)
 		if err != nil {
-			return wgtypes.PeerConfig{}, fmt.Errorf("invalid allowed IP for peer %s: %w", peer.Name, err)
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid preshared key for peer %s: %w", peer.Name, err)
 		}
-		allowedIPs = append(allowedIPs, *ipNet)
+		presharedKey = &key
 	}
 
 	return wgtypes.PeerConfig{
-		PublicKey:         pubKey,
-		Endpoint:          endpoint,
-		AllowedIPs:        allowedIPs,
-		ReplaceAllowedIPs: true,
+		PublicKey:                   pubKey,
+		PresharedKey:                presharedKey,
+		Endpoint:                    endpoint,
+		PersistentKeepaliveInterval: keepalive,
+		AllowedIPs:                  allowedIPs,
+		ReplaceAllowedIPs:           true,
 	}, nil
 }
 
-func (w *WgMesh) monitorPeers() {
-	defer w.wg.Done()
+// StopTunnel tears the tunnel down: it runs the pre_down hooks, clears all
+// peers, removes the interface address and runs the post_down hooks. Every
+// step is attempted even if an earlier one fails, and the errors are combined.
+// Observe-only meshes and replicas leave the interface alone.
+func (w *WgMesh) StopTunnel() error {
+	if w.replicaSource() != nil {
+		// A replica never brought the tunnel up
+		return nil
+	}
+	if w.currentConfig().ObserveOnly {
+		// The interface belongs to whatever manages it
+		return nil
+	}
 
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+	var errs []error
 
-	for {
-		select {
-		case <-w.ctx.Done():
-			return
-		case <-ticker.C:
-			device, err := w.Client.Device(w.Config.NetworkName)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to get device status")
-				continue
-			}
+	if err := w.runAllHooks(w.Config.PreDown); err != nil {
+		log.Error().Err(err).Msg("pre_down hook failed")
+		errs = append(errs, fmt.Errorf("pre_down hook failed: %w", err))
+	}
 
-			// Update status for all peers
-			for _, peer := range device.Peers {
-				peerName := w.getPeerNameByKey(peer.PublicKey.String())
-				if peerName == "" {
-					continue
-				}
-
-				w.statusMu.Lock()
-				status := w.status.Peers[peerName]
-				status.BytesRecv = uint64(peer.ReceiveBytes)
-				status.BytesSent = uint64(peer.TransmitBytes)
-
-				if !peer.LastHandshakeTime.IsZero() && time.Since(peer.LastHandshakeTime) < 3*time.Minute {
-					status.State = "up"
-					status.LastSeen = peer.LastHandshakeTime
-				} else {
-					status.State = "down"
-				}
-
-				w.status.Peers[peerName] = status
-				w.statusMu.Unlock()
-			}
-		}
+	deviceConfig := wgtypes.Config{
+		ReplacePeers: true, // Clear all peers
+		Peers:        nil,  // No peers
+	}
+
+	if err := w.deviceClient().ConfigureDevice(w.Config.NetworkName, deviceConfig); err != nil {
+		log.Error().Err(err).Msg("Failed to clear WireGuard device configuration")
+		errs = append(errs, fmt.Errorf("failed to clear peers: %w", err))
 	}
-}
 
-func (w *WgMesh) getPeerNameByKey(publicKey string) string {
 	for _, peer := range w.Config.Peers {
-		if peer.PublicKey == publicKey {
-			return peer.Name
+		if err := w.delRoutes(w.Config, peer); err != nil {
+			log.Error().Err(err).Msg("Failed to remove peer routes")
+			errs = append(errs, err)
 		}
 	}
-	return ""
-}
 
-func (w *WgMesh) StopTunnel() error {
-	client, err := wgctrl.New()
+	if err := w.unsetDNS(); err != nil {
+		log.Error().Err(err).Msg("Failed to remove DNS servers")
+		errs = append(errs, err)
+	}
+
+	links, err := w.linkManager()
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create WireGuard client")
-		return err
+		errs = append(errs, err)
+	} else {
+		if w.Config.Address != "" {
+			if err := links.DeleteAddress(w.Config.NetworkName, w.Config.Address); err != nil {
+				log.Error().Err(err).Msg("Failed to remove interface address")
+				errs = append(errs, fmt.Errorf("failed to remove address %s: %w", w.Config.Address, err))
+			}
+		}
+		if w.Config.CreateInterface {
+			if err := links.SetDown(w.Config.NetworkName); err != nil {
+				log.Error().Err(err).Msg("Failed to set interface down")
+				errs = append(errs, fmt.Errorf("failed to set interface %s down: %w", w.Config.NetworkName, err))
+			}
+			if err := links.DeleteLink(w.Config.NetworkName); err != nil {
+				log.Error().Err(err).Msg("Failed to delete interface")
+				errs = append(errs, fmt.Errorf("failed to delete interface %s: %w", w.Config.NetworkName, err))
+			}
+		}
 	}
-	defer client.Close()
 
-	deviceConfig := wgtypes.Config{
-		ReplacePeers: true, // Clear all peers
-		Peers:        nil,  // No peers
+	if err := w.runAllHooks(w.Config.PostDown); err != nil {
+		log.Error().Err(err).Msg("post_down hook failed")
+		errs = append(errs, fmt.Errorf("post_down hook failed: %w", err))
 	}
 
-	err = client.ConfigureDevice(w.Config.NetworkName, deviceConfig)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to clear WireGuard device configuration")
+	if err := errors.Join(errs...); err != nil {
 		return err
 	}
 
@@ -620,6 +1759,23 @@ func (w *WgMesh) StopTunnel() error {
 	return nil
 }
 
+// Shutdown stops the mesh as the daemon does on SIGINT or SIGTERM: it tears
+// the tunnel down with StopTunnel and closes the mesh. With
+// Config.AdoptDevice set the device is left running instead, so the next
+// process (e.g. after an upgrade) adopts it without the peers flapping.
+func (w *WgMesh) Shutdown() error {
+	var errs []error
+	if w.currentConfig().AdoptDevice {
+		log.Info().Str("network", w.currentConfig().NetworkName).Msg("Leaving the tunnel up for the next process to adopt")
+	} else if err := w.StopTunnel(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to tear down tunnel: %w", err))
+	}
+	if err := w.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
 func (w *WgMesh) RestartTunnel() error {
 	// Restart the WireGuard tunnel
 	err := w.StopTunnel()
@@ -646,8 +1802,32 @@ func (w *WgMesh) GeneratePeerConfig(peer Peer) string {
 		builder.WriteString("Endpoint = " + peer.Endpoint + "\n")
 	}
 	builder.WriteString("AllowedIPs = " + strings.Join(peer.AllowedIPs, ",") + "\n")
-	if peer.Port != 0 {
-		builder.WriteString("PersistentKeepalive = " + strconv.Itoa(peer.Port) + "\n")
+	if keepalive := peer.keepalive(); keepalive != 0 {
+		builder.WriteString("PersistentKeepalive = " + strconv.Itoa(keepalive) + "\n")
 	}
 	return builder.String()
 }
+
+// LocalPublicKey returns the public key of this node, to be added as a peer
+// on the other nodes. It is derived from the configured private key, or read
+// from the device when the configuration has none (e.g. in observe-only mode
+// or when the key is set by a hook from a file or the environment).
+func (w *WgMesh) LocalPublicKey() (string, error) {
+	cfg := w.currentConfig()
+	if cfg.PrivateKey != "" {
+		key, err := parseKey(cfg.PrivateKey)
+		if err != nil {
+			return "", fmt.Errorf("invalid private key: %w", err)
+		}
+		return key.PublicKey().String(), nil
+	}
+
+	device, err := w.deviceClient().Device(cfg.NetworkName)
+	if err != nil {
+		return "", fmt.Errorf("failed to read device %s: %w", cfg.NetworkName, err)
+	}
+	if device.PublicKey == (wgtypes.Key{}) {
+		return "", fmt.Errorf("device %s has no private key set", cfg.NetworkName)
+	}
+	return device.PublicKey.String(), nil
+}
@@ -1,11 +1,21 @@
 package wgmesh_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/pilab-cloud/wgmesh"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -15,6 +25,9 @@ import (
 // MockWireguardClient is a mock implementation of the WireGuard client
 type MockWireguardClient struct {
 	mock.Mock
+
+	mu      sync.Mutex
+	configs []wgtypes.Config
 }
 
 func (m *MockWireguardClient) Device(name string) (*wgtypes.Device, error) {
@@ -26,6 +39,10 @@ func (m *MockWireguardClient) Device(name string) (*wgtypes.Device, error) {
 }
 
 func (m *MockWireguardClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	m.mu.Lock()
+	m.configs = append(m.configs, cfg)
+	m.mu.Unlock()
+
 	args := m.Called(name, cfg)
 	return args.Error(0)
 }
@@ -35,6 +52,28 @@ func (m *MockWireguardClient) Close() error {
 	return args.Error(0)
 }
 
+// newTestMesh writes yamlData to a temporary file and returns a mesh wired to
+// a mock WireGuard client.
+func newTestMesh(t testing.TB, yamlData string) (*wgmesh.WgMesh, *MockWireguardClient) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(yamlData), 0o600))
+
+	mockClient := &MockWireguardClient{}
+	mesh, err := wgmesh.NewWgMesh(path, wgmesh.WithClient(mockClient))
+	require.NoError(t, err)
+
+	return mesh, mockClient
+}
+
+// configureCalls returns the configs passed to ConfigureDevice, in call order.
+func configureCalls(m *MockWireguardClient) []wgtypes.Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]wgtypes.Config(nil), m.configs...)
+}
+
 func TestLoadConfig(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -184,7 +223,7 @@ peers:
 	require.NoError(t, err)
 
 	// Replace client with mock
-	mesh.Client = mockClient
+	mesh.SetClient(mockClient)
 
 	// Mock device response
 	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
@@ -215,3 +254,748 @@ peers:
 	mesh.Close()
 	mockClient.AssertExpectations(t)
 }
+
+func TestObserveOnlyNeverConfigures(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+observe_only: true
+address: 10.0.0.1/24
+peers:
+  - name: peer1
+    ip: 10.0.0.2/24
+    public_key: a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA=
+    allowed_ips: ["10.0.0.2/32"]
+`)
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil).Maybe()
+	mockClient.On("Close").Return(nil)
+
+	require.NoError(t, mesh.Start())
+
+	// Shut down like the daemon on SIGTERM
+	require.NoError(t, mesh.StopTunnel())
+	require.NoError(t, mesh.Close())
+
+	mockClient.AssertNotCalled(t, "ConfigureDevice", mock.Anything, mock.Anything)
+	assert.Empty(t, runner.Commands())
+}
+
+func TestReloadLogsStructuredDiff(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    private_key: mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	var buf bytes.Buffer
+	oldLogger := log.Logger
+	log.Logger = zerolog.New(&buf)
+	defer func() { log.Logger = oldLogger }()
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    private_key: cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32", "10.0.1.0/24"]
+  - name: peer3
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.4/32"]
+`), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	var event struct {
+		Message string                         `json:"message"`
+		Added   []string                       `json:"added"`
+		Removed []string                       `json:"removed"`
+		Updated map[string][]wgmesh.PeerChange `json:"updated"`
+	}
+	found := 0
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal(line, &entry))
+		if entry["message"] == "Configuration reloaded" {
+			require.NoError(t, json.Unmarshal(line, &event))
+			found++
+		}
+	}
+	require.Equal(t, 1, found, "expected exactly one reload event")
+
+	assert.Equal(t, []string{"peer3"}, event.Added)
+	assert.Equal(t, []string{"peer2"}, event.Removed)
+	assert.Equal(t, []wgmesh.PeerChange{
+		{Field: "PrivateKey", From: "<redacted>", To: "<redacted>"},
+		{Field: "AllowedIPs", From: "10.0.0.2/32", To: "10.0.0.2/32,10.0.1.0/24"},
+	}, event.Updated["peer1"])
+
+	assert.NotContains(t, buf.String(), "mMbvkY1ki4s7pi4uVH3WURRuJmIv8uVWWsuTB3LWhk4=")
+	assert.NotContains(t, buf.String(), "cExy9IaUGGZKaJvQUMT2OIA1E+C5znpbjhSsUx47c1E=")
+}
+
+func TestAggregateMeshState(t *testing.T) {
+	const (
+		up    = wgmesh.PeerStateUp
+		down  = wgmesh.PeerStateDown
+		errSt = wgmesh.PeerStateError
+	)
+
+	tests := []struct {
+		name     string
+		states   map[string]wgmesh.PeerState
+		required []string
+		want     wgmesh.MeshState
+	}{
+		{"no required, all up", map[string]wgmesh.PeerState{"a": up, "b": up}, nil, wgmesh.MeshStateUp},
+		{"no required, all down", map[string]wgmesh.PeerState{"a": down, "b": down}, nil, wgmesh.MeshStateDown},
+		{"no required, mixed", map[string]wgmesh.PeerState{"a": up, "b": down}, nil, wgmesh.MeshStatePartial},
+		{"hub up, spokes up", map[string]wgmesh.PeerState{"hub": up, "s1": up, "s2": up}, []string{"hub"}, wgmesh.MeshStateUp},
+		{"hub up, one spoke down", map[string]wgmesh.PeerState{"hub": up, "s1": down, "s2": up}, []string{"hub"}, wgmesh.MeshStatePartial},
+		{"hub up, all spokes down", map[string]wgmesh.PeerState{"hub": up, "s1": down, "s2": down}, []string{"hub"}, wgmesh.MeshStatePartial},
+		{"hub down, spokes up", map[string]wgmesh.PeerState{"hub": down, "s1": up, "s2": up}, []string{"hub"}, wgmesh.MeshStateDown},
+		{"hub errored", map[string]wgmesh.PeerState{"hub": errSt, "s1": up}, []string{"hub"}, wgmesh.MeshStateDown},
+		{"one of two hubs down", map[string]wgmesh.PeerState{"hub1": up, "hub2": down, "s1": up}, []string{"hub1", "hub2"}, wgmesh.MeshStateDown},
+		{"hub not yet polled", map[string]wgmesh.PeerState{"s1": up}, []string{"hub"}, wgmesh.MeshStatePartial},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peers := make(map[string]wgmesh.PeerStatus, len(tt.states))
+			for name, state := range tt.states {
+				peers[name] = wgmesh.PeerStatus{Name: name, State: state}
+			}
+			required := make(map[string]bool, len(tt.required))
+			for _, name := range tt.required {
+				required[name] = true
+			}
+
+			assert.Equal(t, tt.want, wgmesh.AggregateMeshState(peers, required))
+		})
+	}
+}
+
+func TestNewWgMeshFromConfig(t *testing.T) {
+	cfg := &wgmesh.Config{
+		NetworkName: "wg0",
+		ListenPort:  51820,
+		PrivateKey:  "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+		Peers: []wgmesh.Peer{{
+			Name:       "peer1",
+			PublicKey:  "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=",
+			AllowedIPs: []string{"10.0.0.2/32"},
+		}},
+	}
+
+	mockClient := &MockWireguardClient{}
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Close").Return(nil)
+
+	mesh, err := wgmesh.NewWgMeshFromConfig(cfg, wgmesh.WithClient(mockClient))
+	require.NoError(t, err)
+	assert.Empty(t, mesh.YamlFilePath)
+
+	require.NoError(t, mesh.Start())
+	require.NoError(t, mesh.Close())
+
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	require.NotNil(t, calls[0].PrivateKey)
+	assert.Equal(t, cfg.PrivateKey, calls[0].PrivateKey.String())
+	require.NotNil(t, calls[0].ListenPort)
+	assert.Equal(t, 51820, *calls[0].ListenPort)
+	require.Len(t, calls[0].Peers, 1)
+	assert.Equal(t, cfg.Peers[0].PublicKey, calls[0].Peers[0].PublicKey.String())
+}
+
+func TestNewWgMeshFromConfigInvalid(t *testing.T) {
+	_, err := wgmesh.NewWgMeshFromConfig(&wgmesh.Config{
+		NetworkName: "wg0",
+		PrivateKey:  "not-a-key",
+	}, wgmesh.WithClient(&MockWireguardClient{}))
+	assert.Error(t, err)
+}
+
+func TestSetClientWhileMonitoring(t *testing.T) {
+	mesh, first := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+monitor_interval: 1ms
+peers: []
+`)
+	second := &MockWireguardClient{}
+	for _, c := range []*MockWireguardClient{first, second} {
+		c.On("ConfigureDevice", "wg0", mock.Anything).Return(nil).Maybe()
+		c.On("Device", "wg0").Return(&wgtypes.Device{}, nil).Maybe()
+		c.On("Close").Return(nil).Maybe()
+	}
+
+	require.NoError(t, mesh.Start())
+
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			mesh.SetClient(second)
+		} else {
+			mesh.SetClient(first)
+		}
+		time.Sleep(100 * time.Microsecond)
+	}
+
+	require.NoError(t, mesh.Close())
+	assert.Same(t, first, mesh.Client())
+}
+
+func TestPeerErrorCount(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig)
+
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateError, errors.New("handshake failed"))
+	first := mesh.GetStatus().Peers["peer1"]
+	assert.Equal(t, 1, first.ErrorCount)
+	assert.Equal(t, "handshake failed", first.Error)
+
+	time.Sleep(time.Millisecond)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateError, errors.New("handshake failed again"))
+	second := mesh.GetStatus().Peers["peer1"]
+	assert.Equal(t, 2, second.ErrorCount)
+	assert.True(t, second.LastErrorTime.After(first.LastErrorTime))
+
+	// Transitional states keep the count, only recovery resets it
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateDown, nil)
+	assert.Equal(t, 2, mesh.GetStatus().Peers["peer1"].ErrorCount)
+
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	recovered := mesh.GetStatus().Peers["peer1"]
+	assert.Zero(t, recovered.ErrorCount)
+	assert.Empty(t, recovered.Error)
+	assert.Equal(t, second.LastErrorTime, recovered.LastErrorTime)
+}
+
+func TestPeerEvents(t *testing.T) {
+	mesh, _ := newTestMesh(t, monitorConfig)
+	assert.Empty(t, mesh.PeerEvents("peer1"))
+
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateConfiguring, nil)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil) // not a transition
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateDown, nil)
+	mesh.UpdatePeerState("peer1", wgmesh.PeerStateError, errors.New("handshake failed"))
+
+	events := mesh.PeerEvents("peer1")
+	require.Len(t, events, 4)
+	var transitions []string
+	for i, event := range events {
+		transitions = append(transitions, string(event.From)+"->"+string(event.To))
+		if i > 0 {
+			assert.False(t, event.Time.Before(events[i-1].Time))
+		}
+	}
+	assert.Equal(t, []string{"->configuring", "configuring->up", "up->down", "down->error"}, transitions)
+	snapshot := mesh.GetStatus().Peers["peer1"].Events
+	assert.Equal(t, events, snapshot)
+
+	// Flapping only keeps the last transitions
+	for i := 0; i < wgmesh.MaxPeerEvents; i++ {
+		mesh.UpdatePeerState("peer1", wgmesh.PeerStateUp, nil)
+		mesh.UpdatePeerState("peer1", wgmesh.PeerStateDown, nil)
+	}
+	events = mesh.PeerEvents("peer1")
+	require.Len(t, events, wgmesh.MaxPeerEvents)
+	assert.Equal(t, wgmesh.PeerStateDown, events[0].From)
+	assert.Equal(t, wgmesh.PeerStateUp, events[0].To)
+	assert.Equal(t, wgmesh.PeerStateUp, events[len(events)-1].From)
+	assert.Equal(t, wgmesh.PeerStateDown, events[len(events)-1].To)
+
+	// Earlier snapshots aren't changed by later transitions
+	assert.Equal(t, wgmesh.PeerStateConfiguring, snapshot[0].To)
+	assert.Len(t, snapshot, 4)
+	assert.Empty(t, mesh.PeerEvents("unknown"))
+}
+
+func TestReloadToZeroPeers(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+address: 10.0.0.1/24
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+`)
+	runner := &fakeRunner{}
+	mesh.CommandRunner = runner
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+address: 10.0.0.1/24
+peers: []
+`), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	assert.Equal(t, []wgtypes.Config{{ReplacePeers: true}}, configureCalls(mockClient))
+	assert.Empty(t, mesh.Config.Peers)
+	// The interface address is left in place
+	assert.Empty(t, runner.Commands())
+}
+
+func TestReloadKeepsConfigWhenPeerFails(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`)
+	failing := mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=")
+	mockClient.On("ConfigureDevice", "wg0", mock.MatchedBy(func(cfg wgtypes.Config) bool {
+		return len(cfg.Peers) == 1 && cfg.Peers[0].PublicKey == failing
+	})).Return(errors.New("device busy"))
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+  - name: peer3
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.4/32"]
+`), 0o600))
+
+	err := mesh.Reload()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to add peer peer2")
+	assert.Contains(t, err.Error(), "device busy")
+	assert.NotContains(t, err.Error(), "peer3")
+
+	// The configuration isn't advanced past what failed to apply
+	require.Len(t, mesh.Config.Peers, 1)
+	assert.Equal(t, "peer1", mesh.Config.Peers[0].Name)
+}
+
+func TestNATDefaultKeepalive(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: nat-default
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    nat: true
+  - name: nat-explicit
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+    nat: true
+    persistent_keepalive: 10
+  - name: direct
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.4/32"]
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+	mockClient.On("Close").Return(nil)
+	defer mesh.Close()
+
+	require.NoError(t, mesh.StartTunnel())
+
+	calls := configureCalls(mockClient)
+	require.Len(t, calls, 1)
+	require.Len(t, calls[0].Peers, 3)
+	require.NotNil(t, calls[0].Peers[0].PersistentKeepaliveInterval)
+	assert.Equal(t, 25*time.Second, *calls[0].Peers[0].PersistentKeepaliveInterval)
+	require.NotNil(t, calls[0].Peers[1].PersistentKeepaliveInterval)
+	assert.Equal(t, 10*time.Second, *calls[0].Peers[1].PersistentKeepaliveInterval)
+	assert.Nil(t, calls[0].Peers[2].PersistentKeepaliveInterval)
+
+	assert.Contains(t, mesh.GeneratePeerConfig(mesh.Config.Peers[0]), "PersistentKeepalive = 25\n")
+}
+
+func TestUpdatePeerSetsOnlyChangedFields(t *testing.T) {
+	const base = `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+    endpoint: "192.0.2.1:51820"
+`
+	key := mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=")
+
+	t.Run("keepalive", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, base)
+		mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+		require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(base+"    persistent_keepalive: 15\n"), 0o600))
+		require.NoError(t, mesh.Reload())
+
+		keepalive := 15 * time.Second
+		assert.Equal(t, []wgtypes.Config{{
+			Peers: []wgtypes.PeerConfig{{
+				PublicKey:                   key,
+				UpdateOnly:                  true,
+				PersistentKeepaliveInterval: &keepalive,
+			}},
+		}}, configureCalls(mockClient))
+	})
+
+	t.Run("endpoint", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, base)
+		mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+		require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(strings.Replace(base, "192.0.2.1", "192.0.2.2", 1)), 0o600))
+		require.NoError(t, mesh.Reload())
+
+		calls := configureCalls(mockClient)
+		require.Len(t, calls, 1)
+		require.Len(t, calls[0].Peers, 1)
+		update := calls[0].Peers[0]
+		assert.True(t, update.UpdateOnly)
+		assert.False(t, update.Remove)
+		assert.Equal(t, "192.0.2.2:51820", update.Endpoint.String())
+		assert.False(t, update.ReplaceAllowedIPs)
+		assert.Nil(t, update.AllowedIPs)
+		assert.Nil(t, update.PersistentKeepaliveInterval)
+	})
+
+	t.Run("allowed IPs", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, base)
+		mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+		require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(strings.Replace(base, `["10.0.0.2/32"]`, `["10.0.0.2/32", "10.0.1.0/24"]`, 1)), 0o600))
+		require.NoError(t, mesh.Reload())
+
+		calls := configureCalls(mockClient)
+		require.Len(t, calls, 1)
+		update := calls[0].Peers[0]
+		assert.True(t, update.ReplaceAllowedIPs)
+		assert.Len(t, update.AllowedIPs, 2)
+		assert.Nil(t, update.Endpoint)
+	})
+
+	t.Run("public key", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, base)
+		mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+
+		require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(strings.Replace(base, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=", "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=", 1)), 0o600))
+		require.NoError(t, mesh.Reload())
+
+		// A new key is a new device peer, the old one is removed
+		calls := configureCalls(mockClient)
+		require.Len(t, calls, 2)
+		assert.True(t, calls[0].Peers[0].Remove)
+		assert.Equal(t, key, calls[0].Peers[0].PublicKey)
+		assert.False(t, calls[1].Peers[0].UpdateOnly)
+	})
+}
+
+func TestLocalPublicKey(t *testing.T) {
+	t.Run("from private key", func(t *testing.T) {
+		mesh, _ := newTestMesh(t, `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers: []
+`)
+		key, err := mesh.LocalPublicKey()
+		require.NoError(t, err)
+		assert.Equal(t, "a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA=", key)
+	})
+
+	t.Run("from device", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+observe_only: true
+peers: []
+`)
+		mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+			PublicKey: mustParseKey(t, "a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA="),
+		}, nil)
+
+		key, err := mesh.LocalPublicKey()
+		require.NoError(t, err)
+		assert.Equal(t, "a/iotNMJnrHngs6pBu/fFusGJW88oFYf3/U/hKCq3EA=", key)
+	})
+
+	t.Run("device without key", func(t *testing.T) {
+		mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+observe_only: true
+peers: []
+`)
+		mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+
+		_, err := mesh.LocalPublicKey()
+		assert.EqualError(t, err, "device wg0 has no private key set")
+	})
+}
+
+func TestReloadPreservesPeerStatus(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32"]
+  - name: peer3
+    public_key: WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ=
+    allowed_ips: ["10.0.0.4/32"]
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{
+			{
+				PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+				LastHandshakeTime: time.Now(),
+				ReceiveBytes:      100,
+				TransmitBytes:     200,
+			},
+			{
+				PublicKey:         mustParseKey(t, "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk="),
+				LastHandshakeTime: time.Now(),
+			},
+		},
+	}, nil)
+
+	before, err := mesh.RunOnce()
+	require.NoError(t, err)
+	require.Equal(t, wgmesh.PeerStateUp, before.Peers["peer1"].State)
+	assert.False(t, before.Peers["peer1"].UpSince.IsZero())
+	assert.Equal(t, uint64(100), before.Peers["peer1"].BytesRecv)
+
+	// peer2 is updated, peer3 removed
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=
+    allowed_ips: ["10.0.0.3/32", "192.168.3.0/24"]
+`), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	after := mesh.GetStatus()
+	assert.Equal(t, before.Peers["peer1"], after.Peers["peer1"])
+	assert.Equal(t, before.Peers["peer2"], after.Peers["peer2"])
+	assert.NotContains(t, after.Peers, "peer3")
+
+	// A new key makes it a different peer
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+  - name: peer2
+    public_key: n/jHuKUr91yw9UUcek5OCikEll9cdkLxht2/4SochHw=
+    allowed_ips: ["10.0.0.3/32", "192.168.3.0/24"]
+`), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	after = mesh.GetStatus()
+	assert.Equal(t, before.Peers["peer1"], after.Peers["peer1"])
+	assert.Equal(t, wgmesh.PeerStateConfiguring, after.Peers["peer2"].State)
+	assert.True(t, after.Peers["peer2"].UpSince.IsZero())
+}
+
+func TestDiffMeshMatchesByKey(t *testing.T) {
+	mesh, _ := newTestMesh(t, `
+network_name: wg0
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers: []
+`)
+	peer1 := wgmesh.Peer{Name: "peer1", PublicKey: "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=", AllowedIPs: []string{"10.0.0.2/32"}}
+	peer2 := wgmesh.Peer{Name: "peer2", PublicKey: "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=", AllowedIPs: []string{"10.0.0.3/32"}}
+
+	t.Run("renamed", func(t *testing.T) {
+		renamed := peer1
+		renamed.Name = "gateway"
+
+		added, removed, updated := mesh.DiffMesh([]wgmesh.Peer{peer1, peer2}, []wgmesh.Peer{renamed, peer2})
+		assert.Empty(t, added)
+		assert.Empty(t, removed)
+		assert.Equal(t, []wgmesh.Peer{renamed}, updated)
+	})
+
+	t.Run("names swapped", func(t *testing.T) {
+		swapped1, swapped2 := peer1, peer2
+		swapped1.Name, swapped2.Name = "peer2", "peer1"
+
+		added, removed, updated := mesh.DiffMesh([]wgmesh.Peer{peer1, peer2}, []wgmesh.Peer{swapped1, swapped2})
+		assert.Empty(t, added)
+		assert.Empty(t, removed)
+		assert.Equal(t, []wgmesh.Peer{swapped1, swapped2}, updated)
+	})
+
+	t.Run("key changed", func(t *testing.T) {
+		rekeyed := peer1
+		rekeyed.PublicKey = "WKzK5lHr0MkXj8/RZZkNq4S7EB/msEVhZDFpmCcMaiQ="
+
+		added, removed, updated := mesh.DiffMesh([]wgmesh.Peer{peer1}, []wgmesh.Peer{rekeyed})
+		assert.Empty(t, added)
+		assert.Empty(t, removed)
+		assert.Equal(t, []wgmesh.Peer{rekeyed}, updated)
+	})
+
+	t.Run("renamed and rekeyed", func(t *testing.T) {
+		added, removed, updated := mesh.DiffMesh([]wgmesh.Peer{peer1}, []wgmesh.Peer{peer2})
+		assert.Equal(t, []wgmesh.Peer{peer2}, added)
+		assert.Equal(t, []wgmesh.Peer{peer1}, removed)
+		assert.Empty(t, updated)
+	})
+}
+
+func TestReloadRenamedPeer(t *testing.T) {
+	mesh, mockClient := newTestMesh(t, `
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: peer1
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`)
+	mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+	mockClient.On("Device", "wg0").Return(&wgtypes.Device{
+		Peers: []wgtypes.Peer{{
+			PublicKey:         mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="),
+			LastHandshakeTime: time.Now(),
+		}},
+	}, nil)
+
+	before, err := mesh.RunOnce()
+	require.NoError(t, err)
+	require.Equal(t, wgmesh.PeerStateUp, before.Peers["peer1"].State)
+	calls := len(configureCalls(mockClient))
+
+	require.NoError(t, os.WriteFile(mesh.YamlFilePath, []byte(`
+network_name: wg0
+listen_port: 51820
+private_key: ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=
+peers:
+  - name: gateway
+    public_key: 236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=
+    allowed_ips: ["10.0.0.2/32"]
+`), 0o600))
+	require.NoError(t, mesh.Reload())
+
+	// The device peer is left alone, only the name changed
+	assert.Len(t, configureCalls(mockClient), calls)
+
+	status := mesh.GetStatus()
+	assert.NotContains(t, status.Peers, "peer1")
+	require.Contains(t, status.Peers, "gateway")
+	assert.Equal(t, wgmesh.PeerStateUp, status.Peers["gateway"].State)
+	assert.Equal(t, before.Peers["peer1"].UpSince, status.Peers["gateway"].UpSince)
+}
+
+// failingResolver fails every lookup.
+type failingResolver struct{}
+
+func (failingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestStartupMode(t *testing.T) {
+	newConfig := func(mode string) *wgmesh.Config {
+		return &wgmesh.Config{
+			NetworkName: "wg0",
+			ListenPort:  51820,
+			PrivateKey:  "ANVQk8Dtlqb9FwKITBjsNy7q4a1olz1kLQ8YeC/03U8=",
+			StartupMode: mode,
+			Peers: []wgmesh.Peer{
+				{
+					Name:       "good",
+					PublicKey:  "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw=",
+					AllowedIPs: []string{"10.0.0.2/32"},
+					Endpoint:   "192.0.2.1:51820",
+				},
+				{
+					Name:       "bad",
+					PublicKey:  "iB0X9Csid9Smrvm8LpRGvwDqKWXp6Sfuv+msyWzvCgk=",
+					AllowedIPs: []string{"10.0.0.3/32"},
+					Endpoint:   "gone.example.com:51820",
+				},
+			},
+		}
+	}
+
+	t.Run("fail-fast", func(t *testing.T) {
+		mockClient := &MockWireguardClient{}
+		runner := &fakeRunner{}
+		cfg := newConfig(wgmesh.StartupModeFailFast)
+		cfg.PreUp = []string{"echo up"}
+		mesh := mustMeshFromConfig(t, cfg, wgmesh.WithClient(mockClient), wgmesh.WithResolver(failingResolver{}), wgmesh.WithCommandRunner(runner))
+
+		err := mesh.StartTunnel()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "refusing to start with invalid peers")
+		assert.Contains(t, err.Error(), "invalid endpoint for peer bad")
+
+		// Neither the device nor the hooks were touched
+		mockClient.AssertNotCalled(t, "ConfigureDevice", mock.Anything, mock.Anything)
+		assert.Empty(t, runner.Commands())
+		assert.Equal(t, wgmesh.PeerStateError, mesh.GetStatus().Peers["bad"].State)
+	})
+
+	t.Run("best-effort", func(t *testing.T) {
+		mockClient := &MockWireguardClient{}
+		mockClient.On("ConfigureDevice", "wg0", mock.Anything).Return(nil)
+		mockClient.On("Device", "wg0").Return(&wgtypes.Device{}, nil)
+		mockClient.On("Close").Return(nil)
+		mesh := mustMeshFromConfig(t, newConfig(""), wgmesh.WithClient(mockClient), wgmesh.WithResolver(failingResolver{}))
+		defer mesh.Close()
+
+		require.NoError(t, mesh.StartTunnel())
+
+		calls := configureCalls(mockClient)
+		require.Len(t, calls, 1)
+		require.Len(t, calls[0].Peers, 1)
+		assert.Equal(t, mustParseKey(t, "236ihTkzMSH0J2hpEAEQHmukV0bKsl1HpmYEcXgSCTw="), calls[0].Peers[0].PublicKey)
+		assert.Equal(t, wgmesh.PeerStateError, mesh.GetStatus().Peers["bad"].State)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		assert.ErrorContains(t, newConfig("yolo").Validate(), `unknown startup_mode "yolo"`)
+	})
+}